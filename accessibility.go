@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// auditNone turns off the DEFAULT_AUDIT of an upload
+const auditNone = "none"
+
+// parseAudit checks the audit of an upload, DEFAULT_AUDIT when empty, and returns it with
+// none as empty
+func parseAudit(value string) (string, error) {
+	if value == "" {
+		value = viper.GetString("DEFAULT_AUDIT")
+	}
+	if value == "" || value == auditNone {
+		return "", nil
+	}
+	if !slices.Contains(services.Audits, value) {
+		return "", apierror.InvalidParameter("audit", "audit must be one of "+auditNone+", "+strings.Join(services.Audits, ", "))
+	}
+	return value, nil
+}
+
+// defaultAudit is the audit of uploads without one, DEFAULT_AUDIT
+func defaultAudit() string {
+	audit, _ := parseAudit("")
+	return audit
+}
+
+// withAccessibilityIssue keeps the records the accessibility audit found an issue of a type on
+func withAccessibilityIssue(db *gorm.DB, query *gorm.DB, issue string) *gorm.DB {
+	return query.Where("id IN (?)", db.Model(&models.AccessibilityFinding{}).Select("record_id").Where("issue = ?", issue))
+}
+
+// validAccessibilityIssue checks an accessibility_issue filter
+func validAccessibilityIssue(issue string) error {
+	if issue != "" && !slices.Contains(services.AccessibilityIssues, issue) {
+		return apierror.InvalidParameter("accessibility_issue", "accessibility_issue must be one of "+strings.Join(services.AccessibilityIssues, ", "))
+	}
+	return nil
+}
+
+// listAccessibilityFindings returns the findings of the accessibility audit of a record,
+// most severe first
+func (s *server) listAccessibilityFindings(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.loadImage(w, r)
+	if !ok {
+		return
+	}
+
+	var findings []models.AccessibilityFinding
+	if err := s.db.WithContext(r.Context()).Where("record_id = ?", record.ID).
+		Order("CASE severity WHEN 'high' THEN 0 WHEN 'medium' THEN 1 ELSE 2 END, id").Find(&findings).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load accessibility findings", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"record_id": record.ID,
+		"audited":   record.Audit == services.AuditAccessibility,
+		"findings":  findings,
+	})
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// Handler serves the pprof profiles under /debug/pprof/ and expvar under /debug/vars
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// Start serves the diagnostics endpoints on ADMIN_ADDR when it is set. They are kept
+// off the public port since profiles expose internals. The returned function stops the server.
+func Start() func() {
+	addr := viper.GetString("ADMIN_ADDR")
+	if addr == "" {
+		return func() {}
+	}
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: Handler(),
+	}
+
+	go func() {
+		slog.Info("Admin server starting", "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Admin server failed", "error", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}
+}
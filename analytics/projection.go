@@ -0,0 +1,134 @@
+// Package analytics computes corpus-level views of the stored embeddings
+package analytics
+
+import (
+	"math"
+)
+
+// pcaIterations bounds the power iteration for each principal component
+const pcaIterations = 100
+
+// Projection is a 2D projection of a set of vectors
+type Projection struct {
+	// Points holds the x and y coordinates of each vector, in input order
+	Points [][2]float64
+	// ExplainedVariance is the share of the total variance captured by each axis
+	ExplainedVariance [2]float64
+}
+
+// PCA projects vectors onto their first two principal components. The components are
+// found by power iteration on XᵀX without building the covariance matrix, so memory
+// stays proportional to the input.
+func PCA(vectors [][]float32) Projection {
+	projection := Projection{Points: make([][2]float64, len(vectors))}
+	if len(vectors) == 0 {
+		return projection
+	}
+	dim := len(vectors[0])
+
+	// Center the data on its mean
+	mean := make([]float64, dim)
+	for _, vector := range vectors {
+		for j, value := range vector {
+			mean[j] += float64(value)
+		}
+	}
+	for j := range mean {
+		mean[j] /= float64(len(vectors))
+	}
+
+	centered := make([][]float64, len(vectors))
+	var totalVariance float64
+	for i, vector := range vectors {
+		centered[i] = make([]float64, dim)
+		for j, value := range vector {
+			centered[i][j] = float64(value) - mean[j]
+			totalVariance += centered[i][j] * centered[i][j]
+		}
+	}
+
+	var components [][]float64
+	for axis := range 2 {
+		component, variance := principalComponent(centered, components)
+		components = append(components, component)
+		if totalVariance > 0 {
+			projection.ExplainedVariance[axis] = variance / totalVariance
+		}
+	}
+
+	for i, row := range centered {
+		projection.Points[i] = [2]float64{dot(row, components[0]), dot(row, components[1])}
+	}
+	return projection
+}
+
+// principalComponent finds the direction of greatest variance orthogonal to the previous
+// components and returns it with the variance along it
+func principalComponent(rows [][]float64, previous [][]float64) ([]float64, float64) {
+	dim := len(rows[0])
+
+	// A fixed, uneven start vector keeps the projection stable between calls
+	v := make([]float64, dim)
+	for j := range v {
+		v[j] = 1 + float64(j%7)/7
+	}
+	orthogonalize(v, previous)
+	normalize(v)
+
+	next := make([]float64, dim)
+	var eigenvalue float64
+	for range pcaIterations {
+		// next = Xᵀ(Xv)
+		clear(next)
+		for _, row := range rows {
+			score := dot(row, v)
+			for j, value := range row {
+				next[j] += score * value
+			}
+		}
+		orthogonalize(next, previous)
+
+		eigenvalue = normalize(next)
+		if eigenvalue == 0 {
+			break
+		}
+
+		converged := math.Abs(math.Abs(dot(next, v))-1) < 1e-9
+		copy(v, next)
+		if converged {
+			break
+		}
+	}
+
+	return v, eigenvalue
+}
+
+// orthogonalize removes the projection of v onto each of the unit vectors
+func orthogonalize(v []float64, units [][]float64) {
+	for _, unit := range units {
+		d := dot(v, unit)
+		for j := range v {
+			v[j] -= d * unit[j]
+		}
+	}
+}
+
+// normalize scales v to unit length and returns its previous length
+func normalize(v []float64) float64 {
+	norm := math.Sqrt(dot(v, v))
+	if norm == 0 {
+		return 0
+	}
+	for j := range v {
+		v[j] /= norm
+	}
+	return norm
+}
+
+func dot(a []float64, b []float64) float64 {
+	var sum float64
+	for j := range a {
+		sum += a[j] * b[j]
+	}
+	return sum
+}
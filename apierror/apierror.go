@@ -0,0 +1,117 @@
+// Package apierror writes the JSON error envelope every API handler responds with on failure
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pablobfonseca/go-image-vector/logging"
+)
+
+// Error codes, stable identifiers clients can branch on
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeStorageQuotaExceeded = "storage_quota_exceeded"
+	CodeAnalysisFailed       = "analysis_failed"
+	CodeInternal             = "internal_error"
+)
+
+// Error is an API error, written as the JSON envelope {code, message, details, request_id}
+// with its HTTP status
+type Error struct {
+	Status    int            `json:"-"`
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New returns an error with a status, code and message
+func New(status int, code string, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Newf returns an error with a status, code and formatted message
+func Newf(status int, code string, format string, args ...any) *Error {
+	return New(status, code, fmt.Sprintf(format, args...))
+}
+
+// With adds a detail to the error and returns it
+func (e *Error) With(key string, value any) *Error {
+	if e.Details == nil {
+		e.Details = map[string]any{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+// BadRequest is a 400 for a request that cannot be processed as sent
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+// InvalidParameter is a 400 for an invalid query parameter or form field, named in the details
+func InvalidParameter(parameter string, message string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidParameter, message).With("parameter", parameter)
+}
+
+// NotFound is a 404 for a missing resource
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+// Internal is a 500 for a failure on the server side, with the message describing what failed
+func Internal(message string, err error) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, message+": "+err.Error())
+}
+
+// Write responds with err as a JSON error envelope carrying the request ID. Errors other than
+// *Error are internal errors, except request bodies over their size limit.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			apiErr = New(http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Request body too large").
+				With("limit", maxBytesErr.Limit)
+		} else {
+			apiErr = New(http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+	}
+
+	envelope := *apiErr
+	envelope.RequestID = logging.RequestIDFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(envelope)
+}
+
+// NotFoundHandler answers unknown routes with a not_found error
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, NotFound("No route for "+r.URL.Path))
+	})
+}
+
+// MethodNotAllowedHandler answers routes called with an unsupported method
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, Newf(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method %s is not allowed on %s", r.Method, r.URL.Path))
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/spf13/viper"
+)
+
+// expandArchive spools the images of an uploaded zip archive to temporary files, in name
+// order. Entries are bounded by ARCHIVE_MAX_FILES, MAX_FILE_BYTES each and ARCHIVE_MAX_BYTES
+// decompressed in all, counting the bytes actually decompressed rather than the sizes the
+// archive declares. Directories and hidden entries such as __MACOSX are skipped, and archives
+// with entries escaping their root, links or encrypted entries are rejected.
+func expandArchive(archive *uploadedFile, maxFileBytes int64) ([]*uploadedFile, error) {
+	reader, err := zip.NewReader(archive.File, archive.Size)
+	if err != nil {
+		return nil, apierror.InvalidParameter("archive", fmt.Sprintf("%s is not a valid zip archive", archive.Filename)).
+			With("filename", archive.Filename)
+	}
+
+	maxFiles := viper.GetInt("ARCHIVE_MAX_FILES")
+	remaining := viper.GetInt64("ARCHIVE_MAX_BYTES")
+
+	var entries []*zip.File
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		if err := checkArchiveEntry(archive.Filename, entry); err != nil {
+			return nil, err
+		}
+		if hiddenEntry(entry.Name) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, apierror.InvalidParameter("archive", fmt.Sprintf("%s has no images", archive.Filename)).
+			With("filename", archive.Filename)
+	}
+	if len(entries) > maxFiles {
+		return nil, &uploadLimitError{
+			message: fmt.Sprintf("Archive %s has %d files, maximum %d allowed", archive.Filename, len(entries), maxFiles),
+			limit:   "archive_max_files",
+			value:   int64(maxFiles),
+		}
+	}
+	slices.SortFunc(entries, func(a, b *zip.File) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	files := []*uploadedFile{}
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, entry := range entries {
+		rc, err := entry.Open()
+		if err != nil {
+			closeAll()
+			return nil, apierror.InvalidParameter("archive", fmt.Sprintf("Failed to read %s from %s: %v", entry.Name, archive.Filename, err)).
+				With("filename", archive.Filename)
+		}
+
+		file, err := spoolFile(io.LimitReader(rc, remaining+1), displayName(entry.Name), maxFileBytes)
+		rc.Close()
+		if err != nil {
+			closeAll()
+			var limitErr *uploadLimitError
+			if errors.As(err, &limitErr) {
+				return nil, err
+			}
+			return nil, apierror.InvalidParameter("archive", fmt.Sprintf("Failed to read %s from %s: %v", entry.Name, archive.Filename, err)).
+				With("filename", archive.Filename)
+		}
+		files = append(files, file)
+
+		if remaining -= file.Size; remaining < 0 {
+			closeAll()
+			return nil, &uploadLimitError{
+				message: fmt.Sprintf("Archive %s exceeds the maximum decompressed size of %d bytes", archive.Filename, viper.GetInt64("ARCHIVE_MAX_BYTES")),
+				limit:   "archive_max_bytes",
+				value:   viper.GetInt64("ARCHIVE_MAX_BYTES"),
+			}
+		}
+		file.Archive = archive.Filename
+	}
+	return files, nil
+}
+
+// checkArchiveEntry rejects entries that would escape the archive root if extracted, and
+// entries that are not plain readable files
+func checkArchiveEntry(archiveName string, entry *zip.File) error {
+	invalid := func(reason string) error {
+		return apierror.InvalidParameter("archive", fmt.Sprintf("%s has %s entry %q", archiveName, reason, entry.Name)).
+			With("filename", archiveName).With("entry", entry.Name)
+	}
+
+	name := entry.Name
+	if strings.Contains(name, `\`) || path.IsAbs(name) || slices.Contains(strings.Split(name, "/"), "..") {
+		return invalid("an unsafe path in")
+	}
+	if !entry.Mode().IsRegular() {
+		return invalid("a link or special file as")
+	}
+	if entry.Flags&0x1 != 0 {
+		return invalid("an encrypted")
+	}
+	return nil
+}
+
+// hiddenEntry reports whether an archive entry is operating system metadata, such as the
+// __MACOSX resource forks or .DS_Store files
+func hiddenEntry(name string) bool {
+	for _, element := range strings.Split(name, "/") {
+		if strings.HasPrefix(element, ".") && element != "." || element == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// zipArchive writes an archive of the files to a temporary upload
+func zipArchive(t *testing.T, files map[string]string) *uploadedFile {
+	t.Helper()
+
+	tmp, err := os.CreateTemp(t.TempDir(), "archive-*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(tmp)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(entry, content)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := tmp.Stat()
+	return &uploadedFile{Filename: "screens.zip", Size: info.Size(), File: tmp}
+}
+
+func TestExpandArchive(t *testing.T) {
+	archive := zipArchive(t, map[string]string{
+		"screens/b.png":          "second",
+		"screens/a.png":          "first",
+		"__MACOSX/screens/a.png": "resource fork",
+		".DS_Store":              "finder",
+	})
+	defer archive.File.Close()
+
+	files, err := expandArchive(archive, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	if len(files) != 2 {
+		t.Fatalf("expanded %d files, want the 2 images", len(files))
+	}
+	for i, want := range []string{"first", "second"} {
+		data, _ := io.ReadAll(files[i].File)
+		if string(data) != want || files[i].Archive != "screens.zip" {
+			t.Errorf("file %d = %q from %q, want %q in name order", i, data, files[i].Archive, want)
+		}
+	}
+}
+
+func TestExpandArchiveLimits(t *testing.T) {
+	viper.Set("ARCHIVE_MAX_FILES", 1)
+	t.Cleanup(func() { viper.Set("ARCHIVE_MAX_FILES", nil) })
+
+	archive := zipArchive(t, map[string]string{"a.png": "a", "b.png": "b"})
+	defer archive.File.Close()
+
+	var limitErr *uploadLimitError
+	if _, err := expandArchive(archive, 1<<20); !errors.As(err, &limitErr) {
+		t.Errorf("too many files gave %v, want an upload limit error", err)
+	}
+}
+
+func TestExpandArchiveRejectsTraversal(t *testing.T) {
+	archive := zipArchive(t, map[string]string{"../escape.png": "x"})
+	defer archive.File.Close()
+
+	if _, err := expandArchive(archive, 1<<20); err == nil {
+		t.Error("an entry escaping the archive was accepted")
+	}
+}
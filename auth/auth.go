@@ -0,0 +1,62 @@
+// Package auth authenticates API requests with the bearer tokens listed in API_KEYS, and
+// admins with the ones in ADMIN_API_KEYS
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/config"
+)
+
+// Authenticated reports whether r carries one of API_KEYS or ADMIN_API_KEYS as a bearer
+// token. Every request is authenticated when API_KEYS is empty.
+func Authenticated(r *http.Request) bool {
+	keys := config.List("API_KEYS")
+	if len(keys) == 0 {
+		return true
+	}
+	return bearerMatches(r, append(keys, config.List("ADMIN_API_KEYS")...))
+}
+
+// Admin reports whether r carries one of ADMIN_API_KEYS as a bearer token, such as to read
+// the text of records before it was redacted. Every request is an admin when API_KEYS and
+// ADMIN_API_KEYS are both empty.
+func Admin(r *http.Request) bool {
+	keys := config.List("ADMIN_API_KEYS")
+	if len(keys) == 0 {
+		return len(config.List("API_KEYS")) == 0
+	}
+	return bearerMatches(r, keys)
+}
+
+// KeyID identifies the key r carries, one of API_KEYS or ADMIN_API_KEYS, by a hash of it, so
+// usage can be accounted per key without storing the key. It is empty for requests without
+// a valid key, and for every request when API_KEYS is empty.
+func KeyID(r *http.Request) string {
+	keys := config.List("API_KEYS")
+	if len(keys) == 0 || !bearerMatches(r, append(keys, config.List("ADMIN_API_KEYS")...)) {
+		return ""
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// bearerMatches reports whether the bearer token of r is one of keys
+func bearerMatches(r *http.Request, keys []string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+
+	// Every key is compared so the time taken does not tell which one nearly matched
+	matched := 0
+	for _, key := range keys {
+		matched |= subtle.ConstantTimeCompare([]byte(token), []byte(key))
+	}
+	return matched == 1
+}
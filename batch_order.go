@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Batch orders: the order the files were uploaded in, their EXIF capture time, or the
+// steps given in the sequence field
+const (
+	batchOrderUpload   = "upload"
+	batchOrderCaptured = "captured"
+	batchOrderSequence = "sequence"
+)
+
+// batchImage is a stored image of a batch upload with what it can be ordered by
+type batchImage struct {
+	stored   *storedUpload
+	filename string
+	// index is the position of the file in the upload
+	index int
+	// step is the position given in the sequence field
+	step int
+}
+
+// parseBatchOrder reads the order of a batch upload. sequence lists one step number per
+// uploaded file, in upload order, as repeated fields or separated by commas; giving it
+// implies the sequence order.
+func parseBatchOrder(values url.Values, fileCount int) (string, []int, error) {
+	order := values.Get("order")
+
+	var steps []int
+	for _, value := range values["sequence"] {
+		for _, field := range strings.Split(value, ",") {
+			step, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return "", nil, fmt.Errorf("sequence must list integer steps, got %q", field)
+			}
+			steps = append(steps, step)
+		}
+	}
+
+	if len(steps) == 0 {
+		switch order {
+		case "":
+			return batchOrderUpload, nil, nil
+		case batchOrderUpload, batchOrderCaptured:
+			return order, nil, nil
+		case batchOrderSequence:
+			return "", nil, fmt.Errorf("order sequence needs the sequence field")
+		}
+		return "", nil, fmt.Errorf("order must be one of upload, captured or sequence")
+	}
+
+	if order != "" && order != batchOrderSequence {
+		return "", nil, fmt.Errorf("sequence cannot be combined with order %s", order)
+	}
+	if len(steps) != fileCount {
+		return "", nil, fmt.Errorf("sequence has %d steps for %d images", len(steps), fileCount)
+	}
+	seen := map[int]bool{}
+	for _, step := range steps {
+		if seen[step] {
+			return "", nil, fmt.Errorf("sequence repeats step %d", step)
+		}
+		seen[step] = true
+	}
+	return batchOrderSequence, steps, nil
+}
+
+// sortBatch puts the images of a batch in journey order. By capture time, images without one
+// follow the dated ones in upload order.
+func sortBatch(images []batchImage, order string) {
+	sort.SliceStable(images, func(i, j int) bool {
+		switch order {
+		case batchOrderSequence:
+			return images[i].step < images[j].step
+		case batchOrderCaptured:
+			a, b := images[i].stored.Metadata.TakenAt, images[j].stored.Metadata.TakenAt
+			if a == nil || b == nil {
+				return a != nil && b == nil
+			}
+			return a.Before(*b)
+		}
+		return images[i].index < images[j].index
+	})
+}
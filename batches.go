@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/cleanup"
+	"github.com/pablobfonseca/go-image-vector/httpcache"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/report"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// batchListMax caps the page size of the batch list
+const batchListMax = 200
+
+// batchSummaryWidth is how much of the journey text the batch list includes
+const batchSummaryWidth = 200
+
+// Member statuses: analyzed on its own as well, stored only as part of the batch, or missing
+// from storage
+const (
+	memberAnalyzed = "analyzed"
+	memberStored   = "stored"
+	memberMissing  = "missing"
+)
+
+// batchListFields are the fields of the batches listed by listBatches
+var batchListFields = []string{"id", "batch_id", "file_path", "original_name", "title", "file_count", "summary", "created_at"}
+
+// listBatches returns the batch analyses, newest first, with the total for paging
+func (s *server) listBatches(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := allowQueryParams(query, "limit", "offset", "fields", "exclude"); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	selection, err := newFieldSelection(splitFields(query["fields"]), splitFields(query["exclude"]), batchListFields)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	limit, offset, err := parsePage(query, batchListMax)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	batches := visibleRecords(r, s.db.WithContext(r.Context()).Model(&models.ImageEmbedding{}).Where("is_batch = ?", true))
+
+	var total int64
+	if err := batches.Count(&total).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to count batches", err))
+		return
+	}
+
+	var records []models.ImageEmbedding
+	if err := batches.Omit("embedding").Order("created_at DESC, id DESC").Limit(limit).Offset(offset).
+		Find(&records).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load batches", err))
+		return
+	}
+	s.backfillBatchPaths(records)
+
+	items := make([]any, len(records))
+	for i, record := range records {
+		items[i] = selection.apply(map[string]any{
+			"id":            record.ID,
+			"batch_id":      record.BatchID,
+			"file_path":     record.FilePath,
+			"original_name": record.OriginalName,
+			"title":         record.Title,
+			"file_count":    len(batchMemberPaths(record)),
+			"summary":       truncate(record.Text, batchSummaryWidth),
+			"created_at":    record.CreatedAt,
+		})
+	}
+
+	httpcache.WriteJSON(w, r, publicOnly(r), map[string]any{
+		"batches": items,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// getBatch returns a batch with its journey text, task status and the status of each member
+// image. Batches still being analyzed only have their task status.
+func (s *server) getBatch(w http.ResponseWriter, r *http.Request) {
+	batchID := mux.Vars(r)["id"]
+
+	status, err := s.tasks.GetTaskStatus(batchID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to get batch status", err))
+		return
+	}
+
+	// Callers without a key would otherwise learn from the task status that a private batch exists
+	record, err := s.loadBatch(r, batchID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if status == "unknown" || publicOnly(r) {
+			apierror.Write(w, r, apierror.NotFound("Batch not found"))
+			return
+		}
+		httpcache.WriteJSON(w, r, publicOnly(r), map[string]any{"batch_id": batchID, "status": status})
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load batch", err))
+		return
+	}
+
+	// Task keys expire, the record itself shows the analysis completed
+	if status == "unknown" {
+		status = "completed"
+	}
+
+	paths := batchMemberPaths(record)
+
+	var images []models.ImageEmbedding
+	if err := visibleRecords(r, s.db.WithContext(r.Context()).Select("id, file_path")).
+		Where("is_batch = ? AND file_path IN ?", false, paths).Find(&images).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load batch images", err))
+		return
+	}
+	analyzed := make(map[string]uint, len(images))
+	for _, image := range images {
+		analyzed[image.FilePath] = image.ID
+	}
+
+	members := make([]map[string]any, len(paths))
+	for i, filePath := range paths {
+		member := map[string]any{
+			"position":  i + 1,
+			"file_path": filePath,
+			"status":    memberStored,
+		}
+		if i == 0 && record.OriginalName != "" {
+			member["original_name"] = record.OriginalName
+		}
+
+		key := storage.Key(filePath)
+		exists, err := s.files.Exists(r.Context(), key)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal("Failed to check batch file", err))
+			return
+		}
+		if !exists {
+			member["status"] = memberMissing
+		} else if size, err := s.queue.StoredFileSize(key); err == nil && size > 0 {
+			member["size_bytes"] = size
+		}
+		if id, ok := analyzed[filePath]; ok {
+			member["record_id"] = id
+			if exists {
+				member["status"] = memberAnalyzed
+			}
+		}
+		members[i] = member
+	}
+
+	httpcache.WriteJSON(w, r, publicOnly(r), map[string]any{
+		"id":            record.ID,
+		"batch_id":      record.BatchID,
+		"status":        status,
+		"file_path":     record.FilePath,
+		"original_name": record.OriginalName,
+		"title":         record.Title,
+		"text":          record.Text,
+		"created_at":    record.CreatedAt,
+		"file_count":    len(paths),
+		"members":       members,
+	})
+}
+
+// deleteBatch removes a batch record, and with images=true the single-image records of its
+// members, in one transaction, then the files nothing else references
+func (s *server) deleteBatch(w http.ResponseWriter, r *http.Request) {
+	if !requireAPIKey(w, r, "Deleting batches") {
+		return
+	}
+	batchID := mux.Vars(r)["id"]
+	if err := allowQueryParams(r.URL.Query(), "images"); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	withImages := false
+	if value := r.URL.Query().Get("images"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			apierror.Write(w, r, apierror.InvalidParameter("images", "images must be true or false"))
+			return
+		}
+		withImages = parsed
+	}
+
+	if _, err := s.loadBatch(r, batchID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierror.Write(w, r, apierror.NotFound("Batch not found"))
+			return
+		}
+		apierror.Write(w, r, apierror.Internal("Failed to load batch", err))
+		return
+	}
+
+	deleted, err := cleanup.DeleteBatch(r.Context(), s.db, s.queue, s.files, batchID, withImages)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Write(w, r, apierror.NotFound("Batch not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to delete batch", err))
+		return
+	}
+
+	ids := make([]uint, len(deleted))
+	for i, record := range deleted {
+		ids[i] = record.ID
+	}
+	slog.InfoContext(r.Context(), "Deleted batch", "batch_id", batchID, "records", len(ids))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"batch_id":    batchID,
+		"deleted_ids": ids,
+	})
+}
+
+// loadBatch loads the journey record of a batch r may see, with its batch paths backfilled
+func (s *server) loadBatch(r *http.Request, batchID string) (models.ImageEmbedding, error) {
+	return s.findBatch(visibleRecords(r, s.db.WithContext(r.Context())), batchID)
+}
+
+// findBatch loads the journey record of a batch with query, with its batch paths backfilled
+func (s *server) findBatch(query *gorm.DB, batchID string) (models.ImageEmbedding, error) {
+	var record models.ImageEmbedding
+	if err := query.Omit("embedding").
+		Where("batch_id = ? AND is_batch = ?", batchID, true).First(&record).Error; err != nil {
+		return record, err
+	}
+
+	records := []models.ImageEmbedding{record}
+	s.backfillBatchPaths(records)
+	return records[0], nil
+}
+
+// batchMemberPaths lists the images of a batch, which is the record file alone for
+// batches whose paths were never stored
+func batchMemberPaths(record models.ImageEmbedding) []string {
+	if len(record.BatchPaths) == 0 {
+		return []string{record.FilePath}
+	}
+	return record.BatchPaths
+}
+
+// getBatchReport renders the journey narrative of a batch with thumbnails of its screens
+// as a standalone Markdown or HTML document
+func (s *server) getBatchReport(w http.ResponseWriter, r *http.Request) {
+	batchID := mux.Vars(r)["id"]
+	format, err := reportFormat(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	record, err := s.loadBatch(r, batchID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierror.Write(w, r, apierror.NotFound("Batch not found"))
+			return
+		}
+		apierror.Write(w, r, apierror.Internal("Failed to load batch", err))
+		return
+	}
+	s.writeBatchReport(w, r, record, format, func(filePath string) string {
+		return fileURL(r, filePath)
+	})
+}
+
+// reportFormat reads the format of a journey report, markdown unless html is asked for
+func reportFormat(r *http.Request) (string, error) {
+	if err := allowQueryParams(r.URL.Query(), "format"); err != nil {
+		return "", err
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "markdown"
+	}
+	if format != "markdown" && format != "html" {
+		return "", apierror.InvalidParameter("format", "format must be markdown or html")
+	}
+	return format, nil
+}
+
+// writeBatchReport renders the journey report of a batch record, linking each screen to the
+// URL link gives its file
+func (s *server) writeBatchReport(w http.ResponseWriter, r *http.Request, record models.ImageEmbedding, format string, link func(filePath string) string) {
+	batchID := record.BatchID
+	paths := batchMemberPaths(record)
+
+	journey := report.Journey{
+		ID:        record.ID,
+		BatchID:   record.BatchID,
+		Title:     "Journey report",
+		Narrative: record.Text,
+		CreatedAt: record.CreatedAt,
+	}
+	if record.OriginalName != "" {
+		journey.Title = "Journey report: " + record.OriginalName
+	}
+
+	// Screens that cannot be read or decoded link to the stored file instead of failing the report
+	size := viper.GetInt("REPORT_THUMBNAIL_SIZE")
+	for i, filePath := range paths {
+		screen := report.Screen{Name: path.Base(filePath), URL: link(filePath)}
+		if i == 0 && record.OriginalName != "" {
+			screen.Name = record.OriginalName
+		}
+
+		data, err := storage.ReadFile(r.Context(), s.files, filePath)
+		if err == nil {
+			screen.Thumbnail, err = report.Thumbnail(data, size)
+		}
+		if err != nil {
+			slog.WarnContext(r.Context(), "Linking report screen without thumbnail", "batch_id", batchID, "file_path", filePath, "error", err)
+		}
+		journey.Screens = append(journey.Screens, screen)
+	}
+
+	var document []byte
+	var err error
+	contentType, extension := "text/markdown; charset=utf-8", "md"
+	if format == "html" {
+		if document, err = report.HTML(journey); err != nil {
+			apierror.Write(w, r, apierror.Internal("Failed to render report", err))
+			return
+		}
+		contentType, extension = "text/html; charset=utf-8", "html"
+	} else {
+		document = report.Markdown(journey)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="journey-%s.%s"`, sanitizeFilename(batchID), extension))
+	w.WriteHeader(http.StatusOK)
+	w.Write(document)
+}
+
+// fileURL is the absolute URL a stored file is served at, for links that work outside the API
+func fileURL(r *http.Request, filePath string) string {
+	return fileLink(baseURL(r), filePath)
+}
+
+// baseURL is the scheme and host r was sent to
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// sanitizeFilename keeps the characters that are safe in a Content-Disposition filename
+func sanitizeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/pkg/client"
+)
+
+// defaultBenchQueries are searched in rotation when no --query is given
+var defaultBenchQueries = []string{
+	"login page with an error message",
+	"shopping cart checkout",
+	"dashboard with charts",
+	"settings screen",
+	"dark mode code editor",
+	"invoice with a total amount",
+	"chat conversation",
+	"map with directions",
+}
+
+// benchOptions configures the bench command
+type benchOptions struct {
+	apiURL      string
+	duration    time.Duration
+	uploadRate  float64
+	searchRate  float64
+	maxInFlight int
+	waitTasks   bool
+	drain       time.Duration
+	topK        int
+	queries     []string
+	json        bool
+}
+
+// latencies records the outcome of one kind of operation
+type latencies struct {
+	mu       sync.Mutex
+	name     string
+	samples  []time.Duration
+	errors   int
+	lastErr  string
+	dropped  int
+	inFlight atomic.Int64
+}
+
+func (l *latencies) record(elapsed time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err != nil {
+		l.errors++
+		l.lastErr = err.Error()
+		return
+	}
+	l.samples = append(l.samples, elapsed)
+}
+
+func (l *latencies) drop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dropped++
+}
+
+// benchReport summarizes the latencies of one kind of operation
+type benchReport struct {
+	Operation string        `json:"operation"`
+	Count     int           `json:"count"`
+	Errors    int           `json:"errors"`
+	Dropped   int           `json:"dropped"`
+	PerSecond float64       `json:"per_second"`
+	P50       time.Duration `json:"p50_ns"`
+	P95       time.Duration `json:"p95_ns"`
+	P99       time.Duration `json:"p99_ns"`
+	Max       time.Duration `json:"max_ns"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+func (l *latencies) report(elapsed time.Duration) benchReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sorted := slices.Clone(l.samples)
+	slices.Sort(sorted)
+
+	report := benchReport{
+		Operation: l.name,
+		Count:     len(sorted),
+		Errors:    l.errors,
+		Dropped:   l.dropped,
+		P50:       percentile(sorted, 50),
+		P95:       percentile(sorted, 95),
+		P99:       percentile(sorted, 99),
+		LastError: l.lastErr,
+	}
+	if len(sorted) > 0 {
+		report.Max = sorted[len(sorted)-1]
+	}
+	if elapsed > 0 {
+		report.PerSecond = float64(len(sorted)) / elapsed.Seconds()
+	}
+	return report
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank-1, 0)]
+}
+
+// runBench uploads synthetic images and searches at fixed rates for the configured duration,
+// then reports latency percentiles. Upload latency is the HTTP request, ingest latency runs
+// until the analysis task finishes.
+func runBench(ctx context.Context, opts benchOptions) error {
+	if opts.uploadRate <= 0 && opts.searchRate <= 0 {
+		return fmt.Errorf("at least one of --upload-rate and --search-rate must be positive")
+	}
+	if len(opts.queries) == 0 {
+		opts.queries = defaultBenchQueries
+	}
+
+	api := client.New(opts.apiURL, "")
+
+	uploads := &latencies{name: "upload"}
+	ingests := &latencies{name: "ingest"}
+	searches := &latencies{name: "search"}
+
+	fmt.Fprintf(os.Stderr, "Benchmarking %s for %s: %.2f uploads/s, %.2f searches/s\n",
+		opts.apiURL, opts.duration, opts.uploadRate, opts.searchRate)
+
+	runCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+
+	// Requests still in flight when the run ends may finish during the drain period
+	requestCtx, cancelRequests := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRequests()
+
+	var wg sync.WaitGroup
+	var sequence atomic.Uint64
+	start := time.Now()
+
+	generate(runCtx, &wg, opts.uploadRate, func() {
+		if uploads.inFlight.Load() >= int64(opts.maxInFlight) {
+			uploads.drop()
+			return
+		}
+		uploads.inFlight.Add(1)
+		defer uploads.inFlight.Add(-1)
+
+		benchUpload(requestCtx, api, sequence.Add(1), opts, uploads, ingests)
+	})
+
+	generate(runCtx, &wg, opts.searchRate, func() {
+		if searches.inFlight.Load() >= int64(opts.maxInFlight) {
+			searches.drop()
+			return
+		}
+		searches.inFlight.Add(1)
+		defer searches.inFlight.Add(-1)
+
+		query := opts.queries[int(sequence.Add(1))%len(opts.queries)]
+		started := time.Now()
+		_, err := api.Search(requestCtx, query, opts.topK)
+		searches.record(time.Since(started), err)
+	})
+
+	<-runCtx.Done()
+	elapsed := time.Since(start)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Waiting up to %s for in-flight requests and tasks\n", opts.drain)
+	select {
+	case <-done:
+	case <-time.After(opts.drain):
+		cancelRequests()
+		<-done
+	case <-ctx.Done():
+		cancelRequests()
+		<-done
+	}
+
+	reports := []benchReport{uploads.report(elapsed), searches.report(elapsed)}
+	if opts.waitTasks {
+		reports = slices.Insert(reports, 1, ingests.report(time.Since(start)))
+	}
+
+	if opts.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	}
+	printBenchReports(os.Stdout, reports)
+	return nil
+}
+
+// generate calls op rate times per second on its own goroutine until ctx is done
+func generate(ctx context.Context, wg *sync.WaitGroup, rate float64, op func()) {
+	if rate <= 0 {
+		return
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					op()
+				}()
+			}
+		}
+	}()
+}
+
+// benchUpload uploads one synthetic image and, when waiting for tasks, times its analysis
+func benchUpload(ctx context.Context, api *client.Client, n uint64, opts benchOptions, uploads *latencies, ingests *latencies) {
+	started := time.Now()
+	response, err := api.Upload(ctx, []client.File{{
+		Name:   fmt.Sprintf("bench-%d-%d.png", started.UnixNano(), n),
+		Reader: syntheticImage(),
+	}}, client.UploadOptions{})
+	uploads.record(time.Since(started), err)
+
+	if err != nil || !opts.waitTasks {
+		return
+	}
+	for _, taskID := range response.TaskIDs {
+		task, err := api.WaitForTask(ctx, taskID, 500*time.Millisecond)
+		if err == nil && task.Status == client.StatusFailed {
+			err = fmt.Errorf("task %s failed: %s", taskID, task.ErrorMessage())
+		}
+		ingests.record(time.Since(started), err)
+	}
+}
+
+// syntheticImage draws random rectangles on a 512x512 PNG, so every upload has unique content
+func syntheticImage() io.Reader {
+	img := image.NewRGBA(image.Rect(0, 0, 512, 512))
+	randomColor := func() color.Color {
+		return color.RGBA{R: uint8(rand.IntN(256)), G: uint8(rand.IntN(256)), B: uint8(rand.IntN(256)), A: 255}
+	}
+
+	draw.Draw(img, img.Bounds(), image.NewUniform(randomColor()), image.Point{}, draw.Src)
+	for range 8 + rand.IntN(8) {
+		x, y := rand.IntN(448), rand.IntN(448)
+		rect := image.Rect(x, y, x+16+rand.IntN(256), y+16+rand.IntN(256))
+		draw.Draw(img, rect, image.NewUniform(randomColor()), image.Point{}, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return &buf
+}
+
+// printBenchReports renders the reports as an aligned table
+func printBenchReports(out io.Writer, reports []benchReport) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "OPERATION\tCOUNT\tERRORS\tDROPPED\tRATE/S\tP50\tP95\tP99\tMAX")
+	for _, report := range reports {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%.2f\t%s\t%s\t%s\t%s\n", report.Operation, report.Count,
+			report.Errors, report.Dropped, report.PerSecond, benchDuration(report.P50),
+			benchDuration(report.P95), benchDuration(report.P99), benchDuration(report.Max))
+	}
+	table.Flush()
+
+	for _, report := range reports {
+		if report.LastError != "" {
+			fmt.Fprintf(out, "\nlast %s error: %s\n", report.Operation, report.LastError)
+		}
+	}
+}
+
+func benchDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(time.Millisecond).String()
+}
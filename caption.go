@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/worker"
+	"github.com/spf13/viper"
+)
+
+// imageCaption is the user supplied caption of an image and how it combines with the vision
+// model output, empty for images without one
+type imageCaption struct {
+	text string
+	mode string
+}
+
+// captionAt returns the caption of the i-th image of an upload
+func captionAt(captions []string, mode string, i int) imageCaption {
+	if i >= len(captions) || captions[i] == "" {
+		return imageCaption{}
+	}
+	return imageCaption{text: captions[i], mode: mode}
+}
+
+// parseCaptions reads the caption of each image of an upload, one caption field per image in
+// upload order, empty for images without one, and how captions combine with the vision model
+// output: augment (the default, CAPTION_MODE) embeds both, replace skips the vision model.
+func parseCaptions(values url.Values, fileCount int) ([]string, string, error) {
+	mode := values.Get("caption_mode")
+	if mode == "" {
+		mode = viper.GetString("CAPTION_MODE")
+	}
+	if mode != worker.CaptionAugment && mode != worker.CaptionReplace {
+		return nil, "", apierror.InvalidParameter("caption_mode", "caption_mode must be augment or replace")
+	}
+
+	captions := values["caption"]
+	if len(captions) == 0 {
+		return nil, mode, nil
+	}
+	if len(captions) != fileCount {
+		return nil, "", apierror.InvalidParameter("caption", fmt.Sprintf("caption has %d entries for %d images, send one per image (empty for none)", len(captions), fileCount))
+	}
+
+	maxLength := viper.GetInt("CAPTION_MAX_LENGTH")
+	for i, caption := range captions {
+		caption = strings.TrimSpace(caption)
+		if !utf8.ValidString(caption) {
+			return nil, "", apierror.InvalidParameter("caption", "caption must be valid UTF-8")
+		}
+		if length := utf8.RuneCountInString(caption); length > maxLength {
+			return nil, "", apierror.InvalidParameter("caption", fmt.Sprintf("caption is %d characters long, at most %d are allowed", length, maxLength)).
+				With("limit", maxLength).With("value", length)
+		}
+		captions[i] = caption
+	}
+	return captions, mode, nil
+}
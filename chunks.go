@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+// chunkCandidates is how many times limit nearest chunks are considered, as several chunks
+// of one record can be among the nearest
+const chunkCandidates = 5
+
+// findSimilarChunks searches the description chunks of the records matching the records
+// query and returns up to limit of those records, nearest first. Each record is ranked by
+// its nearest chunk, which is returned as its matched chunk.
+func findSimilarChunks(db *gorm.DB, records *gorm.DB, embedding []float32, limit int) ([]models.ImageEmbedding, error) {
+	var chunks []models.DescriptionChunk
+	if err := db.Model(&models.DescriptionChunk{}).
+		Select("record_id, text, embedding <-> ? AS distance", pgvector.NewVector(embedding)).
+		Where("record_id IN (?)", records.Select("id")).
+		Order("distance").Limit(limit * chunkCandidates).Scan(&chunks).Error; err != nil {
+		return nil, err
+	}
+
+	nearest := map[uint]models.DescriptionChunk{}
+	var ids []uint
+	for _, chunk := range chunks {
+		if _, seen := nearest[chunk.RecordID]; seen {
+			continue
+		}
+		nearest[chunk.RecordID] = chunk
+		if ids = append(ids, chunk.RecordID); len(ids) == limit {
+			break
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var found []models.ImageEmbedding
+	if err := db.Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.ImageEmbedding, len(found))
+	for _, record := range found {
+		byID[record.ID] = record
+	}
+
+	results := make([]models.ImageEmbedding, 0, len(ids))
+	for _, id := range ids {
+		record, ok := byID[id]
+		if !ok {
+			continue
+		}
+		record.Distance = nearest[id].Distance
+		record.MatchedChunk = nearest[id].Text
+		results = append(results, record)
+	}
+	return results, nil
+}
@@ -0,0 +1,195 @@
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/pablobfonseca/go-image-vector/events"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeleteRecords removes records from db along with the keys in q of the tasks that analyzed
+// them and the files in files that no remaining record references
+func DeleteRecords(ctx context.Context, db *gorm.DB, q *queue.Client, files storage.Storage, records []models.ImageEmbedding) error {
+	for _, record := range records {
+		var framePaths []string
+		if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("record_id = ?", record.ID).Delete(&models.DescriptionChunk{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.VideoFrame{}).Where("record_id = ?", record.ID).Pluck("file_path", &framePaths).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("record_id = ?", record.ID).Delete(&models.VideoFrame{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("record_id = ?", record.ID).Delete(&models.Description{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("record_id = ?", record.ID).Delete(&models.AccessibilityFinding{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&models.ImageEmbedding{}, record.ID).Error; err != nil {
+				return err
+			}
+			return events.Record(tx, events.MediaDeleted, events.Media(&record))
+		}); err != nil {
+			return err
+		}
+
+		if err := q.DeleteRecordTasks(record.ID); err != nil {
+			slog.Error("Error deleting task keys", "record_id", record.ID, "error", err)
+		}
+		if record.IsBatch && record.BatchID != "" {
+			if err := q.DeleteTask(record.BatchID); err != nil {
+				slog.Error("Error deleting batch task keys", "batch_id", record.BatchID, "error", err)
+			}
+		}
+
+		for _, filePath := range append(recordFilePaths(record), framePaths...) {
+			if err := deleteFileIfUnused(ctx, db, q, files, filePath); err != nil {
+				slog.Error("Error deleting file", "file_path", filePath, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// DeleteBatch removes the journey record of a batch in one transaction, with the single-image
+// records of its members when withImages is set. Task keys and the stored files no remaining
+// record references are removed once the transaction commits. It returns the deleted records,
+// or gorm.ErrRecordNotFound when there is no such batch.
+func DeleteBatch(ctx context.Context, db *gorm.DB, q *queue.Client, files storage.Storage, batchID string, withImages bool) ([]models.ImageEmbedding, error) {
+	var deleted []models.ImageEmbedding
+	var framePaths []string
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var batches []models.ImageEmbedding
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Omit("embedding").
+			Where("batch_id = ? AND is_batch = ?", batchID, true).Find(&batches).Error; err != nil {
+			return err
+		}
+		if len(batches) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		deleted = batches
+
+		if withImages {
+			var members []string
+			for _, batch := range batches {
+				members = append(members, recordFilePaths(batch)...)
+			}
+
+			var images []models.ImageEmbedding
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Omit("embedding").
+				Where("is_batch = ? AND file_path IN ?", false, members).Find(&images).Error; err != nil {
+				return err
+			}
+			deleted = append(deleted, images...)
+		}
+
+		ids := make([]uint, len(deleted))
+		for i, record := range deleted {
+			ids[i] = record.ID
+			if err := events.Record(tx, events.MediaDeleted, events.Media(&record)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("record_id IN ?", ids).Delete(&models.DescriptionChunk{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.VideoFrame{}).Where("record_id IN ?", ids).Pluck("file_path", &framePaths).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("record_id IN ?", ids).Delete(&models.VideoFrame{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("record_id IN ?", ids).Delete(&models.Description{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("record_id IN ?", ids).Delete(&models.AccessibilityFinding{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.ImageEmbedding{}, ids).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := q.DeleteTask(batchID); err != nil {
+		slog.Error("Error deleting batch task keys", "batch_id", batchID, "error", err)
+	}
+	for _, record := range deleted {
+		if err := q.DeleteRecordTasks(record.ID); err != nil {
+			slog.Error("Error deleting task keys", "record_id", record.ID, "error", err)
+		}
+	}
+
+	seen := map[string]bool{}
+	filePaths := framePaths
+	for _, record := range deleted {
+		filePaths = append(filePaths, recordFilePaths(record)...)
+	}
+	for _, filePath := range filePaths {
+		if seen[filePath] {
+			continue
+		}
+		seen[filePath] = true
+		if err := deleteFileIfUnused(ctx, db, q, files, filePath); err != nil {
+			slog.Error("Error deleting file", "file_path", filePath, "error", err)
+		}
+	}
+
+	return deleted, nil
+}
+
+// recordFilePaths lists every stored file a record points to
+func recordFilePaths(record models.ImageEmbedding) []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, filePath := range append([]string{record.FilePath, record.OriginalPath, record.PreviewPath}, record.BatchPaths...) {
+		if filePath != "" && !seen[filePath] {
+			seen[filePath] = true
+			paths = append(paths, filePath)
+		}
+	}
+	return paths
+}
+
+// deleteFileIfUnused removes a stored file unless another record or video frame still
+// references it, which happens as identical uploads share one content-addressed file
+func deleteFileIfUnused(ctx context.Context, db *gorm.DB, q *queue.Client, files storage.Storage, filePath string) error {
+	member, err := json.Marshal([]string{filePath})
+	if err != nil {
+		return err
+	}
+
+	var references int64
+	if err := db.WithContext(ctx).Model(&models.ImageEmbedding{}).
+		Where("file_path = ? OR original_path = ? OR preview_path = ? OR batch_paths @> ?::jsonb", filePath, filePath, filePath, string(member)).
+		Count(&references).Error; err != nil {
+		return err
+	}
+	if references > 0 {
+		return nil
+	}
+	if err := db.WithContext(ctx).Model(&models.VideoFrame{}).Where("file_path = ?", filePath).
+		Count(&references).Error; err != nil {
+		return err
+	}
+	if references > 0 {
+		return nil
+	}
+
+	key := storage.Key(filePath)
+	if err := files.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	return q.UntrackStoredFile(key)
+}
@@ -0,0 +1,157 @@
+package cleanup
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"gorm.io/gorm"
+)
+
+// DuplicateRecord is a record in a cluster of near-identical media
+type DuplicateRecord struct {
+	ID           uint      `json:"id"`
+	FilePath     string    `json:"file_path"`
+	OriginalName string    `json:"original_name,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Similarity is the cosine similarity of a duplicate to the kept record, and that of the
+	// kept record to its most similar duplicate
+	Similarity float64 `json:"similarity"`
+	SizeBytes  int64   `json:"size_bytes"`
+}
+
+// DuplicateCluster groups near-identical records. Keep is the oldest record, the
+// others are candidates for deletion, each as similar to it as the threshold.
+type DuplicateCluster struct {
+	Keep             DuplicateRecord   `json:"keep"`
+	Duplicates       []DuplicateRecord `json:"duplicates"`
+	ReclaimableBytes int64             `json:"reclaimable_bytes"`
+}
+
+// duplicatePair is two records whose embeddings are at least as similar as the threshold
+type duplicatePair struct {
+	A          uint
+	B          uint
+	Similarity float64
+}
+
+// FindDuplicates self-joins the single image records of db to find pairs with a cosine similarity
+// of at least minSimilarity, and groups connected pairs into clusters. Records sharing one
+// stored file are skipped, as deleting them reclaims nothing. The join compares every pair
+// of records, so it is meant for occasional admin runs rather than requests.
+func FindDuplicates(ctx context.Context, db *gorm.DB, q *queue.Client, minSimilarity float64) ([]DuplicateCluster, error) {
+	var pairs []duplicatePair
+	if err := db.WithContext(ctx).Raw(`
+		SELECT a.id AS a, b.id AS b, 1 - (a.embedding <=> b.embedding) AS similarity
+		FROM image_embeddings a
+		JOIN image_embeddings b ON a.id < b.id AND a.file_path <> b.file_path
+		WHERE a.is_batch = false AND b.is_batch = false
+			AND 1 - (a.embedding <=> b.embedding) >= ?`, minSimilarity).Scan(&pairs).Error; err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	ids := map[uint]bool{}
+	for _, pair := range pairs {
+		ids[pair.A], ids[pair.B] = true, true
+	}
+	recordIDs := make([]uint, 0, len(ids))
+	for id := range ids {
+		recordIDs = append(recordIDs, id)
+	}
+
+	var records []models.ImageEmbedding
+	if err := db.WithContext(ctx).Omit("embedding").Where("id IN ?", recordIDs).
+		Order("created_at, id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return groupDuplicates(pairs, records, func(record models.ImageEmbedding) int64 {
+		return storedSize(q, record)
+	}), nil
+}
+
+// groupDuplicates groups the records of pairs, ordered oldest first, into clusters keeping the
+// oldest record of each. Union-find joins pairs sharing a record, so a chain of similar records
+// can link two that are not similar themselves: only records as similar to the kept one as the
+// threshold of pairs are listed as its duplicates, the others are left alone.
+func groupDuplicates(pairs []duplicatePair, records []models.ImageEmbedding, size func(models.ImageEmbedding) int64) []DuplicateCluster {
+	parent := map[uint]uint{}
+	var find func(id uint) uint
+	find = func(id uint) uint {
+		if _, ok := parent[id]; !ok {
+			parent[id] = id
+		}
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+
+	similarity := map[[2]uint]float64{}
+	for _, pair := range pairs {
+		parent[find(pair.A)] = find(pair.B)
+		similarity[[2]uint{pair.A, pair.B}] = pair.Similarity
+		similarity[[2]uint{pair.B, pair.A}] = pair.Similarity
+	}
+
+	// Records are ordered oldest first, so the first record of each cluster is kept
+	byRoot := map[uint]*DuplicateCluster{}
+	var roots []uint
+	for _, record := range records {
+		duplicate := DuplicateRecord{
+			ID:           record.ID,
+			FilePath:     record.FilePath,
+			OriginalName: record.OriginalName,
+			CreatedAt:    record.CreatedAt,
+			SizeBytes:    size(record),
+		}
+
+		root := find(record.ID)
+		cluster, ok := byRoot[root]
+		if !ok {
+			byRoot[root] = &DuplicateCluster{Keep: duplicate}
+			roots = append(roots, root)
+			continue
+		}
+		kept, ok := similarity[[2]uint{cluster.Keep.ID, record.ID}]
+		if !ok {
+			continue
+		}
+		duplicate.Similarity = kept
+		cluster.Keep.Similarity = max(cluster.Keep.Similarity, kept)
+		cluster.Duplicates = append(cluster.Duplicates, duplicate)
+		cluster.ReclaimableBytes += duplicate.SizeBytes
+	}
+
+	clusters := make([]DuplicateCluster, 0, len(roots))
+	for _, root := range roots {
+		if cluster := byRoot[root]; len(cluster.Duplicates) > 0 {
+			clusters = append(clusters, *cluster)
+		}
+	}
+
+	// Largest savings first
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].ReclaimableBytes > clusters[j].ReclaimableBytes
+	})
+
+	return clusters
+}
+
+// storedSize is the tracked size of the files of a record, 0 when unknown
+func storedSize(q *queue.Client, record models.ImageEmbedding) int64 {
+	var size int64
+	for _, filePath := range recordFilePaths(record) {
+		fileSize, err := q.StoredFileSize(storage.Key(filePath))
+		if err == nil {
+			size += fileSize
+		}
+	}
+	return size
+}
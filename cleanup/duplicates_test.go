@@ -0,0 +1,44 @@
+package cleanup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+)
+
+func TestGroupDuplicatesChain(t *testing.T) {
+	// A is similar to B and B to C, but A and C are further apart than the threshold
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []models.ImageEmbedding{
+		{ID: 1, FilePath: "a.png", CreatedAt: start},
+		{ID: 2, FilePath: "b.png", CreatedAt: start.Add(time.Hour)},
+		{ID: 3, FilePath: "c.png", CreatedAt: start.Add(2 * time.Hour)},
+	}
+	pairs := []duplicatePair{
+		{A: 1, B: 2, Similarity: 0.97},
+		{A: 2, B: 3, Similarity: 0.96},
+	}
+
+	clusters := groupDuplicates(pairs, records, func(models.ImageEmbedding) int64 { return 100 })
+	if len(clusters) != 1 {
+		t.Fatalf("got %d clusters, want 1", len(clusters))
+	}
+	cluster := clusters[0]
+	if cluster.Keep.ID != 1 {
+		t.Errorf("kept record %d, want the oldest, 1", cluster.Keep.ID)
+	}
+	if len(cluster.Duplicates) != 1 || cluster.Duplicates[0].ID != 2 {
+		t.Fatalf("duplicates = %+v, want only record 2, as 3 is not similar to the kept record", cluster.Duplicates)
+	}
+	if cluster.Duplicates[0].Similarity != 0.97 || cluster.ReclaimableBytes != 100 {
+		t.Errorf("duplicate similarity %v reclaiming %d bytes, want 0.97 and 100", cluster.Duplicates[0].Similarity, cluster.ReclaimableBytes)
+	}
+
+	// Once A and C are similar too, C is listed as well
+	pairs = append(pairs, duplicatePair{A: 1, B: 3, Similarity: 0.95})
+	clusters = groupDuplicates(pairs, records, func(models.ImageEmbedding) int64 { return 100 })
+	if len(clusters) != 1 || len(clusters[0].Duplicates) != 2 || clusters[0].ReclaimableBytes != 200 {
+		t.Errorf("clusters = %+v, want records 2 and 3 listed", clusters)
+	}
+}
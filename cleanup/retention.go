@@ -0,0 +1,128 @@
+package cleanup
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// retentionLock is the lock a retention sweep holds, so one process sweeps per interval
+const retentionLock = "retention"
+
+// RunRetention periodically deletes records of db older than the configured retention
+// until the context is cancelled. It does nothing when no retention is configured. Every
+// worker process runs it, and the first to take the retention lock in an interval sweeps.
+func RunRetention(ctx context.Context, db *gorm.DB, q *queue.Client, files storage.Storage) {
+	if retentionDays(false) <= 0 && retentionDays(true) <= 0 {
+		return
+	}
+
+	interval := viper.GetDuration("RETENTION_INTERVAL")
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	slog.Info("Retention enabled", "image_days", retentionDays(false),
+		"batch_days", retentionDays(true), "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sweep(ctx, db, q, files, interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep applies retention unless another process took the retention lock of this interval.
+// The lock is not released, so it expires an interval after it was taken, and is refreshed
+// while a sweep takes longer. Without Redis, retention is applied unlocked.
+func sweep(ctx context.Context, db *gorm.DB, q *queue.Client, files storage.Storage, interval time.Duration) {
+	if q != nil {
+		lock, ok, err := q.TryLock(retentionLock, interval)
+		if err != nil {
+			slog.Warn("Error taking retention lock, skipping this sweep", "error", err)
+			return
+		}
+		if !ok {
+			slog.Debug("Retention swept by another process in this interval")
+			return
+		}
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(interval / 3)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if held, err := lock.Refresh(interval); err != nil || !held {
+						slog.Warn("Retention lock lost while sweeping", "error", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	if err := ApplyRetention(ctx, db, q, files); err != nil {
+		slog.Error("Error applying retention", "error", err)
+	}
+}
+
+// ApplyRetention deletes every record past its retention period
+func ApplyRetention(ctx context.Context, db *gorm.DB, q *queue.Client, files storage.Storage) error {
+	for _, isBatch := range []bool{false, true} {
+		days := retentionDays(isBatch)
+		if days <= 0 {
+			continue
+		}
+
+		cutoff := time.Now().AddDate(0, 0, -days)
+		for {
+			var expired []models.ImageEmbedding
+			if err := db.WithContext(ctx).Where("is_batch = ? AND created_at < ?", isBatch, cutoff).
+				Limit(100).Find(&expired).Error; err != nil {
+				return err
+			}
+			if len(expired) == 0 {
+				break
+			}
+
+			if err := DeleteRecords(ctx, db, q, files, expired); err != nil {
+				return err
+			}
+			slog.Info("Retention removed expired records", "count", len(expired), "days", days)
+		}
+	}
+
+	return nil
+}
+
+// retentionDays returns the retention for single images or batches, falling back
+// to the global RETENTION_DAYS when no specific value is set
+func retentionDays(isBatch bool) int {
+	key := "RETENTION_IMAGE_DAYS"
+	if isBatch {
+		key = "RETENTION_BATCH_DAYS"
+	}
+
+	if viper.IsSet(key) && viper.GetString(key) != "" {
+		return viper.GetInt(key)
+	}
+	return viper.GetInt("RETENTION_DAYS")
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pablobfonseca/go-image-vector/admin"
+	"github.com/pablobfonseca/go-image-vector/cleanup"
+	"github.com/pablobfonseca/go-image-vector/config"
+	"github.com/pablobfonseca/go-image-vector/database"
+	"github.com/pablobfonseca/go-image-vector/deliveries"
+	"github.com/pablobfonseca/go-image-vector/health"
+	"github.com/pablobfonseca/go-image-vector/logging"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/reporting"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/tracing"
+	"github.com/pablobfonseca/go-image-vector/version"
+	"github.com/pablobfonseca/go-image-vector/worker"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// appConfig is the configuration loaded before any command runs
+var appConfig *config.Config
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// newRootCommand builds the CLI. Every command shares the configuration flags, and
+// running without a command starts the API server.
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "go-image-vector",
+		Short:         "Semantic image search with Ollama and pgvector",
+		Version:       fmt.Sprintf("%s (commit %s, built %s)", version.Version, version.Commit, version.Date),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load(cmd.Flags())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+			appConfig = cfg
+
+			logging.Setup()
+			return nil
+		},
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			serve(appConfig)
+		},
+	}
+	root.PersistentFlags().AddFlagSet(config.Flags(root.Use))
+
+	root.AddCommand(
+		newServeCommand(),
+		newWorkerCommand(),
+		newMigrateCommand(),
+		newIngestCommand(),
+		newSearchCommand(),
+		newCleanupCommand(),
+		newDuplicatesCommand(),
+		newQueueCommand(),
+		newMCPCommand(),
+		newSeedCommand(),
+		newTUICommand(),
+		newBenchCommand(),
+		newDoctorCommand(),
+		newWebhooksCommand(),
+	)
+
+	return root
+}
+
+func newServeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Run the HTTP API with an embedded worker pool",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			serve(appConfig)
+		},
+	}
+}
+
+func newWorkerCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "worker",
+		Short: "Process queued analysis tasks",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runWorker(appConfig)
+		},
+	}
+}
+
+func newMigrateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate",
+		Short: "Create or update the database schema",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return database.Migrate(database.Connect())
+		},
+	}
+}
+
+func newIngestCommand() *cobra.Command {
+	var concurrency int
+	var force bool
+	var queueName, priority string
+
+	cmd := &cobra.Command{
+		Use:   "ingest <file or directory>...",
+		Short: "Store local images and queue them for analysis",
+		Long: "Walks the given files and directories, stores every supported image and queues it for analysis.\n" +
+			"Files with the same content are only ingested once, and content that is already stored is skipped.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target, err := parseTaskTarget(queueName, priority)
+			if err != nil {
+				return err
+			}
+
+			s := newServer(nil, queue.Connect(), storage.Initialize())
+			initScanner()
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return s.ingest(ctx, args, concurrency, force, target)
+		},
+	}
+	cmd.Flags().IntVarP(&concurrency, "concurrency", "c", 4, "Number of files processed at once")
+	cmd.Flags().BoolVar(&force, "force", false, "Queue files even if their content was already stored")
+	cmd.Flags().StringVar(&queueName, "queue", "", "Queue for the analysis tasks (default the first of QUEUES)")
+	cmd.Flags().StringVar(&priority, "priority", queue.PriorityNormal, "Task priority: high, normal or low")
+
+	return cmd
+}
+
+func newSearchCommand() *cobra.Command {
+	var opts searchOptions
+
+	cmd := &cobra.Command{
+		Use:   "search [query]",
+		Short: "Search analyzed images by text",
+		Long: "Searches the database directly, or a running server with --api, and prints a table of\n" +
+			"results ordered by distance (lower is closer). Use --json for scripting. --like adds stored\n" +
+			"images as examples, combined with the query text into one query, and --image searches like\n" +
+			"a local image instead. --near keeps only photos whose EXIF location lies within --radius-km\n" +
+			"of a point.",
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSearch(cmd.Context(), strings.Join(args, " "), opts)
+		},
+	}
+	cmd.Flags().IntVarP(&opts.topK, "top-k", "k", 5, "Number of results")
+	cmd.Flags().StringVar(&opts.kind, "kind", searchKindAll, "Records to search: all, batch (journeys only) or image (single images only)")
+	cmd.Flags().StringVar(&opts.field, "field", "", "Embedding to match: description, summary, chunks or frames (default SEARCH_FIELD)")
+	cmd.Flags().StringVar(&opts.label, "label", "", "Only records with this UI state label: error, empty, success, loading or other")
+	cmd.Flags().StringVar(&opts.issue, "accessibility-issue", "", "Only records audited with this accessibility issue: contrast, missing_label, tap_target, text_size or other")
+	cmd.Flags().StringVar(&opts.mediaType, "media-type", "", "Only records of this media type, such as image/png or video/*")
+	cmd.Flags().StringVar(&opts.batchID, "batch-id", "", "Only the journey and image records of this batch")
+	cmd.Flags().StringVar(&opts.since, "since", "", "Only records created at or after this RFC 3339 time")
+	cmd.Flags().StringVar(&opts.until, "until", "", "Only records created before this RFC 3339 time")
+	cmd.Flags().StringVar(&opts.rank, "rank", rankSimilarity, "Ranking: similarity, recency to favor newer records, or hybrid to also match the words of the query")
+	cmd.Flags().DurationVar(&opts.halfLife, "half-life", 0, "Recency half-life (default SEARCH_RECENCY_HALF_LIFE)")
+	cmd.Flags().UintSliceVar(&opts.like, "like", nil, "ID of a stored record to search like, repeatable")
+	cmd.Flags().StringVar(&opts.image, "image", "", "Path of an image to search like, instead of a query")
+	cmd.Flags().Float64Var(&opts.minScore, "min-score", 0, "Only results scoring at least this, from 0 to 1")
+	cmd.Flags().BoolVar(&opts.exact, "exact", false, "Scan every record instead of using the approximate index")
+	cmd.Flags().StringVar(&opts.near, "near", "", "Only photos taken near this lat,lon, such as 48.8584,2.2945")
+	cmd.Flags().Float64Var(&opts.radiusKm, "radius-km", defaultRadiusKm, "Radius around --near in kilometers")
+	cmd.Flags().StringVar(&opts.apiURL, "api", "", "Search through the API at this URL instead of the database")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "Print results as JSON")
+	cmd.Flags().IntVar(&opts.width, "width", 80, "Maximum description length in the table, 0 for no limit")
+
+	return cmd
+}
+
+func newSeedCommand() *cobra.Command {
+	var exportPath string
+
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Load bundled sample images so search works without analyzing anything",
+		Long: "Stores a bundled set of sample images with pre-computed descriptions, so a new installation can\n" +
+			"try search without running the vision model. Samples that are already present are skipped.\n" +
+			"--export-embeddings writes the manifest with embeddings from EMBEDDING_MODEL to a file instead.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if exportPath != "" {
+				return exportSampleEmbeddings(cmd.Context(), exportPath)
+			}
+
+			s := newServer(connectDatabase(appConfig), queue.Connect(), storage.Initialize())
+			initScanner()
+			return s.seed(cmd.Context())
+		},
+	}
+	cmd.Flags().StringVar(&exportPath, "export-embeddings", "", "Write the sample manifest with embeddings to this path, e.g. samples/manifest.json")
+
+	return cmd
+}
+
+func newTUICommand() *cobra.Command {
+	var queueName string
+	var topK int
+
+	cmd := &cobra.Command{
+		Use:   "tui",
+		Short: "Browse queue status, recent ingests and search results in the terminal",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s := newServer(database.Connect(), queue.Connect(), storage.Initialize())
+
+			// Log output would corrupt the screen, errors are shown in the UI instead
+			slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			_, err := tea.NewProgram(newTUIModel(ctx, s, queueName, topK), tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&queueName, "queue", queue.ImageProcessingQueue, "Queue to show the status of")
+	cmd.Flags().IntVarP(&topK, "top-k", "k", 10, "Number of search results")
+
+	return cmd
+}
+
+func newBenchCommand() *cobra.Command {
+	var opts benchOptions
+
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Load test a running server with synthetic uploads and searches",
+		Long: "Uploads generated images and runs search queries against the API at fixed rates, then reports\n" +
+			"p50/p95/p99 latencies for uploads, analysis (with --wait-tasks) and searches. Requests that would\n" +
+			"exceed --max-in-flight are dropped and counted, so a saturated server shows up as drops.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return runBench(ctx, opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.apiURL, "api", "http://localhost:8080", "URL of the server to benchmark")
+	cmd.Flags().DurationVarP(&opts.duration, "duration", "d", 30*time.Second, "How long to generate load")
+	cmd.Flags().Float64Var(&opts.uploadRate, "upload-rate", 1, "Uploads started per second, 0 to disable")
+	cmd.Flags().Float64Var(&opts.searchRate, "search-rate", 5, "Searches started per second, 0 to disable")
+	cmd.Flags().IntVar(&opts.maxInFlight, "max-in-flight", 50, "Maximum concurrent requests of each kind")
+	cmd.Flags().BoolVar(&opts.waitTasks, "wait-tasks", true, "Poll uploaded tasks and report end-to-end ingest latency")
+	cmd.Flags().DurationVar(&opts.drain, "drain", 2*time.Minute, "How long to wait for in-flight requests and tasks after the run")
+	cmd.Flags().IntVarP(&opts.topK, "top-k", "k", 5, "Number of results per search")
+	cmd.Flags().StringArrayVarP(&opts.queries, "query", "q", nil, "Search query to use, repeatable (default: a built-in set)")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "Print the report as JSON")
+
+	return cmd
+}
+
+func newCleanupCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cleanup",
+		Short: "Delete records past their retention period once",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s := newServer(database.Connect(), queue.Connect(), storage.Initialize())
+			return cleanup.ApplyRetention(cmd.Context(), s.db, s.queue, s.files)
+		},
+	}
+}
+
+func newDuplicatesCommand() *cobra.Command {
+	var opts duplicatesOptions
+
+	cmd := &cobra.Command{
+		Use:   "duplicates",
+		Short: "Find clusters of near-identical images to reclaim storage",
+		Long: "Compares the embeddings of every pair of single image records and groups those with a cosine\n" +
+			"similarity of at least --min-similarity. The oldest record of each cluster is kept and the others\n" +
+			"are listed for deletion, with the storage they would free. --delete removes them.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s := newServer(database.Connect(), queue.Connect(), storage.Initialize())
+			return s.runDuplicates(cmd.Context(), opts)
+		},
+	}
+	cmd.Flags().Float64Var(&opts.minSimilarity, "min-similarity", 0.98, "Minimum cosine similarity for two images to be duplicates")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "Print clusters as JSON")
+	cmd.Flags().BoolVar(&opts.delete, "delete", false, "Delete every duplicate except the oldest of each cluster")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Do not ask for confirmation before deleting")
+
+	return cmd
+}
+
+func newDoctorCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check Postgres, pgvector, Redis, Ollama and the configured models",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(health.Doctor(os.Stdout))
+		},
+	}
+}
+
+// runWorker processes queued tasks until interrupted
+func runWorker(cfg *config.Config) {
+	stopTelemetry := startTelemetry("go-image-vector-worker")
+	defer stopTelemetry()
+
+	s := newServer(connectDatabase(cfg), queue.Connect(), storage.Initialize())
+
+	// Setup context with cancellation for clean shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slog.Info("Starting workers", "count", cfg.WorkerCount, "version", version.Version, "commit", version.Commit)
+
+	// Start worker pool
+	workerPool := worker.RunWorkers(ctx, s.workerDeps(), cfg.Queues, cfg.WorkerCount)
+
+	// Setup signal handling for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	// Let the tasks in progress finish, requeueing those still running at the deadline
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer drainCancel()
+	if err := workerPool.Drain(drainCtx); err != nil {
+		slog.Warn("Tasks interrupted by the shutdown timeout were requeued", "error", err)
+	}
+}
+
+// startTelemetry enables config reloads, error reporting, tracing and the admin
+// server for long-running commands. The returned function flushes and stops them.
+func startTelemetry(serviceName string) func() {
+	config.Watch()
+
+	flushReports := reporting.Initialize(serviceName)
+	shutdownTracing := tracing.Initialize(serviceName)
+	stopAdmin := admin.Start()
+
+	return func() {
+		stopAdmin()
+		shutdownTracing()
+		flushReports()
+	}
+}
+
+// connectDatabase connects, migrates the schema unless DB_AUTO_MIGRATE is off, and logs the
+// webhook deliveries of the process to the database
+func connectDatabase(cfg *config.Config) *gorm.DB {
+	db := database.Connect()
+
+	if cfg.AutoMigrate {
+		if err := database.Migrate(db); err != nil {
+			logging.Fatal("Failed to migrate database", "error", err)
+		}
+	}
+	deliveries.Install(db)
+	return db
+}
+
+// initScanner configures the upload scanner, if any
+func initScanner() {
+	var err error
+	if scanner, err = services.NewScanner(); err != nil {
+		logging.Fatal("Failed to configure upload scanner", "error", err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/database"
+	"github.com/pablobfonseca/go-image-vector/mcp"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/version"
+	"github.com/spf13/cobra"
+)
+
+func newMCPCommand() *cobra.Command {
+	var publicURL string
+
+	cmd := &cobra.Command{
+		Use:   "mcp",
+		Short: "Run a Model Context Protocol server on stdio",
+		Long: "Serves the search_images and ask_corpus tools over MCP on stdin/stdout, so agents can\n" +
+			"query the image library. Register it in an MCP client as the command \"go-image-vector mcp\".",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s := newServer(database.Connect(), queue.Connect(), storage.Initialize())
+
+			server := &mcp.Server{
+				Name:    "go-image-vector",
+				Version: version.Version,
+				Tools:   s.mcpTools(strings.TrimSuffix(publicURL, "/")),
+			}
+			return server.Serve(cmd.Context(), os.Stdin, os.Stdout)
+		},
+	}
+	cmd.Flags().StringVar(&publicURL, "public-url", "", "Base URL of the API, used to turn file paths into links")
+
+	return cmd
+}
+
+// mcpTools exposes search and question answering over the analyzed images
+func (s *server) mcpTools(publicURL string) []mcp.Tool {
+	topK := map[string]any{
+		"type":        "integer",
+		"description": "Number of images to retrieve (default 5)",
+		"minimum":     1,
+		"maximum":     50,
+	}
+
+	return []mcp.Tool{
+		{
+			Name:        "search_images",
+			Description: "Search the screenshot and image library by meaning. Returns the closest images with their file links and AI-generated descriptions.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{"type": "string", "description": "What to look for, in natural language"},
+					"top_k": topK,
+					"kind": map[string]any{
+						"type":        "string",
+						"enum":        []string{searchKindAll, searchKindBatch, searchKindImage},
+						"description": "Search multi-image journeys (batch), single images (image) or both (all, the default)",
+					},
+					"rank": map[string]any{
+						"type":        "string",
+						"enum":        []string{rankSimilarity, rankRecency, rankHybrid},
+						"description": "Order by similarity alone (default), by recency to favor current screenshots over old ones, or hybrid to also match exact words such as error codes",
+					},
+					"field": map[string]any{
+						"type":        "string",
+						"enum":        []string{searchFieldDescription, searchFieldSummary, searchFieldChunks},
+						"description": "Match the full descriptions, their one-paragraph summaries, which often retrieve better for short queries, or their chunks, for parts of long journeys",
+					},
+				},
+				"required": []string{"query"},
+			},
+			Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+				var args struct {
+					Query string `json:"query"`
+					TopK  int    `json:"top_k"`
+					Kind  string `json:"kind"`
+					Rank  string `json:"rank"`
+					Field string `json:"field"`
+				}
+				if err := json.Unmarshal(arguments, &args); err != nil {
+					return "", err
+				}
+				if !validSearchKind(args.Kind) {
+					return "", fmt.Errorf("kind must be one of all, batch or image")
+				}
+				if !validSearchRank(args.Rank) {
+					return "", fmt.Errorf("rank must be one of similarity, recency or hybrid")
+				}
+				if args.Rank == rankHybrid && !validHybridField(args.Field) {
+					return "", fmt.Errorf("hybrid ranking matches whole descriptions, so field must be description or summary")
+				}
+				if !validSearchField(args.Field) {
+					return "", fmt.Errorf("field must be one of description, summary or chunks")
+				}
+
+				results, err := s.retrieve(ctx, args.Query, searchParams{TopK: args.TopK, Kind: args.Kind, Field: args.Field, Rank: args.Rank})
+				if err != nil {
+					return "", err
+				}
+				if len(results) == 0 {
+					return "No images found.", nil
+				}
+
+				var text strings.Builder
+				for i, result := range results {
+					fmt.Fprintf(&text, "%d. %s (distance %.4f)\n%s\n\n", i+1, fileLink(publicURL, result.FilePath), result.Distance, result.Text)
+				}
+				return text.String(), nil
+			},
+		},
+		{
+			Name:        "ask_corpus",
+			Description: "Answer a question from the screenshot and image library. Retrieves the most relevant images and answers from their descriptions, citing the sources.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"question": map[string]any{"type": "string", "description": "The question to answer"},
+					"top_k":    topK,
+				},
+				"required": []string{"question"},
+			},
+			Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+				var args struct {
+					Question string `json:"question"`
+					TopK     int    `json:"top_k"`
+				}
+				if err := json.Unmarshal(arguments, &args); err != nil {
+					return "", err
+				}
+
+				results, err := s.retrieve(ctx, args.Question, searchParams{TopK: args.TopK})
+				if err != nil {
+					return "", err
+				}
+				if len(results) == 0 {
+					return "The library has no images to answer from.", nil
+				}
+
+				descriptions := make([]string, len(results))
+				for i, result := range results {
+					descriptions[i] = result.Text
+				}
+
+				answer, err := s.services.AnswerQuestion(ctx, args.Question, descriptions)
+				if err != nil {
+					return "", err
+				}
+
+				var text strings.Builder
+				text.WriteString(strings.TrimSpace(answer))
+				text.WriteString("\n\nSources:\n")
+				for i, result := range results {
+					fmt.Fprintf(&text, "[%d] %s\n", i+1, fileLink(publicURL, result.FilePath))
+				}
+				return text.String(), nil
+			},
+		},
+	}
+}
+
+// retrieve embeds the text and returns the closest records
+func (s *server) retrieve(ctx context.Context, text string, params searchParams) ([]models.ImageEmbedding, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	if params.TopK <= 0 {
+		params.TopK = 5
+	}
+	params.TopK = min(params.TopK, 50)
+
+	embedding, err := s.services.GenerateEmbedding(ctx, text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate embedding: %v", err)
+	}
+	params.Text = text
+	return s.findSimilar(ctx, embedding, params)
+}
+
+// fileLink prefixes a stored file path with the public API URL when one is configured
+func fileLink(publicURL string, filePath string) string {
+	if publicURL == "" {
+		return filePath
+	}
+	return publicURL + "/" + strings.TrimPrefix(filePath, "/")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/spf13/cobra"
+)
+
+// queueTarget is the queue the queue commands act on, with the client connected before they run
+type queueTarget struct {
+	name   string
+	client *queue.Client
+}
+
+// newQueueCommand groups the queue administration commands
+func newQueueCommand() *cobra.Command {
+	target := &queueTarget{}
+
+	cmd := &cobra.Command{
+		Use:   "queue",
+		Short: "Inspect and manage the task queue and its dead letters",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := cmd.Root().PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			target.client = queue.Connect()
+			return target.client.Ping(cmd.Context())
+		},
+	}
+	cmd.PersistentFlags().StringVar(&target.name, "queue", queue.ImageProcessingQueue, "Queue name")
+
+	cmd.AddCommand(
+		newQueueListCommand(target),
+		newQueueStatsCommand(target),
+		newQueueRequeueCommand(target),
+		newQueuePurgeCommand(target),
+	)
+
+	return cmd
+}
+
+func newQueueListCommand(target *queueTarget) *cobra.Command {
+	var dead, asJSON bool
+	var limit int64
+
+	cmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List pending tasks, or dead letters with --dead",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var tasks []queue.TaskPayload
+			var err error
+			if dead {
+				tasks, err = target.client.List(queue.DeadLetterQueue(target.name), 0, limit)
+			} else {
+				tasks, err = target.client.ListPending(target.name, limit)
+			}
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(tasks)
+			}
+
+			if len(tasks) == 0 {
+				fmt.Println("No tasks")
+				return nil
+			}
+
+			table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "TASK ID\tTYPE\tPRIORITY\tAGE\tCATEGORY\tERROR")
+			for _, task := range tasks {
+				fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", task.TaskID, task.TaskType, cmp.Or(task.Priority, queue.PriorityNormal),
+					time.Since(task.Created).Round(time.Second), task.ErrorCategory, truncate(task.LastError, 60))
+			}
+			return table.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&dead, "dead", false, "List dead letters instead of pending tasks")
+	cmd.Flags().Int64Var(&limit, "limit", 50, "Maximum number of tasks to list")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print tasks as JSON")
+
+	return cmd
+}
+
+func newQueueStatsCommand(target *queueTarget) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats",
+		Short: "Show pending and dead letter counts and the age of the oldest task",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stats, err := target.client.Stats(target.name)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Queue:         %s\n", stats.Queue)
+			fmt.Printf("Pending:       %d (%d high, %d normal, %d low)\n", stats.Pending,
+				stats.ByPriority[queue.PriorityHigh], stats.ByPriority[queue.PriorityNormal], stats.ByPriority[queue.PriorityLow])
+			fmt.Printf("Dead letters:  %d\n", stats.DeadLetters)
+			if stats.Pending > 0 {
+				fmt.Printf("Oldest task:   %s\n", stats.OldestAge.Round(time.Second))
+			}
+			return nil
+		},
+	}
+}
+
+func newQueueRequeueCommand(target *queueTarget) *cobra.Command {
+	var limit int
+	var retryableOnly bool
+
+	cmd := &cobra.Command{
+		Use:   "requeue-dlq",
+		Short: "Move dead letters back to the queue for another attempt",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			requeued, err := target.client.RequeueDeadLetters(target.name, limit, retryableOnly)
+			fmt.Printf("Requeued %d tasks\n", requeued)
+			return err
+		},
+	}
+	cmd.Flags().IntVar(&limit, "limit", 0, "Maximum number of dead letters to requeue, 0 for all")
+	cmd.Flags().BoolVar(&retryableOnly, "retryable", false, "Only requeue dead letters whose failure is retryable")
+
+	return cmd
+}
+
+func newQueuePurgeCommand(target *queueTarget) *cobra.Command {
+	var dead, yes bool
+
+	cmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Delete every pending task, or every dead letter with --dead",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := target.name
+			if dead {
+				name = queue.DeadLetterQueue(name)
+			}
+
+			if !yes && !confirm(fmt.Sprintf("Delete every task in %s?", name)) {
+				return fmt.Errorf("aborted")
+			}
+
+			purge := target.client.PurgePending
+			if dead {
+				purge = target.client.Purge
+			}
+			purged, err := purge(name)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Purged %d tasks from %s\n", purged, name)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dead, "dead", false, "Purge dead letters instead of pending tasks")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Do not ask for confirmation")
+
+	return cmd
+}
+
+// confirm asks a yes/no question on the terminal
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
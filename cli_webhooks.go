@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/database"
+	"github.com/pablobfonseca/go-image-vector/deliveries"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// newWebhooksCommand groups the commands that inspect and replay failed webhook deliveries
+func newWebhooksCommand() *cobra.Command {
+	var db *gorm.DB
+
+	cmd := &cobra.Command{
+		Use:   "webhooks",
+		Short: "List and replay failed webhook and notification deliveries",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := cmd.Root().PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			db = database.Connect()
+			return nil
+		},
+	}
+
+	cmd.AddCommand(
+		newWebhooksListCommand(&db),
+		newWebhooksReplayCommand(&db),
+	)
+
+	return cmd
+}
+
+func newWebhooksListCommand(db **gorm.DB) *cobra.Command {
+	var limit int
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List failed deliveries that were not replayed since, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			failed, _, err := deliveries.ListFailed(cmd.Context(), *db, limit, 0)
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(failed)
+			}
+
+			if len(failed) == 0 {
+				fmt.Println("No failed deliveries")
+				return nil
+			}
+
+			table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "ID\tSOURCE\tAGE\tSTATUS\tLATENCY\tERROR")
+			for _, delivery := range failed {
+				fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%dms\t%s\n", delivery.ID, delivery.Source,
+					time.Since(delivery.CreatedAt).Round(time.Second), deliveryStatus(delivery), delivery.LatencyMS, truncate(delivery.Error, 60))
+			}
+			return table.Flush()
+		},
+	}
+	cmd.Flags().IntVar(&limit, "limit", 50, "Maximum number of deliveries to list")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print deliveries as JSON")
+
+	return cmd
+}
+
+func newWebhooksReplayCommand(db **gorm.DB) *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "replay [delivery ID...]",
+		Short: "Post failed deliveries again, by ID or every one with --all",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all == (len(args) > 0) {
+				return fmt.Errorf("give delivery IDs or --all")
+			}
+
+			ids := make([]uint, 0, len(args))
+			for _, arg := range args {
+				id, err := strconv.ParseUint(arg, 10, 64)
+				if err != nil || id == 0 {
+					return fmt.Errorf("invalid delivery ID %q", arg)
+				}
+				ids = append(ids, uint(id))
+			}
+			if all {
+				failed, _, err := deliveries.ListFailed(cmd.Context(), *db, -1, 0)
+				if err != nil {
+					return err
+				}
+				for _, delivery := range failed {
+					ids = append(ids, delivery.ID)
+				}
+			}
+
+			var failures int
+			for _, id := range ids {
+				delivery, err := deliveries.Replay(cmd.Context(), *db, id)
+				if err != nil {
+					return fmt.Errorf("replaying delivery %d: %w", id, err)
+				}
+				if delivery.Failed {
+					failures++
+					fmt.Printf("Delivery %d failed again: %s\n", id, delivery.Error)
+				} else {
+					fmt.Printf("Delivery %d replayed: %s\n", id, deliveryStatus(delivery))
+				}
+			}
+			if failures > 0 {
+				return fmt.Errorf("%d of %d replays failed", failures, len(ids))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "Replay every failed delivery that was not replayed since")
+
+	return cmd
+}
+
+// deliveryStatus is the status code a delivery got, or - when it got no response
+func deliveryStatus(delivery models.WebhookDelivery) string {
+	if delivery.StatusCode == 0 {
+		return "-"
+	}
+	return strconv.Itoa(delivery.StatusCode)
+}
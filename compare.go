@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pgvector/pgvector-go"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// compareToBaseline compares a new screenshot, uploaded as the image field of a multipart
+// form, to the images of a baseline batch, for visual regression monitoring. The screenshot
+// is described and embedded like an upload but never stored. The baseline image nearest to
+// it is its match, and the drift from the match is reported as the cosine distance between
+// their embeddings and the difference between their pixels. A screenshot drifted more than
+// the threshold form field, BASELINE_DRIFT_THRESHOLD by default, also gets the changes the
+// model sees between the descriptions.
+func (s *server) compareToBaseline(w http.ResponseWriter, r *http.Request) {
+	batchID := mux.Vars(r)["id"]
+	if err := parseImageForm(w, r); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	if err := allowQueryParams(r.MultipartForm.Value, "threshold"); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	threshold := viper.GetFloat64("BASELINE_DRIFT_THRESHOLD")
+	if value := r.FormValue("threshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			apierror.Write(w, r, apierror.InvalidParameter("threshold", "threshold must be above 0 and at most 1"))
+			return
+		}
+		threshold = parsed
+	}
+
+	batch, err := s.loadBatch(r, batchID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Write(w, r, apierror.NotFound("Batch not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load batch", err))
+		return
+	}
+
+	// The baseline images are the members of the batch analyzed on their own
+	paths := batchMemberPaths(batch)
+	baseline := visibleRecords(r, s.db.WithContext(r.Context()).Model(&models.ImageEmbedding{})).
+		Where("is_batch = ? AND file_path IN ?", false, paths)
+	var members int64
+	if err := baseline.Session(&gorm.Session{}).Count(&members).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load baseline images", err))
+		return
+	}
+	if members == 0 {
+		apierror.Write(w, r, apierror.New(http.StatusConflict, apierror.CodeConflict,
+			"Batch has no images analyzed on their own to compare to, upload it with per_image=true").With("batch_id", batchID))
+		return
+	}
+
+	image, mediaType, err := readSearchImage(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	description, err := s.services.ExtractTextFromImageData(r.Context(), image, mediaType)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Failed to describe image"))
+		return
+	}
+	embedding, err := s.services.GenerateEmbedding(r.Context(), description)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Failed to generate embedding"))
+		return
+	}
+
+	// The distance is the cosine distance here, the semantic drift
+	var match models.ImageEmbedding
+	if err := baseline.Select("*, embedding <=> ? AS distance", pgvector.NewVector(embedding)).
+		Order("distance").Limit(1).Find(&match).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to search baseline", err))
+		return
+	}
+
+	response := map[string]any{
+		"batch_id":    batchID,
+		"description": description,
+		"baseline": map[string]any{
+			"id":        match.ID,
+			"file_path": match.FilePath,
+			"url":       fileURL(r, match.FilePath),
+			"title":     match.Title,
+			"step":      slices.Index(paths, match.FilePath) + 1,
+		},
+		"cosine_similarity": 1 - match.Distance,
+		"semantic_drift":    match.Distance,
+		"threshold":         threshold,
+		"drifted":           match.Distance > threshold,
+	}
+
+	// Pixels are only compared for formats both images decode from, the drift is still
+	// reported by meaning without them
+	if baselineImage, err := storage.ReadFile(r.Context(), s.files, match.FilePath); err != nil {
+		slog.WarnContext(r.Context(), "Error reading baseline image, comparing without visual drift", "file_path", match.FilePath, "error", err)
+	} else if visual, err := services.VisualDrift(image, baselineImage); err != nil {
+		slog.DebugContext(r.Context(), "Images cannot be compared pixel by pixel", "file_path", match.FilePath, "error", err)
+	} else {
+		response["visual_drift"] = visual
+	}
+
+	if match.Distance > threshold {
+		changes, err := s.services.DescribeDrift(r.Context(), match.Text, description)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Error describing drift, answering without the changes", "error", err)
+		} else {
+			response["changes"] = changes
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
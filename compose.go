@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"gorm.io/gorm"
+)
+
+// queryPart is one element of a composed search query: a text to embed, or the ID of
+// a stored record whose embedding is reused. Weight defaults to 1.
+type queryPart struct {
+	Text   string   `json:"text,omitempty"`
+	ID     uint     `json:"id,omitempty"`
+	Weight *float64 `json:"weight,omitempty"`
+}
+
+// validate checks that the part names exactly one source and has a positive weight
+func (p queryPart) validate() error {
+	if (p.Text == "") == (p.ID == 0) {
+		return fmt.Errorf("each query needs either text or id")
+	}
+	if p.Weight != nil && *p.Weight <= 0 {
+		return fmt.Errorf("query weights must be positive")
+	}
+	return nil
+}
+
+var (
+	// errQueryRecordNotFound is returned when a query part references a missing record
+	errQueryRecordNotFound = errors.New("query record not found")
+	// errQueryEmbedding is returned when a query text could not be embedded
+	errQueryEmbedding = errors.New("failed to generate embedding")
+)
+
+// composeQuery embeds every validated part and combines them into one query vector. It also
+// returns the IDs of the referenced records, so they can be left out of the results. Records
+// contribute their embedding of field, falling back to the description embedding when they
+// have no summary. With publicOnly, private records are not found.
+func (s *server) composeQuery(ctx context.Context, parts []queryPart, field string, publicOnly bool) ([]float32, []uint, error) {
+	vectors := make([][]float32, len(parts))
+	weights := make([]float64, len(parts))
+	var ids []uint
+
+	for i, part := range parts {
+		weights[i] = 1
+		if part.Weight != nil {
+			weights[i] = *part.Weight
+		}
+
+		if part.ID != 0 {
+			var record models.ImageEmbedding
+			query := s.db.WithContext(ctx).Select("id", "embedding", "summary_embedding")
+			if publicOnly {
+				query = query.Where("visibility = ?", models.VisibilityPublic)
+			}
+			err := query.First(&record, part.ID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, fmt.Errorf("%w: %d", errQueryRecordNotFound, part.ID)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			vectors[i] = record.Embedding.Slice()
+			if field == searchFieldSummary && record.SummaryEmbedding != nil {
+				vectors[i] = record.SummaryEmbedding.Slice()
+			}
+			ids = append(ids, part.ID)
+			continue
+		}
+
+		embedding, err := s.services.GenerateEmbedding(ctx, part.Text)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", errQueryEmbedding, err)
+		}
+		vectors[i] = embedding
+	}
+
+	combined, err := combineEmbeddings(vectors, weights)
+	if err != nil {
+		return nil, nil, err
+	}
+	return combined, ids, nil
+}
+
+// combineEmbeddings returns the weighted average of the directions of the vectors, scaled
+// to their mean length. Normalizing first keeps one long vector from dominating the mix.
+func combineEmbeddings(vectors [][]float32, weights []float64) ([]float32, error) {
+	if len(vectors) == 0 {
+		return nil, fmt.Errorf("no query vectors to combine")
+	}
+	if len(vectors) == 1 {
+		return vectors[0], nil
+	}
+
+	dim := len(vectors[0])
+	sum := make([]float64, dim)
+	var meanNorm float64
+
+	for i, vector := range vectors {
+		if len(vector) != dim {
+			return nil, fmt.Errorf("query vectors have different dimensions")
+		}
+
+		var norm float64
+		for _, value := range vector {
+			norm += float64(value) * float64(value)
+		}
+		norm = math.Sqrt(norm)
+		if norm == 0 {
+			continue
+		}
+		meanNorm += norm / float64(len(vectors))
+
+		for j, value := range vector {
+			sum[j] += weights[i] * float64(value) / norm
+		}
+	}
+
+	var sumNorm float64
+	for _, value := range sum {
+		sumNorm += value * value
+	}
+	sumNorm = math.Sqrt(sumNorm)
+	if sumNorm == 0 {
+		return nil, fmt.Errorf("the queries cancel each other out")
+	}
+
+	combined := make([]float32, dim)
+	for j, value := range sum {
+		combined[j] = float32(value / sumNorm * meanNorm)
+	}
+	return combined, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/pablobfonseca/go-image-vector/services/servicestest"
+)
+
+func TestCombineEmbeddings(t *testing.T) {
+	if _, err := combineEmbeddings(nil, nil); err == nil {
+		t.Error("combining no vectors did not fail")
+	}
+	if _, err := combineEmbeddings([][]float32{{1, 0}, {1, 0, 0}}, []float64{1, 1}); err == nil {
+		t.Error("combining vectors of different dimensions did not fail")
+	}
+
+	single := []float32{3, 4}
+	if got, _ := combineEmbeddings([][]float32{single}, []float64{2}); &got[0] != &single[0] {
+		t.Errorf("a single vector was not used as it is: %v", got)
+	}
+
+	// Directions are averaged before scaling, so the longer vector does not dominate
+	got, err := combineEmbeddings([][]float32{{2, 0}, {0, 10}}, []float64{1, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(float64(got[0]-got[1])) > 1e-5 {
+		t.Errorf("equal weights gave %v, want equal components", got)
+	}
+	if norm := math.Hypot(float64(got[0]), float64(got[1])); math.Abs(norm-6) > 1e-4 {
+		t.Errorf("combined length = %v, want the mean length 6", norm)
+	}
+
+	got, _ = combineEmbeddings([][]float32{{1, 0}, {0, 1}}, []float64{3, 1})
+	if got[0] <= got[1] {
+		t.Errorf("weights were ignored: %v", got)
+	}
+}
+
+func TestComposeQueryTexts(t *testing.T) {
+	ollama := servicestest.Start(t)
+	s, _ := newTestServer()
+	s.services = ollama.Clients(nil)
+	weight := 2.0
+	parts := []queryPart{{Text: "login form"}, {Text: "error message", Weight: &weight}}
+
+	embedding, ids, err := s.composeQuery(context.Background(), parts, searchFieldDescription, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embedding) != servicestest.Dimensions || len(ids) != 0 {
+		t.Errorf("got a %d dimension query and ids %v", len(embedding), ids)
+	}
+	if requests := ollama.Requests(); len(requests) != 2 {
+		t.Errorf("embedded %d texts, want 2", len(requests))
+	}
+
+	ollama.Fail(500, "crash")
+	if _, _, err := s.composeQuery(context.Background(), parts, searchFieldDescription, false); !errors.Is(err, errQueryEmbedding) {
+		t.Errorf("failed embedding gave %v, want errQueryEmbedding", err)
+	}
+}
@@ -0,0 +1,200 @@
+package compression
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minSize is the smallest response with a known length worth compressing
+const minSize = 1024
+
+var (
+	gzipPool  = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression); return w }}
+	flatePool = sync.Pool{New: func() any { w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression); return w }}
+)
+
+// Middleware compresses JSON and text responses with gzip or deflate when the
+// client accepts it. Media files are passed through untouched.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate picks gzip or deflate from an Accept-Encoding header, preferring gzip
+func negotiate(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressible reports whether responses of this content type benefit from compression
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		mediaType == "application/x-ndjson",
+		mediaType == "application/javascript",
+		mediaType == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter defers the response header until the first write, when it knows the
+// content type and can decide whether to compress
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	decided  bool
+	writer   io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.decide(p)
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *compressWriter) decide(p []byte) {
+	cw.decided = true
+
+	header := cw.Header()
+	if header.Get("Content-Type") == "" {
+		// Sniff the uncompressed bytes, as net/http would otherwise sniff the compressed ones
+		header.Set("Content-Type", http.DetectContentType(p))
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	small := err == nil && length < minSize
+
+	if header.Get("Content-Encoding") == "" && !small && compressible(header.Get("Content-Type")) &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusNotModified {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+
+		switch cw.encoding {
+		case "gzip":
+			gw := gzipPool.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.writer = &pooledWriter{WriteCloser: gw, release: func() { gzipPool.Put(gw) }}
+		case "deflate":
+			fw := flatePool.Get().(*flate.Writer)
+			fw.Reset(cw.ResponseWriter)
+			cw.writer = &pooledWriter{WriteCloser: fw, release: func() { flatePool.Put(fw) }}
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// Close flushes the compressor, or writes a header set without a body
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		cw.decided = true
+		if cw.status != http.StatusOK {
+			cw.ResponseWriter.WriteHeader(cw.status)
+		}
+		return nil
+	}
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
+
+// Flush sends buffered compressed data to the client, for streamed responses
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		return
+	}
+	if flusher, ok := cw.writer.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets websocket-style handlers take over the connection
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// pooledWriter returns the compressor to its pool once closed
+type pooledWriter struct {
+	io.WriteCloser
+	release func()
+}
+
+func (pw *pooledWriter) Flush() error {
+	if flusher, ok := pw.WriteCloser.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+func (pw *pooledWriter) Close() error {
+	err := pw.WriteCloser.Close()
+	pw.release()
+	return err
+}
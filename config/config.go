@@ -0,0 +1,653 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// DefaultAllowedMediaTypes are the MIME types accepted on upload unless configured otherwise
+var DefaultAllowedMediaTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"image/bmp",
+	"image/heic",
+	"image/avif",
+	"video/mp4",
+	"video/webm",
+	"video/quicktime",
+}
+
+// Config is the typed view of the settings needed at startup. Settings that can
+// change at runtime are still read through viper where they are used, so that
+// configuration reloads apply to them.
+type Config struct {
+	Port        string
+	WorkerCount int
+	Queues      []string
+
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	Compression       bool
+
+	// ShutdownTimeout bounds the wait for requests and tasks in progress on shutdown
+	ShutdownTimeout time.Duration
+	// ServeMode is ServeModeFull or ServeModeSearchOnly
+	ServeMode string
+
+	DBHost      string
+	DBUser      string
+	DBPassword  string
+	DBName      string
+	DBPort      string
+	DBSSLMode   string
+	AutoMigrate bool
+
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+
+	StorageBackend string
+	UploadsDir     string
+	UploadsRoute   string
+
+	OllamaHost     string
+	Model          string
+	EmbeddingModel string
+
+	BatchChunkSize       int
+	BatchMaxParallel     int
+	BatchChunkRetries    int
+	BatchMinChunkSuccess float64
+
+	SearchRecencyHalfLife time.Duration
+	SearchRecencyWeight   float64
+
+	LogLevel  string
+	LogFormat string
+
+	CORSAllowedOrigins   []string
+	CORSAllowCredentials bool
+
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSAutocertDomains  []string
+	TLSAutocertEmail    string
+	TLSAutocertCacheDir string
+	TLSRedirectAddr     string
+}
+
+// TLSEnabled reports whether the API serves HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.TLSAutocertDomains) > 0
+}
+
+// Serve modes. A search-only API runs no workers and serves no endpoints that write, so
+// search replicas can be scaled apart from the nodes ingesting images.
+const (
+	ServeModeFull       = "full"
+	ServeModeSearchOnly = "search-only"
+)
+
+// SearchOnly reports whether the API serves searches only
+func (c *Config) SearchOnly() bool {
+	return c.ServeMode == ServeModeSearchOnly
+}
+
+// setDefaults registers the default value of every setting in one place
+func setDefaults() {
+	viper.SetDefault("PORT", "8080")
+	viper.SetDefault("WORKER_COUNT", 4)
+
+	// HTTP server, the read and write timeouts leave room for large uploads and slow searches
+	viper.SetDefault("SERVER_READ_TIMEOUT", "60s")
+	viper.SetDefault("SERVER_READ_HEADER_TIMEOUT", "10s")
+	viper.SetDefault("SERVER_WRITE_TIMEOUT", "120s")
+	viper.SetDefault("SERVER_IDLE_TIMEOUT", "120s")
+	viper.SetDefault("SHUTDOWN_TIMEOUT", "2m")
+	viper.SetDefault("SERVE_MODE", ServeModeFull)
+	viper.SetDefault("FILE_LOCK_TTL", "1m")
+	viper.SetDefault("FILES_CACHE_MAX_AGE", "1h")
+	viper.SetDefault("DESCRIPTION_LANGUAGE", "en")
+	viper.SetDefault("TRANSLATION_LANGUAGES", "")
+	viper.SetDefault("COMPRESSION_ENABLED", true)
+
+	viper.SetDefault("DB_SSLMODE", "disable")
+	viper.SetDefault("DB_AUTO_MIGRATE", true)
+
+	viper.SetDefault("REDIS_ADDR", "localhost:6379")
+	viper.SetDefault("REDIS_DB", 0)
+	viper.SetDefault("REDIS_PASSWORD", "")
+
+	// Task queues workers consume and uploads may target, the first is the default
+	viper.SetDefault("QUEUES", "image_processing")
+
+	viper.SetDefault("LOG_LEVEL", "info")
+	viper.SetDefault("LOG_FORMAT", "text")
+
+	viper.SetDefault("OLLAMA_HOST", "localhost")
+	viper.SetDefault("MODEL", "gemma3")
+	viper.SetDefault("EMBEDDING_MODEL", "nomic-embed-text")
+
+	// File storage
+	viper.SetDefault("STORAGE_BACKEND", "local")
+	viper.SetDefault("UPLOADS_DIR", "./uploads")
+	viper.SetDefault("UPLOADS_ROUTE", "/uploads/")
+	viper.SetDefault("STORAGE_QUOTA_BYTES", 0)         // 0 disables the quota
+	viper.SetDefault("STORAGE_QUOTA_BYTES_PER_KEY", 0) // of the files uploaded with each API key
+	viper.SetDefault("ALLOWED_MEDIA_TYPES", DefaultAllowedMediaTypes)
+	viper.SetDefault("CONVERT_HEIC_AVIF", true)
+	viper.SetDefault("IMAGE_CONVERTER", "magick")
+
+	// Videos are described and searchable frame by frame, sampled with FFMPEG
+	viper.SetDefault("VIDEO_FRAMES", true)
+	viper.SetDefault("FFMPEG", "ffmpeg")
+	viper.SetDefault("VIDEO_FRAME_INTERVAL", 5*time.Second)
+	viper.SetDefault("VIDEO_MAX_FRAMES", 60)
+	// Videos get a short animated GIF preview for search UIs
+	viper.SetDefault("VIDEO_PREVIEWS", true)
+	viper.SetDefault("VIDEO_PREVIEW_DURATION", 3*time.Second)
+	viper.SetDefault("VIDEO_PREVIEW_WIDTH", 320)
+	viper.SetDefault("VIDEO_PREVIEW_FPS", 10)
+	// Screen recordings uploaded with scenes=true are cut where the picture changes
+	viper.SetDefault("VIDEO_SCENE_THRESHOLD", 0.3)
+	viper.SetDefault("VIDEO_MAX_SCENES", 100)
+
+	// Upload limits
+	viper.SetDefault("MAX_UPLOAD_BYTES", 50<<20) // Max size of a whole upload request
+	viper.SetDefault("MAX_FILE_BYTES", 50<<20)   // Max size of a single file
+	viper.SetDefault("MAX_UPLOAD_FILES", 5)      // Max files per upload
+
+	// Zip archive uploads: most images and decompressed bytes in one archive
+	viper.SetDefault("ARCHIVE_MAX_FILES", 200)
+	viper.SetDefault("ARCHIVE_MAX_BYTES", 500<<20)
+
+	// User supplied captions: how they combine with the vision model output, and their length
+	viper.SetDefault("CAPTION_MODE", "augment")
+	viper.SetDefault("CAPTION_MAX_LENGTH", 2000)
+
+	// Visibility of uploads that do not set one; private records need an API key to be seen
+	viper.SetDefault("DEFAULT_VISIBILITY", "public")
+	// Audit run on uploads without an audit field: none, or accessibility for UI screenshots
+	viper.SetDefault("DEFAULT_AUDIT", "")
+
+	// Share links: lifetime when not given, and the longest one allowed
+	viper.SetDefault("SHARE_DEFAULT_TTL", "168h")
+	viper.SetDefault("SHARE_MAX_TTL", "720h")
+
+	// Generate a short title for every record with MODEL, instead of its first line
+	viper.SetDefault("GENERATE_TITLES", true)
+
+	// Summarize every description in one paragraph with MODEL and embed the summary, so
+	// searches can match summaries instead of full descriptions
+	viper.SetDefault("GENERATE_SUMMARIES", true)
+
+	// Split descriptions into chunks of CHUNK_SIZE characters, overlapping by CHUNK_OVERLAP,
+	// each embedded on its own for searches over chunks
+	viper.SetDefault("CHUNK_DESCRIPTIONS", true)
+	viper.SetDefault("CHUNK_SIZE", 1500)
+	viper.SetDefault("CHUNK_OVERLAP", 200)
+	// Embed every chunk of a longer description after a MODEL sentence situating it in the
+	// whole description (contextual retrieval), one extra model call per chunk
+	viper.SetDefault("CHUNK_CONTEXT", false)
+
+	// Label single images with the UI state they show (error, empty, success, loading, other)
+	viper.SetDefault("CLASSIFY_UI_STATE", false)
+
+	// Redact emails, card numbers and tokens from generated descriptions, and names of people
+	// with an extra MODEL call; the raw text is kept for ADMIN_API_KEYS
+	viper.SetDefault("REDACT_PII", false)
+	viper.SetDefault("REDACT_PII_NAMES", true)
+
+	// Uploads with sync=true analyze one small image inline, queueing it when not done in time
+	viper.SetDefault("SYNC_TIMEOUT", "60s")
+	viper.SetDefault("SYNC_MAX_FILE_BYTES", 10<<20)
+
+	// CORS policy, the default allows the bundled client in development
+	viper.SetDefault("CORS_ALLOWED_ORIGINS", "http://localhost:3000")
+	viper.SetDefault("CORS_ALLOWED_METHODS", "GET,POST,DELETE,OPTIONS")
+	viper.SetDefault("CORS_ALLOWED_HEADERS", "Content-Type,Authorization,X-Request-ID")
+	viper.SetDefault("CORS_ALLOW_CREDENTIALS", false)
+
+	// HTTPS, disabled unless a certificate or autocert domains are configured
+	viper.SetDefault("TLS_AUTOCERT_CACHE_DIR", "./certs")
+
+	// Batch processing configuration
+	viper.SetDefault("BATCH_CHUNK_SIZE", 3)   // Max images per chunk
+	viper.SetDefault("BATCH_MAX_PARALLEL", 4) // Max parallel processing
+	viper.SetDefault("BATCH_SCENARIO", "web") // Prompt preset for batches that do not choose one
+
+	// Chunk error tolerance: retries of a failed chunk, and the share of chunks that must
+	// succeed for a batch to proceed without the others (1 fails the batch on any chunk)
+	viper.SetDefault("BATCH_CHUNK_RETRIES", 0)
+	viper.SetDefault("BATCH_MIN_CHUNK_SUCCESS", 1.0)
+	// Keep finished chunk analyses in Redis so interrupted or requeued batches resume from them
+	viper.SetDefault("BATCH_CHECKPOINTS", true)
+
+	// How chunk analyses are laid out in the synthesis prompt: a text/template for each chunk,
+	// with .Number, .Total and .Text, and the delimiter between them
+	viper.SetDefault("SYNTHESIS_CHUNK_TEMPLATE", "### Part {{.Number}} of {{.Total}}\n\n{{.Text}}")
+	viper.SetDefault("SYNTHESIS_CHUNK_DELIMITER", "\n\n---\n\n")
+
+	// Recency ranking, a record loses half of its recency boost every half-life
+	viper.SetDefault("SEARCH_RECENCY_HALF_LIFE", "720h")
+	viper.SetDefault("SEARCH_RECENCY_WEIGHT", 0.5)
+
+	// Hybrid ranking, the constant of reciprocal rank fusion damping the weight of top ranks
+	viper.SetDefault("SEARCH_HYBRID_RRF_K", 60)
+	// and the weight of each ranking it fuses: distance, and full-text matches of descriptions
+	// and captions
+	viper.SetDefault("SEARCH_HYBRID_WEIGHT_VECTOR", 1.0)
+	viper.SetDefault("SEARCH_HYBRID_WEIGHT_DESCRIPTION", 1.0)
+	viper.SetDefault("SEARCH_HYBRID_WEIGHT_CAPTION", 1.0)
+
+	// Screenshots compared to a baseline batch drifted when the cosine distance to their
+	// nearest baseline image is above this
+	viper.SetDefault("BASELINE_DRIFT_THRESHOLD", 0.1)
+
+	// Embedding searches match when they do not choose a field: description or summary
+	viper.SetDefault("SEARCH_FIELD", "description")
+
+	// Search request limits, checked before any embedding or database work
+	viper.SetDefault("SEARCH_MAX_QUERY_LENGTH", 1000)
+	viper.SetDefault("SEARCH_MAX_TOP_K", 100)
+	viper.SetDefault("SEARCH_STREAM_MAX_TOP_K", 10000)
+	viper.SetDefault("SEARCH_MAX_QUERIES", 10)
+
+	// Most embeddings loaded into memory for one projection request
+	viper.SetDefault("PROJECTION_MAX_POINTS", 5000)
+
+	// Most buckets returned by one timeline request, and the default thumbnails per bucket
+	viper.SetDefault("TIMELINE_MAX_BUCKETS", 120)
+	viper.SetDefault("TIMELINE_THUMBNAILS", 4)
+
+	// Longest side in pixels of the screen thumbnails embedded in batch reports
+	viper.SetDefault("REPORT_THUMBNAIL_SIZE", 320)
+
+	// Notifications about repeated task failures and a growing dead letter list
+	viper.SetDefault("NOTIFY_FAILURE_THRESHOLD", 5)
+	viper.SetDefault("NOTIFY_FAILURE_WINDOW", "10m")
+	viper.SetDefault("NOTIFY_DLQ_THRESHOLD", 10)
+	viper.SetDefault("NOTIFY_COOLDOWN", "1h")
+
+	// Ingestion hook webhooks: time allowed for each call
+	viper.SetDefault("HOOK_TIMEOUT", "30s")
+
+	// Domain events relayed from the outbox to the EVENTS_STREAM Redis stream
+	viper.SetDefault("EVENTS_STREAM_MAX_LEN", 100000)
+	viper.SetDefault("EVENTS_RELAY_INTERVAL", "1s")
+	viper.SetDefault("EVENTS_RELAY_BATCH", 100)
+}
+
+// Flags returns the command line flags shared by all binaries. Each flag overrides
+// the environment variable named in its usage.
+func Flags(name string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	flags.String("config", ".env", "Path to the env configuration file")
+	flags.String("port", "", "HTTP port (PORT)")
+	flags.Int("workers", 0, "Number of workers (WORKER_COUNT)")
+	flags.String("log-level", "", "Log level: debug, info, warn, error (LOG_LEVEL)")
+	flags.String("log-format", "", "Log format: text or json (LOG_FORMAT)")
+	flags.String("storage-backend", "", "Storage backend: local, s3, gcs, azure (STORAGE_BACKEND)")
+	flags.String("model", "", "Vision model (MODEL)")
+	flags.String("embedding-model", "", "Embedding model (EMBEDDING_MODEL)")
+	return flags
+}
+
+// flagKeys maps flag names to the settings they override
+var flagKeys = map[string]string{
+	"port":            "PORT",
+	"workers":         "WORKER_COUNT",
+	"log-level":       "LOG_LEVEL",
+	"log-format":      "LOG_FORMAT",
+	"storage-backend": "STORAGE_BACKEND",
+	"model":           "MODEL",
+	"embedding-model": "EMBEDDING_MODEL",
+}
+
+// Load reads the configuration with flags taking precedence over environment
+// variables, environment variables over the config file, and the file over defaults.
+// flags must already be parsed; it may be nil.
+func Load(flags *pflag.FlagSet) (*Config, error) {
+	setDefaults()
+
+	configFile := ".env"
+	if flags != nil {
+		if value, err := flags.GetString("config"); err == nil && value != "" {
+			configFile = value
+		}
+
+		for name, key := range flagKeys {
+			if flag := flags.Lookup(name); flag != nil && flag.Changed {
+				if err := viper.BindPFlag(key, flag); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	viper.AutomaticEnv()
+
+	viper.SetConfigFile(configFile)
+	viper.SetConfigType("env")
+	if err := viper.ReadInConfig(); err != nil {
+		// A missing file is fine when everything comes from the environment
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %s: %v", configFile, err)
+		}
+		slog.Warn("Config file not found, using environment and defaults", "file", configFile)
+	}
+
+	cfg := current()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// current builds a typed snapshot of the configuration
+func current() *Config {
+	return &Config{
+		Port:        viper.GetString("PORT"),
+		WorkerCount: viper.GetInt("WORKER_COUNT"),
+		Queues:      List("QUEUES"),
+
+		ReadTimeout:       viper.GetDuration("SERVER_READ_TIMEOUT"),
+		ReadHeaderTimeout: viper.GetDuration("SERVER_READ_HEADER_TIMEOUT"),
+		WriteTimeout:      viper.GetDuration("SERVER_WRITE_TIMEOUT"),
+		IdleTimeout:       viper.GetDuration("SERVER_IDLE_TIMEOUT"),
+		Compression:       viper.GetBool("COMPRESSION_ENABLED"),
+
+		ShutdownTimeout: viper.GetDuration("SHUTDOWN_TIMEOUT"),
+		ServeMode:       viper.GetString("SERVE_MODE"),
+
+		DBHost:      viper.GetString("DB_HOST"),
+		DBUser:      viper.GetString("DB_USER"),
+		DBPassword:  viper.GetString("DB_PASSWORD"),
+		DBName:      viper.GetString("DB_NAME"),
+		DBPort:      viper.GetString("DB_PORT"),
+		DBSSLMode:   viper.GetString("DB_SSLMODE"),
+		AutoMigrate: viper.GetBool("DB_AUTO_MIGRATE"),
+
+		RedisAddr:     viper.GetString("REDIS_ADDR"),
+		RedisPassword: viper.GetString("REDIS_PASSWORD"),
+		RedisDB:       viper.GetInt("REDIS_DB"),
+
+		StorageBackend: viper.GetString("STORAGE_BACKEND"),
+		UploadsDir:     viper.GetString("UPLOADS_DIR"),
+		UploadsRoute:   viper.GetString("UPLOADS_ROUTE"),
+
+		OllamaHost:     viper.GetString("OLLAMA_HOST"),
+		Model:          viper.GetString("MODEL"),
+		EmbeddingModel: viper.GetString("EMBEDDING_MODEL"),
+
+		BatchChunkSize:       viper.GetInt("BATCH_CHUNK_SIZE"),
+		BatchMaxParallel:     viper.GetInt("BATCH_MAX_PARALLEL"),
+		BatchChunkRetries:    viper.GetInt("BATCH_CHUNK_RETRIES"),
+		BatchMinChunkSuccess: viper.GetFloat64("BATCH_MIN_CHUNK_SUCCESS"),
+
+		SearchRecencyHalfLife: viper.GetDuration("SEARCH_RECENCY_HALF_LIFE"),
+		SearchRecencyWeight:   viper.GetFloat64("SEARCH_RECENCY_WEIGHT"),
+
+		LogLevel:  viper.GetString("LOG_LEVEL"),
+		LogFormat: viper.GetString("LOG_FORMAT"),
+
+		CORSAllowedOrigins:   List("CORS_ALLOWED_ORIGINS"),
+		CORSAllowCredentials: viper.GetBool("CORS_ALLOW_CREDENTIALS"),
+
+		TLSCertFile:         viper.GetString("TLS_CERT_FILE"),
+		TLSKeyFile:          viper.GetString("TLS_KEY_FILE"),
+		TLSAutocertDomains:  List("TLS_AUTOCERT_DOMAINS"),
+		TLSAutocertEmail:    viper.GetString("TLS_AUTOCERT_EMAIL"),
+		TLSAutocertCacheDir: viper.GetString("TLS_AUTOCERT_CACHE_DIR"),
+		TLSRedirectAddr:     viper.GetString("TLS_REDIRECT_ADDR"),
+	}
+}
+
+// List reads a comma-separated setting, trimming blanks and dropping empty entries
+func List(key string) []string {
+	var values []string
+	for _, entry := range viper.GetStringSlice(key) {
+		for _, value := range strings.Split(entry, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// Validate reports every invalid or missing setting at once
+func (c *Config) Validate() error {
+	var problems []string
+
+	required := map[string]string{
+		"DB_HOST":     c.DBHost,
+		"DB_USER":     c.DBUser,
+		"DB_PASSWORD": c.DBPassword,
+		"DB_NAME":     c.DBName,
+		"DB_PORT":     c.DBPort,
+	}
+	for _, key := range []string{"DB_HOST", "DB_USER", "DB_PASSWORD", "DB_NAME", "DB_PORT"} {
+		if required[key] == "" {
+			problems = append(problems, key+" is required")
+		}
+	}
+
+	if len(c.Queues) == 0 {
+		problems = append(problems, "QUEUES must list at least one queue")
+	}
+	for _, name := range c.Queues {
+		if strings.Contains(name, ":") {
+			problems = append(problems, fmt.Sprintf("QUEUES entry %q cannot contain \":\"", name))
+		}
+	}
+	if c.WorkerCount <= 0 {
+		problems = append(problems, "WORKER_COUNT must be positive")
+	}
+	for key, timeout := range map[string]time.Duration{
+		"SERVER_READ_TIMEOUT":        c.ReadTimeout,
+		"SERVER_READ_HEADER_TIMEOUT": c.ReadHeaderTimeout,
+		"SERVER_WRITE_TIMEOUT":       c.WriteTimeout,
+		"SERVER_IDLE_TIMEOUT":        c.IdleTimeout,
+	} {
+		if timeout < 0 {
+			problems = append(problems, key+" cannot be negative")
+		}
+	}
+	switch c.ServeMode {
+	case ServeModeFull, ServeModeSearchOnly:
+	default:
+		problems = append(problems, fmt.Sprintf("SERVE_MODE %q is not one of %s, %s", c.ServeMode, ServeModeFull, ServeModeSearchOnly))
+	}
+	if viper.GetDuration("FILES_CACHE_MAX_AGE") < 0 {
+		problems = append(problems, "FILES_CACHE_MAX_AGE cannot be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "SHUTDOWN_TIMEOUT must be positive")
+	}
+	if timeout := viper.GetDuration("SYNC_TIMEOUT"); timeout <= 0 {
+		problems = append(problems, "SYNC_TIMEOUT must be positive")
+	} else if c.WriteTimeout > 0 && timeout >= c.WriteTimeout {
+		problems = append(problems, "SYNC_TIMEOUT must be shorter than SERVER_WRITE_TIMEOUT")
+	}
+	switch mode := viper.GetString("CAPTION_MODE"); mode {
+	case "augment", "replace":
+	default:
+		problems = append(problems, fmt.Sprintf("CAPTION_MODE %q is not one of augment, replace", mode))
+	}
+	switch audit := viper.GetString("DEFAULT_AUDIT"); audit {
+	case "", "none", "accessibility":
+	default:
+		problems = append(problems, fmt.Sprintf("DEFAULT_AUDIT %q is not one of none, accessibility", audit))
+	}
+	switch visibility := viper.GetString("DEFAULT_VISIBILITY"); visibility {
+	case "public", "private":
+	default:
+		problems = append(problems, fmt.Sprintf("DEFAULT_VISIBILITY %q is not one of public, private", visibility))
+	}
+	if ttl, maxTTL := viper.GetDuration("SHARE_DEFAULT_TTL"), viper.GetDuration("SHARE_MAX_TTL"); ttl <= 0 || maxTTL <= 0 {
+		problems = append(problems, "SHARE_DEFAULT_TTL and SHARE_MAX_TTL must be positive")
+	} else if ttl > maxTTL {
+		problems = append(problems, "SHARE_DEFAULT_TTL cannot be longer than SHARE_MAX_TTL")
+	}
+	if viper.GetInt("ARCHIVE_MAX_FILES") <= 0 || viper.GetInt64("ARCHIVE_MAX_BYTES") <= 0 {
+		problems = append(problems, "ARCHIVE_MAX_FILES and ARCHIVE_MAX_BYTES must be positive")
+	}
+	if viper.GetInt("CAPTION_MAX_LENGTH") <= 0 {
+		problems = append(problems, "CAPTION_MAX_LENGTH must be positive")
+	}
+	if viper.GetInt64("SYNC_MAX_FILE_BYTES") <= 0 {
+		problems = append(problems, "SYNC_MAX_FILE_BYTES must be positive")
+	}
+	if c.BatchChunkSize <= 0 {
+		problems = append(problems, "BATCH_CHUNK_SIZE must be positive")
+	}
+	if c.BatchMaxParallel <= 0 {
+		problems = append(problems, "BATCH_MAX_PARALLEL must be positive")
+	}
+	if c.BatchChunkRetries < 0 {
+		problems = append(problems, "BATCH_CHUNK_RETRIES must not be negative")
+	}
+	if c.BatchMinChunkSuccess <= 0 || c.BatchMinChunkSuccess > 1 {
+		problems = append(problems, "BATCH_MIN_CHUNK_SUCCESS must be above 0 and at most 1")
+	}
+	if _, err := template.New("chunk").Parse(viper.GetString("SYNTHESIS_CHUNK_TEMPLATE")); err != nil {
+		problems = append(problems, fmt.Sprintf("SYNTHESIS_CHUNK_TEMPLATE is not a valid template: %v", err))
+	}
+	for _, key := range []string{"SEARCH_MAX_QUERY_LENGTH", "SEARCH_MAX_TOP_K", "SEARCH_STREAM_MAX_TOP_K", "SEARCH_MAX_QUERIES"} {
+		if viper.GetInt(key) <= 0 {
+			problems = append(problems, key+" must be positive")
+		}
+	}
+	if c.SearchRecencyHalfLife <= 0 {
+		problems = append(problems, "SEARCH_RECENCY_HALF_LIFE must be positive")
+	}
+	if c.SearchRecencyWeight < 0 || c.SearchRecencyWeight > 1 {
+		problems = append(problems, "SEARCH_RECENCY_WEIGHT must be between 0 and 1")
+	}
+	if viper.GetInt("SEARCH_HYBRID_RRF_K") <= 0 {
+		problems = append(problems, "SEARCH_HYBRID_RRF_K must be positive")
+	}
+	hybridWeights := []string{"SEARCH_HYBRID_WEIGHT_VECTOR", "SEARCH_HYBRID_WEIGHT_DESCRIPTION", "SEARCH_HYBRID_WEIGHT_CAPTION"}
+	weighted := false
+	for _, key := range hybridWeights {
+		if weight := viper.GetFloat64(key); weight < 0 {
+			problems = append(problems, key+" must be at least 0")
+		} else if weight > 0 {
+			weighted = true
+		}
+	}
+	if !weighted {
+		problems = append(problems, "one of "+strings.Join(hybridWeights, ", ")+" must be above 0")
+	}
+	if threshold := viper.GetFloat64("BASELINE_DRIFT_THRESHOLD"); threshold <= 0 || threshold > 1 {
+		problems = append(problems, "BASELINE_DRIFT_THRESHOLD must be above 0 and at most 1")
+	}
+	switch viper.GetString("SEARCH_FIELD") {
+	case "description", "summary", "chunks":
+	default:
+		problems = append(problems, "SEARCH_FIELD must be description, summary or chunks")
+	}
+	if viper.GetInt("CHUNK_SIZE") <= 0 {
+		problems = append(problems, "CHUNK_SIZE must be positive")
+	}
+	if overlap := viper.GetInt("CHUNK_OVERLAP"); overlap < 0 || overlap >= viper.GetInt("CHUNK_SIZE") {
+		problems = append(problems, "CHUNK_OVERLAP must be at least 0 and below CHUNK_SIZE")
+	}
+	if viper.GetDuration("VIDEO_FRAME_INTERVAL") <= 0 || viper.GetInt("VIDEO_MAX_FRAMES") <= 0 {
+		problems = append(problems, "VIDEO_FRAME_INTERVAL and VIDEO_MAX_FRAMES must be positive")
+	}
+	if viper.GetDuration("VIDEO_PREVIEW_DURATION") <= 0 || viper.GetInt("VIDEO_PREVIEW_WIDTH") <= 0 || viper.GetInt("VIDEO_PREVIEW_FPS") <= 0 {
+		problems = append(problems, "VIDEO_PREVIEW_DURATION, VIDEO_PREVIEW_WIDTH and VIDEO_PREVIEW_FPS must be positive")
+	}
+	if threshold := viper.GetFloat64("VIDEO_SCENE_THRESHOLD"); threshold <= 0 || threshold >= 1 {
+		problems = append(problems, "VIDEO_SCENE_THRESHOLD must be above 0 and below 1")
+	}
+	if viper.GetInt("VIDEO_MAX_SCENES") <= 0 {
+		problems = append(problems, "VIDEO_MAX_SCENES must be positive")
+	}
+
+	switch c.StorageBackend {
+	case "local", "s3", "gcs", "azure":
+	default:
+		problems = append(problems, fmt.Sprintf("STORAGE_BACKEND %q is not one of local, s3, gcs, azure", c.StorageBackend))
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL %q is not one of debug, info, warn, error", c.LogLevel))
+	}
+
+	switch strings.ToLower(c.LogFormat) {
+	case "text", "json":
+	default:
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT %q is not one of text, json", c.LogFormat))
+	}
+
+	if c.CORSAllowCredentials {
+		if len(c.CORSAllowedOrigins) == 0 {
+			problems = append(problems, "CORS_ALLOWED_ORIGINS must list origins when CORS_ALLOW_CREDENTIALS is enabled")
+		}
+		for _, origin := range c.CORSAllowedOrigins {
+			if origin == "*" {
+				problems = append(problems, "CORS_ALLOWED_ORIGINS cannot be \"*\" when CORS_ALLOW_CREDENTIALS is enabled")
+				break
+			}
+		}
+	}
+
+	for _, channel := range []string{"SLACK", "DISCORD", "EMAIL"} {
+		for _, event := range List("NOTIFY_" + channel + "_EVENTS") {
+			switch event {
+			case "batch_completed", "task_failures", "dlq_growth":
+			default:
+				problems = append(problems, fmt.Sprintf("NOTIFY_%s_EVENTS entry %q is not one of batch_completed, task_failures, dlq_growth", channel, event))
+			}
+		}
+	}
+	if len(List("NOTIFY_EMAIL_TO")) > 0 && (viper.GetString("SMTP_ADDR") == "" || viper.GetString("NOTIFY_EMAIL_FROM") == "") {
+		problems = append(problems, "NOTIFY_EMAIL_TO requires SMTP_ADDR and NOTIFY_EMAIL_FROM")
+	}
+	if viper.GetInt64("EVENTS_STREAM_MAX_LEN") < 0 {
+		problems = append(problems, "EVENTS_STREAM_MAX_LEN cannot be negative")
+	}
+	for _, key := range []string{"NOTIFY_FAILURE_THRESHOLD", "NOTIFY_DLQ_THRESHOLD", "EVENTS_RELAY_BATCH"} {
+		if viper.GetInt(key) <= 0 {
+			problems = append(problems, key+" must be positive")
+		}
+	}
+	for _, key := range []string{"NOTIFY_FAILURE_WINDOW", "NOTIFY_COOLDOWN", "HOOK_TIMEOUT", "EVENTS_RELAY_INTERVAL", "FILE_LOCK_TTL"} {
+		if viper.GetDuration(key) <= 0 {
+			problems = append(problems, key+" must be positive")
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSCertFile != "" && len(c.TLSAutocertDomains) > 0 {
+		problems = append(problems, "TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS cannot both be set")
+	}
+	if c.TLSRedirectAddr != "" && !c.TLSEnabled() {
+		problems = append(problems, "TLS_REDIRECT_ADDR requires TLS_CERT_FILE or TLS_AUTOCERT_DOMAINS")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   []func()
+)
+
+// Subscribe registers a callback run every time the configuration file is reloaded.
+// Values read through viper at use time need no subscription, this is for state
+// derived from the configuration at startup such as the log level.
+func Subscribe(fn func()) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	subscribers = append(subscribers, fn)
+}
+
+// Watch reloads the configuration file when it changes and notifies subscribers
+func Watch() {
+	if viper.ConfigFileUsed() == "" {
+		return
+	}
+
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		slog.Info("Configuration reloaded", "file", e.Name)
+
+		subscribersMu.Lock()
+		callbacks := append([]func(){}, subscribers...)
+		subscribersMu.Unlock()
+
+		for _, fn := range callbacks {
+			fn()
+		}
+	})
+	viper.WatchConfig()
+}
@@ -2,17 +2,22 @@ package database
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 
+	"github.com/pablobfonseca/go-image-vector/logging"
 	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/tracing"
 	"github.com/spf13/viper"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-var DB *gorm.DB
+// TextSearchConfig is the Postgres text search configuration descriptions are indexed and
+// matched with for hybrid searches
+const TextSearchConfig = "english"
 
-func Connect() {
+// Open connects to the configured database without migrating it
+func Open() (*gorm.DB, error) {
 	host := viper.GetString("DB_HOST")
 	user := viper.GetString("DB_USER")
 	password := viper.GetString("DB_PASSWORD")
@@ -20,26 +25,65 @@ func Connect() {
 	port := viper.GetString("DB_PORT")
 	sslmode := viper.GetString("DB_SSLMODE")
 
-	// Validate that all required environment variables are set
-	if host == "" || user == "" || password == "" || dbname == "" || port == "" || sslmode == "" {
-		log.Fatal("Missing required database environment variables. Please ensure DB_HOST, DB_USER, DB_PASSWORD, DB_NAME, DB_PORT, and DB_SSLMODE are set")
-	}
-
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
 		host, user, password, dbname, port, sslmode)
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+}
+
+// Connect opens the configured database with tracing, exiting when it cannot
+func Connect() *gorm.DB {
+	db, err := Open()
 	if err != nil {
-		log.Fatal("Failed to connect to database: ", err)
+		logging.Fatal("Failed to connect to database", "error", err)
 	}
 
-	db.Exec("CREATE EXTENSION IF NOT EXISTS vector;")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_embedding ON image_embeddings USING hnsw (embedding vector_cosine_ops);")
+	if err := db.Use(tracing.GormPlugin()); err != nil {
+		logging.Fatal("Failed to register tracing plugin", "error", err)
+	}
+
+	slog.Info("Database connected successfully")
+	return db
+}
 
-	if !db.Migrator().HasTable(&models.ImageEmbedding{}) {
-		db.AutoMigrate(&models.ImageEmbedding{})
+// Migrate creates the pgvector extension, tables and indexes
+func Migrate(db *gorm.DB) error {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector;").Error; err != nil {
+		return fmt.Errorf("failed to create vector extension: %v", err)
 	}
 
-	DB = db
-	fmt.Println("Database connected successfully!")
+	if err := db.AutoMigrate(&models.ImageEmbedding{}, &models.DescriptionChunk{}, &models.Description{}, &models.AccessibilityFinding{}, &models.VideoFrame{}, &models.OutboxEvent{}, &models.WebhookDelivery{}); err != nil {
+		return err
+	}
+
+	// Searches order by L2 distance (<->), which only indexes of vector_l2_ops serve. The
+	// cosine indexes created before were never used, so they are replaced.
+	db.Exec("DROP INDEX IF EXISTS idx_embedding;")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_embedding_l2 ON image_embeddings USING hnsw (embedding vector_l2_ops);")
+	db.Exec("DROP INDEX IF EXISTS idx_summary_embedding;")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_summary_embedding_l2 ON image_embeddings USING hnsw (summary_embedding vector_l2_ops);")
+	db.Exec("DROP INDEX IF EXISTS idx_chunk_embedding;")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_chunk_embedding_l2 ON description_chunks USING hnsw (embedding vector_l2_ops);")
+	db.Exec("DROP INDEX IF EXISTS idx_frame_embedding;")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_frame_embedding_l2 ON video_frames USING hnsw (embedding vector_l2_ops);")
+	db.Exec("DROP INDEX IF EXISTS idx_description_embedding;")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_description_embedding_l2 ON descriptions USING hnsw (embedding vector_l2_ops);")
+
+	// The full-text index of descriptions is a generated column, so Postgres keeps it up to date
+	// on every insert and update
+	db.Exec("ALTER TABLE image_embeddings ADD COLUMN IF NOT EXISTS text_search tsvector GENERATED ALWAYS AS (to_tsvector('" + TextSearchConfig + "', coalesce(text, ''))) STORED;")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_text_search ON image_embeddings USING gin (text_search);")
+	db.Exec("ALTER TABLE image_embeddings ADD COLUMN IF NOT EXISTS caption_search tsvector GENERATED ALWAYS AS (to_tsvector('" + TextSearchConfig + "', coalesce(caption, ''))) STORED;")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_caption_search ON image_embeddings USING gin (caption_search);")
+
+	// Records stored before created_at existed would never expire, so retention counts from
+	// the migration that adds it
+	db.Exec("UPDATE image_embeddings SET created_at = now() WHERE created_at IS NULL;")
+
+	// Content-addressed files can back several records, so file paths are no longer unique
+	db.Exec("ALTER TABLE image_embeddings DROP CONSTRAINT IF EXISTS image_embeddings_file_path_key;")
+	db.Exec("ALTER TABLE image_embeddings DROP CONSTRAINT IF EXISTS uni_image_embeddings_file_path;")
+
+	slog.Info("Database migrated")
+	return nil
 }
@@ -0,0 +1,136 @@
+// Package deliveries posts JSON payloads to webhooks and logs every attempt, with its status
+// code, latency and the start of the response or the error, so failed deliveries can be
+// listed and replayed. Attempts are only logged once Install gave the package a database.
+package deliveries
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"gorm.io/gorm"
+)
+
+// Delivery sources
+const (
+	HookPostPersist = "hook_post_persist"
+	NotifySlack     = "notify_slack"
+	NotifyDiscord   = "notify_discord"
+)
+
+// responseExcerpt is how much of a response body is kept
+const responseExcerpt = 1024
+
+// replayTimeout bounds a replayed delivery
+const replayTimeout = 10 * time.Second
+
+// logDB is the database attempts are logged to, nil until Install
+var logDB atomic.Pointer[gorm.DB]
+
+// Install logs the deliveries of this process to db
+func Install(db *gorm.DB) {
+	logDB.Store(db)
+}
+
+// Post posts payload as JSON to url on behalf of source, failing on an error status
+func Post(ctx context.Context, source, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = deliver(ctx, logDB.Load(), models.WebhookDelivery{Source: source, URL: url, Payload: body})
+	return err
+}
+
+// ListFailed returns the failed deliveries no replay succeeded for yet, newest first, and
+// how many there are
+func ListFailed(ctx context.Context, db *gorm.DB, limit, offset int) ([]models.WebhookDelivery, int64, error) {
+	query := db.WithContext(ctx).Model(&models.WebhookDelivery{}).Where("failed AND NOT replayed")
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var deliveries []models.WebhookDelivery
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&deliveries).Error
+	return deliveries, total, err
+}
+
+// Replay posts the payload of a logged delivery to its URL again and returns the new attempt,
+// logged as a replay of it. The delivery is marked replayed when the attempt succeeds; a
+// failed attempt is not an error, its Failed and Error fields say why. Returns
+// gorm.ErrRecordNotFound when there is no such delivery.
+func Replay(ctx context.Context, db *gorm.DB, id uint) (models.WebhookDelivery, error) {
+	var original models.WebhookDelivery
+	if err := db.WithContext(ctx).First(&original, id).Error; err != nil {
+		return original, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, replayTimeout)
+	defer cancel()
+
+	delivery, err := deliver(ctx, db, models.WebhookDelivery{
+		Source:   original.Source,
+		URL:      original.URL,
+		Payload:  original.Payload,
+		ReplayOf: &original.ID,
+	})
+	if err == nil {
+		if err := db.WithContext(context.WithoutCancel(ctx)).Model(&original).Update("replayed", true).Error; err != nil {
+			return delivery, err
+		}
+	}
+	return delivery, nil
+}
+
+// deliver posts a delivery, fills in its outcome and logs it to db unless nil
+func deliver(ctx context.Context, db *gorm.DB, delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	err := send(ctx, &delivery)
+	if err != nil {
+		delivery.Failed = true
+		delivery.Error = err.Error()
+	}
+
+	if db != nil {
+		// Log the attempt even when it failed because ctx ended
+		if err := db.WithContext(context.WithoutCancel(ctx)).Create(&delivery).Error; err != nil {
+			slog.WarnContext(ctx, "Failed to log webhook delivery", "source", delivery.Source, "error", err)
+		}
+	}
+	return delivery, err
+}
+
+// send posts the payload of delivery and records its status code, latency and response
+func send(ctx context.Context, delivery *models.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	delivery.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, responseExcerpt))
+	delivery.Response = strings.ToValidUTF8(string(excerpt), "")
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
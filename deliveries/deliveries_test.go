@@ -0,0 +1,35 @@
+package deliveries
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+)
+
+func TestDeliverRecordsOutcome(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", r.Header.Get("Content-Type"))
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(strings.Repeat("x", responseExcerpt+100)))
+	}))
+	defer server.Close()
+
+	delivery, err := deliver(context.Background(), nil, models.WebhookDelivery{Source: NotifySlack, URL: server.URL, Payload: []byte(`{"text":"hi"}`)})
+	if err == nil || !delivery.Failed || delivery.Error == "" {
+		t.Errorf("deliver to a 502 = %+v, %v, want a failed delivery", delivery, err)
+	}
+	if delivery.StatusCode != http.StatusBadGateway || len(delivery.Response) != responseExcerpt {
+		t.Errorf("status %d with a %d byte response, want 502 with a %d byte excerpt", delivery.StatusCode, len(delivery.Response), responseExcerpt)
+	}
+
+	delivery, err = deliver(context.Background(), nil, models.WebhookDelivery{Source: NotifySlack, URL: "http://127.0.0.1:0", Payload: []byte(`{}`)})
+	if err == nil || !delivery.Failed || delivery.StatusCode != 0 {
+		t.Errorf("deliver to a closed port = %+v, %v, want a failed delivery without a status", delivery, err)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pgvector/pgvector-go"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// languageAll searches the descriptions of records in every language, and the descriptions
+// they were analyzed with
+const languageAll = "all"
+
+// descriptionMaxBodyBytes caps the JSON body of a description
+const descriptionMaxBodyBytes = 1 << 20
+
+// languagePattern matches language tags such as de, pt-br or zh-hant
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// parseLanguage normalizes a language tag, reporting whether it is one
+func parseLanguage(value string) (string, bool) {
+	language := strings.ToLower(strings.TrimSpace(value))
+	return language, languagePattern.MatchString(language)
+}
+
+// descriptionLanguage is the language records are described in when analyzed,
+// DESCRIPTION_LANGUAGE
+func descriptionLanguage() string {
+	language, _ := parseLanguage(viper.GetString("DESCRIPTION_LANGUAGE"))
+	return language
+}
+
+// descriptionRequest is the JSON body of PUT /images/{id}/descriptions/{language}
+type descriptionRequest struct {
+	Text string `json:"text"`
+}
+
+// pathLanguage reads the language of a descriptions route, which cannot be the language
+// records are analyzed in as their own description is in it
+func pathLanguage(r *http.Request) (string, error) {
+	language, ok := parseLanguage(mux.Vars(r)["language"])
+	if !ok {
+		return "", apierror.InvalidParameter("language", "language must be a language tag such as de or pt-br")
+	}
+	if language == descriptionLanguage() {
+		return "", apierror.InvalidParameter("language", "records are described in "+language+" when analyzed")
+	}
+	return language, nil
+}
+
+// listDescriptions returns the descriptions of a record in other languages
+func (s *server) listDescriptions(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.loadImage(w, r)
+	if !ok {
+		return
+	}
+
+	var descriptions []models.Description
+	if err := s.db.WithContext(r.Context()).Omit("embedding").Where("record_id = ?", record.ID).
+		Order("language").Find(&descriptions).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load descriptions", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"record_id":    record.ID,
+		"language":     descriptionLanguage(),
+		"descriptions": descriptions,
+	})
+}
+
+// putDescription stores the description of a record in a language, replacing the one it
+// had, embedded so the record is searchable in that language
+func (s *server) putDescription(w http.ResponseWriter, r *http.Request) {
+	if !requireAPIKey(w, r, "Storing descriptions") {
+		return
+	}
+	language, err := pathLanguage(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	var req descriptionRequest
+	if err := decodeJSON(w, r, descriptionMaxBodyBytes, &req); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		apierror.Write(w, r, apierror.InvalidParameter("text", "text is required"))
+		return
+	}
+	record, ok := s.loadImage(w, r)
+	if !ok {
+		return
+	}
+
+	embedding, err := s.services.GenerateEmbedding(r.Context(), req.Text)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Failed to generate embedding"))
+		return
+	}
+
+	description := models.Description{
+		RecordID:  record.ID,
+		Language:  language,
+		Text:      req.Text,
+		Source:    models.DescriptionProvided,
+		Embedding: pgvector.NewVector(embedding),
+	}
+	if err := saveDescription(s.db.WithContext(r.Context()), &description); err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to save description", err))
+		return
+	}
+	slog.InfoContext(r.Context(), "Stored description", "record_id", record.ID, "language", language)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(description)
+}
+
+// saveDescription stores a description, replacing the one its record has in its language
+func saveDescription(db *gorm.DB, description *models.Description) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "record_id"}, {Name: "language"}},
+		DoUpdates: clause.AssignmentColumns([]string{"text", "source", "embedding", "updated_at"}),
+	}).Create(description).Error
+}
+
+// deleteDescription deletes the description of a record in a language
+func (s *server) deleteDescription(w http.ResponseWriter, r *http.Request) {
+	if !requireAPIKey(w, r, "Deleting descriptions") {
+		return
+	}
+	language, err := pathLanguage(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	record, ok := s.loadImage(w, r)
+	if !ok {
+		return
+	}
+
+	result := s.db.WithContext(r.Context()).Where("record_id = ? AND language = ?", record.ID, language).
+		Delete(&models.Description{})
+	if result.Error != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to delete description", result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		apierror.Write(w, r, apierror.NotFound("Description not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"record_id": record.ID, "language": language, "deleted": true})
+}
+
+// findSimilarDescriptions searches the descriptions of the records a search considers in
+// params.Language, or in every language including the descriptions records were analyzed
+// with for languageAll, and returns up to limit records, nearest first. Each record is
+// ranked by its nearest description, returned as its matched language and description.
+func findSimilarDescriptions(db *gorm.DB, params searchParams, embedding []float32, limit int) ([]models.ImageEmbedding, error) {
+	vector := pgvector.NewVector(embedding)
+
+	query := db.Model(&models.Description{}).
+		Select("record_id, language, text, embedding <-> ? AS distance", vector).
+		Where("record_id IN (?)", searchQuery(db, params).Select("id"))
+	candidates := limit
+	if params.Language == languageAll {
+		// A record can match in several languages, so more are read to find limit records
+		candidates *= chunkCandidates
+	} else {
+		query = query.Where("language = ?", params.Language)
+	}
+
+	var matches []models.Description
+	if err := query.Order("distance").Limit(candidates).Scan(&matches).Error; err != nil {
+		return nil, err
+	}
+
+	// The descriptions records were analyzed with compete with the other languages
+	if params.Language == languageAll {
+		var originals []models.ImageEmbedding
+		if err := searchQuery(db, params).Select("id, embedding <-> ? AS distance", vector).
+			Order("distance").Limit(limit).Scan(&originals).Error; err != nil {
+			return nil, err
+		}
+		for _, original := range originals {
+			matches = append(matches, models.Description{RecordID: original.ID, Language: descriptionLanguage(), Distance: original.Distance})
+		}
+		slices.SortStableFunc(matches, func(a, b models.Description) int {
+			return cmp.Compare(a.Distance, b.Distance)
+		})
+	}
+
+	nearest := map[uint]models.Description{}
+	var ids []uint
+	for _, match := range matches {
+		if _, seen := nearest[match.RecordID]; seen {
+			continue
+		}
+		nearest[match.RecordID] = match
+		if ids = append(ids, match.RecordID); len(ids) == limit {
+			break
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var found []models.ImageEmbedding
+	if err := db.Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.ImageEmbedding, len(found))
+	for _, record := range found {
+		byID[record.ID] = record
+	}
+
+	results := make([]models.ImageEmbedding, 0, len(ids))
+	for _, id := range ids {
+		record, ok := byID[id]
+		if !ok {
+			continue
+		}
+		record.Distance = nearest[id].Distance
+		record.MatchedLanguage = nearest[id].Language
+		record.MatchedDescription = nearest[id].Text
+		results = append(results, record)
+	}
+	return results, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pablobfonseca/go-image-vector/cleanup"
+	"github.com/pablobfonseca/go-image-vector/models"
+)
+
+// duplicatesOptions configures the duplicates command
+type duplicatesOptions struct {
+	minSimilarity float64
+	json          bool
+	delete        bool
+	yes           bool
+}
+
+// runDuplicates lists clusters of near-identical images and optionally deletes every
+// record but the oldest of each cluster
+func (s *server) runDuplicates(ctx context.Context, opts duplicatesOptions) error {
+	if opts.minSimilarity <= 0 || opts.minSimilarity > 1 {
+		return fmt.Errorf("--min-similarity must be greater than 0 and at most 1")
+	}
+	if opts.json && opts.delete && !opts.yes {
+		return fmt.Errorf("--delete with --json requires --yes, as the confirmation prompt would mix with the output")
+	}
+
+	clusters, err := cleanup.FindDuplicates(ctx, s.db, s.queue, opts.minSimilarity)
+	if err != nil {
+		return err
+	}
+
+	var ids []uint
+	var reclaimable int64
+	for _, cluster := range clusters {
+		for _, duplicate := range cluster.Duplicates {
+			ids = append(ids, duplicate.ID)
+		}
+		reclaimable += cluster.ReclaimableBytes
+	}
+
+	if opts.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(clusters); err != nil {
+			return err
+		}
+	} else {
+		printDuplicates(os.Stdout, clusters)
+		fmt.Printf("\n%d clusters, %d duplicates, %s reclaimable\n", len(clusters), len(ids), formatBytes(reclaimable))
+	}
+
+	if !opts.delete || len(ids) == 0 {
+		return nil
+	}
+	if !opts.yes && !confirm(fmt.Sprintf("Delete %d duplicate records and their unused files?", len(ids))) {
+		return fmt.Errorf("aborted")
+	}
+
+	var records []models.ImageEmbedding
+	if err := s.db.WithContext(ctx).Omit("embedding").Where("id IN ?", ids).Find(&records).Error; err != nil {
+		return err
+	}
+	if err := cleanup.DeleteRecords(ctx, s.db, s.queue, s.files, records); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Deleted %d duplicate records\n", len(records))
+	return nil
+}
+
+// printDuplicates renders each cluster as the kept record followed by its duplicates
+func printDuplicates(out io.Writer, clusters []cleanup.DuplicateCluster) {
+	if len(clusters) == 0 {
+		fmt.Fprintln(out, "No duplicates")
+		return
+	}
+
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CLUSTER\tACTION\tID\tSIMILARITY\tSIZE\tCREATED\tFILE")
+	for i, cluster := range clusters {
+		records := append([]cleanup.DuplicateRecord{cluster.Keep}, cluster.Duplicates...)
+		for j, record := range records {
+			action := "delete"
+			if j == 0 {
+				action = "keep"
+			}
+			fmt.Fprintf(table, "%d\t%s\t%d\t%.4f\t%s\t%s\t%s\n", i+1, action, record.ID, record.Similarity,
+				formatBytes(record.SizeBytes), record.CreatedAt.Local().Format("2006-01-02 15:04"), record.FilePath)
+		}
+	}
+	table.Flush()
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,125 @@
+// Package events publishes domain events, such as ingested or deleted media, to a Redis
+// stream so downstream systems can follow changes without polling the database. Events are
+// saved to an outbox table in the transaction of the change they describe, and relayed to
+// the stream by the workers, so no committed change is missed.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Event types
+const (
+	MediaIngested  = "media.ingested"
+	MediaDeleted   = "media.deleted"
+	BatchCompleted = "batch.completed"
+)
+
+// Enabled reports whether events are published, which EVENTS_STREAM turns on
+func Enabled() bool {
+	return viper.GetString("EVENTS_STREAM") != ""
+}
+
+// Record saves an event to the outbox with tx, so it is published only if tx commits. It
+// does nothing when events are disabled.
+func Record(tx *gorm.DB, eventType string, payload any) error {
+	if !Enabled() {
+		return nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.OutboxEvent{Type: eventType, Payload: data}).Error
+}
+
+// Media is the payload describing a record, without its text and embedding
+func Media(record *models.ImageEmbedding) map[string]any {
+	return map[string]any{
+		"id":            record.ID,
+		"file_path":     record.FilePath,
+		"original_name": record.OriginalName,
+		"media_type":    record.MediaType,
+		"visibility":    record.Visibility,
+		"is_batch":      record.IsBatch,
+		"batch_id":      record.BatchID,
+		"batch_paths":   record.BatchPaths,
+		"created_at":    record.CreatedAt,
+	}
+}
+
+// RunRelay publishes the outbox events of db to q every EVENTS_RELAY_INTERVAL until the
+// context is cancelled, skipping rounds while events are disabled
+func RunRelay(ctx context.Context, db *gorm.DB, q *queue.Client) {
+	for {
+		if Enabled() {
+			for {
+				published, err := Relay(ctx, db, q)
+				if err != nil {
+					slog.Error("Error relaying events", "error", err)
+				}
+				if err != nil || published < viper.GetInt("EVENTS_RELAY_BATCH") {
+					break
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(viper.GetDuration("EVENTS_RELAY_INTERVAL")):
+		}
+	}
+}
+
+// Relay publishes up to EVENTS_RELAY_BATCH of the oldest outbox events to EVENTS_STREAM and
+// removes them from the outbox, returning how many were published. Workers relay at the same
+// time without publishing an event twice, though an event may be published again when the
+// outbox cannot be updated after publishing it, so consumers should skip event IDs they saw.
+func Relay(ctx context.Context, db *gorm.DB, q *queue.Client) (int, error) {
+	stream := viper.GetString("EVENTS_STREAM")
+	maxLen := viper.GetInt64("EVENTS_STREAM_MAX_LEN")
+
+	var ids []uint
+	var publishErr error
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var pending []models.OutboxEvent
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Order("id").Limit(viper.GetInt("EVENTS_RELAY_BATCH")).Find(&pending).Error; err != nil {
+			return err
+		}
+
+		for _, event := range pending {
+			if _, publishErr = q.PublishEvent(stream, maxLen, map[string]any{
+				"id":          strconv.FormatUint(uint64(event.ID), 10),
+				"type":        event.Type,
+				"payload":     string(event.Payload),
+				"occurred_at": event.CreatedAt.UTC().Format(time.RFC3339Nano),
+			}); publishErr != nil {
+				// The events published so far are still removed, so they are not published again
+				break
+			}
+			ids = append(ids, event.ID)
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+		return tx.Delete(&models.OutboxEvent{}, ids).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), publishErr
+}
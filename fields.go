@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+)
+
+// fieldSelection picks the fields of the records of a response, such as to leave out the
+// embeddings and full text of search results. The zero value keeps every field.
+type fieldSelection struct {
+	// fields keeps only these fields when set
+	fields []string
+	// exclude drops these fields
+	exclude []string
+}
+
+// newFieldSelection checks the fields and exclude parameters against the fields the records
+// of a response have, so a misspelled field fails instead of being dropped silently
+func newFieldSelection(fields []string, exclude []string, known []string) (fieldSelection, error) {
+	for _, param := range []struct {
+		name  string
+		names []string
+	}{
+		{"fields", fields},
+		{"exclude", exclude},
+	} {
+		for _, name := range param.names {
+			if !slices.Contains(known, name) {
+				return fieldSelection{}, apierror.InvalidParameter(param.name, fmt.Sprintf("unknown field %q, expected one of %s",
+					name, strings.Join(known, ", ")))
+			}
+		}
+	}
+	return fieldSelection{fields: fields, exclude: exclude}, nil
+}
+
+// splitFields reads a query parameter of field names, repeated or comma-separated
+func splitFields(values []string) []string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// apply returns record as a JSON object of the selected fields, or record itself when every
+// field is kept
+func (f fieldSelection) apply(record any) any {
+	if len(f.fields) == 0 && len(f.exclude) == 0 {
+		return record
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return record
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &object); err != nil {
+		return record
+	}
+
+	for name := range object {
+		if (len(f.fields) > 0 && !slices.Contains(f.fields, name)) || slices.Contains(f.exclude, name) {
+			delete(object, name)
+		}
+	}
+	return object
+}
+
+// jsonFieldNames lists the JSON names of the fields of a struct, for checking field
+// selections against the records of a response
+func jsonFieldNames(v any) []string {
+	t := reflect.TypeOf(v)
+	var names []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		names = append(names, cmp.Or(name, field.Name))
+	}
+	return names
+}
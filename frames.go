@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+// findSimilarFrames searches the frames of the video records matching the records query and
+// returns up to limit of those records, nearest first. Each record is ranked by its nearest
+// frame, which is returned as its matched frame.
+func findSimilarFrames(db *gorm.DB, records *gorm.DB, embedding []float32, limit int) ([]models.ImageEmbedding, error) {
+	var frames []models.VideoFrame
+	if err := db.Model(&models.VideoFrame{}).
+		Select("id, record_id, offset_ms, file_path, text, created_at, embedding <-> ? AS distance", pgvector.NewVector(embedding)).
+		Where("record_id IN (?)", records.Select("id")).
+		Order("distance").Limit(limit * chunkCandidates).Scan(&frames).Error; err != nil {
+		return nil, err
+	}
+
+	nearest := map[uint]models.VideoFrame{}
+	var ids []uint
+	for _, frame := range frames {
+		if _, seen := nearest[frame.RecordID]; seen {
+			continue
+		}
+		nearest[frame.RecordID] = frame
+		if ids = append(ids, frame.RecordID); len(ids) == limit {
+			break
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var found []models.ImageEmbedding
+	if err := db.Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.ImageEmbedding, len(found))
+	for _, record := range found {
+		byID[record.ID] = record
+	}
+
+	results := make([]models.ImageEmbedding, 0, len(ids))
+	for _, id := range ids {
+		record, ok := byID[id]
+		if !ok {
+			continue
+		}
+		frame := nearest[id]
+		frame.Timestamp = frameTimestamp(frame)
+		record.Distance = frame.Distance
+		record.MatchedFrame = &frame
+		results = append(results, record)
+	}
+	return results, nil
+}
+
+// frameTimestamp is the offset of a frame into its video, such as 01:23
+func frameTimestamp(frame models.VideoFrame) string {
+	return services.FormatTimestamp(time.Duration(frame.OffsetMS) * time.Millisecond)
+}
+
+// linkFrame sets the URL of a frame of the video at videoPath, playing the video from the
+// frame, and the URL of its thumbnail
+func linkFrame(r *http.Request, videoPath string, frame *models.VideoFrame) {
+	frame.URL = fileURL(r, videoPath) + "#t=" + strconv.FormatFloat(float64(frame.OffsetMS)/1000, 'f', -1, 64)
+	frame.ThumbnailURL = fileURL(r, frame.FilePath)
+}
+
+// linkVideos links the previews and matched frames of video search results
+func linkVideos(r *http.Request, results []models.ImageEmbedding) {
+	for i := range results {
+		if results[i].PreviewPath != "" {
+			results[i].PreviewURL = fileURL(r, results[i].PreviewPath)
+		}
+		if results[i].MatchedFrame != nil {
+			linkFrame(r, results[i].FilePath, results[i].MatchedFrame)
+		}
+	}
+}
+
+// listFrames returns the frames sampled from a video record, in order, with their
+// descriptions and links
+func (s *server) listFrames(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.loadImage(w, r)
+	if !ok {
+		return
+	}
+
+	var frames []models.VideoFrame
+	if err := s.db.WithContext(r.Context()).Omit("embedding").Where("record_id = ?", record.ID).
+		Order("offset_ms").Find(&frames).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load video frames", err))
+		return
+	}
+	for i := range frames {
+		frames[i].Timestamp = frameTimestamp(frames[i])
+		linkFrame(r, record.FilePath, &frames[i])
+	}
+
+	response := map[string]any{
+		"record_id": record.ID,
+		"frames":    frames,
+	}
+	if record.PreviewPath != "" {
+		response["preview_url"] = fileURL(r, record.PreviewPath)
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
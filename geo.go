@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// earthRadiusKm is the mean radius of the Earth
+const earthRadiusKm = 6371.0
+
+// defaultRadiusKm is the search radius when only a point is given
+const defaultRadiusKm = 5.0
+
+// geoFilter restricts a search to records whose EXIF location lies within RadiusKm of a point
+type geoFilter struct {
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	RadiusKm float64 `json:"radius_km"`
+}
+
+// validate checks the coordinates, defaulting the radius to defaultRadiusKm
+func (g *geoFilter) validate() error {
+	if g.Lat < -90 || g.Lat > 90 {
+		return fmt.Errorf("near.lat must be between -90 and 90")
+	}
+	if g.Lon < -180 || g.Lon > 180 {
+		return fmt.Errorf("near.lon must be between -180 and 180")
+	}
+	if g.RadiusKm < 0 {
+		return fmt.Errorf("near.radius_km must be positive")
+	}
+	if g.RadiusKm == 0 {
+		g.RadiusKm = defaultRadiusKm
+	}
+	return nil
+}
+
+// apply adds the radius condition to a query. A bounding box on the indexed columns
+// narrows the candidates before the exact haversine distance is checked.
+func (g geoFilter) apply(query *gorm.DB) *gorm.DB {
+	latDelta := g.RadiusKm / earthRadiusKm * 180 / math.Pi
+	query = query.Where("latitude BETWEEN ? AND ?", g.Lat-latDelta, g.Lat+latDelta)
+
+	// Longitude degrees shrink towards the poles, near them the box spans every longitude
+	if cos := math.Cos(g.Lat * math.Pi / 180); cos > 0 {
+		if lonDelta := latDelta / cos; lonDelta < 180 && g.Lon-lonDelta >= -180 && g.Lon+lonDelta <= 180 {
+			query = query.Where("longitude BETWEEN ? AND ?", g.Lon-lonDelta, g.Lon+lonDelta)
+		}
+	}
+
+	// least guards asin against rounding just above 1 for antipodal points
+	return query.Where(`2 * ? * asin(least(1, sqrt(
+		power(sin(radians(latitude - ?) / 2), 2) +
+		cos(radians(?)) * cos(radians(latitude)) * power(sin(radians(longitude - ?) / 2), 2)
+	))) <= ?`, earthRadiusKm, g.Lat, g.Lat, g.Lon, g.RadiusKm)
+}
+
+// parseGeoPoint parses a "lat,lon" pair as given to the --near flag
+func parseGeoPoint(point string) (float64, float64, error) {
+	latText, lonText, ok := strings.Cut(point, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("--near must be lat,lon")
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latText), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("--near has an invalid latitude: %w", err)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonText), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("--near has an invalid longitude: %w", err)
+	}
+	return lat, lon, nil
+}
@@ -0,0 +1,162 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/database"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// Result is the outcome of a single dependency check
+type Result struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Hint  string `json:"hint,omitempty"`
+}
+
+// Ready reports whether every check passed
+func Ready(results []Result) bool {
+	for _, result := range results {
+		if !result.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// Run checks Postgres, the pgvector extension, Redis, Ollama and the configured models.
+// It uses the open database connection db when there is one.
+func Run(ctx context.Context, db *gorm.DB, q *queue.Client) []Result {
+	var results []Result
+
+	if db == nil {
+		opened, err := database.Open()
+		if err != nil {
+			results = append(results, failed("postgres", err,
+				"Check DB_HOST, DB_PORT, DB_USER, DB_PASSWORD and DB_NAME, and that Postgres is running"))
+		} else {
+			db = opened
+			if sqlDB, err := opened.DB(); err == nil {
+				defer sqlDB.Close()
+			}
+		}
+	}
+
+	if db != nil {
+		results = append(results, checkPostgres(ctx, db), checkPgvector(ctx, db))
+	} else {
+		results = append(results, Result{Name: "pgvector", Error: "skipped, database unavailable"})
+	}
+
+	results = append(results, checkRedis(ctx, q))
+	results = append(results, checkOllama(ctx)...)
+
+	return results
+}
+
+func checkPostgres(ctx context.Context, db *gorm.DB) Result {
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		return failed("postgres", err,
+			"Check DB_HOST, DB_PORT, DB_USER, DB_PASSWORD and DB_NAME, and that Postgres is running")
+	}
+	return Result{Name: "postgres", OK: true}
+}
+
+func checkPgvector(ctx context.Context, db *gorm.DB) Result {
+	var version string
+	err := db.WithContext(ctx).Raw("SELECT extversion FROM pg_extension WHERE extname = 'vector'").Scan(&version).Error
+	if err != nil {
+		return failed("pgvector", err, "")
+	}
+	if version == "" {
+		return Result{
+			Name:  "pgvector",
+			Error: "vector extension is not installed",
+			Hint:  "Install pgvector and run CREATE EXTENSION vector; as a superuser",
+		}
+	}
+	return Result{Name: "pgvector", OK: true}
+}
+
+func checkRedis(ctx context.Context, q *queue.Client) Result {
+	if err := q.Ping(ctx); err != nil {
+		return failed("redis", err, fmt.Sprintf("Check REDIS_ADDR (%s) and REDIS_PASSWORD, and that Redis is running", viper.GetString("REDIS_ADDR")))
+	}
+	return Result{Name: "redis", OK: true}
+}
+
+func checkOllama(ctx context.Context) []Result {
+	models, err := services.Ollama.ListModels(ctx)
+	if err != nil {
+		return []Result{failed("ollama", err, fmt.Sprintf("Check OLLAMA_HOST (%s) and that Ollama is running on port 11434", viper.GetString("OLLAMA_HOST")))}
+	}
+
+	results := []Result{{Name: "ollama", OK: true}}
+	for _, key := range []string{"MODEL", "EMBEDDING_MODEL"} {
+		model := viper.GetString(key)
+		name := "model " + model
+		if hasModel(models, model) {
+			results = append(results, Result{Name: name, OK: true})
+		} else {
+			results = append(results, Result{
+				Name:  name,
+				Error: fmt.Sprintf("%s %q is not available in Ollama", key, model),
+				Hint:  fmt.Sprintf("Run: ollama pull %s", model),
+			})
+		}
+	}
+	return results
+}
+
+// hasModel matches model against Ollama's names, where an untagged model means :latest
+func hasModel(models []string, model string) bool {
+	for _, name := range models {
+		if name == model || name == model+":latest" {
+			return true
+		}
+	}
+	return false
+}
+
+func failed(name string, err error, hint string) Result {
+	return Result{Name: name, Error: err.Error(), Hint: hint}
+}
+
+// Doctor runs every check, prints the results to out and returns the process exit code
+func Doctor(out io.Writer) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	q := queue.Connect()
+	defer q.Close()
+
+	results := Run(ctx, nil, q)
+	for _, result := range results {
+		if result.OK {
+			fmt.Fprintf(out, "[ok]   %s\n", result.Name)
+			continue
+		}
+		fmt.Fprintf(out, "[fail] %s: %s\n", result.Name, result.Error)
+		if result.Hint != "" {
+			fmt.Fprintf(out, "       %s\n", result.Hint)
+		}
+	}
+
+	if !Ready(results) {
+		fmt.Fprintln(out, "Some checks failed")
+		return 1
+	}
+	fmt.Fprintln(out, "All checks passed")
+	return 0
+}
@@ -0,0 +1,96 @@
+// Package hooks lets integrators add steps to the ingestion pipeline: before an image is
+// analyzed, such as removing a watermark, and after its record is saved, such as pushing it
+// to another system. Hooks are Go values registered at startup, or webhooks configured with
+// HOOK_PRE_ANALYSIS_URL and HOOK_POST_PERSIST_URL.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+)
+
+// ErrRejected is wrapped by errors of pre-analysis hooks that refuse an image, so its
+// analysis fails without being retried
+var ErrRejected = errors.New("rejected by hook")
+
+// Image is an image about to be analyzed. Pre-analysis hooks may replace Data, which is only
+// what the vision model sees, the stored file is left as uploaded.
+type Image struct {
+	FilePath  string
+	MediaType string
+	Data      []byte
+}
+
+// PreAnalysis runs on every image before the vision model sees it. An error fails the
+// analysis, wrapping ErrRejected to fail it for good.
+type PreAnalysis interface {
+	Name() string
+	BeforeAnalysis(ctx context.Context, image *Image) error
+}
+
+// PostPersist runs after a record is saved. Errors are logged, the record is kept.
+type PostPersist interface {
+	Name() string
+	AfterPersist(ctx context.Context, record *models.ImageEmbedding) error
+}
+
+var (
+	mu          sync.RWMutex
+	preAnalysis []PreAnalysis
+	postPersist []PostPersist
+)
+
+// RegisterPreAnalysis adds a hook run before analysis, after the ones registered before it.
+// Register hooks at startup, such as from an init function.
+func RegisterPreAnalysis(hook PreAnalysis) {
+	mu.Lock()
+	defer mu.Unlock()
+	preAnalysis = append(preAnalysis, hook)
+}
+
+// RegisterPostPersist adds a hook run after a record is saved, after the ones registered before it
+func RegisterPostPersist(hook PostPersist) {
+	mu.Lock()
+	defer mu.Unlock()
+	postPersist = append(postPersist, hook)
+}
+
+// BeforeAnalysis runs the registered pre-analysis hooks, then the webhook, on image in turn,
+// stopping at the first error
+func BeforeAnalysis(ctx context.Context, image *Image) error {
+	mu.RLock()
+	hooks := append([]PreAnalysis{}, preAnalysis...)
+	mu.RUnlock()
+	if webhook := preAnalysisWebhook(); webhook != nil {
+		hooks = append(hooks, webhook)
+	}
+
+	for _, hook := range hooks {
+		if err := hook.BeforeAnalysis(ctx, image); err != nil {
+			return fmt.Errorf("pre-analysis hook %s: %w", hook.Name(), err)
+		}
+	}
+	return nil
+}
+
+// AfterPersist runs every registered post-persist hook, then the webhook, on record, logging
+// the ones that fail
+func AfterPersist(ctx context.Context, record *models.ImageEmbedding) {
+	mu.RLock()
+	hooks := append([]PostPersist{}, postPersist...)
+	mu.RUnlock()
+	if webhook := postPersistWebhook(); webhook != nil {
+		hooks = append(hooks, webhook)
+	}
+
+	for _, hook := range hooks {
+		if err := hook.AfterPersist(ctx, record); err != nil {
+			slog.WarnContext(ctx, "Post-persist hook failed", "hook", hook.Name(), "record_id", record.ID, "error", err)
+		}
+	}
+}
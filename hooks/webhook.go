@@ -0,0 +1,104 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/deliveries"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/spf13/viper"
+)
+
+// webhook is a hook run by an HTTP endpoint
+type webhook struct {
+	url string
+}
+
+// preAnalysisWebhook is the pre-analysis webhook at HOOK_PRE_ANALYSIS_URL, nil when unset
+func preAnalysisWebhook() PreAnalysis {
+	if url := viper.GetString("HOOK_PRE_ANALYSIS_URL"); url != "" {
+		return &webhook{url: url}
+	}
+	return nil
+}
+
+// postPersistWebhook is the post-persist webhook at HOOK_POST_PERSIST_URL, nil when unset
+func postPersistWebhook() PostPersist {
+	if url := viper.GetString("HOOK_POST_PERSIST_URL"); url != "" {
+		return &webhook{url: url}
+	}
+	return nil
+}
+
+func (h *webhook) Name() string {
+	return "webhook"
+}
+
+// BeforeAnalysis posts the image bytes with their path in X-File-Path. A 200 response body
+// replaces the image, 204 keeps it and 422 rejects it.
+func (h *webhook) BeforeAnalysis(ctx context.Context, image *Image) error {
+	ctx, cancel := context.WithTimeout(ctx, viper.GetDuration("HOOK_TIMEOUT"))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(image.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", image.MediaType)
+	req.Header.Set("X-File-Path", image.FilePath)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		data, err := io.ReadAll(io.LimitReader(resp.Body, viper.GetInt64("MAX_FILE_BYTES")+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > viper.GetInt64("MAX_FILE_BYTES") {
+			return fmt.Errorf("webhook returned an image larger than MAX_FILE_BYTES")
+		}
+		image.Data = data
+		if mediaType := resp.Header.Get("Content-Type"); strings.HasPrefix(mediaType, "image/") {
+			image.MediaType = mediaType
+		}
+		return nil
+	case http.StatusNoContent:
+		return nil
+	case http.StatusUnprocessableEntity:
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w: %s", ErrRejected, strings.TrimSpace(string(reason)))
+	default:
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+}
+
+// AfterPersist posts the record as JSON, without its embedding, logging the delivery
+func (h *webhook) AfterPersist(ctx context.Context, record *models.ImageEmbedding) error {
+	ctx, cancel := context.WithTimeout(ctx, viper.GetDuration("HOOK_TIMEOUT"))
+	defer cancel()
+
+	return deliveries.Post(ctx, deliveries.HookPostPersist, h.url, map[string]any{
+		"id":            record.ID,
+		"file_path":     record.FilePath,
+		"original_name": record.OriginalName,
+		"media_type":    record.MediaType,
+		"original_path": record.OriginalPath,
+		"preview_path":  record.PreviewPath,
+		"text":          record.Text,
+		"caption":       record.Caption,
+		"visibility":    record.Visibility,
+		"is_batch":      record.IsBatch,
+		"batch_id":      record.BatchID,
+		"batch_paths":   record.BatchPaths,
+		"created_at":    record.CreatedAt,
+	})
+}
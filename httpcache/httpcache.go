@@ -0,0 +1,82 @@
+// Package httpcache lets browsers and CDNs cache responses by ETag. Records are updated in
+// place, such as by captions, without a modification time, so responses are validated by
+// ETag rather than Last-Modified.
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/config"
+)
+
+// ETag returns a strong entity tag of data
+func ETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// CacheControl returns the Cache-Control of a response cached for maxAge, or revalidated on
+// every use when maxAge is zero. Public responses, the same for every client without an API
+// key, may be kept by shared caches such as CDNs; others only by the client.
+func CacheControl(public bool, maxAge time.Duration) string {
+	scope := "private"
+	if public {
+		scope = "public"
+	}
+	if maxAge <= 0 {
+		return scope + ", no-cache"
+	}
+	return fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds()))
+}
+
+// NotModified reports whether the If-None-Match header of r lists etag, in which case the
+// client's copy is current. Weak and strong tags compare equal, as for GET requests.
+func NotModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteJSON writes v as a 200 JSON response tagged with the ETag of its content, or answers
+// 304 Not Modified when the client already has it. Caches must revalidate the response on
+// every use, so a record changed by a new analysis is never served stale, while unchanged
+// responses cost no body. public is as for CacheControl. With API_KEYS set, the body depends on
+// the key of the request, so shared caches keep one copy per Authorization header.
+func WriteJSON(w http.ResponseWriter, r *http.Request, public bool, v any) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Weak, as compression changes the bytes sent but not the content
+	etag := "W/" + ETag(body.Bytes())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", CacheControl(public, 0))
+	if len(config.List("API_KEYS")) > 0 {
+		w.Header().Add("Vary", "Authorization")
+	}
+	if NotModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
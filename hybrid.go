@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/pablobfonseca/go-image-vector/database"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pgvector/pgvector-go"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// hybridCandidates is how many times the limit of records the vector and full-text searches
+// each contribute to a hybrid ranking
+const hybridCandidates = 4
+
+// Hybrid fields are the rankings fused by hybrid search: distance to the embedding, and the
+// full-text match of the query text against descriptions and against captions
+const (
+	hybridFieldVector      = "vector"
+	hybridFieldDescription = "description"
+	hybridFieldCaption     = "caption"
+)
+
+// hybridWeights multiply the reciprocal rank score of each hybrid field. A zero weight leaves
+// its ranking out.
+type hybridWeights struct {
+	Vector      float64
+	Description float64
+	Caption     float64
+}
+
+// defaultHybridWeights are the weights of SEARCH_HYBRID_WEIGHT_VECTOR, _DESCRIPTION and _CAPTION
+func defaultHybridWeights() hybridWeights {
+	return hybridWeights{
+		Vector:      viper.GetFloat64("SEARCH_HYBRID_WEIGHT_VECTOR"),
+		Description: viper.GetFloat64("SEARCH_HYBRID_WEIGHT_DESCRIPTION"),
+		Caption:     viper.GetFloat64("SEARCH_HYBRID_WEIGHT_CAPTION"),
+	}
+}
+
+// override returns the weights with the ones a request sets by field name
+func (w hybridWeights) override(weights map[string]float64) hybridWeights {
+	for field, weight := range weights {
+		switch field {
+		case hybridFieldVector:
+			w.Vector = weight
+		case hybridFieldDescription:
+			w.Description = weight
+		case hybridFieldCaption:
+			w.Caption = weight
+		}
+	}
+	return w
+}
+
+// validateHybridWeights checks the weights of a request, by field name, and that the
+// weights they override leave at least one ranking to fuse
+func validateHybridWeights(weights map[string]float64) error {
+	for field, weight := range weights {
+		if field != hybridFieldVector && field != hybridFieldDescription && field != hybridFieldCaption {
+			return fmt.Errorf("unknown hybrid field %q, fields are vector, description and caption", field)
+		}
+		if weight < 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+			return fmt.Errorf("the weight of %s must be a number of at least 0", field)
+		}
+	}
+	if w := defaultHybridWeights().override(weights); w.Vector == 0 && w.Description == 0 && w.Caption == 0 {
+		return fmt.Errorf("at least one hybrid field needs a weight above 0")
+	}
+	return nil
+}
+
+// weightedRanking is the records of one hybrid field, best first, with the weight of its field
+type weightedRanking struct {
+	records []models.ImageEmbedding
+	weight  float64
+}
+
+// findHybrid returns the records matching the search, ranked by weighted reciprocal rank
+// fusion of their rank by distance to the embedding and their ranks by full-text match of
+// the query text against their descriptions and captions, so exact words such as error
+// codes are found even when the embedding misses them
+func findHybrid(db *gorm.DB, params searchParams, embedding []float32, limit int) ([]models.ImageEmbedding, error) {
+	vector := pgvector.NewVector(embedding)
+	distance := searchColumn(params.Field) + " <-> ? AS distance"
+	// Searches that weight no field, such as from the CLI, use the configured weights
+	weights := params.HybridWeights
+	if weights == (hybridWeights{}) {
+		weights = defaultHybridWeights()
+	}
+
+	var rankings []weightedRanking
+	if weights.Vector > 0 {
+		var nearest []models.ImageEmbedding
+		if err := searchQuery(db, params).Select("*, "+distance, vector).
+			Order("distance").Limit(limit * hybridCandidates).Scan(&nearest).Error; err != nil {
+			return nil, err
+		}
+		rankings = append(rankings, weightedRanking{nearest, weights.Vector})
+	}
+
+	// websearch_to_tsquery accepts any text, with quoted phrases and -excluded words
+	tsquery := "websearch_to_tsquery('" + database.TextSearchConfig + "', ?)"
+	if weights.Description > 0 {
+		var matching []models.ImageEmbedding
+		if err := searchQuery(db, params).Select("*, "+distance+", ts_rank_cd(text_search, "+tsquery+") AS text_rank", vector, params.Text).
+			Where("text_search @@ "+tsquery, params.Text).
+			Order("text_rank DESC").Limit(limit * hybridCandidates).Scan(&matching).Error; err != nil {
+			return nil, err
+		}
+		rankings = append(rankings, weightedRanking{matching, weights.Description})
+	}
+	if weights.Caption > 0 {
+		var captioned []models.ImageEmbedding
+		if err := searchQuery(db, params).Select("*, "+distance+", ts_rank_cd(caption_search, "+tsquery+") AS caption_rank", vector, params.Text).
+			Where("caption_search @@ "+tsquery, params.Text).
+			Order("caption_rank DESC").Limit(limit * hybridCandidates).Scan(&captioned).Error; err != nil {
+			return nil, err
+		}
+		rankings = append(rankings, weightedRanking{captioned, weights.Caption})
+	}
+
+	return fuseRanks(rankings, viper.GetInt("SEARCH_HYBRID_RRF_K"), limit), nil
+}
+
+// fuseRanks merges the records of several rankings with weighted reciprocal rank fusion: a
+// record scores weight/(k+rank) in each ranking it is in, ranks counting from 1, and the limit
+// best scores are returned, highest first. Ties keep the order the records first appear in.
+func fuseRanks(rankings []weightedRanking, k int, limit int) []models.ImageEmbedding {
+	records := map[uint]models.ImageEmbedding{}
+	scores := map[uint]float64{}
+	var ids []uint
+	for _, ranking := range rankings {
+		for i, record := range ranking.records {
+			if existing, seen := records[record.ID]; !seen {
+				ids = append(ids, record.ID)
+			} else if record.TextRank == 0 {
+				record.TextRank = existing.TextRank
+			}
+			records[record.ID] = record
+			scores[record.ID] += ranking.weight / float64(k+i+1)
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return scores[ids[i]] > scores[ids[j]]
+	})
+	results := make([]models.ImageEmbedding, 0, min(limit, len(ids)))
+	for _, id := range ids[:min(limit, len(ids))] {
+		record := records[id]
+		record.HybridScore = scores[id]
+		results = append(results, record)
+	}
+	return results
+}
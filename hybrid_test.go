@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+)
+
+func TestFuseRanks(t *testing.T) {
+	nearest := []models.ImageEmbedding{{ID: 1, Distance: 0.1}, {ID: 2, Distance: 0.2}, {ID: 3, Distance: 0.3}}
+	matching := []models.ImageEmbedding{{ID: 3, Distance: 0.3, TextRank: 0.8}, {ID: 4, Distance: 0.9, TextRank: 0.5}}
+
+	// 3 is in both rankings, and 2 and 4 are second in one each, tying on their score and
+	// keeping the order of the distance ranking
+	results := fuseRanks([]weightedRanking{{nearest, 1}, {matching, 1}}, 60, 4)
+	want := []uint{3, 1, 2, 4}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, id := range want {
+		if results[i].ID != id {
+			t.Errorf("results[%d] = %d, want %d", i, results[i].ID, id)
+		}
+	}
+	if results[0].TextRank != 0.8 || results[0].Distance != 0.3 {
+		t.Errorf("fused record lost its ranks: %+v", results[0])
+	}
+	if score := 1.0/61 + 1.0/63; results[0].HybridScore != score {
+		t.Errorf("HybridScore = %v, want %v", results[0].HybridScore, score)
+	}
+}
+
+func TestFuseRanksWeighted(t *testing.T) {
+	nearest := []models.ImageEmbedding{{ID: 1, Distance: 0.1}, {ID: 2, Distance: 0.2}}
+	captioned := []models.ImageEmbedding{{ID: 2, Distance: 0.2}, {ID: 3, Distance: 0.9}}
+
+	// Doubling the caption ranking puts its first record ahead of the nearest one, and its
+	// second one too
+	results := fuseRanks([]weightedRanking{{nearest, 1}, {captioned, 2}}, 60, 3)
+	want := []uint{2, 3, 1}
+	for i, id := range want {
+		if i >= len(results) || results[i].ID != id {
+			t.Fatalf("results = %v, want IDs %v", results, want)
+		}
+	}
+	if score := 1.0/62 + 2.0/61; results[0].HybridScore != score {
+		t.Errorf("HybridScore = %v, want %v", results[0].HybridScore, score)
+	}
+}
+
+func TestValidateHybridWeights(t *testing.T) {
+	for _, weights := range []map[string]float64{
+		{"ocr": 2},
+		{"caption": -1},
+		{"vector": 0, "description": 0, "caption": 0},
+	} {
+		if err := validateHybridWeights(weights); err == nil {
+			t.Errorf("validateHybridWeights(%v) accepted invalid weights", weights)
+		}
+	}
+	if err := validateHybridWeights(map[string]float64{"caption": 2, "vector": 0}); err != nil {
+		t.Errorf("validateHybridWeights rejected valid weights: %v", err)
+	}
+}
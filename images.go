@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/cleanup"
+	"github.com/pablobfonseca/go-image-vector/httpcache"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"gorm.io/gorm"
+)
+
+// imageListMax caps the page size of the image list
+const imageListMax = 200
+
+// imageSnippetWidth is how much of the description the image list includes
+const imageSnippetWidth = 200
+
+// imageListFields are the fields of the records listed by listImages
+var imageListFields = []string{"id", "file_path", "original_name", "media_type", "title", "snippet", "is_batch", "batch_id", "created_at"}
+
+// listImages returns the indexed records, newest first, with the total for paging
+func (s *server) listImages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := allowQueryParams(query, "limit", "offset", "kind", "accessibility_issue", "fields", "exclude"); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	selection, err := newFieldSelection(splitFields(query["fields"]), splitFields(query["exclude"]), imageListFields)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	limit, offset, err := parsePage(query, imageListMax)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	kind := query.Get("kind")
+	if !validSearchKind(kind) {
+		apierror.Write(w, r, apierror.InvalidParameter("kind", "kind must be one of all, batch or image"))
+		return
+	}
+	issue := query.Get("accessibility_issue")
+	if err := validAccessibilityIssue(issue); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	images := visibleRecords(r, s.db.WithContext(r.Context()).Model(&models.ImageEmbedding{}))
+	switch kind {
+	case searchKindBatch:
+		images = images.Where("is_batch = ?", true)
+	case searchKindImage:
+		images = images.Where("is_batch = ?", false)
+	}
+	if issue != "" {
+		images = withAccessibilityIssue(s.db, images, issue)
+	}
+
+	var total int64
+	if err := images.Count(&total).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to count images", err))
+		return
+	}
+
+	var records []models.ImageEmbedding
+	if err := images.Select("id", "file_path", "original_name", "media_type", "title", "text", "is_batch", "batch_id", "created_at").
+		Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&records).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load images", err))
+		return
+	}
+
+	items := make([]any, len(records))
+	for i, record := range records {
+		items[i] = selection.apply(map[string]any{
+			"id":            record.ID,
+			"file_path":     record.FilePath,
+			"original_name": record.OriginalName,
+			"media_type":    record.MediaType,
+			"title":         record.Title,
+			"snippet":       truncate(record.Text, imageSnippetWidth),
+			"is_batch":      record.IsBatch,
+			"batch_id":      record.BatchID,
+			"created_at":    record.CreatedAt,
+		})
+	}
+
+	httpcache.WriteJSON(w, r, publicOnly(r), map[string]any{
+		"images": items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// imageID reads the record ID of an images route
+func imageID(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id == 0 {
+		return 0, apierror.InvalidParameter("id", "id must be a positive integer")
+	}
+	return uint(id), nil
+}
+
+// loadImage loads the record of an images route that r may see, without its embeddings,
+// answering the request when it cannot
+func (s *server) loadImage(w http.ResponseWriter, r *http.Request) (models.ImageEmbedding, bool) {
+	var record models.ImageEmbedding
+	id, err := imageID(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return record, false
+	}
+
+	err = visibleRecords(r, s.db.WithContext(r.Context())).Omit("embedding", "summary_embedding").First(&record, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Write(w, r, apierror.NotFound("Image not found"))
+		return record, false
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load image", err))
+		return record, false
+	}
+	return record, true
+}
+
+// getImage returns a record with its description and batch metadata, and the URLs its files
+// are served at
+func (s *server) getImage(w http.ResponseWriter, r *http.Request) {
+	if err := allowQueryParams(r.URL.Query()); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	record, ok := s.loadImage(w, r)
+	if !ok {
+		return
+	}
+
+	if record.Audit == services.AuditAccessibility {
+		if err := s.db.WithContext(r.Context()).Where("record_id = ?", record.ID).Order("id").
+			Find(&record.AccessibilityFindings).Error; err != nil {
+			apierror.Write(w, r, apierror.Internal("Failed to load accessibility findings", err))
+			return
+		}
+	}
+	records := []models.ImageEmbedding{record}
+	s.backfillBatchPaths(records)
+	record = records[0]
+
+	// The embeddings are not loaded, they are searched rather than read
+	response, ok := fieldSelection{exclude: []string{"embedding", "summary_embedding"}}.apply(record).(map[string]json.RawMessage)
+	if !ok {
+		apierror.Write(w, r, apierror.Internal("Failed to encode image", errors.New("record is not a JSON object")))
+		return
+	}
+	links := map[string]any{"url": fileURL(r, record.FilePath)}
+	if record.OriginalPath != "" {
+		links["original_url"] = fileURL(r, record.OriginalPath)
+	}
+	if record.PreviewPath != "" {
+		links["preview_url"] = fileURL(r, record.PreviewPath)
+	}
+	if record.IsBatch {
+		members := batchMemberPaths(record)
+		urls := make([]string, len(members))
+		for i, filePath := range members {
+			urls[i] = fileURL(r, filePath)
+		}
+		links["batch_urls"] = urls
+	}
+	for name, value := range links {
+		response[name], _ = json.Marshal(value)
+	}
+
+	httpcache.WriteJSON(w, r, publicOnly(r), response)
+}
+
+// getRawText returns the description of a record before REDACT_PII redacted it, only to
+// admins
+func (s *server) getRawText(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r, "Reading raw text") {
+		return
+	}
+	record, ok := s.loadImage(w, r)
+	if !ok {
+		return
+	}
+
+	text := record.RawText
+	if text == "" {
+		text = record.Text
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":         record.ID,
+		"text":       text,
+		"redacted":   record.RawText != "",
+		"redactions": record.Redactions,
+	})
+}
+
+// deleteImage deletes a record with its chunks, and every file of it that no other record
+// references
+func (s *server) deleteImage(w http.ResponseWriter, r *http.Request) {
+	if !requireAPIKey(w, r, "Deleting images") {
+		return
+	}
+	id, err := imageID(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	var record models.ImageEmbedding
+	if err := s.db.WithContext(r.Context()).Omit("embedding").First(&record, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierror.Write(w, r, apierror.NotFound("Image not found"))
+			return
+		}
+		apierror.Write(w, r, apierror.Internal("Failed to load image", err))
+		return
+	}
+
+	// Batch records whose member paths are only in their task result need them to delete the files
+	records := []models.ImageEmbedding{record}
+	s.backfillBatchPaths(records)
+	if err := cleanup.DeleteRecords(r.Context(), s.db, s.queue, s.files, records); err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to delete image", err))
+		return
+	}
+	slog.InfoContext(r.Context(), "Deleted image", "id", record.ID, "file_path", record.FilePath)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"deleted_id": record.ID,
+		"file_path":  record.FilePath,
+	})
+}
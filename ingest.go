@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/spf13/viper"
+)
+
+// ingestOutcome is what happened to one file during ingest
+type ingestOutcome int
+
+const (
+	ingestQueued ingestOutcome = iota
+	ingestDuplicate
+	ingestUnsupported
+	ingestQuarantined
+	ingestFailed
+)
+
+// ingestSummary counts ingest outcomes
+type ingestSummary struct {
+	mu          sync.Mutex
+	total       int
+	done        int
+	queued      int
+	duplicates  int
+	unsupported int
+	quarantined int
+	failed      int
+}
+
+func (s *ingestSummary) record(outcome ingestOutcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done++
+	switch outcome {
+	case ingestQueued:
+		s.queued++
+	case ingestDuplicate:
+		s.duplicates++
+	case ingestUnsupported:
+		s.unsupported++
+	case ingestQuarantined:
+		s.quarantined++
+	case ingestFailed:
+		s.failed++
+	}
+}
+
+// ingest walks the given files and directories, storing each new file and queueing it
+// for analysis with at most concurrency files in flight. Files whose content was already
+// seen in this run or is already stored are skipped unless force is set. Tasks are queued
+// on target.
+func (s *server) ingest(ctx context.Context, paths []string, concurrency int, force bool, target taskTarget) error {
+	files, err := collectFiles(paths)
+	if err != nil {
+		return err
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	summary := &ingestSummary{total: len(files)}
+	start := time.Now()
+
+	var seen sync.Map
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				outcome, detail := s.ingestFile(ctx, path, &seen, force, target)
+				summary.record(outcome)
+				reportIngest(summary, path, outcome, detail)
+			}
+		}()
+	}
+
+	for _, path := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+
+	fmt.Fprintf(os.Stderr, "\nIngested %d files in %s: %d queued, %d duplicates, %d unsupported, %d quarantined, %d failed\n",
+		summary.done, time.Since(start).Round(time.Millisecond),
+		summary.queued, summary.duplicates, summary.unsupported, summary.quarantined, summary.failed)
+
+	if summary.failed > 0 {
+		return fmt.Errorf("%d files failed", summary.failed)
+	}
+	return ctx.Err()
+}
+
+// collectFiles expands directories into the regular files below them, skipping hidden entries
+func collectFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != root && strings.HasPrefix(entry.Name(), ".") {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.Type().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// ingestFile hashes and stores one local file, then queues it for analysis
+func (s *server) ingestFile(ctx context.Context, path string, seen *sync.Map, force bool, target taskTarget) (ingestOutcome, string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ingestFailed, err.Error()
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return ingestFailed, err.Error()
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	if original, loaded := seen.LoadOrStore(hash, path); loaded && !force {
+		return ingestDuplicate, "same content as " + original.(string)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ingestFailed, err.Error()
+	}
+
+	// Skip content that an earlier upload or ingest already stored
+	if !force {
+		mediaType, err := storage.DetectMediaType(file)
+		if err != nil {
+			return ingestFailed, err.Error()
+		}
+		exists, err := s.files.Exists(ctx, storage.HashKey(hash, mediaType))
+		if err != nil {
+			return ingestFailed, err.Error()
+		}
+		if exists {
+			return ingestDuplicate, "already stored"
+		}
+	}
+
+	filename := displayName(filepath.Base(path))
+	stored, err := s.storeUpload(ctx, file, filename, hash, size, "")
+	if err != nil {
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnsupportedMediaType {
+			return ingestUnsupported, apiErr.Message
+		}
+		return ingestFailed, err.Error()
+	}
+	if stored.QuarantineTaskID != "" {
+		return ingestQuarantined, "flagged by scanner"
+	}
+
+	taskID, err := s.enqueueAnalysis(ctx, stored, filename, imageCaption{}, viper.GetString("DEFAULT_VISIBILITY"), defaultAudit(), target)
+	if err != nil {
+		return ingestFailed, err.Error()
+	}
+	return ingestQueued, "task " + taskID
+}
+
+// reportIngest prints failures and a progress line to stderr
+func reportIngest(summary *ingestSummary, path string, outcome ingestOutcome, detail string) {
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+
+	if outcome == ingestFailed || outcome == ingestQuarantined {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s: %s\n", path, detail)
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K[%d/%d] %d queued, %d duplicates, %d skipped, %d failed",
+		summary.done, summary.total, summary.queued, summary.duplicates,
+		summary.unsupported+summary.quarantined, summary.failed)
+}
@@ -0,0 +1,413 @@
+//go:build integration
+
+package main
+
+// The integration tests run the API and a worker against Postgres with pgvector and Redis
+// started in Docker by dockertest, with the fake Ollama of servicestest:
+//
+//	go test -tags integration -run Integration .
+//
+// They are skipped when Docker is not available.
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/pablobfonseca/go-image-vector/cleanup"
+	"github.com/pablobfonseca/go-image-vector/database"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/services/servicestest"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/worker"
+	"github.com/pgvector/pgvector-go"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// integrationTimeout bounds the wait for containers to accept connections and for tasks to finish
+const integrationTimeout = 2 * time.Minute
+
+// startContainer runs a container removed when the test ends, and returns the host and port
+// its port is published on
+func startContainer(t *testing.T, pool *dockertest.Pool, repository string, tag string, port string, env ...string) (string, string) {
+	t.Helper()
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: repository,
+		Tag:        tag,
+		Env:        env,
+	}, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		t.Fatalf("Failed to start %s:%s: %v", repository, tag, err)
+	}
+	t.Cleanup(func() { pool.Purge(resource) })
+
+	// Containers left behind by an interrupted run are removed by Docker
+	resource.Expire(uint(integrationTimeout.Seconds()) * 5)
+
+	host, hostPort, err := net.SplitHostPort(resource.GetHostPort(port))
+	if err != nil {
+		t.Fatalf("Failed to find the port of %s: %v", repository, err)
+	}
+	return host, hostPort
+}
+
+// startIntegration returns a server connected the way serve does, to Postgres and Redis
+// containers and a fake Ollama, and runs a worker for it until the test ends
+func startIntegration(t *testing.T) (*server, *servicestest.Ollama) {
+	t.Helper()
+
+	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
+	if err != nil {
+		t.Skipf("Docker is not available: %v", err)
+	}
+	pool.MaxWait = integrationTimeout
+
+	dbHost, dbPort := startContainer(t, pool, "pgvector/pgvector", "pg16", "5432/tcp",
+		"POSTGRES_USER=test", "POSTGRES_PASSWORD=test", "POSTGRES_DB=images")
+	viper.Set("DB_HOST", dbHost)
+	viper.Set("DB_PORT", dbPort)
+	viper.Set("DB_USER", "test")
+	viper.Set("DB_PASSWORD", "test")
+	viper.Set("DB_NAME", "images")
+	viper.Set("DB_SSLMODE", "disable")
+
+	err = pool.Retry(func() error {
+		db, err := database.Open()
+		if err != nil {
+			return err
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		defer sqlDB.Close()
+		return sqlDB.Ping()
+	})
+	if err != nil {
+		t.Fatalf("Postgres did not start: %v", err)
+	}
+
+	redisHost, redisPort := startContainer(t, pool, "redis", "7-alpine", "6379/tcp")
+	viper.Set("REDIS_ADDR", net.JoinHostPort(redisHost, redisPort))
+	q := queue.Connect()
+	t.Cleanup(func() { q.Close() })
+	if err := pool.Retry(func() error { return q.Ping(context.Background()) }); err != nil {
+		t.Fatalf("Redis did not start: %v", err)
+	}
+
+	viper.Set("STORAGE_BACKEND", "local")
+	viper.Set("UPLOADS_DIR", t.TempDir())
+	s := newServer(database.Connect(), q, storage.Initialize())
+	if err := database.Migrate(s.db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	ollama := servicestest.Start(t)
+	s.services = ollama.Clients(s.files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	workers := worker.RunWorkers(ctx, s.workerDeps(), []string{queue.ImageProcessingQueue}, 1)
+	t.Cleanup(func() {
+		cancel()
+		workers.Stop()
+	})
+	return s, ollama
+}
+
+// pngImage encodes a one pixel image of a color, so images of different colors are stored apart
+func pngImage(t *testing.T, c color.Color) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, c)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// postUpload posts images with form fields to the upload endpoint of s
+func postUpload(t *testing.T, s *server, fields map[string]string, images ...[]byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	for i, data := range images {
+		part, _ := form.CreateFormFile("images", fmt.Sprintf("screen-%d.png", i+1))
+		part.Write(data)
+	}
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.handler(testConfig).ServeHTTP(rec, req)
+	return rec
+}
+
+// upload posts images with form fields and returns the IDs of the queued tasks, and the record
+// ID reserved for each image
+func upload(t *testing.T, s *server, fields map[string]string, images ...[]byte) ([]string, []uint) {
+	t.Helper()
+
+	rec := postUpload(t, s, fields, images...)
+	var response struct {
+		TaskIDs []string `json:"task_ids"`
+		Files   []struct {
+			RecordID uint `json:"record_id"`
+		} `json:"files"`
+	}
+	if rec.Code != http.StatusAccepted || json.Unmarshal(rec.Body.Bytes(), &response) != nil || len(response.TaskIDs) == 0 {
+		t.Fatalf("Upload answered %d: %s", rec.Code, rec.Body.String())
+	}
+
+	recordIDs := make([]uint, len(response.Files))
+	for i, file := range response.Files {
+		recordIDs[i] = file.RecordID
+	}
+	return response.TaskIDs, recordIDs
+}
+
+// waitForTask polls a task until it completes and returns its result
+func waitForTask(t *testing.T, s *server, taskID string) map[string]any {
+	t.Helper()
+
+	deadline := time.Now().Add(integrationTimeout)
+	for time.Now().Before(deadline) {
+		_, response := request(t, s, "GET", "/api/v1/tasks/"+taskID, "")
+		switch response["status"] {
+		case "completed":
+			result, _ := response["result"].(map[string]any)
+			return result
+		case "failed":
+			t.Fatalf("Task %s failed: %v", taskID, response["result"])
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Task %s did not finish in %s", taskID, integrationTimeout)
+	return nil
+}
+
+// search posts a search and returns the IDs of the results in order
+func search(t *testing.T, s *server, body string) []uint {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/api/v1/search", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.handler(testConfig).ServeHTTP(rec, req)
+
+	var results []struct {
+		ID uint `json:"id"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &results) != nil {
+		t.Fatalf("Search answered %d: %s", rec.Code, rec.Body.String())
+	}
+
+	ids := make([]uint, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	return ids
+}
+
+func TestIntegrationUploadAndSearch(t *testing.T) {
+	s, ollama := startIntegration(t)
+
+	// Single screenshots and journeys get different descriptions, by the images in the call
+	ollama.Respond(func(prompt string, images int) string {
+		switch {
+		case images == 1:
+			return "# Checkout\n\nA checkout page with a credit card form and a pay button."
+		case images > 1:
+			return "# Signup journey\n\nA user creates an account, confirms the email address and lands on a welcome dashboard."
+		}
+		return "Fake answer"
+	})
+
+	imageTask, imageRecord := upload(t, s, nil, pngImage(t, color.White))
+	imageResult := waitForTask(t, s, imageTask[0])
+	imageID := uint(imageResult["id"].(float64))
+
+	batchTask, batchRecords := upload(t, s, map[string]string{"batch_analyze": "true"},
+		pngImage(t, color.Black), pngImage(t, color.RGBA{R: 255, A: 255}))
+	batchResult := waitForTask(t, s, batchTask[0])
+	batchID := uint(batchResult["id"].(float64))
+
+	// The records are saved under the IDs the uploads answered with
+	if imageRecord[0] != imageID {
+		t.Errorf("upload reserved record %d, the task saved %d", imageRecord[0], imageID)
+	}
+	for _, id := range batchRecords {
+		if id != batchID {
+			t.Errorf("batch upload reserved records %v, the task saved journey %d", batchRecords, batchID)
+			break
+		}
+	}
+
+	if ids := search(t, s, `{"query": "credit card checkout"}`); len(ids) == 0 || ids[0] != imageID {
+		t.Errorf("checkout search found %v, want image %d first", ids, imageID)
+	}
+	if ids := search(t, s, `{"query": "account signup email confirmation", "kind": "batch"}`); len(ids) != 1 || ids[0] != batchID {
+		t.Errorf("journey search found %v, want only journey %d", ids, batchID)
+	}
+
+	// Uploading the same bytes again with their digest answers with the stored record
+	white := pngImage(t, color.White)
+	sum := sha256.Sum256(white)
+	rec := postUpload(t, s, map[string]string{"sha256": hex.EncodeToString(sum[:])}, white)
+	if rec.Code != http.StatusOK || !bytes.Contains(rec.Body.Bytes(), []byte(`"existing":true`)) {
+		t.Errorf("second upload of the same image answered %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// explainSearch returns the plan of a search by description, exact or through the index.
+// Sequential scans are disabled, as a table of a few records is cheaper to scan than to search
+// by index, so only the exact search falls back to one.
+func explainSearch(t *testing.T, s *server, exact bool) string {
+	t.Helper()
+
+	var plan strings.Builder
+	err := s.withSearchDB(context.Background(), exact, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SET LOCAL enable_seqscan = off").Error; err != nil {
+				return err
+			}
+			stmt := searchQuery(tx.Session(&gorm.Session{DryRun: true}), searchParams{TopK: 5, Field: searchFieldDescription}).
+				Select("*, embedding <-> ? AS distance", pgvector.NewVector(make([]float32, 768))).
+				Order("distance").Limit(5).Find(&[]models.ImageEmbedding{}).Statement
+
+			rows, err := tx.Raw("EXPLAIN "+stmt.SQL.String(), stmt.Vars...).Rows()
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var line string
+				if err := rows.Scan(&line); err != nil {
+					return err
+				}
+				plan.WriteString(line + "\n")
+			}
+			return rows.Err()
+		})
+	})
+	if err != nil {
+		t.Fatalf("Failed to explain search: %v", err)
+	}
+	return plan.String()
+}
+
+func TestIntegrationSearchIndex(t *testing.T) {
+	s, _ := startIntegration(t)
+
+	if plan := explainSearch(t, s, false); !strings.Contains(plan, "idx_embedding_l2") {
+		t.Errorf("search does not use the L2 index:\n%s", plan)
+	}
+	if plan := explainSearch(t, s, true); strings.Contains(plan, "idx_embedding_l2") {
+		t.Errorf("exact search uses the index:\n%s", plan)
+	}
+}
+
+func TestIntegrationStorageReservation(t *testing.T) {
+	s, _ := startIntegration(t)
+	quota := queue.StorageQuota{Total: 1000, PerKey: 300}
+
+	// Reservations at once only pass the quota of the key as long as it holds
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var reserved, exceeded int
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := s.queue.ReserveStoredFile(fmt.Sprintf("file-%d.png", i), 100, "key-a", quota)
+			mu.Lock()
+			defer mu.Unlock()
+			var quotaErr *queue.QuotaExceededError
+			switch {
+			case errors.As(err, &quotaErr):
+				exceeded++
+			case err != nil:
+				t.Errorf("ReserveStoredFile: %v", err)
+			case ok:
+				reserved++
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 3 || exceeded != 7 {
+		t.Errorf("reserved %d and rejected %d files, want 3 and 7", reserved, exceeded)
+	}
+	if usage, _ := s.queue.GetStorageUsage("key-a"); usage != 300 {
+		t.Errorf("key usage = %d, want 300", usage)
+	}
+
+	// A file tracked already is not counted again, and releasing a reservation frees it
+	if ok, err := s.queue.ReserveStoredFile("file-0.png", 100, "key-b", quota); ok || err != nil {
+		t.Errorf("reserving a tracked file = %v, %v, want false without error", ok, err)
+	}
+	if err := s.queue.UntrackStoredFile("file-0.png"); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := s.queue.GetStorageUsage(""); usage != 200 {
+		t.Errorf("usage after releasing a file = %d, want 200", usage)
+	}
+}
+
+func TestIntegrationDeleteRecordTasks(t *testing.T) {
+	s, _ := startIntegration(t)
+
+	// Both uploads of the same bytes analyze one record, and deleting it removes both tasks
+	image := pngImage(t, color.White)
+	first, _ := upload(t, s, nil, image)
+	result := waitForTask(t, s, first[0])
+	second, _ := upload(t, s, nil, image)
+	waitForTask(t, s, second[0])
+
+	var record models.ImageEmbedding
+	if err := s.db.First(&record, uint(result["id"].(float64))).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanup.DeleteRecords(context.Background(), s.db, s.queue, s.files, []models.ImageEmbedding{record}); err != nil {
+		t.Fatal(err)
+	}
+	for _, taskID := range []string{first[0], second[0]} {
+		if status, _ := s.queue.GetTaskStatus(taskID); status != "unknown" {
+			t.Errorf("task %s of the deleted record is still %s", taskID, status)
+		}
+		if result, _ := s.queue.GetTaskResult(taskID); result != nil {
+			t.Errorf("task %s of the deleted record kept its result", taskID)
+		}
+	}
+}
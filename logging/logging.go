@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/config"
+	"github.com/spf13/viper"
+)
+
+// level is the active log level, updated when the configuration is reloaded
+var level slog.LevelVar
+
+// Setup installs the default slog logger using LOG_LEVEL (debug, info, warn, error)
+// and LOG_FORMAT (text or json)
+func Setup() {
+	level.Set(ParseLevel(viper.GetString("LOG_LEVEL")))
+	config.Subscribe(func() {
+		level.Set(ParseLevel(viper.GetString("LOG_LEVEL")))
+	})
+
+	options := &slog.HandlerOptions{Level: &level}
+
+	var handler slog.Handler
+	if strings.EqualFold(viper.GetString("LOG_FORMAT"), "json") {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// ParseLevel converts a level name to a slog level, defaulting to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Fatal logs an error with the given attributes and exits the process
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
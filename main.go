@@ -1,171 +1,455 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/pablobfonseca/go-image-vector/database"
+	"github.com/pablobfonseca/go-image-vector/analytics"
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/auth"
+	"github.com/pablobfonseca/go-image-vector/compression"
+	"github.com/pablobfonseca/go-image-vector/config"
+	"github.com/pablobfonseca/go-image-vector/health"
+	"github.com/pablobfonseca/go-image-vector/logging"
+	"github.com/pablobfonseca/go-image-vector/metrics"
 	"github.com/pablobfonseca/go-image-vector/models"
 	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/reporting"
 	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/tracing"
+	"github.com/pablobfonseca/go-image-vector/version"
 	"github.com/pablobfonseca/go-image-vector/worker"
 	"github.com/pgvector/pgvector-go"
 	"github.com/rs/cors"
 	"github.com/spf13/viper"
+	"gorm.io/gorm"
 )
 
+// scanner checks uploads for malware before they are stored, nil when scanning is disabled
+var scanner services.Scanner
+
 // uploadImage handles image uploads and queues analysis tasks
-func uploadImage(w http.ResponseWriter, r *http.Request) {
-	uploadsDir := "./uploads"
-	if _, err := os.Stat(uploadsDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-			http.Error(w, "Failed to create uploads directory", http.StatusInternalServerError)
+func (s *server) uploadImage(w http.ResponseWriter, r *http.Request) {
+	maxUploadBytes := viper.GetInt64("MAX_UPLOAD_BYTES")
+	maxFileBytes := viper.GetInt64("MAX_FILE_BYTES")
+	maxFiles := viper.GetInt("MAX_UPLOAD_FILES")
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+
+	files, values, err := readUpload(r, maxUploadBytes, maxFileBytes, maxFiles)
+	if err != nil {
+		var limitErr *uploadLimitError
+		if errors.As(err, &limitErr) {
+			apierror.Write(w, r, apierror.New(http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, limitErr.message).
+				With("limit", limitErr.limit).With("value", limitErr.value))
+			return
+		}
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			apierror.Write(w, r, apiErr)
 			return
 		}
+		apierror.Write(w, r, apierror.BadRequest("Invalid multipart form: "+err.Error()))
+		return
 	}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
 
-	r.ParseMultipartForm(50 << 20)
+	// Validate every file and field before anything is stored or queued
+	if err := allowQueryParams(values, uploadFields...); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
 
-	form := r.MultipartForm
-	files := form.File["images"]
+	// Private records are only visible to requests with an API key, so only those may create them
+	visibility, err := parseVisibility(values.Get("visibility"))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if visibility == models.VisibilityPrivate && publicOnly(r) {
+		apierror.Write(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "Private uploads need an API key in the Authorization header"))
+		return
+	}
 
+	// Clients may identify files by content hash, so contents analyzed before are answered with
+	// their records, without uploading or analyzing them again
+	hashes, err := parseUploadHashes(values, files)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
 	if len(files) == 0 {
-		http.Error(w, "No images uploaded", http.StatusBadRequest)
+		if len(hashes) > 0 && values.Get("batch_analyze") != "true" {
+			s.respondExisting(w, r, hashes, visibility)
+			return
+		}
+		apierror.Write(w, r, apierror.InvalidParameter("images", "No images uploaded"))
 		return
 	}
 
-	if len(files) > 5 {
-		http.Error(w, "Maximum 5 images allowed", http.StatusBadRequest)
+	if err := validateUploadFiles(files); err != nil {
+		apierror.Write(w, r, err)
 		return
 	}
 
-	// Check if batch analysis is requested
-	batchAnalyze := r.FormValue("batch_analyze") == "true"
+	// Reject the upload up front if it would exceed the storage quota, in total or of its key
+	keyID := auth.KeyID(r)
+	if err := s.checkStorageQuota(files, keyID); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
 
-	taskIDs := []string{}
-	filePaths := []string{}
+	// Check if batch analysis is requested, optionally with each image analyzed on its own too.
+	// The images of an archive are always analyzed as one batch.
+	fromArchive := slices.ContainsFunc(files, func(file *uploadedFile) bool { return file.Archive != "" })
+	if fromArchive && values.Get("batch_analyze") == "false" {
+		apierror.Write(w, r, apierror.InvalidParameter("batch_analyze", "archives are analyzed as one batch, batch_analyze cannot be false"))
+		return
+	}
+	batchAnalyze := values.Get("batch_analyze") == "true" || fromArchive
+	perImage := batchAnalyze && values.Get("per_image") == "true"
+
+	// Journey narratives depend on the order of the steps
+	order := batchOrderUpload
+	var steps []int
+	if batchAnalyze {
+		if order, steps, err = parseBatchOrder(values, len(files)); err != nil {
+			apierror.Write(w, r, apierror.InvalidParameter("order", err.Error()))
+			return
+		}
+	}
 
-	// Save all the uploaded files
-	for _, handler := range files {
-		file, err := handler.Open()
-		if err != nil {
-			http.Error(w, "Failed to open uploaded file: "+err.Error(), http.StatusBadRequest)
+	// The scenario picks the batch prompts, BATCH_SCENARIO when not given
+	scenario := values.Get("scenario")
+	if scenario == "" {
+		scenario = viper.GetString("BATCH_SCENARIO")
+	}
+	if _, ok := services.LookupScenario(scenario); batchAnalyze && !ok {
+		apierror.Write(w, r, apierror.InvalidParameter("scenario", fmt.Sprintf("scenario must be one of %s", strings.Join(services.ScenarioNames(), ", "))))
+		return
+	}
+
+	// Captions describe single images, embedded with the vision model output or instead of it
+	captions, captionMode, err := parseCaptions(values, len(files))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if batchAnalyze && len(captions) > 0 {
+		apierror.Write(w, r, apierror.InvalidParameter("caption", "captions describe single images, they cannot be combined with batch_analyze"))
+		return
+	}
+
+	// Audits run on single images, and on the members of a batch analyzed on their own
+	audit, err := parseAudit(values.Get("audit"))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if values.Get("audit") != "" && audit != "" && batchAnalyze && !perImage {
+		apierror.Write(w, r, apierror.InvalidParameter("audit", "audits run on single images, batches need per_image=true"))
+		return
+	}
+
+	// Interactive clients that cannot poll wait for the analysis of one small image
+	sync := values.Get("sync") == "true"
+	if sync && (batchAnalyze || len(files) != 1) {
+		apierror.Write(w, r, apierror.InvalidParameter("sync", "sync=true analyzes a single image, without batch_analyze"))
+		return
+	}
+	if maxSyncBytes := viper.GetInt64("SYNC_MAX_FILE_BYTES"); sync && files[0].Size > maxSyncBytes {
+		apierror.Write(w, r, apierror.InvalidParameter("sync", fmt.Sprintf("sync=true analyzes images up to %d bytes", maxSyncBytes)).
+			With("limit", maxSyncBytes).With("value", files[0].Size))
+		return
+	}
+
+	// Long screen recordings can be cut into scenes, each described on its own, making a journey
+	// record of each recording
+	scenes := values.Get("scenes") == "true"
+	if scenes && (batchAnalyze || sync || len(captions) > 0 || values.Get("audit") != "") {
+		apierror.Write(w, r, apierror.InvalidParameter("scenes", "scenes=true cannot be combined with batch_analyze, sync, captions or audits"))
+		return
+	}
+	if scenes {
+		if err := validateSceneFiles(files); err != nil {
+			apierror.Write(w, r, err)
 			return
 		}
-		defer file.Close()
+	}
 
-		// Create a unique filename with original extension
-		filePath := fmt.Sprintf("%s/%d_%s", uploadsDir,
-			time.Now().UnixNano(), handler.Filename)
+	// Urgent interactive uploads can jump ahead of bulk jobs with a higher priority, or go to
+	// a queue served by dedicated workers
+	target, err := parseTaskTarget(values.Get("queue"), values.Get("priority"))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
 
-		out, err := os.Create(filePath)
-		if err != nil {
-			http.Error(w, "Failed to save file: "+err.Error(), http.StatusInternalServerError)
+	// Batch processing parameters from the form, or the defaults
+	maxChunkSize, err := positiveFormInt(values, "max_chunk_size", viper.GetInt("BATCH_CHUNK_SIZE"))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	maxParallel, err := positiveFormInt(values, "max_parallel", viper.GetInt("BATCH_MAX_PARALLEL"))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	// Failed chunks are retried chunk_retries times, and the batch proceeds without them when at
+	// least min_chunk_success of the chunks succeeded
+	tolerance := map[string]any{}
+	if value := values.Get("chunk_retries"); value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			apierror.Write(w, r, apierror.InvalidParameter("chunk_retries", "chunk_retries must be a non-negative integer"))
 			return
 		}
-		defer out.Close()
+		tolerance["chunk_retries"] = float64(retries)
+	}
+	if value := values.Get("min_chunk_success"); value != "" {
+		share, err := strconv.ParseFloat(value, 64)
+		if err != nil || share <= 0 || share > 1 {
+			apierror.Write(w, r, apierror.InvalidParameter("min_chunk_success", "min_chunk_success must be above 0 and at most 1"))
+			return
+		}
+		tolerance["min_chunk_success"] = share
+	}
+
+	taskIDs := []string{}
+	batch := []batchImage{}
+	quarantined := []string{}
+	existing := []map[string]any{}
+	var analysis *worker.AnalyzeImageResult
+	uploaded := make([]map[string]any, len(files))
+
+	// Save all the uploaded files
+	for i, file := range files {
+		// Single images the client identified by hash are not analyzed again when a record exists,
+		// unless a caption is to be applied to it
+		if len(hashes) > 0 && !batchAnalyze && !scenes && captionAt(captions, captionMode, i).text == "" {
+			record, err := s.findAnalyzedByHash(r.Context(), file.Hash, visibility)
+			if err != nil {
+				apierror.Write(w, r, apierror.Internal("Failed to look up existing records", err))
+				return
+			}
+			if record != nil {
+				existing = append(existing, existingRecord(file.Hash, record))
+				uploaded[i] = uploadedEntry(r, file, record.FilePath, record.MediaType, "existing")
+				uploaded[i]["record_id"] = record.ID
+				continue
+			}
+		}
 
-		_, err = io.Copy(out, file)
+		stored, err := s.storeUpload(r.Context(), file, file.Filename, file.Hash, file.Size, keyID)
 		if err != nil {
-			http.Error(w, "Failed while copying file: "+err.Error(), http.StatusInternalServerError)
+			var apiErr *apierror.Error
+			if !errors.As(err, &apiErr) {
+				apiErr = apierror.Internal("Failed to save file", err)
+			}
+			apierror.Write(w, r, apiErr)
 			return
 		}
 
-		filePaths = append(filePaths, filePath)
+		if stored.QuarantineTaskID != "" {
+			taskIDs = append(taskIDs, stored.QuarantineTaskID)
+			quarantined = append(quarantined, file.Filename)
+			uploaded[i] = uploadedEntry(r, file, "", "", "quarantined")
+			uploaded[i]["task_id"] = stored.QuarantineTaskID
+			continue
+		}
+		uploaded[i] = uploadedEntry(r, file, stored.FilePath, stored.MediaType, "pending")
 
-		// If not doing batch analysis, queue each image individually
+		// The record of each single image or recording gets its ID now, so clients know it
+		// before the analysis ends. A batch reserves one for its journey record below.
 		if !batchAnalyze {
-			// Queue the image analysis task
-			taskData := map[string]any{
-				"file_path": filePath,
+			if scenes {
+				stored.RecordID, err = s.reserveRecordID(r.Context())
+			} else {
+				stored.RecordID, err = s.recordIDFor(r.Context(), stored.FilePath, visibility)
 			}
-
-			taskID, err := queue.Enqueue(queue.ImageProcessingQueue, worker.TaskTypeAnalyzeImage, taskData)
 			if err != nil {
-				http.Error(w, "Failed to queue image for processing: "+err.Error(), http.StatusInternalServerError)
+				apierror.Write(w, r, apierror.Internal("Failed to reserve a record ID", err))
 				return
 			}
+			uploaded[i]["record_id"] = stored.RecordID
+		}
 
-			// Set initial task status
-			queue.SetTaskStatus(taskID, "pending")
+		// If not doing batch analysis, queue each image individually
+		if batchAnalyze {
+			image := batchImage{stored: stored, filename: file.Filename, index: i}
+			if steps != nil {
+				image.step = steps[i]
+			}
+			batch = append(batch, image)
+		} else if scenes {
+			taskID, err := s.enqueue(r.Context(), target, worker.TaskTypeAnalyzeScenes, analysisTaskData(stored, file.Filename, imageCaption{}, visibility, ""))
+			if err != nil {
+				apierror.Write(w, r, apierror.Internal("Failed to queue video for scene analysis", err))
+				return
+			}
 			taskIDs = append(taskIDs, taskID)
+			uploaded[i]["task_id"] = taskID
+		} else if sync {
+			taskID, result, err := s.analyzeSync(r.Context(), stored, file.Filename, captionAt(captions, captionMode, i), visibility, audit, target)
+			if err != nil && taskID == "" {
+				apierror.Write(w, r, apierror.Internal("Failed to queue image for processing", err))
+				return
+			}
+			if err != nil {
+				category, retryable := worker.Classify(err)
+				apierror.Write(w, r, apierror.New(http.StatusBadGateway, apierror.CodeAnalysisFailed, "Image analysis failed: "+err.Error()).
+					With("task_id", taskID).With("category", category).With("retryable", retryable))
+				return
+			}
+			taskIDs = append(taskIDs, taskID)
+			uploaded[i]["task_id"] = taskID
+			if analysis = result; analysis != nil {
+				uploaded[i]["status"] = "completed"
+				uploaded[i]["record_id"] = analysis.ID
+			}
+		} else {
+			taskID, err := s.enqueueAnalysis(r.Context(), stored, file.Filename, captionAt(captions, captionMode, i), visibility, audit, target)
+			if err != nil {
+				apierror.Write(w, r, apierror.Internal("Failed to queue image for processing", err))
+				return
+			}
+			taskIDs = append(taskIDs, taskID)
+			uploaded[i]["task_id"] = taskID
 		}
 	}
 
 	// If batch analysis is requested, queue a single task for all images
-	if batchAnalyze && len(filePaths) > 0 {
-		// Get batch processing parameters from form (if provided) or use defaults
-		maxChunkSize := viper.GetInt("BATCH_CHUNK_SIZE")
-		maxParallel := viper.GetInt("BATCH_MAX_PARALLEL")
-
-		// Check if parameters were explicitly provided in the request
-		if chunkSizeStr := r.FormValue("max_chunk_size"); chunkSizeStr != "" {
-			if val, err := fmt.Sscanf(chunkSizeStr, "%d", &maxChunkSize); err != nil || val <= 0 {
-				maxChunkSize = viper.GetInt("BATCH_CHUNK_SIZE")
-			}
+	if batchAnalyze && len(batch) > 0 {
+		sortBatch(batch, order)
+
+		var filePaths, originalNames, mediaTypes, originalPaths []string
+		for _, image := range batch {
+			filePaths = append(filePaths, image.stored.FilePath)
+			originalNames = append(originalNames, image.filename)
+			mediaTypes = append(mediaTypes, image.stored.MediaType)
+			originalPaths = append(originalPaths, image.stored.OriginalPath)
 		}
 
-		if parallelStr := r.FormValue("max_parallel"); parallelStr != "" {
-			if val, err := fmt.Sscanf(parallelStr, "%d", &maxParallel); err != nil || val <= 0 {
-				maxParallel = viper.GetInt("BATCH_MAX_PARALLEL")
-			}
-		}
-
-		// Queue the batch analysis task with processing parameters
+		// Queue the batch analysis task with processing parameters, listing the files in journey order
 		taskData := map[string]any{
 			"file_paths":     filePaths,
+			"original_names": originalNames,
+			"media_types":    mediaTypes,
+			"original_paths": originalPaths,
 			"max_chunk_size": float64(maxChunkSize),
 			"max_parallel":   float64(maxParallel),
+			"per_image":      perImage,
+			"scenario":       scenario,
+			"visibility":     visibility,
+		}
+		if perImage && audit != "" {
+			taskData["audit"] = audit
 		}
 
-		log.Printf("Queueing batch with %d images: chunk_size=%d, parallel=%d",
-			len(filePaths), maxChunkSize, maxParallel)
+		// Chunk error tolerance overrides BATCH_CHUNK_RETRIES and BATCH_MIN_CHUNK_SUCCESS
+		for key, value := range tolerance {
+			taskData[key] = value
+		}
 
-		taskID, err := queue.Enqueue(queue.ImageProcessingQueue, worker.TaskTypeAnalyzeMultipleImages, taskData)
+		journeyID, err := s.reserveRecordID(r.Context())
 		if err != nil {
-			http.Error(w, "Failed to queue batch image analysis: "+err.Error(), http.StatusInternalServerError)
+			apierror.Write(w, r, apierror.Internal("Failed to reserve a record ID", err))
 			return
 		}
+		taskData["record_id"] = float64(journeyID)
+
+		slog.InfoContext(r.Context(), "Queueing batch", "file_count", len(filePaths),
+			"chunk_size", maxChunkSize, "parallel", maxParallel, "order", order,
+			"queue", target.queue, "priority", target.priority)
 
-		// Set initial task status
-		queue.SetTaskStatus(taskID, "pending")
+		taskID, err := s.enqueue(r.Context(), target, worker.TaskTypeAnalyzeMultipleImages, taskData)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal("Failed to queue batch image analysis", err))
+			return
+		}
 		taskIDs = append(taskIDs, taskID)
+		for _, image := range batch {
+			uploaded[image.index]["task_id"] = taskID
+			uploaded[image.index]["record_id"] = journeyID
+		}
 	}
 
 	response := map[string]any{
 		"message":       "Images uploaded and queued for processing",
 		"task_ids":      taskIDs,
 		"batch_analyze": batchAnalyze,
+		"scenes":        scenes,
+		"queue":         target.queue,
+		"priority":      target.priority,
+		"files":         uploaded,
 	}
 
-	// Add batch processing parameters to response if we're doing batch analysis
-	if batchAnalyze && len(filePaths) > 0 {
-		response["max_chunk_size"] = viper.GetInt("BATCH_CHUNK_SIZE")
-		response["max_parallel"] = viper.GetInt("BATCH_MAX_PARALLEL")
-		response["file_count"] = len(filePaths)
-
-		// Only add actual parameters if they were provided and different from defaults
-		if chunkSizeStr := r.FormValue("max_chunk_size"); chunkSizeStr != "" {
-			if val, err := strconv.Atoi(chunkSizeStr); err == nil && val > 0 {
-				response["max_chunk_size"] = val
-			}
+	if len(quarantined) > 0 {
+		response["quarantined"] = quarantined
+	}
+
+	// Inline analyses answer with the record, or are reported as queued when they took too long
+	if sync && analysis != nil {
+		response["message"] = "Image analyzed"
+		response["record"] = analysis
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	if sync && len(taskIDs) > 0 && len(quarantined) == 0 {
+		response["message"] = "Image analysis did not finish in time and was queued for processing"
+		response["sync_timeout"] = true
+	}
+
+	// Files analyzed before, nothing was queued when all of them were
+	if len(existing) > 0 {
+		response["records"] = existing
+		if len(existing) == len(files) {
+			response["message"] = "Images already analyzed"
+			response["existing"] = true
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(response)
+			return
 		}
+	}
 
-		if parallelStr := r.FormValue("max_parallel"); parallelStr != "" {
-			if val, err := strconv.Atoi(parallelStr); err == nil && val > 0 {
-				response["max_parallel"] = val
-			}
+	// Add batch processing parameters to response if we're doing batch analysis
+	if batchAnalyze && len(batch) > 0 {
+		response["max_chunk_size"] = maxChunkSize
+		response["max_parallel"] = maxParallel
+		response["file_count"] = len(batch)
+		response["per_image"] = perImage
+		response["order"] = order
+		response["scenario"] = scenario
+
+		// The files in journey order, for clients to confirm the sequence
+		sequence := make([]string, len(batch))
+		for i, image := range batch {
+			sequence[i] = image.filename
 		}
+		response["sequence"] = sequence
 	}
 
 	w.WriteHeader(http.StatusAccepted)
@@ -173,27 +457,46 @@ func uploadImage(w http.ResponseWriter, r *http.Request) {
 }
 
 // getTaskStatus retrieves the status of a task
-func getTaskStatus(w http.ResponseWriter, r *http.Request) {
+func (s *server) getTaskStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	taskID := vars["taskID"]
 
 	if taskID == "" {
-		http.Error(w, "Task ID is required", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.BadRequest("Task ID is required"))
 		return
 	}
 
-	status, err := queue.GetTaskStatus(taskID)
+	status, err := s.tasks.GetTaskStatus(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get task status: "+err.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.Internal("Failed to get task status", err))
 		return
 	}
 
-	if status == "completed" {
-		result, err := queue.GetTaskResult(taskID)
+	// Failed tasks carry the error in their result
+	if status == "completed" || status == "failed" {
+		resultJSON, err := s.tasks.GetTaskResult(taskID)
 		if err != nil {
-			http.Error(w, "Failed to get task result: "+err.Error(), http.StatusInternalServerError)
+			apierror.Write(w, r, apierror.Internal("Failed to get task result", err))
 			return
 		}
+		var result any
+		if resultJSON != nil {
+			if result, err = worker.DecodeResult(resultJSON); err != nil {
+				apierror.Write(w, r, apierror.Internal("Failed to decode task result", err))
+				return
+			}
+		}
+
+		// The task ID is the batch ID and handed out at upload, so the record of a result is
+		// only shown to requests that may see it
+		visible, err := s.resultVisible(r, result)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal("Failed to check task result visibility", err))
+			return
+		}
+		if !visible {
+			result = nil
+		}
 
 		response := map[string]any{
 			"task_id": taskID,
@@ -213,57 +516,575 @@ func getTaskStatus(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// retryTask queues a failed task again with its original payload, from the dead letter list
+// of its queue, so a one-off failure does not require uploading the files again
+func (s *server) retryTask(w http.ResponseWriter, r *http.Request) {
+	if !requireAPIKey(w, r, "Retrying tasks") {
+		return
+	}
+	taskID := mux.Vars(r)["taskID"]
+
+	status, err := s.tasks.GetTaskStatus(taskID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to get task status", err))
+		return
+	}
+	if status == "unknown" {
+		apierror.Write(w, r, apierror.NotFound("Task not found"))
+		return
+	}
+	if status != "failed" {
+		apierror.Write(w, r, apierror.Newf(http.StatusConflict, apierror.CodeConflict, "Only failed tasks can be retried, task is %s", status).
+			With("status", status))
+		return
+	}
+
+	for _, queueName := range config.List("QUEUES") {
+		task, err := s.queue.RetryDeadLetter(queueName, taskID)
+		if errors.Is(err, queue.ErrNotDeadLetter) {
+			continue
+		}
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal("Failed to retry task", err))
+			return
+		}
+
+		slog.InfoContext(r.Context(), "Retrying task", "task_id", taskID, "queue", queueName, "attempt", task.Attempt)
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{
+			"task_id":  taskID,
+			"status":   "pending",
+			"queue":    queueName,
+			"priority": cmp.Or(task.Priority, queue.PriorityNormal),
+			"attempt":  task.Attempt,
+		})
+		return
+	}
+
+	// Quarantined uploads fail without a payload, and dead letters can be purged
+	apierror.Write(w, r, apierror.NotFound("Failed task has no dead letter to retry, upload the files again"))
+}
+
 // searchImages finds similar images based on text query
-func searchImages(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		QueryText string `json:"query"`
-		TopK      int    `json:"top_k"`
+func (s *server) searchImages(w http.ResponseWriter, r *http.Request) {
+	var req searchRequest
+	if err := decodeJSON(w, r, searchMaxBodyBytes, &req); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if err := req.validate(acceptsNDJSON(r)); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	params := req.params(r)
+
+	// A plain query is the common case, queries combine several texts and stored images
+	parts := req.Queries
+	if req.QueryText != "" {
+		parts = append([]queryPart{{Text: req.QueryText}}, parts...)
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	queryEmbedding, referenced, err := s.composeQuery(r.Context(), parts, req.Field, publicOnly(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, errQueryRecordNotFound):
+			apierror.Write(w, r, apierror.NotFound(err.Error()))
+		case errors.Is(err, errQueryEmbedding):
+			apierror.Write(w, r, apierror.BadRequest("Failed to generate embedding"))
+		default:
+			apierror.Write(w, r, apierror.Internal("Failed to compose query", err))
+		}
 		return
 	}
+	params.ExcludeIDs = referenced
 
-	if req.TopK <= 0 {
-		req.TopK = 5
+	if acceptsNDJSON(r) {
+		s.streamSearch(w, r, queryEmbedding, params, req.selection)
+		return
 	}
 
-	queryEmbedding, err := services.GenerateEmbedding(req.QueryText)
+	results, err := s.findSimilar(r.Context(), queryEmbedding, params)
 	if err != nil {
-		http.Error(w, "Failed to generate embedding", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Internal("Failed to search database", err))
 		return
 	}
+	linkVideos(r, results)
+
+	response := make([]any, len(results))
+	for i, result := range results {
+		response[i] = req.selection.apply(result)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// params are the search parameters of a validated search request made by r
+func (req *searchRequest) params(r *http.Request) searchParams {
+	params := searchParams{TopK: req.TopK, Kind: req.Kind, Field: req.Field, Rank: req.Rank, RecencyWeight: req.RecencyWeight,
+		Exact: req.Exact, Near: req.Near, Label: req.Label, AccessibilityIssue: req.AccessibilityIssue, Language: req.Language,
+		MediaType: req.MediaType, BatchID: req.BatchID, Text: req.text(), MinScore: req.MinScore, PublicOnly: publicOnly(r),
+		HybridWeights: defaultHybridWeights().override(req.HybridWeights)}
+	if req.HalfLife != "" {
+		params.HalfLife, _ = time.ParseDuration(req.HalfLife)
+	}
+	params.Since, _ = parseTimeBound(req.Since)
+	params.Until, _ = parseTimeBound(req.Until)
+	return params
+}
+
+// Search kinds select batch journey records, individual images, or both
+const (
+	searchKindAll   = "all"
+	searchKindBatch = "batch"
+	searchKindImage = "image"
+)
+
+// validSearchKind reports whether kind is a known search kind, empty meaning all
+func validSearchKind(kind string) bool {
+	switch kind {
+	case "", searchKindAll, searchKindBatch, searchKindImage:
+		return true
+	}
+	return false
+}
+
+// Search fields select which embedding of a record a search matches, the one of its full
+// description, the one of its summary, the ones of the chunks of its description, or the
+// ones of the frames of a video
+const (
+	searchFieldDescription = "description"
+	searchFieldSummary     = "summary"
+	searchFieldChunks      = "chunks"
+	searchFieldFrames      = "frames"
+)
+
+// validSearchField reports whether field is a known search field, empty meaning SEARCH_FIELD
+func validSearchField(field string) bool {
+	switch field {
+	case "", searchFieldDescription, searchFieldSummary, searchFieldChunks, searchFieldFrames:
+		return true
+	}
+	return false
+}
+
+// Search ranks order results by distance alone, by distance adjusted for age, or by distance
+// fused with the full-text match of the query
+const (
+	rankSimilarity = "similarity"
+	rankRecency    = "recency"
+	rankHybrid     = "hybrid"
+)
+
+// recencyCandidates is how many times TopK nearest records are reranked by recency
+const recencyCandidates = 10
+
+// validSearchRank reports whether rank is a known ranking, empty meaning similarity
+func validSearchRank(rank string) bool {
+	return rank == "" || rank == rankSimilarity || rank == rankRecency || rank == rankHybrid
+}
+
+// validHybridField reports whether hybrid ranking can search field, which must be an embedding
+// of the whole record like the full-text index of its description
+func validHybridField(field string) bool {
+	return field != searchFieldChunks && field != searchFieldFrames
+}
+
+// searchParams configures a similarity search
+type searchParams struct {
+	TopK int
+	Kind string
+
+	// Field is the embedding matched, defaulting to SEARCH_FIELD. Searching summaries,
+	// chunks or frames leaves out records without them.
+	Field string
+
+	// Rank by recency blends distance with age. HalfLife and RecencyWeight default
+	// to SEARCH_RECENCY_HALF_LIFE and SEARCH_RECENCY_WEIGHT.
+	Rank          string
+	HalfLife      time.Duration
+	RecencyWeight *float64
+
+	// Text is the query matched against the full-text index of descriptions by hybrid ranking
+	Text string
+	// HybridWeights weight the rankings hybrid ranking fuses, the configured ones when zero
+	HybridWeights hybridWeights
+
+	// Exact skips the approximate index for a full scan
+	Exact bool
+
+	// MinScore leaves out records scoring below it, so a search may return fewer than TopK
+	MinScore float64
+
+	// ExcludeIDs leaves records out of the results, such as the examples of the query
+	ExcludeIDs []uint
+
+	// Near keeps only records photographed within a radius of a point
+	Near *geoFilter
+
+	// Label keeps only records classified with this UI state label
+	Label string
+
+	// AccessibilityIssue keeps only records the accessibility audit found this issue type on
+	AccessibilityIssue string
+
+	// Language matches the descriptions of records in a language, or in every language
+	// with languageAll, instead of the descriptions they were analyzed with
+	Language string
+
+	// MediaType keeps only records of a media type, or of every subtype of one with type/*
+	MediaType string
+
+	// BatchID keeps only the journey record of a batch and the records of its images
+	BatchID string
+
+	// Since and Until keep only records created at or after Since and before Until, when set
+	Since time.Time
+	Until time.Time
+
+	// PublicOnly leaves private records out, for requests that are not authenticated
+	PublicOnly bool
+}
+
+// findSimilar returns the records closest to the embedding
+func (s *server) findSimilar(ctx context.Context, embedding []float32, params searchParams) ([]models.ImageEmbedding, error) {
+	// Reranking by recency considers more of the nearest records than it returns
+	limit := params.TopK
+	if params.Rank == rankRecency {
+		limit *= recencyCandidates
+	}
+	params.Field = cmp.Or(params.Field, viper.GetString("SEARCH_FIELD"))
 
 	var results []models.ImageEmbedding
-	if err := database.DB.Raw(`SELECT * FROM image_embeddings ORDER BY embedding <-> ? LIMIT ?`,
-		pgvector.NewVector(queryEmbedding), req.TopK).Scan(&results).Error; err != nil {
-		http.Error(w, "Failed to search database: "+err.Error(), http.StatusInternalServerError)
-		return
+	err := s.withSearchDB(ctx, params.Exact, func(db *gorm.DB) error {
+		if params.Language != "" {
+			var err error
+			results, err = findSimilarDescriptions(db, params, embedding, limit)
+			return err
+		}
+		if params.Rank == rankHybrid {
+			var err error
+			results, err = findHybrid(db, params, embedding, limit)
+			return err
+		}
+		query := searchQuery(db, params)
+		if params.Field == searchFieldChunks {
+			var err error
+			results, err = findSimilarChunks(db, query, embedding, limit)
+			return err
+		}
+		if params.Field == searchFieldFrames {
+			var err error
+			results, err = findSimilarFrames(db, query, embedding, limit)
+			return err
+		}
+		return query.Select("*, "+searchColumn(params.Field)+" <-> ? AS distance", pgvector.NewVector(embedding)).
+			Order("distance").Limit(limit).Scan(&results).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.backfillBatchPaths(results)
+	// Hybrid results are ordered by their hybrid_score, which a score of the distance alone contradicts
+	if params.Rank != rankHybrid {
+		results = scoreResults(results, params.MinScore)
+	}
+
+	if params.Rank == rankRecency {
+		halfLife := params.HalfLife
+		if halfLife <= 0 {
+			halfLife = viper.GetDuration("SEARCH_RECENCY_HALF_LIFE")
+		}
+		weight := viper.GetFloat64("SEARCH_RECENCY_WEIGHT")
+		if params.RecencyWeight != nil {
+			weight = *params.RecencyWeight
+		}
+
+		rankByRecency(results, halfLife, weight, time.Now())
+		results = results[:min(params.TopK, len(results))]
 	}
 
-	// For batch results, fetch the associated image paths if they exist
+	return results, nil
+}
+
+// searchScore is the similarity score of a distance, from 1 for the query itself towards 0.
+// Distances are Euclidean between embeddings that are not normalized, so the score orders
+// records like their distance but is not a cosine similarity.
+func searchScore(distance float64) float64 {
+	return 1 / (1 + distance)
+}
+
+// scoreResults sets the score of results and keeps those scoring at least minScore, in order
+func scoreResults(results []models.ImageEmbedding, minScore float64) []models.ImageEmbedding {
+	kept := results[:0]
+	for _, result := range results {
+		if result.Score = searchScore(result.Distance); result.Score >= minScore {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+// searchQuery returns the query of the records a search considers, by its filters
+func searchQuery(db *gorm.DB, params searchParams) *gorm.DB {
+	query := db.Model(&models.ImageEmbedding{})
+
+	switch params.Kind {
+	case searchKindBatch:
+		query = query.Where("is_batch = ?", true)
+	case searchKindImage:
+		query = query.Where("is_batch = ?", false)
+	}
+	if params.Field == searchFieldSummary {
+		query = query.Where("summary_embedding IS NOT NULL")
+	}
+	if len(params.ExcludeIDs) > 0 {
+		query = query.Where("id NOT IN ?", params.ExcludeIDs)
+	}
+	if params.Near != nil {
+		query = params.Near.apply(query)
+	}
+	if params.Label != "" {
+		query = query.Where("label = ?", params.Label)
+	}
+	if params.AccessibilityIssue != "" {
+		query = withAccessibilityIssue(db, query, params.AccessibilityIssue)
+	}
+	if family, ok := strings.CutSuffix(params.MediaType, "/*"); ok {
+		query = query.Where("media_type LIKE ?", family+"/%")
+	} else if params.MediaType != "" {
+		query = query.Where("media_type = ?", params.MediaType)
+	}
+	if params.BatchID != "" {
+		query = query.Where("batch_id = ?", params.BatchID)
+	}
+	if !params.Since.IsZero() {
+		query = query.Where("created_at >= ?", params.Since)
+	}
+	if !params.Until.IsZero() {
+		query = query.Where("created_at < ?", params.Until)
+	}
+	if params.PublicOnly {
+		query = query.Where("visibility = ?", models.VisibilityPublic)
+	}
+	return query
+}
+
+// searchColumn returns the embedding column matched for a search field. Both columns are
+// whitelisted, so they can be written into the statement.
+func searchColumn(field string) string {
+	if field == searchFieldSummary {
+		return "summary_embedding"
+	}
+	return "embedding"
+}
+
+// withSearchDB runs search against the database, in a full scan for exact searches
+func (s *server) withSearchDB(ctx context.Context, exact bool, search func(db *gorm.DB) error) error {
+	if !exact {
+		return search(s.db.WithContext(ctx))
+	}
+
+	// Disabling index scans for this transaction only forces a full scan, so the
+	// results are the true nearest neighbours rather than the ANN approximation
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SET LOCAL enable_indexscan = off").Error; err != nil {
+			return err
+		}
+		return search(tx)
+	})
+}
+
+// backfillBatchPaths fetches the batch paths of batch records stored before they were
+// persisted from the task result
+func (s *server) backfillBatchPaths(results []models.ImageEmbedding) {
 	for i, result := range results {
-		if result.IsBatch && result.BatchID != "" {
+		if result.IsBatch && result.BatchID != "" && len(result.BatchPaths) == 0 {
 			// Get all the batch paths for this batch from Redis
-			batchResult, err := queue.GetTaskResult(result.BatchID)
-			if err == nil && batchResult != nil {
-				if batchPaths, ok := batchResult["batch_paths"].([]any); ok {
-					// Convert the interface slice to string slice
-					stringPaths := make([]string, 0, len(batchPaths))
-					for _, path := range batchPaths {
-						if strPath, ok := path.(string); ok {
-							stringPaths = append(stringPaths, strPath)
-						}
+			resultJSON, err := s.tasks.GetTaskResult(result.BatchID)
+			if err == nil && resultJSON != nil {
+				if batchResult, err := worker.DecodeResult(resultJSON); err == nil {
+					if batch, ok := batchResult.(*worker.BatchResult); ok {
+						results[i].BatchPaths = batch.BatchPaths
 					}
-					results[i].BatchPaths = stringPaths
 				}
 			}
 		}
 	}
+}
+
+// rankByRecency orders results by distance scaled up with age. A new record keeps its
+// distance, one a half-life old has it increased by half of weight, and the oldest
+// records approach an increase of weight.
+func rankByRecency(results []models.ImageEmbedding, halfLife time.Duration, weight float64, now time.Time) {
+	for i := range results {
+		age := max(now.Sub(results[i].CreatedAt), 0)
+		decay := math.Exp2(-float64(age) / float64(halfLife))
+		results[i].RankedDistance = results[i].Distance * (1 + weight*(1-decay))
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].RankedDistance < results[j].RankedDistance
+	})
+}
+
+// getStats returns storage usage and record counts, with the usage of the API key of r
+func (s *server) getStats(w http.ResponseWriter, r *http.Request) {
+	usage, err := s.queue.GetStorageUsage("")
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to get storage usage", err))
+		return
+	}
+
+	var imageCount, batchCount int64
+	if err := s.db.WithContext(r.Context()).Model(&models.ImageEmbedding{}).Where("is_batch = ?", false).
+		Count(&imageCount).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to count records", err))
+		return
+	}
+	if err := s.db.WithContext(r.Context()).Model(&models.ImageEmbedding{}).Where("is_batch = ?", true).
+		Count(&batchCount).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to count records", err))
+		return
+	}
+
+	stats := map[string]any{
+		"storage_used_bytes":  usage,
+		"storage_quota_bytes": viper.GetInt64("STORAGE_QUOTA_BYTES"),
+		"image_count":         imageCount,
+		"batch_count":         batchCount,
+	}
+	if keyID := auth.KeyID(r); keyID != "" {
+		keyUsage, err := s.queue.GetStorageUsage(keyID)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal("Failed to get storage usage", err))
+			return
+		}
+		stats["key_storage_used_bytes"] = keyUsage
+		stats["key_storage_quota_bytes"] = viper.GetInt64("STORAGE_QUOTA_BYTES_PER_KEY")
+	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(results)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// getProjection projects the embeddings of the most recent records (optionally filtered by
+// kind and creation time) to 2D with PCA, for a scatter plot of the corpus
+func (s *server) getProjection(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := allowQueryParams(query, "method", "kind", "limit", "since", "until"); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	if method := query.Get("method"); method != "" && method != "pca" {
+		apierror.Write(w, r, apierror.InvalidParameter("method", "method must be pca"))
+		return
+	}
+
+	kind := query.Get("kind")
+	if !validSearchKind(kind) {
+		apierror.Write(w, r, apierror.InvalidParameter("kind", "kind must be one of all, batch or image"))
+		return
+	}
+
+	maxPoints := viper.GetInt("PROJECTION_MAX_POINTS")
+	limit := maxPoints
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			apierror.Write(w, r, apierror.InvalidParameter("limit", "limit must be a positive integer"))
+			return
+		}
+		limit = min(parsed, maxPoints)
+	}
+
+	records := visibleRecords(r, s.db.WithContext(r.Context()).Model(&models.ImageEmbedding{}).
+		Select("id, file_path, original_name, is_batch, created_at, embedding"))
+
+	switch kind {
+	case searchKindBatch:
+		records = records.Where("is_batch = ?", true)
+	case searchKindImage:
+		records = records.Where("is_batch = ?", false)
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"since", "created_at >= ?"},
+		{"until", "created_at < ?"},
+	} {
+		if value := query.Get(bound.param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				apierror.Write(w, r, apierror.InvalidParameter(bound.param, bound.param+" must be an RFC 3339 time such as 2024-01-31T00:00:00Z"))
+				return
+			}
+			records = records.Where(bound.condition, t)
+		}
+	}
+
+	var embeddings []models.ImageEmbedding
+	if err := records.Order("created_at DESC").Limit(limit).Find(&embeddings).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load embeddings", err))
+		return
+	}
+
+	vectors := make([][]float32, len(embeddings))
+	for i, embedding := range embeddings {
+		vectors[i] = embedding.Embedding.Slice()
+	}
+	projection := analytics.PCA(vectors)
+
+	points := make([]map[string]any, len(embeddings))
+	for i, embedding := range embeddings {
+		points[i] = map[string]any{
+			"id":            embedding.ID,
+			"x":             projection.Points[i][0],
+			"y":             projection.Points[i][1],
+			"file_path":     embedding.FilePath,
+			"original_name": embedding.OriginalName,
+			"is_batch":      embedding.IsBatch,
+			"created_at":    embedding.CreatedAt,
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"method":             "pca",
+		"count":              len(points),
+		"truncated":          len(points) == limit,
+		"explained_variance": projection.ExplainedVariance,
+		"points":             points,
+	})
+}
+
+// getReadiness reports whether the dependencies needed to serve requests are available
+func (s *server) getReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	results := health.Run(ctx, s.db, s.queue)
+
+	status := "ready"
+	code := http.StatusOK
+	if !health.Ready(results) {
+		status = "not_ready"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": status,
+		"checks": results,
+	})
+}
+
+// getVersion returns the build information
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(version.Info())
 }
 
 // getConfig returns current system configuration
@@ -271,81 +1092,89 @@ func getConfig(w http.ResponseWriter, r *http.Request) {
 	config := map[string]any{
 		// Worker configuration
 		"worker_count": viper.GetInt("WORKER_COUNT"),
+		"serve_mode":   viper.GetString("SERVE_MODE"),
 
 		// Batch processing configuration
 		"batch_chunk_size":   viper.GetInt("BATCH_CHUNK_SIZE"),
 		"batch_max_parallel": viper.GetInt("BATCH_MAX_PARALLEL"),
+		"batch_scenario":     viper.GetString("BATCH_SCENARIO"),
+		"batch_scenarios":    services.ScenarioNames(),
+
+		// Queues and priorities uploads may target
+		"queues":     config.List("QUEUES"),
+		"priorities": queue.Priorities,
+
+		// Upload limits
+		"max_upload_bytes":  viper.GetInt64("MAX_UPLOAD_BYTES"),
+		"max_file_bytes":    viper.GetInt64("MAX_FILE_BYTES"),
+		"max_upload_files":  viper.GetInt("MAX_UPLOAD_FILES"),
+		"archive_max_files": viper.GetInt("ARCHIVE_MAX_FILES"),
+		"archive_max_bytes": viper.GetInt64("ARCHIVE_MAX_BYTES"),
 
 		// Model configuration
 		"model":           viper.GetString("MODEL"),
 		"embedding_model": viper.GetString("EMBEDDING_MODEL"),
 
 		// System info
-		"version": "1.1.0", // Update with your actual version
+		"version": version.Version,
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(config)
 }
 
-func main() {
-	database.Connect()
+// serve runs the HTTP API together with an in-process worker pool
+func serve(cfg *config.Config) {
+	stopTelemetry := startTelemetry("go-image-vector-api")
+	defer stopTelemetry()
 
-	queue.Initialize()
+	s := newServer(connectDatabase(cfg), queue.Connect(), storage.Initialize())
+	initScanner()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	numWorkers := viper.GetInt("WORKER_COUNT")
-	if numWorkers <= 0 {
-		numWorkers = 4
+	// Search replicas leave the tasks to the ingestion nodes
+	var workerPool *worker.Worker
+	if !cfg.SearchOnly() {
+		workerPool = worker.RunWorkers(ctx, s.workerDeps(), cfg.Queues, cfg.WorkerCount)
 	}
 
-	workerPool := worker.RunWorkers(ctx, numWorkers)
-	defer workerPool.Stop()
-
-	r := mux.NewRouter()
-	apiRouter := r.PathPrefix("/api/v1").Subrouter()
-
-	apiRouter.HandleFunc("/upload", uploadImage).Methods("POST")
-	apiRouter.HandleFunc("/search", searchImages).Methods("POST")
-	apiRouter.HandleFunc("/tasks/{taskID}", getTaskStatus).Methods("GET")
-	apiRouter.HandleFunc("/config", getConfig).Methods("GET")
-
-	r.HandleFunc("/upload", uploadImage).Methods("POST")
-	r.HandleFunc("/search", searchImages).Methods("POST")
-	r.HandleFunc("/config", getConfig).Methods("GET")
+	handler := s.handler(cfg)
 
-	uploadsDir := "./uploads"
-	if _, err := os.Stat(uploadsDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-			log.Fatal("Failed to create uploads directory:", err)
-		}
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%s", cfg.Port),
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
-	fs := http.FileServer(http.Dir(uploadsDir))
-	r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", fs))
 
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-		AllowCredentials: true,
-	})
-
-	handler := c.Handler(r)
-
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", getPort()),
-		Handler: handler,
+	var redirectSrv *http.Server
+	if cfg.TLSEnabled() {
+		redirectSrv = configureTLS(cfg, srv)
 	}
 
-	serverErrors := make(chan error, 1)
+	serverErrors := make(chan error, 2)
 
 	go func() {
-		log.Printf("Server starting on port %s...\n", getPort())
+		slog.Info("Server starting", "port", cfg.Port, "mode", cfg.ServeMode, "tls", cfg.TLSEnabled(), "version", version.Version, "commit", version.Commit)
+		if cfg.TLSEnabled() {
+			// Certificate files are empty with autocert, which supplies them through TLSConfig
+			serverErrors <- srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+			return
+		}
 		serverErrors <- srv.ListenAndServe()
 	}()
 
+	if redirectSrv != nil {
+		go func() {
+			slog.Info("HTTP redirect server starting", "addr", redirectSrv.Addr)
+			serverErrors <- redirectSrv.ListenAndServe()
+		}()
+	}
+
 	// Listen for OS signals
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
@@ -353,53 +1182,112 @@ func main() {
 	// Block until a signal is received or an error occurs
 	select {
 	case err := <-serverErrors:
-		log.Fatalf("Error starting server: %v", err)
+		logging.Fatal("Error starting server", "error", err)
 
 	case <-shutdown:
-		log.Println("Server is shutting down...")
+		slog.Info("Server is shutting down", "timeout", cfg.ShutdownTimeout)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// Requests and then tasks in progress share the one deadline
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 		defer cancel()
 
+		// Stop accepting requests and let those in progress finish, such as uploads that
+		// are still enqueuing
+		if redirectSrv != nil {
+			redirectSrv.Shutdown(ctx)
+		}
+
 		err := srv.Shutdown(ctx)
 		if err != nil {
-			log.Printf("Error during server shutdown: %v", err)
+			slog.Error("Error during server shutdown", "error", err)
 			err = srv.Close()
 		}
 
-		switch {
-		case err != nil:
-			log.Fatalf("Error during server shutdown: %v", err)
-		case ctx.Err() != nil:
-			log.Fatalf("Timeout during shutdown: %v", ctx.Err())
+		// Stop dequeuing and wait for the tasks in progress. Those still running at the
+		// deadline are requeued for the next worker.
+		if workerPool != nil {
+			if drainErr := workerPool.Drain(ctx); drainErr != nil {
+				slog.Warn("Tasks interrupted by the shutdown timeout were requeued", "error", drainErr)
+			}
+		}
+
+		if err != nil {
+			logging.Fatal("Error during server shutdown", "error", err)
 		}
+		slog.Info("Server stopped")
 	}
 }
 
-// getPort returns the port to listen on
-func getPort() string {
-	port := viper.GetString("PORT")
-	if port == "" {
-		port = "8080"
+// handler routes the API, the uploaded files and the operational endpoints, behind the
+// request ID, tracing, metrics, error reporting, CORS and compression middleware
+func (s *server) handler(cfg *config.Config) http.Handler {
+	r := mux.NewRouter()
+	r.Use(logging.RequestIDMiddleware)
+	r.Use(tracing.Middleware)
+	r.Use(metrics.Middleware)
+	r.Use(reporting.Middleware)
+
+	// Unmatched routes skip the middleware, so they get their request ID here
+	r.NotFoundHandler = logging.RequestIDMiddleware(apierror.NotFoundHandler())
+	r.MethodNotAllowedHandler = logging.RequestIDMiddleware(apierror.MethodNotAllowedHandler())
+
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+	r.HandleFunc("/readyz", s.getReadiness).Methods("GET")
+	apiRouter := r.PathPrefix("/api/v1").Subrouter()
+
+	// Endpoints that write are left out of search replicas
+	if !cfg.SearchOnly() {
+		apiRouter.HandleFunc("/upload", s.uploadImage).Methods("POST")
+		apiRouter.HandleFunc("/tasks/{taskID}/retry", s.retryTask).Methods("POST")
+		apiRouter.HandleFunc("/images/{id}", s.deleteImage).Methods("DELETE")
+		apiRouter.HandleFunc("/images/{id}/descriptions/{language}", s.putDescription).Methods("PUT")
+		apiRouter.HandleFunc("/images/{id}/descriptions/{language}", s.deleteDescription).Methods("DELETE")
+		apiRouter.HandleFunc("/translations", s.createTranslation).Methods("POST")
+		apiRouter.HandleFunc("/batches/{id}", s.deleteBatch).Methods("DELETE")
+		apiRouter.HandleFunc("/shares", s.createShare).Methods("POST")
+		apiRouter.HandleFunc("/shares/{token}", s.deleteShare).Methods("DELETE")
+		apiRouter.HandleFunc("/webhooks/deliveries/{id}/replay", s.replayDelivery).Methods("POST")
+		r.HandleFunc("/upload", s.uploadImage).Methods("POST")
 	}
-	return port
-}
 
-func init() {
-	viper.SetConfigFile(".env")
-	viper.SetConfigType("env")
+	apiRouter.HandleFunc("/search", s.searchImages).Methods("POST")
+	apiRouter.HandleFunc("/search/image", s.searchByImage).Methods("POST")
+	apiRouter.HandleFunc("/tasks/{taskID}", s.getTaskStatus).Methods("GET")
+	apiRouter.HandleFunc("/config", getConfig).Methods("GET")
+	apiRouter.HandleFunc("/stats", s.getStats).Methods("GET")
+	apiRouter.HandleFunc("/version", getVersion).Methods("GET")
+	apiRouter.HandleFunc("/analytics/projection", s.getProjection).Methods("GET")
+	apiRouter.HandleFunc("/timeline", s.getTimeline).Methods("GET")
+	apiRouter.HandleFunc("/images", s.listImages).Methods("GET")
+	apiRouter.HandleFunc("/images/{id}", s.getImage).Methods("GET")
+	apiRouter.HandleFunc("/images/{id}/raw", s.getRawText).Methods("GET")
+	apiRouter.HandleFunc("/images/{id}/frames", s.listFrames).Methods("GET")
+	apiRouter.HandleFunc("/images/{id}/descriptions", s.listDescriptions).Methods("GET")
+	apiRouter.HandleFunc("/images/{id}/accessibility", s.listAccessibilityFindings).Methods("GET")
+	apiRouter.HandleFunc("/batches", s.listBatches).Methods("GET")
+	apiRouter.HandleFunc("/batches/{id}", s.getBatch).Methods("GET")
+	apiRouter.HandleFunc("/batches/{id}/report", s.getBatchReport).Methods("GET")
+	apiRouter.HandleFunc("/batches/{id}/compare", s.compareToBaseline).Methods("POST")
+	apiRouter.HandleFunc("/shares/{token}", s.getShare).Methods("GET")
+	apiRouter.HandleFunc("/shares/{token}/report", s.getShareReport).Methods("GET")
+	apiRouter.HandleFunc("/webhooks/deliveries", s.listFailedDeliveries).Methods("GET")
+
+	r.HandleFunc("/search", s.searchImages).Methods("POST")
+	r.HandleFunc("/config", getConfig).Methods("GET")
 
-	viper.SetDefault("PORT", "8080")
-	viper.SetDefault("WORKER_COUNT", 4)
-	viper.SetDefault("REDIS_ADDR", "localhost:6379")
-	viper.SetDefault("REDIS_DB", 0)
-	viper.SetDefault("REDIS_PASSWORD", "")
+	r.PathPrefix(storage.Route()).Handler(http.StripPrefix(storage.Route(), storage.Handler(s.files, s.canServeFile)))
 
-	// Batch processing configuration
-	viper.SetDefault("BATCH_CHUNK_SIZE", 3)   // Max images per chunk
-	viper.SetDefault("BATCH_MAX_PARALLEL", 4) // Max parallel processing
+	c := cors.New(cors.Options{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedMethods:   config.List("CORS_ALLOWED_METHODS"),
+		AllowedHeaders:   config.List("CORS_ALLOWED_HEADERS"),
+		ExposedHeaders:   []string{logging.RequestIDHeader},
+		AllowCredentials: cfg.CORSAllowCredentials,
+	})
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Println("Warning: Error reading .env file:", err)
+	handler := c.Handler(r)
+	if cfg.Compression {
+		handler = compression.Middleware(handler)
 	}
+	return handler
 }
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pablobfonseca/go-image-vector/config"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/queue/queuetest"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/storage/storagetest"
+	"github.com/pablobfonseca/go-image-vector/worker"
+	"github.com/spf13/viper"
+)
+
+var testConfig *config.Config
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// The database settings are required, though these tests never connect
+	for key, value := range map[string]string{
+		"DB_HOST": "localhost", "DB_USER": "test", "DB_PASSWORD": "test", "DB_NAME": "test", "DB_PORT": "5432",
+	} {
+		os.Setenv(key, value)
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid test configuration:", err)
+		os.Exit(1)
+	}
+	testConfig = cfg
+	os.Exit(m.Run())
+}
+
+// newTestServer returns a server without a database or Redis, keeping its tasks in memory
+func newTestServer() (*server, *queuetest.Memory) {
+	tasks := queuetest.NewMemory()
+	return &server{tasks: tasks}, tasks
+}
+
+// request sends a request through the API handler of s and decodes its JSON response
+func request(t *testing.T, s *server, method string, target string, body string) (int, map[string]any) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.handler(testConfig).ServeHTTP(rec, req)
+
+	var response map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s %s answered %q: %v", method, target, rec.Body.String(), err)
+	}
+	return rec.Code, response
+}
+
+func TestGetTaskStatus(t *testing.T) {
+	s, tasks := newTestServer()
+	tasks.SetTaskStatus("queued", "pending")
+	tasks.SetTaskStatus("done", "completed")
+	tasks.StoreTaskResult("done", worker.AnalyzeImageResult{Type: worker.ResultTypeAnalyzeImage, ID: 7, Text: "A login form"})
+
+	status, response := request(t, s, "GET", "/api/v1/tasks/queued", "")
+	if status != http.StatusOK || response["status"] != "pending" || response["result"] != nil {
+		t.Errorf("pending task: %d %v", status, response)
+	}
+
+	status, response = request(t, s, "GET", "/api/v1/tasks/done", "")
+	result, _ := response["result"].(map[string]any)
+	if status != http.StatusOK || response["status"] != "completed" || result["text"] != "A login form" {
+		t.Errorf("completed task: %d %v", status, response)
+	}
+
+	status, response = request(t, s, "GET", "/api/v1/tasks/missing", "")
+	if status != http.StatusOK || response["status"] != "unknown" {
+		t.Errorf("unknown task: %d %v", status, response)
+	}
+}
+
+func TestSearchValidation(t *testing.T) {
+	s, _ := newTestServer()
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"empty body", ``, ""},
+		{"unknown field", `{"query": "login", "limit": 3}`, "limit"},
+		{"no query", `{}`, "query"},
+		{"negative top_k", `{"query": "login", "top_k": -1}`, "top_k"},
+		{"top_k over the limit", `{"query": "login", "top_k": 100000}`, "top_k"},
+		{"unknown search field", `{"query": "login", "field": "title"}`, "field"},
+		{"unknown kind", `{"query": "login", "kind": "video"}`, "kind"},
+		{"part without a source", `{"queries": [{"weight": 1}]}`, "queries"},
+		{"bad half life", `{"query": "login", "rank": "recency", "half_life": "soon"}`, "half_life"},
+		{"unknown selected field", `{"query": "login", "fields": ["id", "rating"]}`, "fields"},
+		{"unknown excluded field", `{"query": "login", "exclude": ["vector"]}`, "exclude"},
+		{"bad language", `{"query": "login", "language": "german!"}`, "language"},
+		{"unknown accessibility issue", `{"query": "login", "accessibility_issue": "focus"}`, "accessibility_issue"},
+		{"language on summaries", `{"query": "login", "language": "de", "field": "summary"}`, "language"},
+		{"media type without subtype", `{"query": "login", "media_type": "video"}`, "media_type"},
+		{"media type pattern", `{"query": "login", "media_type": "*/png"}`, "media_type"},
+		{"is_batch against kind", `{"query": "login", "kind": "image", "is_batch": true}`, "is_batch"},
+		{"bad since", `{"query": "login", "since": "2024-01-31"}`, "since"},
+		{"bad until", `{"query": "login", "until": "yesterday"}`, "until"},
+		{"hybrid on chunks", `{"query": "E1042", "rank": "hybrid", "field": "chunks"}`, "rank"},
+		{"hybrid without text", `{"queries": [{"id": 3}], "rank": "hybrid"}`, "rank"},
+		{"negative min_score", `{"query": "login", "min_score": -0.1}`, "min_score"},
+		{"min_score over 1", `{"query": "login", "min_score": 1.5}`, "min_score"},
+		{"hybrid_weights without hybrid", `{"query": "E1042", "hybrid_weights": {"caption": 2}}`, "hybrid_weights"},
+		{"unknown hybrid field", `{"query": "E1042", "rank": "hybrid", "hybrid_weights": {"ocr": 2}}`, "hybrid_weights"},
+		{"min_score with hybrid", `{"query": "E1042", "rank": "hybrid", "min_score": 0.5}`, "min_score"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response := request(t, s, "POST", "/api/v1/search", tt.body)
+			if status != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %v", status, response)
+			}
+			if tt.field == "" {
+				return
+			}
+			if body, _ := json.Marshal(response); !strings.Contains(string(body), `"`+tt.field+`"`) {
+				t.Errorf("error does not name %s: %s", tt.field, body)
+			}
+		})
+	}
+}
+
+func TestImageID(t *testing.T) {
+	s, _ := newTestServer()
+	for _, method := range []string{"GET", "DELETE"} {
+		for _, id := range []string{"abc", "0", "-1"} {
+			status, response := request(t, s, method, "/api/v1/images/"+id, "")
+			if status != http.StatusBadRequest || response["code"] != "invalid_parameter" {
+				t.Errorf("%s /api/v1/images/%s: %d %v", method, id, status, response)
+			}
+		}
+	}
+}
+
+func TestRawTextNeedsAdmin(t *testing.T) {
+	viper.Set("API_KEYS", "user-key")
+	viper.Set("ADMIN_API_KEYS", "admin-key")
+	t.Cleanup(func() {
+		viper.Set("API_KEYS", "")
+		viper.Set("ADMIN_API_KEYS", "")
+	})
+
+	s, _ := newTestServer()
+	status, response := request(t, s, "GET", "/api/v1/images/7/raw", "")
+	if status != http.StatusForbidden || response["code"] != "forbidden" {
+		t.Errorf("GET /api/v1/images/7/raw: %d %v", status, response)
+	}
+}
+
+func TestWebhookDeliveriesNeedAdmin(t *testing.T) {
+	viper.Set("API_KEYS", "user-key")
+	viper.Set("ADMIN_API_KEYS", "admin-key")
+	t.Cleanup(func() {
+		viper.Set("API_KEYS", "")
+		viper.Set("ADMIN_API_KEYS", "")
+	})
+
+	s, _ := newTestServer()
+	for _, route := range []struct{ method, target string }{
+		{"GET", "/api/v1/webhooks/deliveries"},
+		{"POST", "/api/v1/webhooks/deliveries/3/replay"},
+	} {
+		status, response := request(t, s, route.method, route.target, "")
+		if status != http.StatusForbidden || response["code"] != "forbidden" {
+			t.Errorf("%s %s: %d %v", route.method, route.target, status, response)
+		}
+	}
+}
+
+func TestWriteRoutesNeedAPIKey(t *testing.T) {
+	viper.Set("API_KEYS", "user-key")
+	t.Cleanup(func() { viper.Set("API_KEYS", "") })
+
+	s, tasks := newTestServer()
+	tasks.SetTaskStatus("failed", "failed")
+	for _, route := range []struct{ method, target string }{
+		{"DELETE", "/api/v1/batches/batch-1"},
+		{"POST", "/api/v1/tasks/failed/retry"},
+	} {
+		status, response := request(t, s, route.method, route.target, "")
+		if status != http.StatusUnauthorized || response["code"] != "unauthorized" {
+			t.Errorf("%s %s: %d %v", route.method, route.target, status, response)
+		}
+	}
+}
+
+func TestListImagesValidation(t *testing.T) {
+	s, _ := newTestServer()
+	for target, param := range map[string]string{
+		"/api/v1/images?limit=0":                   "limit",
+		"/api/v1/images?offset=-1":                 "offset",
+		"/api/v1/images?kind=video":                "kind",
+		"/api/v1/images?fields=vector":             "fields",
+		"/api/v1/images?page=2":                    "page",
+		"/api/v1/images?accessibility_issue=focus": "accessibility_issue",
+	} {
+		status, response := request(t, s, "GET", target, "")
+		if status != http.StatusBadRequest || response["details"].(map[string]any)["parameter"] != param {
+			t.Errorf("GET %s: %d %v", target, status, response)
+		}
+	}
+}
+
+func TestCreateTranslation(t *testing.T) {
+	s, tasks := newTestServer()
+
+	status, response := request(t, s, "POST", "/api/v1/translations", `{"languages": ["DE", "pt-br", "de"], "record_ids": [4]}`)
+	if status != http.StatusAccepted || response["priority"] != queue.PriorityLow {
+		t.Fatalf("POST /api/v1/translations: %d %v", status, response)
+	}
+	queued := tasks.Queued(queue.ImageProcessingQueue, queue.PriorityLow)
+	if len(queued) != 1 || queued[0].TaskType != worker.TaskTypeTranslateDescriptions ||
+		!reflect.DeepEqual(queued[0].Data["languages"], []string{"de", "pt-br"}) {
+		t.Fatalf("queued %+v", queued)
+	}
+
+	for _, body := range []string{`{}`, `{"languages": ["en"]}`, `{"languages": ["german!"]}`} {
+		status, response := request(t, s, "POST", "/api/v1/translations", body)
+		if status != http.StatusBadRequest || response["code"] != "invalid_parameter" {
+			t.Errorf("POST /api/v1/translations %s: %d %v", body, status, response)
+		}
+	}
+}
+
+func TestUnknownRoute(t *testing.T) {
+	s, _ := newTestServer()
+	status, response := request(t, s, "GET", "/api/v1/nothing", "")
+	if status != http.StatusNotFound || response["code"] != "not_found" {
+		t.Errorf("unknown route: %d %v", status, response)
+	}
+}
+
+func TestSearchOnlyRoutes(t *testing.T) {
+	s, tasks := newTestServer()
+	tasks.SetTaskStatus("queued", "pending")
+	cfg := *testConfig
+	cfg.ServeMode = config.ServeModeSearchOnly
+	handler := s.handler(&cfg)
+
+	for _, tt := range []struct {
+		method string
+		target string
+		want   int
+	}{
+		{"POST", "/api/v1/upload", http.StatusNotFound},
+		{"POST", "/upload", http.StatusNotFound},
+		{"POST", "/api/v1/tasks/queued/retry", http.StatusNotFound},
+		{"POST", "/api/v1/shares", http.StatusNotFound},
+		{"DELETE", "/api/v1/shares/abc", http.StatusNotFound},
+		{"DELETE", "/api/v1/images/1", http.StatusNotFound},
+		{"GET", "/api/v1/tasks/queued", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s answered %d in search-only mode, want %d", tt.method, tt.target, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestSearchByImageValidation(t *testing.T) {
+	s, _ := newTestServer()
+	for _, tt := range []struct {
+		name   string
+		search string
+		image  []byte
+		status int
+		param  string
+	}{
+		{"no image", "", nil, http.StatusBadRequest, "image"},
+		{"not an image", "", []byte("plain text, not pixels"), http.StatusUnsupportedMediaType, ""},
+		{"with a query", `{"query": "login"}`, []byte("GIF89a"), http.StatusBadRequest, "query"},
+		{"unknown option", `{"top_n": 3}`, []byte("GIF89a"), http.StatusBadRequest, "top_n"},
+		{"bad field", `{"field": "pixels"}`, []byte("GIF89a"), http.StatusBadRequest, "field"},
+	} {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		if tt.search != "" {
+			form.WriteField("search", tt.search)
+		}
+		if tt.image != nil {
+			part, _ := form.CreateFormFile("image", "example.gif")
+			part.Write(tt.image)
+		}
+		form.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/search/image", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		s.handler(testConfig).ServeHTTP(rec, req)
+
+		var response map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		details, _ := response["details"].(map[string]any)
+		if rec.Code != tt.status || tt.param != "" && details["parameter"] != tt.param {
+			t.Errorf("%s: %d %v", tt.name, rec.Code, response)
+		}
+	}
+}
+
+func TestCompareToBaselineValidation(t *testing.T) {
+	s, _ := newTestServer()
+	for _, tt := range []struct {
+		name  string
+		field string
+		value string
+		param string
+	}{
+		{"zero threshold", "threshold", "0", "threshold"},
+		{"threshold above 1", "threshold", "1.5", "threshold"},
+		{"bad threshold", "threshold", "low", "threshold"},
+		{"unknown field", "top_k", "3", "top_k"},
+	} {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField(tt.field, tt.value)
+		part, _ := form.CreateFormFile("image", "current.gif")
+		part.Write([]byte("GIF89a"))
+		form.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/batches/abc/compare", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		s.handler(testConfig).ServeHTTP(rec, req)
+
+		var response map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		details, _ := response["details"].(map[string]any)
+		if rec.Code != http.StatusBadRequest || details["parameter"] != tt.param {
+			t.Errorf("%s: %d %v", tt.name, rec.Code, response)
+		}
+	}
+}
+
+func TestScoreResults(t *testing.T) {
+	results := []models.ImageEmbedding{{ID: 1, Distance: 0}, {ID: 2, Distance: 0.25}, {ID: 3, Distance: 1}, {ID: 4, Distance: 3}}
+
+	kept := scoreResults(results, 0.5)
+	if len(kept) != 3 || kept[0].ID != 1 || kept[1].ID != 2 || kept[2].ID != 3 {
+		t.Fatalf("scoreResults kept %v, want records 1, 2 and 3", kept)
+	}
+	if kept[0].Score != 1 || kept[1].Score != 0.8 || kept[2].Score != 0.5 {
+		t.Errorf("scores = %v, %v, %v, want 1, 0.8 and 0.5", kept[0].Score, kept[1].Score, kept[2].Score)
+	}
+}
+
+func TestAcceptsNDJSON(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                                       false,
+		"application/json":                       false,
+		"application/x-ndjson":                   true,
+		"application/json, application/x-ndjson": true,
+		"application/x-ndjson; charset=utf-8":    true,
+	} {
+		req := httptest.NewRequest("POST", "/api/v1/search", nil)
+		req.Header.Set("Accept", accept)
+		if got := acceptsNDJSON(req); got != want {
+			t.Errorf("acceptsNDJSON(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestFieldSelection(t *testing.T) {
+	record := models.ImageEmbedding{ID: 3, FilePath: "uploads/a.png", Title: "Login", Text: "A login form"}
+
+	if got := (fieldSelection{}).apply(record); !reflect.DeepEqual(got, record) {
+		t.Errorf("empty selection changed the record: %v", got)
+	}
+
+	selected, _ := json.Marshal(fieldSelection{fields: []string{"id", "title"}}.apply(record))
+	if string(selected) != `{"id":3,"title":"Login"}` {
+		t.Errorf("fields selected %s", selected)
+	}
+
+	excluded, _ := json.Marshal(fieldSelection{exclude: []string{"embedding", "text"}}.apply(record))
+	if strings.Contains(string(excluded), `"embedding"`) || strings.Contains(string(excluded), `"text"`) || !strings.Contains(string(excluded), `"file_path"`) {
+		t.Errorf("exclude left %s", excluded)
+	}
+}
+
+func TestStoredFileETag(t *testing.T) {
+	s, _ := newTestServer()
+	s.files = storagetest.NewMemory()
+	s.files.Save(context.Background(), "abc.png", strings.NewReader("image"))
+	handler := s.handler(testConfig)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", storage.Route()+"abc.png", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || rec.Body.String() != "image" {
+		t.Fatalf("first download answered %d with ETag %q: %q", rec.Code, etag, rec.Body.String())
+	}
+	if cacheControl := rec.Header().Get("Cache-Control"); !strings.HasPrefix(cacheControl, "private") {
+		t.Errorf("Cache-Control = %q, want private for an authenticated download", cacheControl)
+	}
+
+	req := httptest.NewRequest("GET", storage.Route()+"abc.png", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidation answered %d with %d bytes, want 304 without a body", rec.Code, rec.Body.Len())
+	}
+}
@@ -0,0 +1,187 @@
+// Package mcp implements a Model Context Protocol server over stdio, exposing tools
+// that MCP clients such as IDE agents can call.
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// protocolVersions are the MCP revisions this server speaks, newest first
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Tool is a callable tool. Handler receives the raw arguments and returns text content;
+// a returned error is reported to the client as a failed tool call.
+type Tool struct {
+	Name        string
+	Description string
+	InputSchema map[string]any
+	Handler     func(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// Server answers MCP requests for a set of tools
+type Server struct {
+	Name    string
+	Version string
+	Tools   []Tool
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Serve reads newline-delimited JSON-RPC messages from in and writes responses to out
+// until in is closed or ctx is done. Tool calls run concurrently.
+func (s *Server) Serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(out)
+	send := func(resp response) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := encoder.Encode(resp); err != nil {
+			slog.Error("Failed to write MCP response", "error", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			send(response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{codeParseError, err.Error()}})
+			continue
+		}
+
+		// Notifications carry no ID and get no response
+		if len(req.ID) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, rpcErr := s.handle(ctx, req)
+			send(response{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr})
+		}()
+	}
+
+	return scanner.Err()
+}
+
+func (s *Server) handle(ctx context.Context, req request) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+
+		version := protocolVersions[0]
+		for _, supported := range protocolVersions {
+			if supported == params.ProtocolVersion {
+				version = supported
+			}
+		}
+
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": s.Name, "version": s.Version},
+		}, nil
+
+	case "ping":
+		return map[string]any{}, nil
+
+	case "tools/list":
+		tools := make([]map[string]any, 0, len(s.Tools))
+		for _, tool := range s.Tools {
+			tools = append(tools, map[string]any{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"inputSchema": tool.InputSchema,
+			})
+		}
+		return map[string]any{"tools": tools}, nil
+
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{codeInvalidParams, err.Error()}
+		}
+
+		for _, tool := range s.Tools {
+			if tool.Name != params.Name {
+				continue
+			}
+
+			arguments := params.Arguments
+			if len(arguments) == 0 {
+				arguments = json.RawMessage("{}")
+			}
+
+			text, err := tool.Handler(ctx, arguments)
+			if err != nil {
+				slog.Warn("MCP tool call failed", "tool", tool.Name, "error", err)
+				return toolResult(err.Error(), true), nil
+			}
+			return toolResult(text, false), nil
+		}
+		return nil, &rpcError{codeInvalidParams, fmt.Sprintf("unknown tool %q", params.Name)}
+
+	case "":
+		return nil, &rpcError{codeInvalidRequest, "missing method"}
+	}
+
+	return nil, &rpcError{codeMethodNotFound, fmt.Sprintf("method %q not found", req.Method)}
+}
+
+func toolResult(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]any{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequests = NewCounter("http_requests_total",
+		"Total number of HTTP requests.", "method", "route", "status")
+	httpDuration = NewHistogram("http_request_duration_seconds",
+		"HTTP request latency in seconds.", DefaultBuckets, "method", "route")
+)
+
+// responseRecorder captures the status code and body size written by a handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, such as to flush
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Middleware records per-route request counts and latencies and writes a structured access log line
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		duration := time.Since(start)
+		httpRequests.Inc(r.Method, route, strconv.Itoa(recorder.status))
+		httpDuration.Observe(duration.Seconds(), r.Method, route)
+
+		slog.InfoContext(r.Context(), "HTTP request",
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", duration.Milliseconds(),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
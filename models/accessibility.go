@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// AccessibilityFinding is an accessibility issue found on a record by the accessibility
+// audit, such as low contrast text or an unlabeled button
+type AccessibilityFinding struct {
+	ID       uint `gorm:"primaryKey" json:"id"`
+	RecordID uint `gorm:"index" json:"record_id"`
+	// Issue is the type of the issue: contrast, missing_label, tap_target, text_size or other
+	Issue       string    `gorm:"index" json:"issue"`
+	Severity    string    `json:"severity"`
+	Element     string    `json:"element,omitempty"`
+	Description string    `gorm:"text" json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// DescriptionChunk is a part of the text of a record with its own embedding, so long
+// journey narratives are searchable by any of their parts
+type DescriptionChunk struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	RecordID uint   `gorm:"index" json:"record_id"`
+	Position int    `json:"position"`
+	Text     string `gorm:"text" json:"text"`
+	// Context situates the chunk in the whole description, embedded before Text with CHUNK_CONTEXT
+	Context   string          `gorm:"text" json:"context,omitempty"`
+	Embedding pgvector.Vector `gorm:"type:vector(768)" json:"embedding"`
+	CreatedAt time.Time       `json:"created_at"`
+
+	// Distance to the query, only set on search results
+	Distance float64 `gorm:"->;-:migration" json:"distance,omitempty"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// Description sources
+const (
+	// DescriptionTranslated descriptions were translated from the record's description by the model
+	DescriptionTranslated = "translated"
+	// DescriptionProvided descriptions were written or translated elsewhere and stored through the API
+	DescriptionProvided = "provided"
+)
+
+// Description is the description of a record in another language than the one it was
+// analyzed in, with its own embedding, so the record is searchable in that language
+type Description struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	RecordID  uint            `gorm:"uniqueIndex:idx_description_language" json:"record_id"`
+	Language  string          `gorm:"uniqueIndex:idx_description_language" json:"language"`
+	Text      string          `gorm:"text" json:"text"`
+	Source    string          `json:"source"`
+	Embedding pgvector.Vector `gorm:"type:vector(768)" json:"-"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+
+	// Distance to the query, only set on search results
+	Distance float64 `gorm:"->;-:migration" json:"distance,omitempty"`
+}
@@ -1,13 +1,71 @@
 package models
 
-import "github.com/pgvector/pgvector-go"
+import (
+	"time"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// Record visibilities: private records are only listed, searched and served to authenticated
+// requests
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
 
 type ImageEmbedding struct {
-	ID         uint            `gorm:"primaryKey" json:"id"`
-	FilePath   string          `gorm:"unique" json:"file_path"`
-	Text       string          `gorm:"text" json:"text"`
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	FilePath     string `gorm:"index" json:"file_path"`
+	OriginalName string `json:"original_name,omitempty"`
+	MediaType    string `json:"media_type,omitempty"`
+	OriginalPath string `json:"original_path,omitempty"`
+	// PreviewPath is the animated GIF previewing a video, empty for other records
+	PreviewPath string `json:"preview_path,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Text        string `gorm:"text" json:"text"`
+	Summary     string `gorm:"text" json:"summary,omitempty"`
+	Caption     string `gorm:"text" json:"caption,omitempty"`
+	Visibility  string `gorm:"default:public;index" json:"visibility"`
+	Label       string `gorm:"index" json:"label,omitempty"`
+	// Audit is the audit the record passed, such as accessibility, with its findings stored apart
+	Audit string `gorm:"index" json:"audit,omitempty"`
+	// RawText is the description before REDACT_PII redacted it, only served to admins
+	RawText string `gorm:"text" json:"-"`
+	// Redactions lists the kinds of data redacted from the description, such as email
+	Redactions []string        `gorm:"type:jsonb;serializer:json" json:"redactions,omitempty"`
 	Embedding  pgvector.Vector `gorm:"type:vector(768)" json:"embedding"`
-	IsBatch    bool            `gorm:"default:false" json:"is_batch"`
-	BatchID    string          `gorm:"index" json:"batch_id"`
-	BatchPaths []string        `gorm:"-" json:"batch_paths,omitempty"`
+	// SummaryEmbedding is the embedding of Summary, nil for records without one
+	SummaryEmbedding *pgvector.Vector `gorm:"type:vector(768)" json:"summary_embedding,omitempty"`
+	IsBatch          bool             `gorm:"default:false" json:"is_batch"`
+	BatchID          string           `gorm:"index" json:"batch_id"`
+	BatchPaths       []string         `gorm:"type:jsonb;serializer:json" json:"batch_paths,omitempty"`
+	BatchSequence    int              `gorm:"default:0" json:"batch_sequence,omitempty"`
+	Latitude         *float64         `gorm:"index:idx_location" json:"latitude,omitempty"`
+	Longitude        *float64         `gorm:"index:idx_location" json:"longitude,omitempty"`
+	TakenAt          *time.Time       `gorm:"index" json:"taken_at,omitempty"`
+	CreatedAt        time.Time        `gorm:"index" json:"created_at"`
+
+	// Distance to the query, only set on search results
+	Distance float64 `gorm:"->;-:migration" json:"distance,omitempty"`
+	// Score is the similarity to the query from 0 to 1, 1/(1+distance), only set on search
+	// results not ranked by hybrid, whose order is their HybridScore
+	Score float64 `gorm:"-" json:"score,omitempty"`
+	// MatchedChunk is the description chunk nearest to the query, only set on searches over chunks
+	MatchedChunk string `gorm:"-" json:"matched_chunk,omitempty"`
+	// MatchedFrame is the video frame nearest to the query, only set on searches over frames
+	MatchedFrame *VideoFrame `gorm:"-" json:"matched_frame,omitempty"`
+	// MatchedLanguage and MatchedDescription are the language and text of the description
+	// nearest to the query, only set on searches in a language
+	MatchedLanguage    string `gorm:"-" json:"matched_language,omitempty"`
+	MatchedDescription string `gorm:"-" json:"matched_description,omitempty"`
+	// AccessibilityFindings are the findings of the accessibility audit, only set when analyzed
+	AccessibilityFindings []AccessibilityFinding `gorm:"-" json:"accessibility_findings,omitempty"`
+	// TextRank is the full-text match of the description with the query, and HybridScore the
+	// fused rank results are ordered by, only set when ranking hybrid
+	TextRank    float64 `gorm:"->;-:migration" json:"text_rank,omitempty"`
+	HybridScore float64 `gorm:"-" json:"hybrid_score,omitempty"`
+	// RankedDistance is the distance adjusted for age, only set when ranking by recency
+	RankedDistance float64 `gorm:"-" json:"ranked_distance,omitempty"`
+	// PreviewURL is the URL PreviewPath is served at, set by the API
+	PreviewURL string `gorm:"-" json:"preview_url,omitempty"`
 }
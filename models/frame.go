@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// VideoFrame is a still sampled from a video record with its own description and embedding,
+// so searches find the moment of a video that matches
+type VideoFrame struct {
+	ID       uint `gorm:"primaryKey" json:"id"`
+	RecordID uint `gorm:"index" json:"record_id"`
+	// OffsetMS is the time of the frame from the start of the video, in milliseconds
+	OffsetMS int64 `json:"offset_ms"`
+	// FilePath is the JPEG of the frame, served under UPLOADS_ROUTE as its thumbnail
+	FilePath  string          `gorm:"index" json:"file_path"`
+	Text      string          `gorm:"text" json:"text"`
+	Embedding pgvector.Vector `gorm:"type:vector(768)" json:"-"`
+	CreatedAt time.Time       `json:"created_at"`
+
+	// Distance to the query, only set on search results
+	Distance float64 `gorm:"->;-:migration" json:"distance,omitempty"`
+	// Timestamp is OffsetMS as 01:23, URL links the video at the frame and ThumbnailURL the
+	// frame itself, set by the API
+	Timestamp    string `gorm:"-" json:"timestamp,omitempty"`
+	URL          string `gorm:"-" json:"url,omitempty"`
+	ThumbnailURL string `gorm:"-" json:"thumbnail_url,omitempty"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// OutboxEvent is a domain event saved in the transaction of the change it describes, until
+// the relay publishes it to the event stream
+type OutboxEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Type      string    `json:"type"`
+	Payload   []byte    `gorm:"type:jsonb" json:"payload"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WebhookDelivery is an attempt to post a payload to a webhook, kept so failed deliveries can
+// be listed and replayed
+type WebhookDelivery struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	// Source is what posted it: hook_post_persist, notify_slack or notify_discord
+	Source     string          `gorm:"index" json:"source"`
+	URL        string          `json:"url"`
+	Payload    json.RawMessage `gorm:"type:jsonb" json:"payload"`
+	StatusCode int             `json:"status_code,omitempty"`
+	LatencyMS  int64           `json:"latency_ms"`
+	Error      string          `json:"error,omitempty"`
+	// Response is the start of the response body
+	Response string `json:"response,omitempty"`
+	Failed   bool   `gorm:"index" json:"failed"`
+	// Replayed is set on a failed delivery once a replay of it succeeded
+	Replayed bool `json:"replayed,omitempty"`
+	// ReplayOf is the delivery this one replayed
+	ReplayOf  *uint     `gorm:"index" json:"replay_of,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
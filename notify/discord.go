@@ -0,0 +1,26 @@
+package notify
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/deliveries"
+)
+
+// discordMaxContent is the longest message Discord accepts
+const discordMaxContent = 2000
+
+// DiscordNotifier posts events to a Discord webhook
+type DiscordNotifier struct {
+	WebhookURL string
+}
+
+func (n *DiscordNotifier) Notify(ctx context.Context, event Event) error {
+	content := []rune(plainText(event, func(s string) string {
+		return "**" + strings.ReplaceAll(s, "*", "") + "**"
+	}))
+	if len(content) > discordMaxContent {
+		content = append(content[:discordMaxContent-1], '…')
+	}
+	return deliveries.Post(ctx, deliveries.NotifyDiscord, n.WebhookURL, map[string]string{"content": string(content)})
+}
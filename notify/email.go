@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// EmailNotifier mails events through an SMTP server, authenticating when a username is set
+type EmailNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, event Event) error {
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP_ADDR %q: %v", n.Addr, err)
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", n.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", headerValue(event.Title))
+	fmt.Fprintf(&message, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(plainText(event, func(s string) string { return s }), "\n", "\r\n"))
+	message.WriteString("\r\n")
+
+	// net/smtp does not take a context, so the send is abandoned rather than interrupted
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(n.Addr, auth, n.From, n.To, []byte(message.String()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// headerValue keeps a header value on one line
+func headerValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
@@ -0,0 +1,130 @@
+// Package notify sends operational notifications, such as finished batches or a growing dead
+// letter list, to Slack, Discord and email
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/config"
+	"github.com/spf13/viper"
+)
+
+// Event kinds notifiers can subscribe to
+const (
+	EventBatchCompleted = "batch_completed"
+	EventTaskFailures   = "task_failures"
+	EventDeadLetters    = "dlq_growth"
+)
+
+// Events lists every event kind
+var Events = []string{EventBatchCompleted, EventTaskFailures, EventDeadLetters}
+
+// Field is a labelled value shown with a notification
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Event is a notification
+type Event struct {
+	Kind   string
+	Title  string
+	Text   string
+	Fields []Field
+	Time   time.Time
+}
+
+// Notifier delivers events to one channel
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// sendTimeout bounds the delivery of an event to one channel
+const sendTimeout = 10 * time.Second
+
+// channel is a configured notifier with the events it subscribed to
+type channel struct {
+	name     string
+	notifier Notifier
+	events   []string
+}
+
+// channels returns the configured notifiers. Each one is enabled by its own settings and
+// subscribes to the events in NOTIFY_<NAME>_EVENTS, every event when empty.
+func channels() []channel {
+	var configured []channel
+	add := func(name string, notifier Notifier) {
+		events := config.List("NOTIFY_" + strings.ToUpper(name) + "_EVENTS")
+		if len(events) == 0 {
+			events = Events
+		}
+		configured = append(configured, channel{name: name, notifier: notifier, events: events})
+	}
+
+	if url := viper.GetString("NOTIFY_SLACK_WEBHOOK_URL"); url != "" {
+		add("slack", &SlackNotifier{WebhookURL: url})
+	}
+	if url := viper.GetString("NOTIFY_DISCORD_WEBHOOK_URL"); url != "" {
+		add("discord", &DiscordNotifier{WebhookURL: url})
+	}
+	if to := config.List("NOTIFY_EMAIL_TO"); len(to) > 0 {
+		add("email", &EmailNotifier{
+			Addr:     viper.GetString("SMTP_ADDR"),
+			Username: viper.GetString("SMTP_USERNAME"),
+			Password: viper.GetString("SMTP_PASSWORD"),
+			From:     viper.GetString("NOTIFY_EMAIL_FROM"),
+			To:       to,
+		})
+	}
+	return configured
+}
+
+// Enabled reports whether any notifier subscribed to events of kind
+func Enabled(kind string) bool {
+	for _, channel := range channels() {
+		if slices.Contains(channel.events, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// Send delivers event to every notifier subscribed to its kind, in the background so callers
+// are not held up by slow webhooks or mail servers. Failures are logged.
+func Send(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, channel := range channels() {
+		if !slices.Contains(channel.events, event.Kind) {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+
+			if err := channel.notifier.Notify(ctx, event); err != nil {
+				slog.Warn("Failed to send notification", "channel", channel.name, "event", event.Kind, "error", err)
+			}
+		}()
+	}
+}
+
+// plainText renders an event as the title, text and one line per field
+func plainText(event Event, bold func(string) string) string {
+	var text strings.Builder
+	text.WriteString(bold(event.Title))
+	if event.Text != "" {
+		text.WriteString("\n" + event.Text)
+	}
+	for _, field := range event.Fields {
+		fmt.Fprintf(&text, "\n%s: %s", bold(field.Name), field.Value)
+	}
+	return text.String()
+}
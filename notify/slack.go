@@ -0,0 +1,21 @@
+package notify
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/deliveries"
+)
+
+// SlackNotifier posts events to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, event Event) error {
+	return deliveries.Post(ctx, deliveries.NotifySlack, n.WebhookURL, map[string]string{
+		"text": plainText(event, func(s string) string {
+			return "*" + strings.ReplaceAll(s, "*", "") + "*"
+		}),
+	})
+}
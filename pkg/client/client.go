@@ -0,0 +1,1376 @@
+// Package client is a Go client for the go-image-vector API.
+//
+//	c := client.New("http://localhost:8080", "")
+//	upload, err := c.UploadFiles(ctx, client.UploadOptions{}, "screenshot.png")
+//	tasks, err := c.WaitForTasks(ctx, upload.TaskIDs, nil)
+//	results, err := c.Search(ctx, "login error", 5)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Task statuses reported by the API
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+)
+
+// DefaultPollInterval is how often the wait helpers poll task status
+const DefaultPollInterval = 2 * time.Second
+
+// Client calls the go-image-vector HTTP API
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// Option customizes a Client
+type Option func(*Client)
+
+// WithHTTPClient replaces the default HTTP client, e.g. to set timeouts or a transport
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// New creates a client for the API at baseURL. A non-empty apiKey is sent as a bearer token.
+func New(baseURL string, apiKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is a non-success response from the API
+type APIError struct {
+	StatusCode int
+	// Code identifies the error, such as invalid_parameter or not_found
+	Code    string
+	Message string
+	// Details carry error specific context, such as the invalid parameter
+	Details   map[string]any
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("go-image-vector: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// File is a file to upload
+type File struct {
+	Name   string
+	Reader io.Reader
+}
+
+// UploadOptions configures an upload
+type UploadOptions struct {
+	// BatchAnalyze analyzes all files together as one journey
+	BatchAnalyze bool
+	// MaxChunkSize and MaxParallel tune batch analysis, zero uses the server defaults
+	MaxChunkSize int
+	MaxParallel  int
+	// PerImage also analyzes each file of a batch on its own, so they are searchable individually
+	PerImage bool
+	// Order is the journey order of a batch: OrderUpload (the default) or OrderCaptured to sort
+	// by EXIF capture time. Sequence instead gives the step of each file, in the order of files.
+	Order    string
+	Sequence []int
+	// ChunkRetries and MinChunkSuccess tolerate failing chunks of a large batch: failed chunks
+	// are retried, and the batch proceeds without them when at least MinChunkSuccess (0 to 1)
+	// of the chunks succeeded. Zero values use the server defaults.
+	ChunkRetries    int
+	MinChunkSuccess float64
+	// Scenario selects the batch prompts: web, mobile_app, photo_album, surveillance or
+	// document_scan. Empty uses the server default.
+	Scenario string
+	// Priority is PriorityHigh, PriorityNormal or PriorityLow, so urgent analyses are taken
+	// before bulk ones. Empty is normal.
+	Priority string
+	// Queue is one of the server's QUEUES to analyze the files on, empty for the first
+	Queue string
+	// SHA256 lists the hex SHA-256 digest of each file, in the order of files. Files analyzed
+	// before on their own are then answered with their records instead of being analyzed again.
+	// Without files, the digests alone ask for those records.
+	SHA256 []string
+	// Captions gives each file a human description, in the order of files, empty for files
+	// without one. CaptionMode is CaptionAugment (the server default) to embed the caption
+	// with the vision model output, or CaptionReplace to skip the vision model.
+	Captions    []string
+	CaptionMode string
+	// Visibility is VisibilityPublic or VisibilityPrivate, empty for the server's
+	// DEFAULT_VISIBILITY. Private records are only seen by clients with an API key.
+	Visibility string
+	// Audit runs an audit on single images besides their description, AuditAccessibility
+	// to find accessibility issues in UI screenshots, or AuditNone to skip the server's
+	// DEFAULT_AUDIT. Batches are audited with PerImage.
+	Audit string
+	// Scenes cuts each file, a video such as a screen recording, into scenes described on
+	// their own, making a journey record of each recording. The task result is read with
+	// Task.ScenesResult.
+	Scenes bool
+	// Sync analyzes a single small image while the request waits, returning its record in
+	// UploadResponse.Record. Analyses that take longer than the server's SYNC_TIMEOUT are queued.
+	Sync bool
+	// Archive is a zip of images, expanded by the server and analyzed as one batch with the
+	// batch options, after any files
+	Archive *File
+	// Progress is called as the request body is sent with the number of bytes written so far
+	Progress func(sent int64)
+}
+
+// UploadResponse is the result of an upload
+type UploadResponse struct {
+	Message      string   `json:"message"`
+	TaskIDs      []string `json:"task_ids"`
+	BatchAnalyze bool     `json:"batch_analyze"`
+	Scenes       bool     `json:"scenes,omitempty"`
+	Queue        string   `json:"queue,omitempty"`
+	Priority     string   `json:"priority,omitempty"`
+	Quarantined  []string `json:"quarantined,omitempty"`
+	FileCount    int      `json:"file_count,omitempty"`
+	MaxChunkSize int      `json:"max_chunk_size,omitempty"`
+	MaxParallel  int      `json:"max_parallel,omitempty"`
+	PerImage     bool     `json:"per_image,omitempty"`
+	Order        string   `json:"order,omitempty"`
+	Scenario     string   `json:"scenario,omitempty"`
+	// Sequence lists the file names of a batch in journey order
+	Sequence []string `json:"sequence,omitempty"`
+	// Existing is true when every file was analyzed before and nothing was queued
+	Existing bool `json:"existing,omitempty"`
+	// Records lists the files that were analyzed before
+	Records []ExistingRecord `json:"records,omitempty"`
+	// Record is the result of a sync analysis, like the result of a completed task
+	Record *AnalyzeImageResult `json:"record,omitempty"`
+	// SyncTimeout is true when a sync analysis was queued instead, to be followed by its task
+	SyncTimeout bool `json:"sync_timeout,omitempty"`
+	// Files describes each uploaded file, in upload order
+	Files []UploadedFile `json:"files,omitempty"`
+}
+
+// UploadedFile is an uploaded file: where it is stored and the task and record of its analysis
+type UploadedFile struct {
+	Filename string `json:"filename"`
+	SHA256   string `json:"sha256"`
+	// Archive is the zip archive the file was expanded from, if any
+	Archive string `json:"archive,omitempty"`
+	// Status is pending, completed (sync analyses), existing (analyzed before) or quarantined
+	Status    string `json:"status"`
+	FilePath  string `json:"file_path,omitempty"`
+	URL       string `json:"url,omitempty"`
+	MediaType string `json:"media_type,omitempty"`
+	// TaskID is the task analyzing the file, shared by the files of a batch
+	TaskID string `json:"task_id,omitempty"`
+	// RecordID is the ID reserved for the record of the file, which its task saves it under,
+	// or the journey record of a batch. Quarantined files have none.
+	RecordID uint `json:"record_id,omitempty"`
+}
+
+// ExistingRecord is the record of an uploaded file that was analyzed before
+type ExistingRecord struct {
+	SHA256       string    `json:"sha256"`
+	ID           uint      `json:"id"`
+	FilePath     string    `json:"file_path"`
+	OriginalName string    `json:"original_name"`
+	MediaType    string    `json:"media_type"`
+	Caption      string    `json:"caption,omitempty"`
+	Visibility   string    `json:"visibility"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Batch orders for UploadOptions.Order
+const (
+	OrderUpload   = "upload"
+	OrderCaptured = "captured"
+)
+
+// Caption modes for UploadOptions.CaptionMode
+const (
+	CaptionAugment = "augment"
+	CaptionReplace = "replace"
+)
+
+// Record visibilities for UploadOptions.Visibility
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// Audits for UploadOptions.Audit
+const (
+	AuditNone          = "none"
+	AuditAccessibility = "accessibility"
+)
+
+// Accessibility issue types for SearchRequest.AccessibilityIssue
+const (
+	IssueContrast     = "contrast"
+	IssueMissingLabel = "missing_label"
+	IssueTapTarget    = "tap_target"
+	IssueTextSize     = "text_size"
+	IssueOther        = "other"
+)
+
+// Task priorities for UploadOptions.Priority
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// Result is a search result
+type Result struct {
+	ID           uint   `json:"id"`
+	FilePath     string `json:"file_path"`
+	OriginalName string `json:"original_name,omitempty"`
+	MediaType    string `json:"media_type,omitempty"`
+	OriginalPath string `json:"original_path,omitempty"`
+	// PreviewPath is the animated GIF previewing a video, served at PreviewURL
+	PreviewPath string `json:"preview_path,omitempty"`
+	PreviewURL  string `json:"preview_url,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Summary     string `json:"summary,omitempty"`
+	Text        string `json:"text"`
+	Caption     string `json:"caption,omitempty"`
+	Visibility  string `json:"visibility"`
+	Label       string `json:"label,omitempty"`
+	Audit       string `json:"audit,omitempty"`
+	// Redactions lists the kinds of data redacted from Text with the server's REDACT_PII,
+	// such as email, card_number, token or name
+	Redactions []string  `json:"redactions,omitempty"`
+	IsBatch    bool      `json:"is_batch"`
+	BatchID    string    `json:"batch_id"`
+	BatchPaths []string  `json:"batch_paths,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Distance   float64   `json:"distance,omitempty"`
+	// Score is the similarity to the query from 0 to 1, higher for nearer records, not set
+	// on results ranked by RankHybrid
+	Score float64 `json:"score,omitempty"`
+	// Latitude and Longitude are the EXIF location of photos that carry one
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	// TakenAt is the EXIF capture time of photos that carry one
+	TakenAt *time.Time `json:"taken_at,omitempty"`
+	// MatchedChunk is the part of the description nearest to the query, with FieldChunks
+	MatchedChunk string `json:"matched_chunk,omitempty"`
+	// MatchedFrame is the frame of a video nearest to the query, with FieldFrames
+	MatchedFrame *Frame `json:"matched_frame,omitempty"`
+	// MatchedLanguage and MatchedDescription are the language and text of the description
+	// nearest to the query, with a search Language
+	MatchedLanguage    string `json:"matched_language,omitempty"`
+	MatchedDescription string `json:"matched_description,omitempty"`
+	// TextRank is the full-text match of the description with the query, and HybridScore the
+	// fused rank results are ordered by with RankHybrid
+	TextRank    float64 `json:"text_rank,omitempty"`
+	HybridScore float64 `json:"hybrid_score,omitempty"`
+	// RankedDistance is the age-adjusted distance results are ordered by with RankRecency
+	RankedDistance float64 `json:"ranked_distance,omitempty"`
+}
+
+// Task is the status of an analysis task, with its result once finished. The result is
+// decoded with ImageResult, BatchResult or TranslationResult depending on its type.
+type Task struct {
+	TaskID string          `json:"task_id"`
+	Status string          `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Task result types
+const (
+	ResultTypeAnalyzeImage = "analyze_image"
+	ResultTypeBatch        = "analyze_multiple_images"
+	ResultTypeTranslation  = "translate_descriptions"
+	ResultTypeScenes       = "analyze_scenes"
+	ResultTypeError        = "error"
+)
+
+// AnalyzeImageResult is the result of a single image analysis
+type AnalyzeImageResult struct {
+	Type         string `json:"type"`
+	ID           uint   `json:"id"`
+	FilePath     string `json:"file_path"`
+	OriginalName string `json:"original_name"`
+	MediaType    string `json:"media_type,omitempty"`
+	OriginalPath string `json:"original_path,omitempty"`
+	PreviewPath  string `json:"preview_path,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Summary      string `json:"summary,omitempty"`
+	Text         string `json:"text"`
+	Caption      string `json:"caption,omitempty"`
+	Visibility   string `json:"visibility,omitempty"`
+	// Audit is the audit the image passed, with its AccessibilityFindings for AuditAccessibility
+	Audit                 string                 `json:"audit,omitempty"`
+	AccessibilityFindings []AccessibilityFinding `json:"accessibility_findings,omitempty"`
+	// Redactions lists the kinds of data redacted from Text with the server's REDACT_PII
+	Redactions []string `json:"redactions,omitempty"`
+	// Existing is true when the file was analyzed before and its record was reused
+	Existing bool `json:"existing,omitempty"`
+}
+
+// AccessibilityFinding is an accessibility issue the audit found on an element of a screen
+type AccessibilityFinding struct {
+	ID       uint `json:"id"`
+	RecordID uint `json:"record_id"`
+	// Issue is one of the Issue types, Severity low, medium or high
+	Issue       string    `json:"issue"`
+	Severity    string    `json:"severity"`
+	Element     string    `json:"element,omitempty"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BatchImageResult is an image of a batch analyzed on its own, at its step in the journey
+type BatchImageResult struct {
+	ID       uint   `json:"id"`
+	FilePath string `json:"file_path"`
+	Sequence int    `json:"sequence"`
+	Existing bool   `json:"existing"`
+}
+
+// SkippedChunk is a chunk of a batch left out of the narrative after its retries failed
+type SkippedChunk struct {
+	Index     int      `json:"index"`
+	FilePaths []string `json:"file_paths"`
+	Attempts  int      `json:"attempts"`
+	Error     string   `json:"error"`
+}
+
+// Scene is a scene of a screen recording, a step of its journey, described from its first
+// frame at OffsetMS into the recording
+type Scene struct {
+	Step      int    `json:"step"`
+	OffsetMS  int64  `json:"offset_ms"`
+	Timestamp string `json:"timestamp"`
+	FilePath  string `json:"file_path"`
+	Text      string `json:"text"`
+}
+
+// BatchResult is the result of a batch analysis, the journey record, or of the scene
+// analysis of a recording
+type BatchResult struct {
+	Type             string             `json:"type"`
+	ID               uint               `json:"id"`
+	FilePath         string             `json:"file_path"`
+	Title            string             `json:"title,omitempty"`
+	Summary          string             `json:"summary,omitempty"`
+	Text             string             `json:"text"`
+	FileCount        int                `json:"file_count"`
+	IsBatch          bool               `json:"is_batch"`
+	BatchID          string             `json:"batch_id"`
+	BatchPaths       []string           `json:"batch_paths"`
+	PreviewPath      string             `json:"preview_path,omitempty"`
+	Scenario         string             `json:"scenario"`
+	ProcessingTimeMS int64              `json:"processing_time_ms"`
+	Images           []BatchImageResult `json:"images,omitempty"`
+	SkippedChunks    []SkippedChunk     `json:"skipped_chunks,omitempty"`
+	// Redactions lists the kinds of data redacted from Text with the server's REDACT_PII
+	Redactions []string `json:"redactions,omitempty"`
+	// Scenes are the scenes of a recording, in order
+	Scenes []Scene `json:"scenes,omitempty"`
+}
+
+// TranslationResult is the result of a translation task
+type TranslationResult struct {
+	Type      string   `json:"type"`
+	Languages []string `json:"languages"`
+	// Records is the number of records considered, Translated the descriptions stored,
+	// Skipped the ones records already had and Failed the ones the model failed to translate
+	Records    int `json:"records"`
+	Translated int `json:"translated"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+}
+
+// Error categories of failed tasks
+const (
+	ErrorCategoryOllamaUnreachable = "ollama_unreachable"
+	ErrorCategoryModel             = "model_error"
+	ErrorCategoryDatabase          = "db_error"
+	ErrorCategoryBadInput          = "bad_input"
+	ErrorCategoryInternal          = "internal"
+)
+
+// ErrorResult is the result of a failed task
+type ErrorResult struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+	// Category is one of the ErrorCategory values, and Retryable tells whether running the
+	// task again may succeed
+	Category         string `json:"category,omitempty"`
+	Retryable        bool   `json:"retryable"`
+	ModerationReason string `json:"moderation_reason,omitempty"`
+	OriginalName     string `json:"original_name,omitempty"`
+}
+
+// Done reports whether the task completed or failed
+func (t *Task) Done() bool {
+	return t.Status == StatusCompleted || t.Status == StatusFailed
+}
+
+// ResultType returns the type of the task result, empty while the task is not finished
+func (t *Task) ResultType() string {
+	var result struct {
+		Type string `json:"type"`
+	}
+	if len(t.Result) == 0 || json.Unmarshal(t.Result, &result) != nil {
+		return ""
+	}
+	return result.Type
+}
+
+// ImageResult decodes the result of a completed single image analysis
+func (t *Task) ImageResult() (*AnalyzeImageResult, error) {
+	var result AnalyzeImageResult
+	if err := t.decodeResult(ResultTypeAnalyzeImage, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// BatchResult decodes the result of a completed batch analysis
+func (t *Task) BatchResult() (*BatchResult, error) {
+	var result BatchResult
+	if err := t.decodeResult(ResultTypeBatch, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ScenesResult decodes the result of a completed scene analysis, the journey record of a
+// recording uploaded with UploadOptions.Scenes
+func (t *Task) ScenesResult() (*BatchResult, error) {
+	var result BatchResult
+	if err := t.decodeResult(ResultTypeScenes, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// TranslationResult decodes the result of a completed translation task
+func (t *Task) TranslationResult() (*TranslationResult, error) {
+	var result TranslationResult
+	if err := t.decodeResult(ResultTypeTranslation, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ErrorResult decodes the result of a failed task, with the category of the failure
+func (t *Task) ErrorResult() (*ErrorResult, error) {
+	var result ErrorResult
+	if err := t.decodeResult(ResultTypeError, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (t *Task) decodeResult(resultType string, v any) error {
+	if actual := t.ResultType(); actual != resultType {
+		return fmt.Errorf("task %s has a %q result, not %q", t.TaskID, actual, resultType)
+	}
+	return json.Unmarshal(t.Result, v)
+}
+
+// ErrorMessage returns the failure message of a failed task
+func (t *Task) ErrorMessage() string {
+	var result struct {
+		Error string `json:"error"`
+	}
+	if len(t.Result) == 0 || json.Unmarshal(t.Result, &result) != nil {
+		return ""
+	}
+	return result.Error
+}
+
+// Upload streams files to the API as a multipart request and returns the queued task IDs
+func (c *Client) Upload(ctx context.Context, files []File, opts UploadOptions) (*UploadResponse, error) {
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	// Write the form in the background so large files are streamed rather than buffered
+	go func() {
+		writer.CloseWithError(writeUploadForm(form, files, opts))
+	}()
+
+	var reader io.Reader = body
+	if opts.Progress != nil {
+		reader = &progressReader{reader: body, progress: opts.Progress}
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/upload", reader)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var response UploadResponse
+	if err := c.do(req, &response); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return &response, nil
+}
+
+// UploadFiles uploads local files by path
+func (c *Client) UploadFiles(ctx context.Context, opts UploadOptions, paths ...string) (*UploadResponse, error) {
+	var opened []*os.File
+	defer func() {
+		for _, f := range opened {
+			f.Close()
+		}
+	}()
+
+	files := make([]File, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		opened = append(opened, f)
+		files = append(files, File{Name: filepath.Base(path), Reader: f})
+	}
+
+	return c.Upload(ctx, files, opts)
+}
+
+func writeUploadForm(form *multipart.Writer, files []File, opts UploadOptions) error {
+	if opts.Priority != "" {
+		if err := form.WriteField("priority", opts.Priority); err != nil {
+			return err
+		}
+	}
+	if opts.Queue != "" {
+		if err := form.WriteField("queue", opts.Queue); err != nil {
+			return err
+		}
+	}
+	if opts.Sync {
+		if err := form.WriteField("sync", "true"); err != nil {
+			return err
+		}
+	}
+	for _, caption := range opts.Captions {
+		if err := form.WriteField("caption", caption); err != nil {
+			return err
+		}
+	}
+	if opts.CaptionMode != "" {
+		if err := form.WriteField("caption_mode", opts.CaptionMode); err != nil {
+			return err
+		}
+	}
+	if opts.Visibility != "" {
+		if err := form.WriteField("visibility", opts.Visibility); err != nil {
+			return err
+		}
+	}
+	if opts.Audit != "" {
+		if err := form.WriteField("audit", opts.Audit); err != nil {
+			return err
+		}
+	}
+	if opts.Scenes {
+		if err := form.WriteField("scenes", "true"); err != nil {
+			return err
+		}
+	}
+	for _, sum := range opts.SHA256 {
+		if err := form.WriteField("sha256", sum); err != nil {
+			return err
+		}
+	}
+	if opts.BatchAnalyze || opts.Archive != nil {
+		if err := form.WriteField("batch_analyze", "true"); err != nil {
+			return err
+		}
+		if opts.MaxChunkSize > 0 {
+			if err := form.WriteField("max_chunk_size", strconv.Itoa(opts.MaxChunkSize)); err != nil {
+				return err
+			}
+		}
+		if opts.MaxParallel > 0 {
+			if err := form.WriteField("max_parallel", strconv.Itoa(opts.MaxParallel)); err != nil {
+				return err
+			}
+		}
+		if opts.PerImage {
+			if err := form.WriteField("per_image", "true"); err != nil {
+				return err
+			}
+		}
+		if opts.ChunkRetries > 0 {
+			if err := form.WriteField("chunk_retries", strconv.Itoa(opts.ChunkRetries)); err != nil {
+				return err
+			}
+		}
+		if opts.MinChunkSuccess > 0 {
+			if err := form.WriteField("min_chunk_success", strconv.FormatFloat(opts.MinChunkSuccess, 'f', -1, 64)); err != nil {
+				return err
+			}
+		}
+		if opts.Scenario != "" {
+			if err := form.WriteField("scenario", opts.Scenario); err != nil {
+				return err
+			}
+		}
+		if opts.Order != "" {
+			if err := form.WriteField("order", opts.Order); err != nil {
+				return err
+			}
+		}
+		for _, step := range opts.Sequence {
+			if err := form.WriteField("sequence", strconv.Itoa(step)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, file := range files {
+		part, err := form.CreateFormFile("images", file.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, file.Reader); err != nil {
+			return err
+		}
+	}
+	if opts.Archive != nil {
+		part, err := form.CreateFormFile("archive", opts.Archive.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, opts.Archive.Reader); err != nil {
+			return err
+		}
+	}
+
+	return form.Close()
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	reader   io.Reader
+	sent     int64
+	progress func(sent int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.progress(r.sent)
+	}
+	return n, err
+}
+
+// Search kinds for SearchRequest.Kind
+const (
+	KindAll   = "all"
+	KindBatch = "batch"
+	KindImage = "image"
+)
+
+// UI state labels for SearchRequest.Label, set on records when the server classifies them
+const (
+	LabelError   = "error"
+	LabelEmpty   = "empty"
+	LabelSuccess = "success"
+	LabelLoading = "loading"
+	LabelOther   = "other"
+)
+
+// Search fields for SearchRequest.Field, the embedding of a record a search matches
+const (
+	FieldDescription = "description"
+	FieldSummary     = "summary"
+	FieldChunks      = "chunks"
+	FieldFrames      = "frames"
+)
+
+// Rankings for SearchRequest.Rank
+const (
+	RankSimilarity = "similarity"
+	RankRecency    = "recency"
+	RankHybrid     = "hybrid"
+)
+
+// QueryPart is one element of a composed query: a text, or the ID of a stored record
+// to search like. Parts are averaged, or weighted when Weight is set.
+type QueryPart struct {
+	Text   string   `json:"text,omitempty"`
+	ID     uint     `json:"id,omitempty"`
+	Weight *float64 `json:"weight,omitempty"`
+}
+
+// GeoFilter keeps results whose EXIF location lies within RadiusKm of a point
+type GeoFilter struct {
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	RadiusKm float64 `json:"radius_km,omitempty"`
+}
+
+// SearchRequest is a search with all of its options
+type SearchRequest struct {
+	Query string `json:"query,omitempty"`
+	// Queries are combined with Query into one query vector. Referenced records are
+	// left out of the results.
+	Queries []QueryPart `json:"queries,omitempty"`
+	TopK    int         `json:"top_k,omitempty"`
+	// Kind limits results to batch journeys or single images, empty searches both
+	Kind string `json:"kind,omitempty"`
+	// Field matches FieldDescription, FieldSummary, FieldChunks or FieldFrames embeddings,
+	// empty uses the server default. Searching summaries, chunks or the frames of videos
+	// leaves out records without them.
+	Field string `json:"field,omitempty"`
+	// Rank is RankSimilarity (the default), RankRecency to favor newer records, or RankHybrid
+	// to also match the words of the query text against descriptions, such as error codes
+	Rank string `json:"rank,omitempty"`
+	// HalfLife and RecencyWeight tune recency ranking, zero values use the server defaults
+	HalfLife      time.Duration `json:"-"`
+	RecencyWeight *float64      `json:"recency_weight,omitempty"`
+	// MinScore leaves out results scoring below it, from 0 to 1, so fewer than TopK may return.
+	// It cannot be combined with RankHybrid.
+	MinScore float64 `json:"min_score,omitempty"`
+	// HybridWeights override the server weights of the rankings RankHybrid fuses, by field:
+	// "vector", "description" or "caption". A zero weight leaves a ranking out.
+	HybridWeights map[string]float64 `json:"hybrid_weights,omitempty"`
+	// Exact scans every record instead of using the approximate index, for evaluation
+	// and correctness-critical queries
+	Exact bool `json:"exact,omitempty"`
+	// Near limits results to photos taken within a radius, the server defaults it to 5 km
+	Near *GeoFilter `json:"near,omitempty"`
+	// Label limits results to records classified with a UI state label, such as LabelError
+	Label string `json:"label,omitempty"`
+	// AccessibilityIssue limits results to records the accessibility audit found an issue of
+	// this type on, such as IssueContrast
+	AccessibilityIssue string `json:"accessibility_issue,omitempty"`
+	// Language matches the descriptions stored in a language, such as "de", instead of the
+	// ones records were analyzed with, or in every language with "all"
+	Language string `json:"language,omitempty"`
+	// MediaType limits results to a media type, such as "image/png", or to every subtype of
+	// one with "video/*"
+	MediaType string `json:"media_type,omitempty"`
+	// IsBatch limits results to batch journeys when true or single images when false, like Kind
+	IsBatch *bool `json:"is_batch,omitempty"`
+	// BatchID limits results to the journey record of a batch and the records of its images
+	BatchID string `json:"batch_id,omitempty"`
+	// Since and Until limit results to records created in a range, zero values leave it open
+	Since time.Time `json:"-"`
+	Until time.Time `json:"-"`
+	// Fields limits results to these JSON fields, such as "id" and "title", and Exclude
+	// leaves fields out, such as "embedding"; fields left out keep their zero values
+	Fields  []string `json:"fields,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// MarshalJSON encodes HalfLife as a duration string, and Since and Until as RFC 3339 times
+func (r SearchRequest) MarshalJSON() ([]byte, error) {
+	type request SearchRequest
+	encoded := struct {
+		request
+		HalfLife string `json:"half_life,omitempty"`
+		Since    string `json:"since,omitempty"`
+		Until    string `json:"until,omitempty"`
+	}{request: request(r)}
+	if r.HalfLife > 0 {
+		encoded.HalfLife = r.HalfLife.String()
+	}
+	if !r.Since.IsZero() {
+		encoded.Since = r.Since.Format(time.RFC3339)
+	}
+	if !r.Until.IsZero() {
+		encoded.Until = r.Until.Format(time.RFC3339)
+	}
+	return json.Marshal(encoded)
+}
+
+// Search returns the topK images closest to the query
+func (c *Client) Search(ctx context.Context, query string, topK int) ([]Result, error) {
+	return c.Find(ctx, SearchRequest{Query: query, TopK: topK})
+}
+
+// Find runs a search with the options of the request
+func (c *Client) Find(ctx context.Context, search SearchRequest) ([]Result, error) {
+	body, err := json.Marshal(search)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/search", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var results []Result
+	if err := c.do(req, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ImageSearch is the result of a search by an example image
+type ImageSearch struct {
+	// Description is what the model saw in the image, which was embedded as the query
+	Description string   `json:"description"`
+	Results     []Result `json:"results"`
+}
+
+// FindLike searches for the records most similar to an example image, with the options of
+// search besides its query. The image is not stored.
+func (c *Client) FindLike(ctx context.Context, image File, search SearchRequest) (*ImageSearch, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	options, err := json.Marshal(search)
+	if err != nil {
+		return nil, err
+	}
+	if err := form.WriteField("search", string(options)); err != nil {
+		return nil, err
+	}
+	part, err := form.CreateFormFile("image", image.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, image.Reader); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/search/image", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var response ImageSearch
+	if err := c.do(req, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Baseline is the image of a baseline batch nearest to a compared screenshot
+type Baseline struct {
+	ID       uint   `json:"id"`
+	FilePath string `json:"file_path"`
+	URL      string `json:"url"`
+	Title    string `json:"title"`
+	// Step is the 1-based step of the image in the batch
+	Step int `json:"step"`
+}
+
+// Comparison is the drift of a screenshot from its nearest image of a baseline batch
+type Comparison struct {
+	BatchID string `json:"batch_id"`
+	// Description is what the model saw in the screenshot
+	Description string   `json:"description"`
+	Baseline    Baseline `json:"baseline"`
+	// CosineSimilarity is 1 minus the semantic drift, unlike the score of search results
+	CosineSimilarity float64 `json:"cosine_similarity"`
+	// SemanticDrift is the cosine distance between the embeddings of the descriptions
+	SemanticDrift float64 `json:"semantic_drift"`
+	// VisualDrift is the mean pixel difference, nil when the images could not be compared
+	VisualDrift *float64 `json:"visual_drift,omitempty"`
+	Threshold   float64  `json:"threshold"`
+	Drifted     bool     `json:"drifted"`
+	// Changes lists what the model sees changed, only for drifted screenshots
+	Changes string `json:"changes,omitempty"`
+}
+
+// CompareToBaseline compares a screenshot to the images of the baseline batch batchID,
+// which must have been uploaded with per_image. A threshold of 0 uses the server default.
+// The screenshot is not stored.
+func (c *Client) CompareToBaseline(ctx context.Context, batchID string, image File, threshold float64) (*Comparison, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if threshold > 0 {
+		if err := form.WriteField("threshold", strconv.FormatFloat(threshold, 'f', -1, 64)); err != nil {
+			return nil, err
+		}
+	}
+	part, err := form.CreateFormFile("image", image.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, image.Reader); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/batches/"+url.PathEscape(batchID)+"/compare", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var response Comparison
+	if err := c.do(req, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Stream runs a search with results streamed as NDJSON, calling fn with each result as it
+// arrives, so large searches need not be held in memory. An error from fn stops the stream.
+func (c *Client) Stream(ctx context.Context, search SearchRequest, fn func(Result) error) error {
+	body, err := json.Marshal(search)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/search", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return newAPIError(resp.StatusCode, body)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var result Result
+		if err := decoder.Decode(&result); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+}
+
+// TaskStatus returns the current status of a task
+func (c *Client) TaskStatus(ctx context.Context, taskID string) (*Task, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/v1/tasks/"+url.PathEscape(taskID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var task Task
+	if err := c.do(req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// RetryResponse is a failed task queued again
+type RetryResponse struct {
+	TaskID   string `json:"task_id"`
+	Status   string `json:"status"`
+	Queue    string `json:"queue"`
+	Priority string `json:"priority"`
+	// Attempt is the run the task is queued for, 2 for the first retry
+	Attempt int `json:"attempt"`
+}
+
+// RetryTask queues a failed task again with its original payload, without uploading the
+// files again. Tasks that did not fail are rejected with a 409 APIError.
+func (c *Client) RetryTask(ctx context.Context, taskID string) (*RetryResponse, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/tasks/"+url.PathEscape(taskID)+"/retry", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var retry RetryResponse
+	if err := c.do(req, &retry); err != nil {
+		return nil, err
+	}
+	return &retry, nil
+}
+
+// TranslationRequest translates the descriptions of records into languages they have none in
+type TranslationRequest struct {
+	// Languages are language tags such as "de", the server's TRANSLATION_LANGUAGES when empty
+	Languages []string `json:"languages,omitempty"`
+	// RecordIDs limits the translation to these records, every record when empty
+	RecordIDs []uint `json:"record_ids,omitempty"`
+	Queue     string `json:"queue,omitempty"`
+	Priority  string `json:"priority,omitempty"`
+}
+
+// TranslationResponse is a queued translation task
+type TranslationResponse struct {
+	TaskID    string   `json:"task_id"`
+	Languages []string `json:"languages"`
+	Queue     string   `json:"queue"`
+	Priority  string   `json:"priority"`
+}
+
+// Translate queues a task translating the descriptions of records, polled with WaitForTask
+// and read with TranslationResult
+func (c *Client) Translate(ctx context.Context, translation TranslationRequest) (*TranslationResponse, error) {
+	payload, err := json.Marshal(translation)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/translations", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var queued TranslationResponse
+	if err := c.do(req, &queued); err != nil {
+		return nil, err
+	}
+	return &queued, nil
+}
+
+// ShareRequest shares a record by RecordID, or a batch by BatchID
+type ShareRequest struct {
+	RecordID uint   `json:"record_id,omitempty"`
+	BatchID  string `json:"batch_id,omitempty"`
+	// ExpiresIn is how long the share lasts, the server's SHARE_DEFAULT_TTL when zero
+	ExpiresIn time.Duration `json:"-"`
+}
+
+// Share is a link giving read-only access to a record or batch without an API key
+type Share struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	RecordID  uint      `json:"record_id,omitempty"`
+	BatchID   string    `json:"batch_id,omitempty"`
+	ReportURL string    `json:"report_url,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateShare creates an expiring share link to a record or batch, with its images and, for
+// batches, its journey report
+func (c *Client) CreateShare(ctx context.Context, share ShareRequest) (*Share, error) {
+	body := map[string]any{}
+	if share.RecordID != 0 {
+		body["record_id"] = share.RecordID
+	}
+	if share.BatchID != "" {
+		body["batch_id"] = share.BatchID
+	}
+	if share.ExpiresIn > 0 {
+		body["expires_in"] = share.ExpiresIn.String()
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/shares", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var created Share
+	if err := c.do(req, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Image is a record with the URLs its files are served at
+type Image struct {
+	Result
+	URL string `json:"url"`
+	// OriginalURL serves the HEIC or AVIF original of a converted upload
+	OriginalURL string `json:"original_url,omitempty"`
+	// BatchURLs serve the member images of a batch, in journey order
+	BatchURLs             []string               `json:"batch_urls,omitempty"`
+	AccessibilityFindings []AccessibilityFinding `json:"accessibility_findings,omitempty"`
+}
+
+// GetImage returns a record by its ID, with its full description
+func (c *Client) GetImage(ctx context.Context, id uint) (*Image, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/v1/images/"+strconv.FormatUint(uint64(id), 10), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var image Image
+	if err := c.do(req, &image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// Frame is a frame sampled from a video every VIDEO_FRAME_INTERVAL, with its description
+type Frame struct {
+	ID       uint `json:"id"`
+	RecordID uint `json:"record_id"`
+	// OffsetMS is the time of the frame from the start of the video, Timestamp the same as 01:23
+	OffsetMS  int64  `json:"offset_ms"`
+	Timestamp string `json:"timestamp"`
+	FilePath  string `json:"file_path"`
+	Text      string `json:"text"`
+	// URL plays the video from the frame, ThumbnailURL serves the frame as JPEG
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	Distance     float64   `json:"distance,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ListFrames returns the frames of a video record, in order
+func (c *Client) ListFrames(ctx context.Context, id uint) ([]Frame, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/v1/images/"+strconv.FormatUint(uint64(id), 10)+"/frames", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Frames []Frame `json:"frames"`
+	}
+	if err := c.do(req, &response); err != nil {
+		return nil, err
+	}
+	return response.Frames, nil
+}
+
+// RawText is the description of a record before the server's REDACT_PII redacted it
+type RawText struct {
+	ID   uint   `json:"id"`
+	Text string `json:"text"`
+	// Redacted is false when nothing was redacted from the description, so Text is the one
+	// served with the record
+	Redacted   bool     `json:"redacted"`
+	Redactions []string `json:"redactions"`
+}
+
+// GetRawText returns the description of a record before it was redacted. The client's API
+// key must be one of the server's ADMIN_API_KEYS.
+func (c *Client) GetRawText(ctx context.Context, id uint) (*RawText, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/v1/images/"+strconv.FormatUint(uint64(id), 10)+"/raw", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw RawText
+	if err := c.do(req, &raw); err != nil {
+		return nil, err
+	}
+	return &raw, nil
+}
+
+// ImageSummary is an indexed record as listed by ListImages
+type ImageSummary struct {
+	ID           uint   `json:"id"`
+	FilePath     string `json:"file_path"`
+	OriginalName string `json:"original_name"`
+	MediaType    string `json:"media_type"`
+	Title        string `json:"title"`
+	// Snippet is the start of the description
+	Snippet   string    `json:"snippet"`
+	IsBatch   bool      `json:"is_batch"`
+	BatchID   string    `json:"batch_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ImagePage is a page of indexed records, newest first
+type ImagePage struct {
+	Images []ImageSummary `json:"images"`
+	// Total is the number of records across every page
+	Total  int64 `json:"total"`
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+}
+
+// ListImages returns a page of the indexed records, newest first. A limit of zero uses the
+// server's default, and kind is one of the search kinds, empty for every record.
+func (c *Client) ListImages(ctx context.Context, limit int, offset int, kind string) (*ImagePage, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	if kind != "" {
+		query.Set("kind", kind)
+	}
+	path := "/api/v1/images"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var page ImagePage
+	if err := c.do(req, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// DeleteImage deletes a record, and its files once no other record references them
+func (c *Client) DeleteImage(ctx context.Context, id uint) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/api/v1/images/"+strconv.FormatUint(uint64(id), 10), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// SetDescription stores the description of a record in a language, such as a translation,
+// so the record is found by searches in that language
+func (c *Client) SetDescription(ctx context.Context, id uint, language, text string) error {
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, "/api/v1/images/"+strconv.FormatUint(uint64(id), 10)+
+		"/descriptions/"+url.PathEscape(language), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, nil)
+}
+
+// DeleteDescription deletes the description of a record in a language
+func (c *Client) DeleteDescription(ctx context.Context, id uint, language string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/api/v1/images/"+strconv.FormatUint(uint64(id), 10)+
+		"/descriptions/"+url.PathEscape(language), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// RevokeShare revokes a share link before it expires
+func (c *Client) RevokeShare(ctx context.Context, token string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/api/v1/shares/"+url.PathEscape(token), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// WaitForTask polls a task every interval (DefaultPollInterval when zero) until it
+// completes or fails, or ctx is done
+func (c *Client) WaitForTask(ctx context.Context, taskID string, interval time.Duration) (*Task, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		task, err := c.TaskStatus(ctx, taskID)
+		if err != nil {
+			return nil, err
+		}
+		if task.Done() {
+			return task, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return task, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitForTasks waits for every task to finish, calling progress (when not nil) as each one does
+func (c *Client) WaitForTasks(ctx context.Context, taskIDs []string, progress func(task *Task, done int, total int)) ([]*Task, error) {
+	tasks := make([]*Task, len(taskIDs))
+	for i, taskID := range taskIDs {
+		task, err := c.WaitForTask(ctx, taskID, 0)
+		if err != nil {
+			return tasks, err
+		}
+		tasks[i] = task
+
+		if progress != nil {
+			progress(task, i+1, len(taskIDs))
+		}
+	}
+	return tasks, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return req, nil
+}
+
+// do sends the request and decodes a JSON response into out
+func (c *Client) do(req *http.Request, out any) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return newAPIError(resp.StatusCode, body)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// newAPIError reads a JSON error envelope {code, message, details, request_id}, falling
+// back to the text of other bodies
+func newAPIError(statusCode int, body []byte) *APIError {
+	var envelope struct {
+		Code      string         `json:"code"`
+		Message   string         `json:"message"`
+		Details   map[string]any `json:"details"`
+		RequestID string         `json:"request_id"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Message != "" {
+		return &APIError{StatusCode: statusCode, Code: envelope.Code, Message: envelope.Message,
+			Details: envelope.Details, RequestID: envelope.RequestID}
+	}
+	return &APIError{StatusCode: statusCode, Message: strings.TrimSpace(string(body))}
+}
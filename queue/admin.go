@@ -0,0 +1,255 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotDeadLetter is returned when a task to retry is not in the dead letter list
+var ErrNotDeadLetter = errors.New("task is not a dead letter")
+
+// DeadLetterQueue returns the name of the list holding the failed tasks of a queue
+func DeadLetterQueue(queueName string) string {
+	return queueName + ":dead"
+}
+
+// DeadLetter moves a failed task to the queue's dead letter list with the failure reason
+func (c *Client) DeadLetter(queueName string, task *TaskPayload, reason string) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	failed := *task
+	failed.LastError = reason
+	failed.FailedAt = time.Now()
+
+	taskJSON, err := json.Marshal(failed)
+	if err != nil {
+		return err
+	}
+
+	return c.rdb.RPush(ctx, DeadLetterQueue(queueName), taskJSON).Err()
+}
+
+// Length returns the number of tasks waiting in a list
+func (c *Client) Length(queueName string) (int64, error) {
+	if c == nil {
+		return 0, fmt.Errorf("redis client not initialized")
+	}
+
+	return c.rdb.LLen(ctx, queueName).Result()
+}
+
+// List returns up to count tasks from a list starting at offset, oldest first
+func (c *Client) List(queueName string, offset int64, count int64) ([]TaskPayload, error) {
+	if c == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+
+	entries, err := c.rdb.LRange(ctx, queueName, offset, offset+count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]TaskPayload, 0, len(entries))
+	for _, entry := range entries {
+		var task TaskPayload
+		if err := json.Unmarshal([]byte(entry), &task); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// RequeueDeadLetters moves up to limit dead letters back to the queue at their priority,
+// oldest first, marking them pending again. A limit of 0 requeues all of them. With
+// retryableOnly, dead letters whose failure would happen again stay in the dead letter list.
+func (c *Client) RequeueDeadLetters(queueName string, limit int, retryableOnly bool) (int, error) {
+	if c == nil {
+		return 0, fmt.Errorf("redis client not initialized")
+	}
+
+	// Kept dead letters go back to the end of the list, so each one is looked at once
+	remaining, err := c.rdb.LLen(ctx, DeadLetterQueue(queueName)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for ; remaining > 0 && (limit <= 0 || requeued < limit); remaining-- {
+		entry, err := c.rdb.LPop(ctx, DeadLetterQueue(queueName)).Result()
+		if err != nil {
+			if err == redis.Nil {
+				break
+			}
+			return requeued, err
+		}
+
+		var task TaskPayload
+		if err := json.Unmarshal([]byte(entry), &task); err != nil {
+			return requeued, err
+		}
+
+		// Dead letters from before failures were classified have no category and are retried
+		if retryableOnly && task.ErrorCategory != "" && !task.Retryable {
+			if err := c.rdb.RPush(ctx, DeadLetterQueue(queueName), entry).Err(); err != nil {
+				return requeued, err
+			}
+			continue
+		}
+
+		if err := c.requeueDeadLetter(queueName, &task); err != nil {
+			return requeued, err
+		}
+		requeued++
+	}
+
+	return requeued, nil
+}
+
+// RetryDeadLetter moves the dead letter of a task back to the queue at its priority, marking
+// it pending again, and returns the task as queued
+func (c *Client) RetryDeadLetter(queueName string, taskID string) (*TaskPayload, error) {
+	if c == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+
+	entries, err := c.rdb.LRange(ctx, DeadLetterQueue(queueName), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		var task TaskPayload
+		if err := json.Unmarshal([]byte(entry), &task); err != nil || task.TaskID != taskID {
+			continue
+		}
+
+		// Another retry may have taken the entry since it was read
+		removed, err := c.rdb.LRem(ctx, DeadLetterQueue(queueName), 1, entry).Result()
+		if err != nil {
+			return nil, err
+		}
+		if removed == 0 {
+			break
+		}
+
+		if err := c.requeueDeadLetter(queueName, &task); err != nil {
+			return nil, err
+		}
+		return &task, nil
+	}
+
+	return nil, ErrNotDeadLetter
+}
+
+// requeueDeadLetter queues a task taken off the dead letter list for another attempt
+func (c *Client) requeueDeadLetter(queueName string, task *TaskPayload) error {
+	task.LastError = ""
+	task.FailedAt = time.Time{}
+	task.ErrorCategory = ""
+	task.Retryable = false
+	task.Attempt = max(task.Attempt, 1) + 1
+
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	if err := c.rdb.RPush(ctx, PriorityQueue(queueName, task.Priority), taskJSON).Err(); err != nil {
+		return err
+	}
+
+	return c.SetTaskStatus(task.TaskID, "pending")
+}
+
+// Purge deletes every task in a list, returning how many were removed
+func (c *Client) Purge(queueName string) (int64, error) {
+	if c == nil {
+		return 0, fmt.Errorf("redis client not initialized")
+	}
+
+	count, err := c.rdb.LLen(ctx, queueName).Result()
+	if err != nil {
+		return 0, err
+	}
+	if err := c.rdb.Del(ctx, queueName).Err(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ListPending returns up to count pending tasks of a queue in the order workers take them,
+// highest priority first
+func (c *Client) ListPending(queueName string, count int64) ([]TaskPayload, error) {
+	var tasks []TaskPayload
+	for _, priority := range Priorities {
+		if int64(len(tasks)) >= count {
+			break
+		}
+		listed, err := c.List(PriorityQueue(queueName, priority), 0, count-int64(len(tasks)))
+		if err != nil {
+			return tasks, err
+		}
+		tasks = append(tasks, listed...)
+	}
+	return tasks, nil
+}
+
+// PurgePending deletes the pending tasks of a queue at every priority, returning how many
+// were removed
+func (c *Client) PurgePending(queueName string) (int64, error) {
+	var purged int64
+	for _, priority := range Priorities {
+		count, err := c.Purge(PriorityQueue(queueName, priority))
+		purged += count
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// QueueStats summarizes the backlog of a queue
+type QueueStats struct {
+	Queue       string           `json:"queue"`
+	Pending     int64            `json:"pending"`
+	ByPriority  map[string]int64 `json:"by_priority"`
+	DeadLetters int64            `json:"dead_letters"`
+	OldestAge   time.Duration    `json:"oldest_age_ns"`
+}
+
+// Stats reports the pending counts of a queue by priority, its dead letter count and the
+// age of its oldest task
+func (c *Client) Stats(queueName string) (*QueueStats, error) {
+	dead, err := c.Length(DeadLetterQueue(queueName))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &QueueStats{Queue: queueName, ByPriority: map[string]int64{}, DeadLetters: dead}
+
+	for _, priority := range Priorities {
+		name := PriorityQueue(queueName, priority)
+		pending, err := c.Length(name)
+		if err != nil {
+			return nil, err
+		}
+		stats.ByPriority[priority] = pending
+		stats.Pending += pending
+
+		oldest, err := c.List(name, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(oldest) > 0 {
+			stats.OldestAge = max(stats.OldestAge, time.Since(oldest[0].Created))
+		}
+	}
+
+	return stats, nil
+}
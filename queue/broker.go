@@ -0,0 +1,207 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker moves tasks from the API to the workers and keeps their status and result. Client
+// is the Redis broker, tests use an in-memory one.
+type Broker interface {
+	Push(ctx context.Context, task *TaskPayload) error
+	Requeue(task *TaskPayload) error
+	Dequeue(queueNames []string, timeout time.Duration) (*TaskPayload, error)
+	GetTaskStatus(taskID string) (string, error)
+	SetTaskStatus(taskID string, status string) error
+	StoreTaskResult(taskID string, result any) error
+	GetTaskResult(taskID string) ([]byte, error)
+	DeleteTask(taskID string) error
+	LinkRecordTask(recordID uint, taskID string) error
+}
+
+// Push adds a task at the tail of its queue and priority
+func (c *Client) Push(ctx context.Context, task *TaskPayload) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	return c.rdb.RPush(ctx, PriorityQueue(task.Queue, task.Priority), taskJSON).Err()
+}
+
+// Requeue puts a task back at the head of its queue and priority, so it is the next one picked up
+func (c *Client) Requeue(task *TaskPayload) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	return c.rdb.LPush(ctx, PriorityQueue(task.Queue, task.Priority), taskJSON).Err()
+}
+
+// Dequeue retrieves a task from the queues with timeout, taking the highest priority
+// tasks first and, within a priority, the queues in the order given
+func (c *Client) Dequeue(queueNames []string, timeout time.Duration) (*TaskPayload, error) {
+	if c == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+
+	var keys []string
+	for _, priority := range Priorities {
+		for _, queueName := range queueNames {
+			keys = append(keys, PriorityQueue(queueName, priority))
+		}
+	}
+
+	// BLPOP blocks until an element is available, or until timeout, popping from the first
+	// non-empty list
+	result, err := c.rdb.BLPop(ctx, timeout, keys...).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil // No message available
+		}
+		return nil, err
+	}
+
+	// Result contains queue name at index 0 and payload at index 1
+	if len(result) < 2 {
+		return nil, fmt.Errorf("invalid result format from redis")
+	}
+
+	var task TaskPayload
+	err = json.Unmarshal([]byte(result[1]), &task)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tasks queued before priorities existed are normal priority tasks of the list they came from
+	if task.Queue == "" {
+		task.Queue = result[0]
+	}
+
+	return &task, nil
+}
+
+// GetTaskStatus retrieves the status of a task
+func (c *Client) GetTaskStatus(taskID string) (string, error) {
+	if c == nil {
+		return "", fmt.Errorf("redis client not initialized")
+	}
+
+	status, err := c.rdb.Get(ctx, fmt.Sprintf("task:%s:status", taskID)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "unknown", nil
+		}
+		return "", err
+	}
+
+	return status, nil
+}
+
+// SetTaskStatus updates the status of a task
+func (c *Client) SetTaskStatus(taskID string, status string) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	return c.rdb.Set(ctx, fmt.Sprintf("task:%s:status", taskID), status, 24*time.Hour).Err()
+}
+
+// StoreTaskResult stores the result of a finished task as JSON
+func (c *Client) StoreTaskResult(taskID string, result any) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return c.rdb.Set(ctx, fmt.Sprintf("task:%s:result", taskID), resultJSON, 24*time.Hour).Err()
+}
+
+// GetTaskResult retrieves the JSON result of a finished task, nil when there is none
+func (c *Client) GetTaskResult(taskID string) ([]byte, error) {
+	if c == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+
+	resultJSON, err := c.rdb.Get(ctx, fmt.Sprintf("task:%s:result", taskID)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return resultJSON, nil
+}
+
+// DeleteTask removes the status, result and chunk checkpoints of a task
+func (c *Client) DeleteTask(taskID string) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	return c.rdb.Del(ctx,
+		fmt.Sprintf("task:%s:status", taskID),
+		fmt.Sprintf("task:%s:result", taskID),
+		chunksKey(taskID)).Err()
+}
+
+// recordTasksKey is the set of the tasks that analyzed a record
+func recordTasksKey(recordID uint) string {
+	return fmt.Sprintf("record:%d:tasks", recordID)
+}
+
+// LinkRecordTask notes that a task analyzed the record recordID, so deleting the record deletes
+// the task too. The link expires with the task keys.
+func (c *Client) LinkRecordTask(recordID uint, taskID string) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	key := recordTasksKey(recordID)
+	pipe := c.rdb.TxPipeline()
+	pipe.SAdd(ctx, key, taskID)
+	pipe.Expire(ctx, key, 24*time.Hour)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// DeleteRecordTasks removes the status, result and chunk checkpoints of every task linked to
+// the record recordID, and the links
+func (c *Client) DeleteRecordTasks(recordID uint) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	key := recordTasksKey(recordID)
+	taskIDs, err := c.rdb.SMembers(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{key}
+	for _, taskID := range taskIDs {
+		keys = append(keys,
+			fmt.Sprintf("task:%s:status", taskID),
+			fmt.Sprintf("task:%s:result", taskID),
+			chunksKey(taskID))
+	}
+	return c.rdb.Del(ctx, keys...).Err()
+}
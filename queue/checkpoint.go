@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ChunkCheckpoint is the analysis of a finished chunk of a batch task
+type ChunkCheckpoint struct {
+	FilePaths []string `json:"file_paths"`
+	Text      string   `json:"text"`
+}
+
+// chunksKey is the hash of a task's chunk checkpoints, by chunk index
+func chunksKey(taskID string) string {
+	return fmt.Sprintf("task:%s:chunks", taskID)
+}
+
+// SaveChunkCheckpoint records the analysis of a finished chunk of a task. Checkpoints
+// expire with the task status.
+func (c *Client) SaveChunkCheckpoint(taskID string, index int, filePaths []string, text string) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	checkpointJSON, err := json.Marshal(ChunkCheckpoint{FilePaths: filePaths, Text: text})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, chunksKey(taskID), strconv.Itoa(index), checkpointJSON)
+		pipe.Expire(ctx, chunksKey(taskID), 24*time.Hour)
+		return nil
+	})
+	return err
+}
+
+// LoadChunkCheckpoint returns the analysis of a chunk finished by an earlier run of a task.
+// It is only found when the chunk still holds the same files.
+func (c *Client) LoadChunkCheckpoint(taskID string, index int, filePaths []string) (string, bool, error) {
+	if c == nil {
+		return "", false, fmt.Errorf("redis client not initialized")
+	}
+
+	checkpointJSON, err := c.rdb.HGet(ctx, chunksKey(taskID), strconv.Itoa(index)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	var checkpoint ChunkCheckpoint
+	if err := json.Unmarshal([]byte(checkpointJSON), &checkpoint); err != nil {
+		return "", false, err
+	}
+	if !slices.Equal(checkpoint.FilePaths, filePaths) {
+		return "", false, nil
+	}
+	return checkpoint.Text, true, nil
+}
+
+// DeleteChunkCheckpoints removes the chunk checkpoints of a task
+func (c *Client) DeleteChunkCheckpoints(taskID string) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	return c.rdb.Del(ctx, chunksKey(taskID)).Err()
+}
@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+)
+
+// CountInWindow increments a counter shared by every process and returns its value. The
+// counter starts over window after its first increment.
+func (c *Client) CountInWindow(name string, window time.Duration) (int64, error) {
+	if c == nil {
+		return 0, fmt.Errorf("redis client not initialized")
+	}
+
+	key := "counter:" + name
+	count, err := c.rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if err := c.rdb.Expire(ctx, key, window).Err(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// ClaimOnce reports whether this call is the first to claim name within ttl, across every
+// process, so an action such as an alert happens once per period
+func (c *Client) ClaimOnce(name string, ttl time.Duration) (bool, error) {
+	if c == nil {
+		return false, fmt.Errorf("redis client not initialized")
+	}
+
+	return c.rdb.SetNX(ctx, "claim:"+name, time.Now().Unix(), ttl).Result()
+}
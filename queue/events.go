@@ -0,0 +1,22 @@
+package queue
+
+import (
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PublishEvent appends an event to a Redis stream, trimming it to about maxLen entries
+// (0 keeps every entry), and returns its stream ID
+func (c *Client) PublishEvent(stream string, maxLen int64, values map[string]any) (string, error) {
+	if c == nil {
+		return "", fmt.Errorf("redis client not initialized")
+	}
+
+	return c.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: maxLen,
+		Approx: true,
+		Values: values,
+	}).Result()
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Lock is a lock on a name shared by every process, held until it is released or its TTL
+// passes without a refresh
+type Lock struct {
+	client *Client
+	key    string
+	token  string
+}
+
+// releaseLock deletes a lock only while it is still held by the token, so a holder whose TTL
+// passed cannot release the lock of the next one
+var releaseLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// refreshLock extends a lock only while it is still held by the token
+var refreshLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// TryLock takes the lock on name for ttl. It reports false when another holder has it.
+func (c *Client) TryLock(name string, ttl time.Duration) (*Lock, bool, error) {
+	if c == nil {
+		return nil, false, fmt.Errorf("redis client not initialized")
+	}
+
+	lock := &Lock{client: c, key: "lock:" + name, token: NewTaskID()}
+	acquired, err := c.rdb.SetNX(ctx, lock.key, lock.token, ttl).Result()
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+	return lock, true, nil
+}
+
+// Refresh extends the lock to ttl from now. It reports false when the lock was lost, after
+// its TTL passed.
+func (l *Lock) Refresh(ttl time.Duration) (bool, error) {
+	held, err := refreshLock.Run(ctx, l.client.rdb, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+	return held == 1, err
+}
+
+// Release releases the lock, unless it was already lost
+func (l *Lock) Release() error {
+	return releaseLock.Run(ctx, l.client.rdb, []string{l.key}, l.token).Err()
+}
@@ -2,11 +2,13 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
 	"time"
 
+	"github.com/pablobfonseca/go-image-vector/logging"
+	"github.com/pablobfonseca/go-image-vector/tracing"
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 )
@@ -15,20 +17,68 @@ const (
 	ImageProcessingQueue = "image_processing"
 )
 
-var (
-	redisClient *redis.Client
-	ctx         = context.Background()
+// Task priorities. Workers take high priority tasks of every queue they consume before
+// normal ones, and normal ones before low.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
 )
 
+// Priorities lists the task priorities from the first served to the last
+var Priorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+// ValidPriority reports whether priority is one of Priorities
+func ValidPriority(priority string) bool {
+	return slices.Contains(Priorities, priority)
+}
+
+// PriorityQueue returns the name of the list holding the tasks of a queue with a priority.
+// Normal priority tasks use the queue name itself.
+func PriorityQueue(queueName string, priority string) string {
+	if priority == "" || priority == PriorityNormal {
+		return queueName
+	}
+	return queueName + ":" + priority
+}
+
+// ctx is the context of Redis calls made without one
+var ctx = context.Background()
+
 type TaskPayload struct {
-	TaskID   string         `json:"task_id"`
-	TaskType string         `json:"task_type"`
-	Data     map[string]any `json:"data"`
-	Created  time.Time      `json:"created"`
+	TaskID      string         `json:"task_id"`
+	TaskType    string         `json:"task_type"`
+	Queue       string         `json:"queue,omitempty"`
+	Priority    string         `json:"priority,omitempty"`
+	Data        map[string]any `json:"data"`
+	Created     time.Time      `json:"created"`
+	TraceParent string         `json:"trace_parent,omitempty"`
+	RequestID   string         `json:"request_id,omitempty"`
+	// Attempt counts the runs of the task once it was retried, zero for a first run
+	Attempt int `json:"attempt,omitempty"`
+
+	// Set on dead letters
+	LastError     string    `json:"last_error,omitempty"`
+	FailedAt      time.Time `json:"failed_at,omitzero"`
+	ErrorCategory string    `json:"error_category,omitempty"`
+	Retryable     bool      `json:"retryable,omitempty"`
+}
+
+// Client is the Redis connection of the queues and of the state shared by every process, such
+// as storage usage, shares and counters. As a Broker, it keeps tasks in Redis lists, one per
+// queue and priority, and their status and result in keys that expire after a day.
+type Client struct {
+	rdb *redis.Client
 }
 
-// Initialize sets up the Redis connection
-func Initialize() {
+// NewClient returns a client using a Redis connection
+func NewClient(rdb *redis.Client) *Client {
+	return &Client{rdb: rdb}
+}
+
+// Connect returns a client of the configured Redis. An unreachable Redis is logged rather than
+// fatal, the calls of the client fail until it is reachable.
+func Connect() *Client {
 	redisAddr := viper.GetString("REDIS_ADDR")
 	if redisAddr == "" {
 		redisAddr = "localhost:6379"
@@ -37,136 +87,54 @@ func Initialize() {
 	redisPassword := viper.GetString("REDIS_PASSWORD")
 	redisDB := viper.GetInt("REDIS_DB")
 
-	redisClient = redis.NewClient(&redis.Options{
+	client := NewClient(redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
 		Password: redisPassword,
 		DB:       redisDB,
-	})
+	}))
 
 	// Ping Redis to ensure connection is working
-	_, err := redisClient.Ping(ctx).Result()
-	if err != nil {
-		log.Printf("Warning: Redis connection failed: %v. Queue functionality will be disabled.", err)
+	if err := client.Ping(ctx); err != nil {
+		slog.Warn("Redis connection failed, queue functionality will be disabled", "addr", redisAddr, "error", err)
 	} else {
-		log.Println("Redis connected successfully")
-	}
-}
-
-// Enqueue adds a task to the specified queue
-func Enqueue(queueName string, taskType string, data map[string]any) (string, error) {
-	if redisClient == nil {
-		return "", fmt.Errorf("redis client not initialized")
-	}
-
-	taskID := fmt.Sprintf("%d", time.Now().UnixNano())
-	task := TaskPayload{
-		TaskID:   taskID,
-		TaskType: taskType,
-		Data:     data,
-		Created:  time.Now(),
-	}
-
-	taskJSON, err := json.Marshal(task)
-	if err != nil {
-		return "", err
+		slog.Info("Redis connected successfully", "addr", redisAddr)
 	}
-
-	err = redisClient.RPush(ctx, queueName, taskJSON).Err()
-	if err != nil {
-		return "", err
-	}
-
-	return taskID, nil
+	return client
 }
 
-// Dequeue retrieves a task from the queue with timeout
-func Dequeue(queueName string, timeout time.Duration) (*TaskPayload, error) {
-	if redisClient == nil {
-		return nil, fmt.Errorf("redis client not initialized")
-	}
-
-	// BLPOP blocks until an element is available, or until timeout
-	result, err := redisClient.BLPop(ctx, timeout, queueName).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return nil, nil // No message available
-		}
-		return nil, err
-	}
-
-	// Result contains queue name at index 0 and payload at index 1
-	if len(result) < 2 {
-		return nil, fmt.Errorf("invalid result format from redis")
-	}
-
-	var task TaskPayload
-	err = json.Unmarshal([]byte(result[1]), &task)
-	if err != nil {
-		return nil, err
-	}
-
-	return &task, nil
-}
-
-// GetTaskStatus retrieves the status of a task
-func GetTaskStatus(taskID string) (string, error) {
-	if redisClient == nil {
-		return "", fmt.Errorf("redis client not initialized")
-	}
-
-	status, err := redisClient.Get(ctx, fmt.Sprintf("task:%s:status", taskID)).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return "unknown", nil
-		}
-		return "", err
-	}
-
-	return status, nil
-}
-
-// SetTaskStatus updates the status of a task
-func SetTaskStatus(taskID string, status string) error {
-	if redisClient == nil {
+// Ping checks that Redis is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	if c == nil {
 		return fmt.Errorf("redis client not initialized")
 	}
-
-	return redisClient.Set(ctx, fmt.Sprintf("task:%s:status", taskID), status, 24*time.Hour).Err()
+	return c.rdb.Ping(ctx).Err()
 }
 
-// StoreTaskResult stores the result of a completed task
-func StoreTaskResult(taskID string, result map[string]any) error {
-	if redisClient == nil {
-		return fmt.Errorf("redis client not initialized")
-	}
-
-	resultJSON, err := json.Marshal(result)
-	if err != nil {
-		return err
-	}
-
-	return redisClient.Set(ctx, fmt.Sprintf("task:%s:result", taskID), resultJSON, 24*time.Hour).Err()
+// Close closes the Redis connection
+func (c *Client) Close() error {
+	return c.rdb.Close()
 }
 
-// GetTaskResult retrieves the result of a completed task
-func GetTaskResult(taskID string) (map[string]any, error) {
-	if redisClient == nil {
-		return nil, fmt.Errorf("redis client not initialized")
-	}
-
-	resultJSON, err := redisClient.Get(ctx, fmt.Sprintf("task:%s:result", taskID)).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return nil, nil
-		}
-		return nil, err
-	}
+// NewTaskID generates a new task identifier
+func NewTaskID() string {
+	return fmt.Sprintf("%d", time.Now().UnixNano())
+}
 
-	var result map[string]any
-	err = json.Unmarshal([]byte(resultJSON), &result)
-	if err != nil {
-		return nil, err
+// Enqueue adds a task to the specified queue of a broker with a priority, carrying the trace
+// context and request ID of ctx
+func Enqueue(ctx context.Context, tasks Broker, queueName string, priority string, taskType string, data map[string]any) (string, error) {
+	task := TaskPayload{
+		TaskID:      NewTaskID(),
+		TaskType:    taskType,
+		Queue:       queueName,
+		Priority:    priority,
+		Data:        data,
+		Created:     time.Now(),
+		TraceParent: tracing.Inject(ctx),
+		RequestID:   logging.RequestIDFromContext(ctx),
+	}
+	if err := tasks.Push(ctx, &task); err != nil {
+		return "", err
 	}
-
-	return result, nil
+	return task.TaskID, nil
 }
@@ -0,0 +1,68 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pablobfonseca/go-image-vector/logging"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/queue/queuetest"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	tests := map[string]string{
+		"":                   "image_processing",
+		queue.PriorityNormal: "image_processing",
+		queue.PriorityHigh:   "image_processing:high",
+		queue.PriorityLow:    "image_processing:low",
+	}
+	for priority, want := range tests {
+		if got := queue.PriorityQueue(queue.ImageProcessingQueue, priority); got != want {
+			t.Errorf("PriorityQueue(%q) = %q, want %q", priority, got, want)
+		}
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	tasks := queuetest.NewMemory()
+	ctx := logging.ContextWithRequestID(context.Background(), "request-1")
+
+	taskID, err := queue.Enqueue(ctx, tasks, queue.ImageProcessingQueue, queue.PriorityHigh, "analyze_image",
+		map[string]any{"file_path": "a.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued := tasks.Queued(queue.ImageProcessingQueue, queue.PriorityHigh)
+	if len(queued) != 1 {
+		t.Fatalf("queued %d tasks, want 1", len(queued))
+	}
+	task := queued[0]
+	if task.TaskID != taskID || task.TaskType != "analyze_image" || task.Queue != queue.ImageProcessingQueue ||
+		task.Data["file_path"] != "a.png" || task.RequestID != "request-1" || task.Created.IsZero() {
+		t.Errorf("queued task = %+v", task)
+	}
+}
+
+func TestDequeueByPriority(t *testing.T) {
+	tasks := queuetest.NewMemory()
+	ctx := context.Background()
+
+	low, _ := queue.Enqueue(ctx, tasks, "other", queue.PriorityLow, "image", nil)
+	normal, _ := queue.Enqueue(ctx, tasks, queue.ImageProcessingQueue, queue.PriorityNormal, "image", nil)
+	high, _ := queue.Enqueue(ctx, tasks, "other", queue.PriorityHigh, "image", nil)
+
+	queues := []string{queue.ImageProcessingQueue, "other"}
+	for _, want := range []string{high, normal, low} {
+		task, err := tasks.Dequeue(queues, 0)
+		if err != nil || task == nil {
+			t.Fatalf("Dequeue() = %v, %v", task, err)
+		}
+		if task.TaskID != want {
+			t.Errorf("Dequeue() took task %s, want %s", task.TaskID, want)
+		}
+	}
+	if task, _ := tasks.Dequeue(queues, 0); task != nil {
+		t.Errorf("Dequeue() of empty queues = %v, want nil", task)
+	}
+}
@@ -0,0 +1,126 @@
+// Package queuetest provides an in-memory task broker for tests.
+package queuetest
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/queue"
+)
+
+// Memory is a task broker keeping tasks, statuses and results in memory. Dequeue does not
+// block, it returns nil at once when no task is queued.
+type Memory struct {
+	mu       sync.Mutex
+	lists    map[string][]*queue.TaskPayload
+	statuses map[string]string
+	results  map[string][]byte
+	// records are the tasks linked to each record
+	records map[uint][]string
+}
+
+// NewMemory returns an empty in-memory broker
+func NewMemory() *Memory {
+	return &Memory{
+		lists:    map[string][]*queue.TaskPayload{},
+		statuses: map[string]string{},
+		results:  map[string][]byte{},
+		records:  map[uint][]string{},
+	}
+}
+
+func (m *Memory) Push(ctx context.Context, task *queue.TaskPayload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := queue.PriorityQueue(task.Queue, task.Priority)
+	m.lists[key] = append(m.lists[key], task)
+	return nil
+}
+
+func (m *Memory) Requeue(task *queue.TaskPayload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := queue.PriorityQueue(task.Queue, task.Priority)
+	m.lists[key] = append([]*queue.TaskPayload{task}, m.lists[key]...)
+	return nil
+}
+
+func (m *Memory) Dequeue(queueNames []string, timeout time.Duration) (*queue.TaskPayload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, priority := range queue.Priorities {
+		for _, queueName := range queueNames {
+			key := queue.PriorityQueue(queueName, priority)
+			if tasks := m.lists[key]; len(tasks) > 0 {
+				m.lists[key] = tasks[1:]
+				return tasks[0], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) GetTaskStatus(taskID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if status, ok := m.statuses[taskID]; ok {
+		return status, nil
+	}
+	return "unknown", nil
+}
+
+func (m *Memory) SetTaskStatus(taskID string, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statuses[taskID] = status
+	return nil
+}
+
+func (m *Memory) StoreTaskResult(taskID string, result any) error {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[taskID] = resultJSON
+	return nil
+}
+
+func (m *Memory) GetTaskResult(taskID string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.results[taskID], nil
+}
+
+func (m *Memory) DeleteTask(taskID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.statuses, taskID)
+	delete(m.results, taskID)
+	return nil
+}
+
+func (m *Memory) LinkRecordTask(recordID uint, taskID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[recordID] = append(m.records[recordID], taskID)
+	return nil
+}
+
+// RecordTasks returns the tasks linked to a record, in the order they were linked
+func (m *Memory) RecordTasks(recordID uint) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.records[recordID]...)
+}
+
+// Queued returns the tasks waiting in a queue at a priority, in the order they are taken
+func (m *Memory) Queued(queueName string, priority string) []*queue.TaskPayload {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*queue.TaskPayload(nil), m.lists[queue.PriorityQueue(queueName, priority)]...)
+}
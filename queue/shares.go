@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrShareNotFound is returned for share tokens that never existed, expired or were revoked
+var ErrShareNotFound = errors.New("share not found")
+
+// Share grants read-only access to a record, or to a batch by its batch ID, until it expires
+type Share struct {
+	Token     string    `json:"token"`
+	RecordID  uint      `json:"record_id,omitempty"`
+	BatchID   string    `json:"batch_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// shareKey is the key of a share by its token
+func shareKey(token string) string {
+	return "share:" + token
+}
+
+// CreateShare stores share under a new random token, expiring after ttl
+func (c *Client) CreateShare(share *Share, ttl time.Duration) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	share.Token = base64.RawURLEncoding.EncodeToString(token)
+	share.CreatedAt = time.Now().UTC()
+	share.ExpiresAt = share.CreatedAt.Add(ttl)
+
+	shareJSON, err := json.Marshal(share)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, shareKey(share.Token), shareJSON, ttl).Err()
+}
+
+// GetShare returns the share of a token, or ErrShareNotFound
+func (c *Client) GetShare(token string) (*Share, error) {
+	if c == nil {
+		return nil, fmt.Errorf("redis client not initialized")
+	}
+
+	shareJSON, err := c.rdb.Get(ctx, shareKey(token)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var share Share
+	if err := json.Unmarshal(shareJSON, &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// DeleteShare revokes the share of a token, returning ErrShareNotFound when there is none
+func (c *Client) DeleteShare(token string) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	deleted, err := c.rdb.Del(ctx, shareKey(token)).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
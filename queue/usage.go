@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	storageUsageKey      = "storage:usage_bytes"
+	storageFileSizesKey  = "storage:file_sizes"
+	storageFileOwnersKey = "storage:file_owners"
+)
+
+// storageKeyUsageKey is the counter of the bytes stored by the uploads of one API key
+func storageKeyUsageKey(keyID string) string {
+	return storageUsageKey + ":" + keyID
+}
+
+// reserveStoredFile adds a file to the storage usage unless it is tracked already, failing
+// when the usage, or the usage of the API key storing it, would pass its quota. The checks,
+// the HSETNX of the file size and the INCRBY of the counters run as one script, so uploads at
+// once cannot all pass the same check. It returns 1 when it reserved the file, 0 when it was
+// tracked already and {-1, usage} or {-2, key usage} when a quota would be exceeded.
+var reserveStoredFile = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+	return 0
+end
+local size = tonumber(ARGV[2])
+local usage = tonumber(redis.call("GET", KEYS[2]) or "0")
+if tonumber(ARGV[3]) > 0 and usage + size > tonumber(ARGV[3]) then
+	return {-1, usage}
+end
+if ARGV[5] ~= "" then
+	local keyUsage = tonumber(redis.call("GET", KEYS[4]) or "0")
+	if tonumber(ARGV[4]) > 0 and keyUsage + size > tonumber(ARGV[4]) then
+		return {-2, keyUsage}
+	end
+end
+redis.call("HSETNX", KEYS[1], ARGV[1], size)
+redis.call("INCRBY", KEYS[2], size)
+if ARGV[5] ~= "" then
+	redis.call("HSETNX", KEYS[3], ARGV[1], ARGV[5])
+	redis.call("INCRBY", KEYS[4], size)
+end
+return 1`)
+
+// StorageQuota limits the storage usage in total and of each API key, in bytes. A limit of 0
+// is no limit.
+type StorageQuota struct {
+	Total  int64
+	PerKey int64
+}
+
+// QuotaExceededError is returned when storing a file would take the storage usage past its
+// quota, the quota of the API key KeyID when it is not empty
+type QuotaExceededError struct {
+	KeyID string
+	Usage int64
+	Quota int64
+	Size  int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d of %d bytes used, file needs %d more", e.Usage, e.Quota, e.Size)
+}
+
+// ReserveStoredFile adds a file about to be stored to the storage usage, and to the usage of
+// the API key keyID when it is not empty, within quota. Files are stored once by content, so
+// they count for the key that stored them first, and a file tracked already is not counted
+// again. It reports whether the file was reserved, in which case a failed save must be rolled
+// back with UntrackStoredFile, and returns a *QuotaExceededError when over quota.
+func (c *Client) ReserveStoredFile(key string, size int64, keyID string, quota StorageQuota) (bool, error) {
+	if c == nil {
+		return false, fmt.Errorf("redis client not initialized")
+	}
+
+	result, err := reserveStoredFile.Run(ctx, c.rdb,
+		[]string{storageFileSizesKey, storageUsageKey, storageFileOwnersKey, storageKeyUsageKey(keyID)},
+		key, size, quota.Total, quota.PerKey, keyID).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if exceeded, ok := result.([]any); ok && len(exceeded) == 2 {
+		usage, _ := exceeded[1].(int64)
+		if code, _ := exceeded[0].(int64); code == -2 {
+			return false, &QuotaExceededError{KeyID: keyID, Usage: usage, Quota: quota.PerKey, Size: size}
+		}
+		return false, &QuotaExceededError{Usage: usage, Quota: quota.Total, Size: size}
+	}
+	reserved, _ := result.(int64)
+	return reserved == 1, nil
+}
+
+// UntrackStoredFile forgets a deleted file and subtracts its size from the storage usage and
+// from the usage of the API key that stored it
+func (c *Client) UntrackStoredFile(key string) error {
+	if c == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+
+	size, err := c.rdb.HGet(ctx, storageFileSizesKey, key).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	}
+	keyID, err := c.StoredFileOwner(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, storageFileSizesKey, key)
+		pipe.DecrBy(ctx, storageUsageKey, size)
+		if keyID != "" {
+			pipe.HDel(ctx, storageFileOwnersKey, key)
+			pipe.DecrBy(ctx, storageKeyUsageKey(keyID), size)
+		}
+		return nil
+	})
+	return err
+}
+
+// GetStorageUsage returns the number of bytes held in file storage, in total when keyID is
+// empty or stored by the uploads of the API key keyID
+func (c *Client) GetStorageUsage(keyID string) (int64, error) {
+	if c == nil {
+		return 0, fmt.Errorf("redis client not initialized")
+	}
+
+	counter := storageUsageKey
+	if keyID != "" {
+		counter = storageKeyUsageKey(keyID)
+	}
+	usage, err := c.rdb.Get(ctx, counter).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return usage, nil
+}
+
+// StoredFileSize returns the tracked size of a stored file, 0 when it is not tracked
+func (c *Client) StoredFileSize(key string) (int64, error) {
+	if c == nil {
+		return 0, fmt.Errorf("redis client not initialized")
+	}
+
+	size, err := c.rdb.HGet(ctx, storageFileSizesKey, key).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// StoredFileOwner returns the API key that stored a file, empty when it was stored without
+// one or is not tracked
+func (c *Client) StoredFileOwner(key string) (string, error) {
+	if c == nil {
+		return "", fmt.Errorf("redis client not initialized")
+	}
+
+	keyID, err := c.rdb.HGet(ctx, storageFileOwnersKey, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return keyID, err
+}
@@ -0,0 +1,98 @@
+// Package redact replaces personal data and secrets in generated descriptions with markers,
+// such as an email address read off a screenshot with [redacted email]
+package redact
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Kinds of redacted data
+const (
+	KindEmail      = "email"
+	KindCardNumber = "card_number"
+	KindToken      = "token"
+	KindName       = "name"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	// cardPattern matches 13 to 19 digits, optionally grouped by spaces or dashes; matches
+	// are only card numbers when they pass the Luhn check
+	cardPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	// tokenPattern matches JWTs, bearer tokens, common API key prefixes and AWS access keys
+	tokenPattern = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+|` +
+		`(?i:bearer)\s+[A-Za-z0-9._~+/-]{16,}=*|` +
+		`\b(?:sk|pk|rk)_(?:live|test)_[A-Za-z0-9]{16,}|\b(?:sk-|ghp_|gho_|github_pat_|xox[abpr]-|glpat-)[A-Za-z0-9_-]{16,}|` +
+		`\bAKIA[0-9A-Z]{16}\b`)
+)
+
+// Text replaces the email addresses, card numbers, tokens and the given names of people in
+// text with markers, returning the redacted text and the kinds of data it had, in order
+func Text(text string, names []string) (string, []string) {
+	var kinds []string
+	replace := func(kind string, pattern *regexp.Regexp, match func(string) bool) {
+		text = pattern.ReplaceAllStringFunc(text, func(value string) string {
+			if match != nil && !match(value) {
+				return value
+			}
+			if !slices.Contains(kinds, kind) {
+				kinds = append(kinds, kind)
+			}
+			return marker(kind)
+		})
+	}
+
+	// Tokens first, as they may contain digits or @ signs the other patterns would cut
+	replace(KindToken, tokenPattern, nil)
+	replace(KindEmail, emailPattern, nil)
+	replace(KindCardNumber, cardPattern, luhn)
+	if pattern := namesPattern(names); pattern != nil {
+		replace(KindName, pattern, nil)
+	}
+
+	slices.Sort(kinds)
+	return text, kinds
+}
+
+// marker is the text data of a kind is replaced with
+func marker(kind string) string {
+	return "[redacted " + strings.ReplaceAll(kind, "_", " ") + "]"
+}
+
+// namesPattern matches any of the names as whole words, longest first so full names are
+// replaced before their parts
+func namesPattern(names []string) *regexp.Regexp {
+	var quoted []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); len(name) > 1 {
+			quoted = append(quoted, regexp.QuoteMeta(name))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	slices.SortFunc(quoted, func(a, b string) int { return len(b) - len(a) })
+	return regexp.MustCompile(`\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// luhn reports whether the digits of value pass the Luhn checksum of card numbers
+func luhn(value string) bool {
+	sum, double := 0, false
+	for i := len(value) - 1; i >= 0; i-- {
+		c := value[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
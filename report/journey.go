@@ -0,0 +1,112 @@
+// Package report renders batch journey analyses as standalone documents for sharing
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// Journey is a batch analysis with the screens it covers
+type Journey struct {
+	ID        uint
+	BatchID   string
+	Title     string
+	Narrative string
+	CreatedAt time.Time
+	Screens   []Screen
+}
+
+// Screen is one image of a journey. Thumbnail is a JPEG to embed in the document; when it
+// is empty the screen links to URL instead.
+type Screen struct {
+	Name      string
+	URL       string
+	Thumbnail []byte
+}
+
+// source is the image reference of a screen, a data URI when a thumbnail is embedded
+func (s Screen) source() string {
+	if len(s.Thumbnail) == 0 {
+		return s.URL
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(s.Thumbnail)
+}
+
+// Markdown renders the journey as a Markdown document
+func Markdown(j Journey) []byte {
+	var doc bytes.Buffer
+
+	fmt.Fprintf(&doc, "# %s\n\n", j.Title)
+	fmt.Fprintf(&doc, "- Batch: `%s` (record %d)\n", j.BatchID, j.ID)
+	fmt.Fprintf(&doc, "- Analyzed: %s\n", j.CreatedAt.UTC().Format(time.RFC1123))
+	fmt.Fprintf(&doc, "- Screens: %d\n\n", len(j.Screens))
+
+	doc.WriteString("## Journey\n\n")
+	doc.WriteString(strings.TrimSpace(j.Narrative) + "\n\n")
+
+	doc.WriteString("## Screens\n")
+	for i, screen := range j.Screens {
+		fmt.Fprintf(&doc, "\n### %d. %s\n\n", i+1, screen.Name)
+		if source := screen.source(); source != "" {
+			fmt.Fprintf(&doc, "![%s](%s)\n", markdownAlt(screen.Name), source)
+		}
+	}
+	return doc.Bytes()
+}
+
+// markdownAlt keeps brackets in file names from ending the alt text early
+func markdownAlt(text string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(text)
+}
+
+var htmlTemplate = template.Must(template.New("journey").Funcs(template.FuncMap{
+	"inc":    func(i int) int { return i + 1 },
+	"source": func(s Screen) template.URL { return template.URL(s.source()) },
+	"date":   func(t time.Time) string { return t.UTC().Format(time.RFC1123) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 960px; margin: 2rem auto; padding: 0 1rem; color: #222; line-height: 1.5; }
+.meta { color: #666; }
+.narrative { white-space: pre-wrap; }
+.screens { display: grid; grid-template-columns: repeat(auto-fill, minmax(240px, 1fr)); gap: 1rem; }
+figure { margin: 0; }
+figure img { max-width: 100%; border: 1px solid #ddd; border-radius: 4px; }
+figcaption { font-size: 0.9rem; color: #444; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Batch <code>{{.BatchID}}</code> (record {{.ID}}) · analyzed {{date .CreatedAt}} · {{len .Screens}} screens</p>
+<h2>Journey</h2>
+<div class="narrative">{{.Narrative}}</div>
+<h2>Screens</h2>
+<div class="screens">
+{{- range $i, $screen := .Screens}}
+<figure>
+{{- with source $screen}}<img src="{{.}}" alt="{{$screen.Name}}">{{end}}
+<figcaption>{{inc $i}}. {{$screen.Name}}</figcaption>
+</figure>
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+// HTML renders the journey as a self-contained HTML page
+func HTML(j Journey) ([]byte, error) {
+	j.Narrative = strings.TrimSpace(j.Narrative)
+
+	var doc bytes.Buffer
+	if err := htmlTemplate.Execute(&doc, j); err != nil {
+		return nil, err
+	}
+	return doc.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package report
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for image.Decode
+	"image/jpeg"
+	_ "image/png"
+)
+
+// Thumbnail decodes a JPEG, PNG or GIF image and scales it to fit within size pixels,
+// re-encoded as JPEG. Other formats return the decoding error.
+func Thumbnail(data []byte, size int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if scale := float64(size) / float64(max(width, height)); scale < 1 {
+		width = max(int(float64(width)*scale), 1)
+		height = max(int(float64(height)*scale), 1)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, downscale(src, width, height), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// downscale averages the source pixels covered by each destination pixel. Transparent
+// areas are composed over white, as JPEG has no alpha channel.
+func downscale(src image.Image, width, height int) image.Image {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+		for x := range width {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					// Colors are alpha-premultiplied, so adding the missing alpha as white composes over white
+					r += uint64(pr + 0xffff - pa)
+					g += uint64(pg + 0xffff - pa)
+					b += uint64(pb + 0xffff - pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: 0xffff})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,161 @@
+package reporting
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/logging"
+	"github.com/pablobfonseca/go-image-vector/tracing"
+	"github.com/spf13/viper"
+)
+
+// Event is an error report with the context it happened in
+type Event struct {
+	Err       error
+	Message   string
+	Level     string
+	Timestamp time.Time
+	Tags      map[string]string
+	Stack     []runtime.Frame
+	RequestID string
+	TraceID   string
+	SpanID    string
+}
+
+// Reporter sends error events to an error tracking service
+type Reporter interface {
+	Report(event *Event)
+	Flush(timeout time.Duration)
+}
+
+// reporter is the active reporter, nil when error reporting is disabled
+var reporter Reporter
+
+// Initialize enables error reporting when SENTRY_DSN is set. The returned function
+// flushes pending events.
+func Initialize(defaultServiceName string) func() {
+	dsn := viper.GetString("SENTRY_DSN")
+	if dsn == "" {
+		return func() {}
+	}
+
+	serverName, _ := os.Hostname()
+	sentry, err := NewSentryReporter(SentryConfig{
+		DSN:         dsn,
+		Environment: viper.GetString("SENTRY_ENVIRONMENT"),
+		Release:     viper.GetString("SENTRY_RELEASE"),
+		ServerName:  serverName,
+		Logger:      defaultServiceName,
+	})
+	if err != nil {
+		logging.Fatal("Failed to initialize error reporting", "error", err)
+	}
+
+	SetReporter(sentry)
+	slog.Info("Error reporting enabled", "host", sentry.host)
+
+	return func() {
+		sentry.Flush(5 * time.Second)
+	}
+}
+
+// SetReporter replaces the active reporter, nil disables reporting
+func SetReporter(r Reporter) {
+	reporter = r
+}
+
+// Capture reports err along with the request ID and trace found in ctx. tags are
+// key/value pairs attached to the event.
+func Capture(ctx context.Context, err error, tags ...any) {
+	if reporter == nil || err == nil {
+		return
+	}
+	reporter.Report(newEvent(ctx, err, "error", tags))
+}
+
+// CapturePanic reports a recovered panic value
+func CapturePanic(ctx context.Context, recovered any, tags ...any) {
+	if reporter == nil {
+		return
+	}
+
+	err, ok := recovered.(error)
+	if !ok {
+		err = fmt.Errorf("%v", recovered)
+	}
+	reporter.Report(newEvent(ctx, err, "fatal", tags))
+}
+
+func newEvent(ctx context.Context, err error, level string, tags []any) *Event {
+	event := &Event{
+		Err:       err,
+		Message:   err.Error(),
+		Level:     level,
+		Timestamp: time.Now(),
+		Tags:      map[string]string{},
+		Stack:     callers(3),
+		RequestID: logging.RequestIDFromContext(ctx),
+	}
+
+	for i := 0; i+1 < len(tags); i += 2 {
+		if key, ok := tags[i].(string); ok {
+			event.Tags[key] = fmt.Sprint(tags[i+1])
+		}
+	}
+
+	if span := tracing.FromContext(ctx); span != nil {
+		event.TraceID = hex.EncodeToString(span.TraceID[:])
+		event.SpanID = hex.EncodeToString(span.SpanID[:])
+	}
+
+	return event
+}
+
+// callers returns the stack of the caller, skipping the reporting frames
+func callers(skip int) []runtime.Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+1, pcs)
+
+	var stack []runtime.Frame
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, frame)
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// Middleware recovers from handler panics, reports them and responds with a 500
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			slog.ErrorContext(r.Context(), "Handler panic", "panic", recovered, "method", r.Method, "path", r.URL.Path)
+			CapturePanic(r.Context(), recovered, "http.method", r.Method, "http.path", r.URL.Path)
+
+			apierror.Write(w, r, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "Internal server error"))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
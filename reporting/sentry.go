@@ -0,0 +1,212 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SentryConfig holds the settings of the Sentry reporter
+type SentryConfig struct {
+	DSN         string
+	Environment string
+	Release     string
+	ServerName  string
+	Logger      string
+}
+
+// SentryReporter sends events to Sentry's envelope endpoint
+type SentryReporter struct {
+	config    SentryConfig
+	host      string
+	endpoint  string
+	publicKey string
+	client    *http.Client
+
+	events chan []byte
+	wg     sync.WaitGroup
+}
+
+// NewSentryReporter parses the DSN (https://<key>@<host>/<project>) and starts the sender
+func NewSentryReporter(config SentryConfig) (*SentryReporter, error) {
+	dsn, err := url.Parse(config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SENTRY_DSN: %v", err)
+	}
+
+	projectID := strings.Trim(dsn.Path, "/")
+	if dsn.User == nil || dsn.User.Username() == "" || dsn.Host == "" || projectID == "" {
+		return nil, fmt.Errorf("invalid SENTRY_DSN: expected <scheme>://<key>@<host>/<project>")
+	}
+
+	// Self-hosted instances may be served under a path prefix
+	prefix := ""
+	if i := strings.LastIndex(projectID, "/"); i >= 0 {
+		prefix = "/" + projectID[:i]
+		projectID = projectID[i+1:]
+	}
+
+	r := &SentryReporter{
+		config:    config,
+		host:      dsn.Host,
+		endpoint:  fmt.Sprintf("%s://%s%s/api/%s/envelope/", dsn.Scheme, dsn.Host, prefix, projectID),
+		publicKey: dsn.User.Username(),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		events:    make(chan []byte, 100),
+	}
+	go r.run()
+
+	return r, nil
+}
+
+// Report queues the event for sending, dropping it when the queue is full
+func (r *SentryReporter) Report(event *Event) {
+	envelope, err := r.envelope(event)
+	if err != nil {
+		slog.Error("Failed to encode error event", "error", err)
+		return
+	}
+
+	r.wg.Add(1)
+	select {
+	case r.events <- envelope:
+	default:
+		r.wg.Done()
+		slog.Warn("Error reporting queue full, dropping event")
+	}
+}
+
+// Flush waits up to timeout for queued events to be sent
+func (r *SentryReporter) Flush(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Timed out flushing error events")
+	}
+}
+
+func (r *SentryReporter) run() {
+	for envelope := range r.events {
+		r.send(envelope)
+		r.wg.Done()
+	}
+}
+
+func (r *SentryReporter) send(envelope []byte) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, r.endpoint, bytes.NewReader(envelope))
+	if err != nil {
+		slog.Error("Failed to send error event", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=go-image-vector/1.0, sentry_key=%s", r.publicKey))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		slog.Error("Failed to send error event", "error", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		slog.Error("Failed to send error event", "status", resp.StatusCode)
+	}
+}
+
+// envelope encodes the event as a Sentry envelope with a single event item
+func (r *SentryReporter) envelope(event *Event) ([]byte, error) {
+	eventID := make([]byte, 16)
+	rand.Read(eventID)
+
+	payload := map[string]any{
+		"event_id":    hex.EncodeToString(eventID),
+		"timestamp":   event.Timestamp.UTC().Format(time.RFC3339Nano),
+		"level":       event.Level,
+		"platform":    "go",
+		"logger":      r.config.Logger,
+		"server_name": r.config.ServerName,
+		"exception": map[string]any{
+			"values": []map[string]any{{
+				"type":       fmt.Sprintf("%T", event.Err),
+				"value":      event.Message,
+				"stacktrace": map[string]any{"frames": sentryFrames(event)},
+			}},
+		},
+	}
+	if r.config.Environment != "" {
+		payload["environment"] = r.config.Environment
+	}
+	if r.config.Release != "" {
+		payload["release"] = r.config.Release
+	}
+
+	tags := map[string]string{}
+	for key, value := range event.Tags {
+		tags[key] = value
+	}
+	if event.RequestID != "" {
+		tags["request_id"] = event.RequestID
+	}
+	payload["tags"] = tags
+
+	if event.TraceID != "" {
+		payload["contexts"] = map[string]any{
+			"trace": map[string]any{"trace_id": event.TraceID, "span_id": event.SpanID},
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(map[string]any{
+		"event_id": payload["event_id"],
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return nil, err
+	}
+	item, err := json.Marshal(map[string]any{"type": "event", "length": len(body)})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	buf.WriteByte('\n')
+	buf.Write(item)
+	buf.WriteByte('\n')
+	buf.Write(body)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// sentryFrames converts the stack to Sentry frames, oldest call first
+func sentryFrames(event *Event) []map[string]any {
+	frames := make([]map[string]any, 0, len(event.Stack))
+	for i := len(event.Stack) - 1; i >= 0; i-- {
+		frame := event.Stack[i]
+		frames = append(frames, map[string]any{
+			"function": frame.Function,
+			"abs_path": frame.File,
+			"lineno":   frame.Line,
+			"in_app":   strings.HasPrefix(frame.Function, "github.com/pablobfonseca/go-image-vector") || strings.HasPrefix(frame.Function, "main."),
+		})
+	}
+	return frames
+}
@@ -0,0 +1,46 @@
+// Package samples bundles demo images with pre-computed descriptions, loaded by the seed command
+package samples
+
+import (
+	"embed"
+	"encoding/json"
+)
+
+//go:embed manifest.json *.png
+var files embed.FS
+
+// ManifestFile is the name of the manifest describing the samples
+const ManifestFile = "manifest.json"
+
+// Sample is a bundled image with its description and, optionally, its embedding
+type Sample struct {
+	File        string    `json:"file"`
+	Description string    `json:"description"`
+	Embedding   []float32 `json:"embedding,omitempty"`
+}
+
+// Manifest lists the samples. EmbeddingModel names the model the embeddings were computed
+// with, they are only usable when it matches the configured EMBEDDING_MODEL.
+type Manifest struct {
+	EmbeddingModel string   `json:"embedding_model"`
+	Samples        []Sample `json:"samples"`
+}
+
+// Load reads the bundled manifest
+func Load() (*Manifest, error) {
+	data, err := files.ReadFile(ManifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// ReadFile returns the contents of a bundled image
+func ReadFile(name string) ([]byte, error) {
+	return files.ReadFile(name)
+}
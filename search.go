@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/database"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/spf13/viper"
+)
+
+// searchOptions configures the search command
+type searchOptions struct {
+	topK     int
+	kind     string
+	field    string
+	rank     string
+	halfLife time.Duration
+	minScore float64
+	exact    bool
+	like     []uint
+	image    string
+	near     string
+	radiusKm float64
+	label    string
+	issue    string
+	// mediaType, batchID, since and until filter records by their metadata
+	mediaType string
+	batchID   string
+	since     string
+	until     string
+	apiURL    string
+	json      bool
+	width     int
+}
+
+// runSearch searches through the API when apiURL is set, or the database directly,
+// and prints the results as a table or JSON
+func runSearch(ctx context.Context, query string, opts searchOptions) error {
+	if !validSearchKind(opts.kind) {
+		return fmt.Errorf("--kind must be one of all, batch or image")
+	}
+	if !validSearchField(opts.field) {
+		return fmt.Errorf("--field must be one of description, summary, chunks or frames")
+	}
+	if !validSearchRank(opts.rank) {
+		return fmt.Errorf("--rank must be one of similarity, recency or hybrid")
+	}
+	if opts.rank == rankHybrid && !validHybridField(opts.field) {
+		return fmt.Errorf("--rank hybrid matches whole descriptions, so --field must be description or summary")
+	}
+	if opts.rank == rankHybrid && query == "" && opts.image == "" {
+		return fmt.Errorf("--rank hybrid matches the query text, so it needs a query")
+	}
+	if opts.label != "" && !services.ValidLabel(opts.label) {
+		return fmt.Errorf("--label must be one of %s", strings.Join(services.Labels, ", "))
+	}
+	if err := validAccessibilityIssue(opts.issue); err != nil {
+		return fmt.Errorf("--accessibility-issue must be one of %s", strings.Join(services.AccessibilityIssues, ", "))
+	}
+	if opts.minScore < 0 || opts.minScore > 1 {
+		return fmt.Errorf("--min-score must be between 0 and 1")
+	}
+	if opts.rank == rankHybrid && opts.minScore > 0 {
+		return fmt.Errorf("--min-score scores the distance alone, so it cannot be used with --rank hybrid")
+	}
+	if opts.mediaType != "" && !validMediaTypeFilter(opts.mediaType) {
+		return fmt.Errorf("--media-type must be a media type such as image/png, or image/* for every image type")
+	}
+	since, err := parseTimeBound(opts.since)
+	if err != nil {
+		return fmt.Errorf("--since must be an RFC 3339 time such as 2024-01-31T00:00:00Z")
+	}
+	until, err := parseTimeBound(opts.until)
+	if err != nil {
+		return fmt.Errorf("--until must be an RFC 3339 time such as 2024-01-31T00:00:00Z")
+	}
+	if opts.image != "" && (query != "" || len(opts.like) > 0) {
+		return fmt.Errorf("--image is the query, it cannot be combined with a query or --like")
+	}
+	if query == "" && len(opts.like) == 0 && opts.image == "" {
+		return fmt.Errorf("a query, --like or --image is required")
+	}
+
+	var near *geoFilter
+	if opts.near != "" {
+		lat, lon, err := parseGeoPoint(opts.near)
+		if err != nil {
+			return err
+		}
+		if opts.radiusKm <= 0 {
+			return fmt.Errorf("--radius-km must be positive")
+		}
+		near = &geoFilter{Lat: lat, Lon: lon, RadiusKm: opts.radiusKm}
+		if err := near.validate(); err != nil {
+			return err
+		}
+	}
+
+	var results []models.ImageEmbedding
+
+	if opts.apiURL != "" {
+		results, err = searchAPI(ctx, query, near, opts)
+	} else {
+		s := newServer(database.Connect(), nil, nil)
+
+		field := cmp.Or(opts.field, viper.GetString("SEARCH_FIELD"))
+		var embedding []float32
+		var referenced []uint
+		hybridText := query
+		if opts.image != "" {
+			embedding, hybridText, err = s.embedImageFile(ctx, opts.image)
+		} else {
+			embedding, referenced, err = s.composeQuery(ctx, searchQueryParts(query, opts.like), field, false)
+		}
+		if err != nil {
+			return err
+		}
+		results, err = s.findSimilar(ctx, embedding, searchParams{TopK: opts.topK, Kind: opts.kind, Field: field, Rank: opts.rank,
+			HalfLife: opts.halfLife, Text: hybridText, Exact: opts.exact, ExcludeIDs: referenced, Near: near, Label: opts.label,
+			AccessibilityIssue: opts.issue, MediaType: opts.mediaType, BatchID: opts.batchID, Since: since, Until: until,
+			MinScore: opts.minScore})
+		if err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+
+	printResults(os.Stdout, results, opts.width)
+	return nil
+}
+
+// searchQueryParts combines the text query and the IDs of example records into query parts
+func searchQueryParts(query string, like []uint) []queryPart {
+	var parts []queryPart
+	if query != "" {
+		parts = append(parts, queryPart{Text: query})
+	}
+	for _, id := range like {
+		parts = append(parts, queryPart{ID: id})
+	}
+	return parts
+}
+
+// embedImageFile describes a local image and embeds its description, as the query of a
+// search by image
+func (s *server) embedImageFile(ctx context.Context, path string) ([]float32, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType, err := storage.DetectMediaType(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, "", fmt.Errorf("--image %s is not an image but %s", path, mediaType)
+	}
+
+	description, err := s.services.ExtractTextFromImageData(ctx, data, mediaType)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to describe image: %w", err)
+	}
+	embedding, err := s.services.GenerateEmbedding(ctx, description)
+	return embedding, description, err
+}
+
+// searchAPI runs the search through a running API server, by image with --image
+func searchAPI(ctx context.Context, query string, near *geoFilter, opts searchOptions) ([]models.ImageEmbedding, error) {
+	request := map[string]any{"top_k": opts.topK, "kind": opts.kind, "rank": opts.rank, "exact": opts.exact}
+	if opts.image == "" {
+		request["queries"] = searchQueryParts(query, opts.like)
+	}
+	if near != nil {
+		request["near"] = near
+	}
+	if opts.label != "" {
+		request["label"] = opts.label
+	}
+	if opts.issue != "" {
+		request["accessibility_issue"] = opts.issue
+	}
+	if opts.field != "" {
+		request["field"] = opts.field
+	}
+	if opts.mediaType != "" {
+		request["media_type"] = opts.mediaType
+	}
+	if opts.batchID != "" {
+		request["batch_id"] = opts.batchID
+	}
+	if opts.since != "" {
+		request["since"] = opts.since
+	}
+	if opts.until != "" {
+		request["until"] = opts.until
+	}
+	if opts.halfLife > 0 {
+		request["half_life"] = opts.halfLife.String()
+	}
+	if opts.minScore > 0 {
+		request["min_score"] = opts.minScore
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, contentType := "/api/v1/search", "application/json"
+	if opts.image != "" {
+		if body, contentType, err = imageSearchForm(opts.image, body); err != nil {
+			return nil, err
+		}
+		endpoint = "/api/v1/search/image"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(opts.apiURL, "/")+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		var apiErr apierror.Error
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("search failed: %s: %s", resp.Status, apiErr.Message)
+		}
+		return nil, fmt.Errorf("search failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var results []models.ImageEmbedding
+	if opts.image != "" {
+		var response struct {
+			Results []models.ImageEmbedding `json:"results"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		results = response.Results
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&results)
+	}
+	return results, err
+}
+
+// imageSearchForm is the multipart body of a search by the image at path, with the options
+// of the search, and its content type
+func imageSearchForm(path string, options []byte) ([]byte, string, error) {
+	image, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("search", string(options)); err != nil {
+		return nil, "", err
+	}
+	part, err := form.CreateFormFile("image", filepath.Base(path))
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := part.Write(image); err != nil {
+		return nil, "", err
+	}
+	if err := form.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), form.FormDataContentType(), nil
+}
+
+// printResults renders results as an aligned table with descriptions cut to width
+func printResults(out io.Writer, results []models.ImageEmbedding, width int) {
+	if len(results) == 0 {
+		fmt.Fprintln(out, "No results")
+		return
+	}
+
+	// Results ranked by recency also show the age-adjusted distance they are ordered by, and
+	// hybrid results their fused score
+	ranked := results[0].RankedDistance > 0
+	hybrid := results[0].HybridScore > 0
+
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if ranked {
+		fmt.Fprintln(table, "RANKED\tDISTANCE\tID\tCREATED\tFILE\tDESCRIPTION")
+	} else if hybrid {
+		fmt.Fprintln(table, "SCORE\tDISTANCE\tID\tFILE\tDESCRIPTION")
+	} else {
+		fmt.Fprintln(table, "DISTANCE\tID\tFILE\tDESCRIPTION")
+	}
+	for _, result := range results {
+		file := result.FilePath
+		if result.IsBatch {
+			file = fmt.Sprintf("%s (+%d)", file, max(len(result.BatchPaths)-1, 0))
+		}
+		if ranked {
+			fmt.Fprintf(table, "%.4f\t%.4f\t%d\t%s\t%s\t%s\n", result.RankedDistance, result.Distance, result.ID,
+				result.CreatedAt.Local().Format(time.DateOnly), file, describe(result, width))
+			continue
+		}
+		if hybrid {
+			fmt.Fprintf(table, "%.4f\t%.4f\t%d\t%s\t%s\n", result.HybridScore, result.Distance, result.ID, file, describe(result, width))
+			continue
+		}
+		fmt.Fprintf(table, "%.4f\t%d\t%s\t%s\n", result.Distance, result.ID, file, describe(result, width))
+	}
+	table.Flush()
+}
+
+// describe is the title of a result, or the start of its description for records without one.
+// Videos matched by a frame are described by the frame, at its timestamp.
+func describe(result models.ImageEmbedding, width int) string {
+	if result.MatchedFrame != nil {
+		return truncate("at "+result.MatchedFrame.Timestamp+": "+result.MatchedFrame.Text, width)
+	}
+	if result.Title != "" {
+		return truncate(result.Title, width)
+	}
+	return truncate(result.Text, width)
+}
+
+// truncate collapses whitespace and shortens text to at most width characters
+func truncate(text string, width int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if width <= 0 || len(runes) <= width {
+		return text
+	}
+	if width <= 3 {
+		return string(runes[:width])
+	}
+	return string(runes[:width-3]) + "..."
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/spf13/viper"
+)
+
+// searchByImage finds the records most similar to an example image, uploaded as the image
+// field of a multipart form. The image is described and embedded like an upload but never
+// stored. The optional search field holds the options of a search as JSON, without a query.
+func (s *server) searchByImage(w http.ResponseWriter, r *http.Request) {
+	if err := parseImageForm(w, r); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	if err := allowQueryParams(r.MultipartForm.Value, "search"); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	req := searchRequest{byImage: true}
+	if options := r.FormValue("search"); options != "" {
+		if err := decodeStrictJSON(strings.NewReader(options), &req); err != nil {
+			apierror.Write(w, r, err)
+			return
+		}
+	}
+	if err := req.validate(false); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	image, mediaType, err := readSearchImage(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	description, err := s.services.ExtractTextFromImageData(r.Context(), image, mediaType)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Failed to describe image"))
+		return
+	}
+	embedding, err := s.services.GenerateEmbedding(r.Context(), description)
+	if err != nil {
+		apierror.Write(w, r, apierror.BadRequest("Failed to generate embedding"))
+		return
+	}
+
+	// Hybrid ranking matches the description of the image against the full-text index
+	params := req.params(r)
+	params.Text = description
+	results, err := s.findSimilar(r.Context(), embedding, params)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to search database", err))
+		return
+	}
+	linkVideos(r, results)
+
+	response := make([]any, len(results))
+	for i, result := range results {
+		response[i] = req.selection.apply(result)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"description": description,
+		"results":     response,
+	})
+}
+
+// parseImageForm parses the multipart form of a request sending an image of up to
+// MAX_FILE_BYTES, whose files the caller removes
+func parseImageForm(w http.ResponseWriter, r *http.Request) error {
+	maxFileBytes := viper.GetInt64("MAX_FILE_BYTES")
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileBytes+searchMaxBodyBytes)
+	if err := r.ParseMultipartForm(maxFileBytes); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return apierror.New(http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge,
+				fmt.Sprintf("Image exceeds the maximum file size of %d bytes", maxFileBytes)).
+				With("limit", "max_file_bytes").With("value", maxFileBytes)
+		}
+		return apierror.BadRequest("Invalid multipart form: " + err.Error())
+	}
+	return nil
+}
+
+// readSearchImage reads the image field of a search by image, which must be an image of
+// ALLOWED_MEDIA_TYPES. HEIC and AVIF images are converted to JPEG like uploads.
+func readSearchImage(r *http.Request) ([]byte, string, error) {
+	file, header, err := r.FormFile("image")
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, "", apierror.InvalidParameter("image", "image is required")
+	}
+	if err != nil {
+		return nil, "", apierror.BadRequest("Failed to read image: " + err.Error())
+	}
+	defer file.Close()
+	if header.Size == 0 {
+		return nil, "", apierror.InvalidParameter("image", "image is empty")
+	}
+
+	mediaType, err := storage.DetectMediaType(file)
+	if err != nil {
+		return nil, "", apierror.BadRequest("Failed to read image: " + err.Error())
+	}
+	if !strings.HasPrefix(mediaType, "image/") || !storage.IsAllowedMediaType(mediaType, viper.GetStringSlice("ALLOWED_MEDIA_TYPES")) {
+		return nil, "", apierror.Newf(http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType,
+			"Image has unsupported type %s", mediaType).With("media_type", mediaType)
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", apierror.BadRequest("Failed to read image: " + err.Error())
+	}
+	if services.NeedsConversion(mediaType) {
+		converted, err := services.ConvertToJPEG(r.Context(), bytes.NewReader(data), storage.MediaTypeExtension(mediaType))
+		if err != nil {
+			return nil, "", apierror.Internal("Failed to convert image", err)
+		}
+		return converted, "image/jpeg", nil
+	}
+	return data, mediaType, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pgvector/pgvector-go"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// ndjsonContentType is the media type of streamed search results, one JSON record per line
+const ndjsonContentType = "application/x-ndjson"
+
+// acceptsNDJSON reports whether a request asks for streamed search results
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted)); err == nil && mediaType == ndjsonContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// streamSearch writes search results as NDJSON with the selected fields, each record flushed
+// as soon as it is read. Errors before the first record are answered as usual; later ones
+// end the stream early.
+func (s *server) streamSearch(w http.ResponseWriter, r *http.Request, embedding []float32, params searchParams, selection fieldSelection) {
+	flusher := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+	started := false
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", ndjsonContentType)
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+
+	err := s.streamSimilar(r.Context(), embedding, params, func(record models.ImageEmbedding) error {
+		start()
+		linked := []models.ImageEmbedding{record}
+		linkVideos(r, linked)
+		if err := encoder.Encode(selection.apply(linked[0])); err != nil {
+			return err
+		}
+		if err := flusher.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	})
+	switch {
+	case err != nil && !started:
+		apierror.Write(w, r, apierror.Internal("Failed to search database", err))
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Search stream ended early", "error", err)
+	default:
+		start()
+	}
+}
+
+// streamSimilar calls emit with the nearest records in order. Searches ranked by distance
+// alone emit each record as it is read from the database; recency and hybrid ranking, chunk,
+// frame and language searches need every candidate first, so their records are emitted once
+// ranked.
+func (s *server) streamSimilar(ctx context.Context, embedding []float32, params searchParams, emit func(models.ImageEmbedding) error) error {
+	params.Field = cmp.Or(params.Field, viper.GetString("SEARCH_FIELD"))
+	if params.Rank == rankRecency || params.Rank == rankHybrid || params.Field == searchFieldChunks || params.Field == searchFieldFrames || params.Language != "" {
+		results, err := s.findSimilar(ctx, embedding, params)
+		if err != nil {
+			return err
+		}
+		for _, record := range results {
+			if err := emit(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return s.withSearchDB(ctx, params.Exact, func(db *gorm.DB) error {
+		rows, err := searchQuery(db, params).
+			Select("*, "+searchColumn(params.Field)+" <-> ? AS distance", pgvector.NewVector(embedding)).
+			Order("distance").Limit(params.TopK).Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			record := make([]models.ImageEmbedding, 1)
+			if err := db.ScanRows(rows, &record[0]); err != nil {
+				return err
+			}
+			// Records are read nearest first, so the first one scoring too low ends the search
+			if record[0].Score = searchScore(record[0].Distance); record[0].Score < params.MinScore {
+				return nil
+			}
+			s.backfillBatchPaths(record)
+			if err := emit(record[0]); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
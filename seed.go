@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pablobfonseca/go-image-vector/events"
+	"github.com/pablobfonseca/go-image-vector/hooks"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/samples"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pgvector/pgvector-go"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// seed stores the bundled sample images and records their pre-computed descriptions, so
+// they are searchable without running the vision model. Embeddings bundled for the
+// configured EMBEDDING_MODEL are used as is, otherwise the descriptions are embedded.
+func (s *server) seed(ctx context.Context) error {
+	manifest, err := samples.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load samples: %v", err)
+	}
+
+	embeddingModel := viper.GetString("EMBEDDING_MODEL")
+	precomputed := manifest.EmbeddingModel != "" && manifest.EmbeddingModel == embeddingModel
+
+	var seeded, skipped int
+	for _, sample := range manifest.Samples {
+		created, err := s.seedSample(ctx, sample, precomputed)
+		if err != nil {
+			return fmt.Errorf("failed to seed %s: %v", sample.File, err)
+		}
+		if created {
+			seeded++
+			fmt.Printf("seeded   %s\n", sample.File)
+		} else {
+			skipped++
+			fmt.Printf("skipped  %s (already seeded)\n", sample.File)
+		}
+	}
+
+	fmt.Printf("\nSeeded %d sample images, %d already present. Try: go-image-vector search \"login error\"\n", seeded, skipped)
+	return nil
+}
+
+// seedSample stores one sample and creates its record unless the file was already analyzed
+func (s *server) seedSample(ctx context.Context, sample samples.Sample, precomputed bool) (bool, error) {
+	data, err := samples.ReadFile(sample.File)
+	if err != nil {
+		return false, err
+	}
+
+	sum := sha256.Sum256(data)
+	stored, err := s.storeUpload(ctx, bytes.NewReader(data), sample.File, hex.EncodeToString(sum[:]), int64(len(data)), "")
+	if err != nil {
+		return false, err
+	}
+	if stored.QuarantineTaskID != "" {
+		return false, fmt.Errorf("flagged by the upload scanner")
+	}
+
+	var existing models.ImageEmbedding
+	err = s.db.WithContext(ctx).Where("file_path = ? AND is_batch = ?", stored.FilePath, false).First(&existing).Error
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+
+	embedding := sample.Embedding
+	if !precomputed || len(embedding) == 0 {
+		if embedding, err = s.services.GenerateEmbedding(ctx, sample.Description); err != nil {
+			return false, fmt.Errorf("failed to generate embedding: %v", err)
+		}
+	}
+
+	record := models.ImageEmbedding{
+		FilePath:     stored.FilePath,
+		OriginalName: sample.File,
+		MediaType:    stored.MediaType,
+		OriginalPath: stored.OriginalPath,
+		Text:         sample.Description,
+		Embedding:    pgvector.NewVector(embedding),
+	}
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.MediaIngested, events.Media(&record))
+	}); err != nil {
+		return false, err
+	}
+	hooks.AfterPersist(ctx, &record)
+	return true, nil
+}
+
+// exportSampleEmbeddings embeds every sample description with the configured EMBEDDING_MODEL
+// and writes the manifest with the embeddings to path, to refresh the bundled manifest
+func exportSampleEmbeddings(ctx context.Context, path string) error {
+	manifest, err := samples.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load samples: %v", err)
+	}
+
+	clients := services.NewClients(nil)
+	manifest.EmbeddingModel = viper.GetString("EMBEDDING_MODEL")
+	for i, sample := range manifest.Samples {
+		embedding, err := clients.GenerateEmbedding(ctx, sample.Description)
+		if err != nil {
+			return fmt.Errorf("failed to embed %s: %v", sample.File, err)
+		}
+		manifest.Samples[i].Embedding = embedding
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %d embeddings from %s to %s\n", len(manifest.Samples), manifest.EmbeddingModel, path)
+	return nil
+}
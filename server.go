@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/worker"
+	"gorm.io/gorm"
+)
+
+// server holds the connections the API handlers and commands use. Each server has its own,
+// so several can run in one process, as the tests do.
+type server struct {
+	db    *gorm.DB
+	queue *queue.Client
+	// tasks is the broker tasks are queued on and report to, queue unless replaced
+	tasks queue.Broker
+	// files is the storage backend uploads and derived files are kept in
+	files storage.Storage
+	// services are the model clients, reading images from files
+	services services.Clients
+}
+
+// newServer returns a server using a database, a Redis client and a storage backend
+func newServer(db *gorm.DB, q *queue.Client, files storage.Storage) *server {
+	return &server{db: db, queue: q, tasks: q, files: files, services: services.NewClients(files)}
+}
+
+// workerDeps are the connections of the tasks the server processes or runs workers for
+func (s *server) workerDeps() worker.Deps {
+	return worker.Deps{DB: s.db, Queue: s.queue, Tasks: s.tasks, Files: s.files, Services: s.services}
+}
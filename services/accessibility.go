@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// Audits, analysis presets run on single images besides their description
+const (
+	// AuditAccessibility flags the accessibility issues of a UI screenshot
+	AuditAccessibility = "accessibility"
+)
+
+// Audits lists every audit
+var Audits = []string{AuditAccessibility}
+
+// Accessibility issue types
+const (
+	IssueContrast     = "contrast"
+	IssueMissingLabel = "missing_label"
+	IssueTapTarget    = "tap_target"
+	IssueTextSize     = "text_size"
+	IssueOther        = "other"
+)
+
+// AccessibilityIssues lists every accessibility issue type
+var AccessibilityIssues = []string{IssueContrast, IssueMissingLabel, IssueTapTarget, IssueTextSize, IssueOther}
+
+// Finding severities
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// AccessibilityFinding is an accessibility issue of an element on a screen
+type AccessibilityFinding struct {
+	Issue    string `json:"issue"`
+	Severity string `json:"severity"`
+	// Element is the element with the issue, such as "Sign in button"
+	Element     string `json:"element"`
+	Description string `json:"description"`
+}
+
+// AuditImageAccessibility asks the vision model for the accessibility issues of a screenshot:
+// low contrast text, controls without a visible label, tap targets too small to hit and text
+// too small to read
+func (c Clients) AuditImageAccessibility(ctx context.Context, imagePath string) ([]AccessibilityFinding, error) {
+	imageBytes, err := c.readImage(ctx, imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := "Audit this user interface screenshot for accessibility issues. Answer with a JSON array only, " +
+		"one object per issue with the fields issue, severity, element and description. issue is one of " +
+		"contrast (text or controls with too little contrast against their background), missing_label " +
+		"(icons, inputs or buttons without a visible or descriptive label), tap_target (controls too small " +
+		"or too close together to tap), text_size (text too small to read) or other. severity is low, " +
+		"medium or high. Answer with [] when there are no issues."
+
+	answer, err := c.Generator.Generate(ctx, GenerateOptions{Prompt: prompt, Images: [][]byte{imageBytes}})
+	if err != nil {
+		return nil, err
+	}
+	return parseFindings(answer)
+}
+
+// parseFindings reads the findings of a model answer, which may wrap the JSON array in a
+// code block or a sentence. Unknown issue types are other and unknown severities medium.
+func parseFindings(answer string) ([]AccessibilityFinding, error) {
+	start, end := strings.Index(answer, "["), strings.LastIndex(answer, "]")
+	if start < 0 || end < start {
+		return nil, modelError("model answered without a JSON array of findings")
+	}
+
+	var findings []AccessibilityFinding
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &findings); err != nil {
+		return nil, modelError("model answered with invalid findings: %v", err)
+	}
+
+	valid := findings[:0]
+	for _, finding := range findings {
+		finding.Issue = strings.ToLower(strings.TrimSpace(finding.Issue))
+		finding.Severity = strings.ToLower(strings.TrimSpace(finding.Severity))
+		finding.Element = strings.TrimSpace(finding.Element)
+		finding.Description = strings.TrimSpace(finding.Description)
+		if finding.Element == "" && finding.Description == "" {
+			continue
+		}
+		if !slices.Contains(AccessibilityIssues, finding.Issue) {
+			finding.Issue = IssueOther
+		}
+		if !slices.Contains([]string{SeverityLow, SeverityMedium, SeverityHigh}, finding.Severity) {
+			finding.Severity = SeverityMedium
+		}
+		valid = append(valid, finding)
+	}
+	return valid, nil
+}
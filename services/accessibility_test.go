@@ -0,0 +1,32 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFindings(t *testing.T) {
+	answer := "Here are the issues:\n```json\n" +
+		`[{"issue": "Contrast", "severity": "HIGH", "element": "Sign in button", "description": "Grey on white"},` +
+		`{"issue": "focus_order", "severity": "urgent", "element": "Menu", "description": "Hidden"},` +
+		`{"issue": "tap_target", "severity": "low"}]` + "\n```"
+
+	findings, err := parseFindings(answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AccessibilityFinding{
+		{Issue: IssueContrast, Severity: SeverityHigh, Element: "Sign in button", Description: "Grey on white"},
+		{Issue: IssueOther, Severity: SeverityMedium, Element: "Menu", Description: "Hidden"},
+	}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("parseFindings = %+v, want %+v", findings, want)
+	}
+
+	if findings, err := parseFindings("No issues found: []"); err != nil || len(findings) != 0 {
+		t.Errorf("parseFindings of no issues = %v, %v", findings, err)
+	}
+	if _, err := parseFindings("The screen looks fine"); err == nil {
+		t.Error("parseFindings accepted an answer without findings")
+	}
+}
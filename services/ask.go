@@ -0,0 +1,26 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// AnswerQuestion asks the text model to answer a question using only the given image
+// descriptions, citing them by their [n] number
+func (c Clients) AnswerQuestion(ctx context.Context, question string, descriptions []string) (string, error) {
+	if len(descriptions) == 0 {
+		return "", fmt.Errorf("no images to answer from")
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("Answer the question using only the descriptions of screenshots and images below. ")
+	prompt.WriteString("Cite the descriptions you used by their number, like [1]. ")
+	prompt.WriteString("If they do not contain the answer, say so.\n\n")
+	for i, description := range descriptions {
+		fmt.Fprintf(&prompt, "[%d]\n%s\n\n", i+1, description)
+	}
+	fmt.Fprintf(&prompt, "Question: %s\n", question)
+
+	return c.Generator.Generate(ctx, GenerateOptions{Prompt: prompt.String()})
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChunkContext asks the text model for one sentence situating a chunk within the whole
+// description it was split from, such as which step of a journey it covers. The sentence is
+// embedded with the chunk, so chunks that only make sense with the rest of the description
+// are still found (contextual retrieval).
+func (c Clients) ChunkContext(ctx context.Context, description string, chunk string) (string, error) {
+	prompt := "<document>\n" + description + "\n</document>\n\n" +
+		"Here is a part of the document above:\n<chunk>\n" + chunk + "\n</chunk>\n\n" +
+		"Write one short sentence situating this part within the whole document, to improve search " +
+		"retrieval of the part. Answer with the sentence only."
+
+	answer, err := c.Generator.Generate(ctx, GenerateOptions{Prompt: prompt})
+	if err != nil {
+		return "", err
+	}
+	sentence := strings.Join(strings.Fields(answer), " ")
+	if sentence == "" {
+		return "", modelError("model returned an empty chunk context")
+	}
+	return sentence, nil
+}
+
+// SplitText splits a description into chunks of at most size characters, keeping its
+// paragraphs together where they fit and splitting longer ones between words. Each chunk
+// after the first starts with up to overlap characters of the end of the previous one, so
+// a sentence cut at a boundary is still whole in one of them.
+func SplitText(text string, size int, overlap int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if utf8.RuneCountInString(text) <= size {
+		return []string{text}
+	}
+
+	// Long paragraphs are split short enough to leave room for the overlap
+	pieceSize := max(size-overlap-2, size/2)
+	var pieces []string
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		switch paragraph = strings.TrimSpace(paragraph); {
+		case paragraph == "":
+		case utf8.RuneCountInString(paragraph) <= size:
+			pieces = append(pieces, paragraph)
+		default:
+			pieces = append(pieces, splitWords(paragraph, pieceSize)...)
+		}
+	}
+
+	var chunks []string
+	current := ""
+	for _, piece := range pieces {
+		if current != "" && utf8.RuneCountInString(current)+2+utf8.RuneCountInString(piece) > size {
+			chunks = append(chunks, current)
+			current = ""
+			if tail := textTail(chunks[len(chunks)-1], overlap); tail != "" &&
+				utf8.RuneCountInString(tail)+2+utf8.RuneCountInString(piece) <= size {
+				current = tail
+			}
+		}
+		if current != "" {
+			current += "\n\n"
+		}
+		current += piece
+	}
+	return append(chunks, current)
+}
+
+// splitWords splits a paragraph into lines of whole words of at most size characters,
+// leaving a single word longer than size on a line of its own
+func splitWords(paragraph string, size int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(paragraph) {
+		if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > size {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, line)
+}
+
+// textTail is the end of text, at most n characters starting at a word
+func textTail(text string, n int) string {
+	runes := []rune(text)
+	if n <= 0 {
+		return ""
+	}
+	if len(runes) <= n {
+		return text
+	}
+	tail := string(runes[len(runes)-n:])
+	if _, rest, ok := strings.Cut(tail, " "); ok {
+		return strings.TrimSpace(rest)
+	}
+	return ""
+}
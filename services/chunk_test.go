@@ -0,0 +1,59 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitText(t *testing.T) {
+	if chunks := SplitText("  ", 100, 10); chunks != nil {
+		t.Errorf("empty text: got %q, want no chunks", chunks)
+	}
+	if chunks := SplitText("short text", 100, 10); len(chunks) != 1 || chunks[0] != "short text" {
+		t.Errorf("short text: got %q, want it whole", chunks)
+	}
+
+	paragraphs := []string{
+		strings.Repeat("alpha ", 10),
+		strings.Repeat("beta ", 10),
+		strings.Repeat("gamma ", 40),
+	}
+	text := strings.Join(paragraphs, "\n\n")
+	chunks := SplitText(text, 120, 20)
+	if len(chunks) < 3 {
+		t.Fatalf("got %d chunks, want the long text split", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if n := utf8.RuneCountInString(chunk); n > 120 {
+			t.Errorf("chunk %d has %d characters, want at most 120", i, n)
+		}
+		if strings.HasPrefix(chunk, " ") || strings.HasSuffix(chunk, " ") {
+			t.Errorf("chunk %d %q is not trimmed", i, chunk)
+		}
+	}
+	if !strings.Contains(chunks[0], "alpha") || !strings.Contains(chunks[0], "beta") {
+		t.Errorf("first chunk %q does not keep the short paragraphs together", chunks[0])
+	}
+
+	// Chunks of a split paragraph start with the end of the previous chunk
+	last, previous := chunks[len(chunks)-1], chunks[len(chunks)-2]
+	overlap, _, _ := strings.Cut(last, "\n\n")
+	if !strings.HasSuffix(previous, overlap) {
+		t.Errorf("chunk %q does not start with the end of %q", last, previous)
+	}
+}
+
+func TestSplitTextLongWord(t *testing.T) {
+	word := strings.Repeat("x", 50)
+	chunks := SplitText("a "+word+" b", 20, 0)
+	found := false
+	for _, chunk := range chunks {
+		if strings.Contains(chunk, word) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("chunks %q lost a word longer than the chunk size", chunks)
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// UI state labels of screenshots, for bug triage
+const (
+	LabelError   = "error"
+	LabelEmpty   = "empty"
+	LabelSuccess = "success"
+	LabelLoading = "loading"
+	LabelOther   = "other"
+)
+
+// Labels lists every UI state label
+var Labels = []string{LabelError, LabelEmpty, LabelSuccess, LabelLoading, LabelOther}
+
+// ValidLabel reports whether label is a known UI state label
+func ValidLabel(label string) bool {
+	return slices.Contains(Labels, label)
+}
+
+// ClassifyUIState asks the text model which state the screen in a description shows: an
+// error, an empty state, a success confirmation, a loading state, or other for anything else
+func (c Clients) ClassifyUIState(ctx context.Context, description string) (string, error) {
+	prompt := "Classify the state of the screen in this screenshot description. Answer with one word: " +
+		"error (an error message, crash or failed action), empty (an empty list, no results or blank content), " +
+		"success (a confirmation or completed action), loading (a spinner, skeleton or progress indicator) " +
+		"or other.\n\nDescription:\n" + description
+
+	answer, err := c.Generator.Generate(ctx, GenerateOptions{Prompt: prompt})
+	if err != nil {
+		return "", err
+	}
+	return parseLabel(answer), nil
+}
+
+// parseLabel finds the label in a model answer, which may add punctuation or a sentence
+// around it, falling back to other
+func parseLabel(answer string) string {
+	words := strings.FieldsFunc(strings.ToLower(answer), func(r rune) bool {
+		return (r < 'a' || r > 'z') && r != '_'
+	})
+	for _, word := range words {
+		if ValidLabel(word) {
+			return word
+		}
+	}
+	return LabelOther
+}
@@ -0,0 +1,21 @@
+package services
+
+import "testing"
+
+func TestParseLabel(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   string
+	}{
+		{"error", LabelError},
+		{"Empty.", LabelEmpty},
+		{"The screen shows a success confirmation", LabelSuccess},
+		{"**loading**", LabelLoading},
+		{"I am not sure", LabelOther},
+	}
+	for _, tt := range tests {
+		if got := parseLabel(tt.answer); got != tt.want {
+			t.Errorf("parseLabel(%q) = %q, want %q", tt.answer, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// NeedsConversion reports whether a media type should get a JPEG rendition on ingest,
+// as HEIC/AVIF are not understood by most vision models and browsers
+func NeedsConversion(mediaType string) bool {
+	if !viper.GetBool("CONVERT_HEIC_AVIF") {
+		return false
+	}
+	return mediaType == "image/heic" || mediaType == "image/avif"
+}
+
+// ConvertToJPEG converts an image to JPEG using the external command configured in
+// IMAGE_CONVERTER (ImageMagick's "magick" by default), which is invoked as "<cmd> <in> <out>"
+func ConvertToJPEG(ctx context.Context, r io.Reader, ext string) ([]byte, error) {
+	converter := viper.GetString("IMAGE_CONVERTER")
+	if converter == "" {
+		converter = "magick"
+	}
+
+	tmpDir, err := os.MkdirTemp("", "convert-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	inPath := filepath.Join(tmpDir, "input"+ext)
+	outPath := filepath.Join(tmpDir, "output.jpg")
+
+	in, err := os.Create(inPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(in, r); err != nil {
+		in.Close()
+		return nil, err
+	}
+	in.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	args := append(strings.Fields(converter)[1:], inPath, outPath)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, strings.Fields(converter)[0], args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to convert image with %s: %v: %s", converter, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.ReadFile(outPath)
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"strings"
+)
+
+// driftSize is the side of the grayscale thumbnails compared for visual drift, small enough
+// to ignore antialiasing and compression noise
+const driftSize = 64
+
+// VisualDrift compares two images pixel by pixel and returns how much they differ, from 0
+// for identical images to 1 for a black and a white one. Both are reduced to grayscale
+// thumbnails first, so images of different sizes compare by their layout. PNG, JPEG and GIF
+// images can be compared.
+func VisualDrift(a, b []byte) (float64, error) {
+	thumbA, err := grayThumbnail(a)
+	if err != nil {
+		return 0, err
+	}
+	thumbB, err := grayThumbnail(b)
+	if err != nil {
+		return 0, err
+	}
+
+	var total float64
+	for i := range thumbA {
+		total += math.Abs(thumbA[i] - thumbB[i])
+	}
+	return total / float64(len(thumbA)), nil
+}
+
+// grayThumbnail decodes an image and averages its luminance over a driftSize square grid,
+// each cell between 0 and 1
+func grayThumbnail(data []byte) ([]float64, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, errors.New("image is empty")
+	}
+
+	thumb := make([]float64, driftSize*driftSize)
+	for row := range driftSize {
+		y0, y1 := driftSpan(bounds.Min.Y, bounds.Dy(), row)
+		for col := range driftSize {
+			x0, x1 := driftSpan(bounds.Min.X, bounds.Dx(), col)
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := img.At(x, y).RGBA()
+					// ITU-R BT.601 luma, from 16-bit channels
+					sum += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0xffff
+				}
+			}
+			thumb[row*driftSize+col] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return thumb, nil
+}
+
+// driftSpan is the range of pixels of cell i of a dimension of size pixels from start, at
+// least one pixel for images smaller than the grid
+func driftSpan(start, size, i int) (int, int) {
+	from := start + i*size/driftSize
+	to := start + (i+1)*size/driftSize
+	return from, max(to, from+1)
+}
+
+// DescribeDrift asks the text model what changed between the description of a baseline
+// screenshot and the description of a new screenshot of the same screen
+func (c Clients) DescribeDrift(ctx context.Context, baseline string, current string) (string, error) {
+	prompt := "The two descriptions below are of a baseline screenshot and of a new screenshot of the same screen. " +
+		"List what changed in the new screenshot, such as elements added, removed, moved or restyled and text that differs, " +
+		"one change per line starting with \"- \". Leave out differences in wording that do not change what the screen shows. " +
+		"Answer with the list only.\n\nBaseline:\n" + baseline + "\n\nNew screenshot:\n" + current
+
+	answer, err := c.Generator.Generate(ctx, GenerateOptions{Prompt: prompt})
+	if err != nil {
+		return "", err
+	}
+	changes := strings.TrimSpace(answer)
+	if changes == "" {
+		return "", modelError("model returned no changes")
+	}
+	return changes, nil
+}
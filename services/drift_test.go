@@ -0,0 +1,60 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+// solidPNG encodes a width by height PNG of one color, with its left half in left when set
+func solidPNG(t *testing.T, width, height int, fill color.Color, left color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			if left != nil && x < width/2 {
+				img.Set(x, y, left)
+			} else {
+				img.Set(x, y, fill)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVisualDrift(t *testing.T) {
+	white := solidPNG(t, 200, 100, color.White, nil)
+	tests := []struct {
+		name string
+		a, b []byte
+		want float64
+	}{
+		{"identical", white, white, 0},
+		{"black and white", white, solidPNG(t, 200, 100, color.Black, nil), 1},
+		{"half changed", white, solidPNG(t, 200, 100, color.White, color.Black), 0.5},
+		{"different sizes", white, solidPNG(t, 1000, 500, color.White, nil), 0},
+		{"smaller than the grid", solidPNG(t, 10, 5, color.White, color.Black), solidPNG(t, 20, 10, color.White, color.Black), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VisualDrift(tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("VisualDrift() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := VisualDrift(white, []byte("not an image")); err == nil {
+		t.Error("comparing with a file that is not an image did not fail")
+	}
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// exifScanLimit bounds how much of a file is read looking for EXIF metadata
+const exifScanLimit = 4 << 20
+
+// EXIF tags of the GPS and Exif IFDs
+const (
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+
+	tagGPSIFD          = 0x8825
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+// Location is a GPS position in decimal degrees
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Metadata is what is read from the EXIF metadata of an image, fields are nil when absent
+type Metadata struct {
+	Location *Location
+	TakenAt  *time.Time
+}
+
+// ExtractMetadata reads the GPS coordinates and capture time from the EXIF metadata of a
+// JPEG, PNG or WebP image
+func ExtractMetadata(r io.Reader) Metadata {
+	data, err := io.ReadAll(io.LimitReader(r, exifScanLimit))
+	if err != nil {
+		return Metadata{}
+	}
+
+	tiff := newTIFFReader(findEXIF(data))
+	if tiff == nil {
+		return Metadata{}
+	}
+	ifd0 := tiff.entries(tiff.order.Uint32(tiff.data[4:]))
+	return Metadata{Location: parseGPS(tiff, ifd0), TakenAt: parseTakenAt(tiff, ifd0)}
+}
+
+// findEXIF returns the TIFF structure holding the EXIF metadata of the image
+func findEXIF(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return jpegEXIF(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngEXIF(data)
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return webpEXIF(data)
+	}
+	return nil
+}
+
+// jpegEXIF walks the JPEG segments up to the image data looking for the APP1 Exif segment
+func jpegEXIF(data []byte) []byte {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+// pngEXIF looks for the eXIf chunk
+func pngEXIF(data []byte) []byte {
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunk := string(data[i+4 : i+8])
+		end := i + 8 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+		if chunk == "eXIf" {
+			return data[i+8 : end]
+		}
+		if chunk == "IEND" {
+			return nil
+		}
+		i = end + 4 // skip the CRC
+	}
+	return nil
+}
+
+// webpEXIF looks for the EXIF chunk of an extended WebP file
+func webpEXIF(data []byte) []byte {
+	for i := 12; i+8 <= len(data); {
+		chunk := string(data[i : i+4])
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+		if chunk == "EXIF" {
+			// Some writers keep the JPEG style header in the chunk
+			return bytes.TrimPrefix(data[i+8:end], []byte("Exif\x00\x00"))
+		}
+		i = end + length%2 // chunks are padded to an even size
+	}
+	return nil
+}
+
+// tiffReader reads IFD entries in the byte order of a TIFF structure
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is a raw IFD entry
+type ifdEntry struct {
+	tag      uint16
+	typ      uint16
+	count    uint32
+	valueOff []byte // the 4 value/offset bytes
+}
+
+func (t *tiffReader) entries(offset uint32) map[uint16]ifdEntry {
+	if int(offset)+2 > len(t.data) {
+		return nil
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	entries := make(map[uint16]ifdEntry, count)
+	for i := range count {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(t.data) {
+			break
+		}
+		entry := t.data[start : start+12]
+		entries[t.order.Uint16(entry)] = ifdEntry{
+			tag:      t.order.Uint16(entry),
+			typ:      t.order.Uint16(entry[2:]),
+			count:    t.order.Uint32(entry[4:]),
+			valueOff: entry[8:12],
+		}
+	}
+	return entries
+}
+
+// rationals reads the RATIONAL values of an entry
+func (t *tiffReader) rationals(entry ifdEntry) []float64 {
+	const typeRational = 5
+	if entry.typ != typeRational || entry.count == 0 || entry.count > 8 {
+		return nil
+	}
+	offset := int(t.order.Uint32(entry.valueOff))
+	if offset+int(entry.count)*8 > len(t.data) {
+		return nil
+	}
+
+	values := make([]float64, entry.count)
+	for i := range values {
+		numerator := t.order.Uint32(t.data[offset+i*8:])
+		denominator := t.order.Uint32(t.data[offset+i*8+4:])
+		if denominator == 0 {
+			return nil
+		}
+		values[i] = float64(numerator) / float64(denominator)
+	}
+	return values
+}
+
+// newTIFFReader detects the byte order of a TIFF structure, returning nil when it is not one
+func newTIFFReader(data []byte) *tiffReader {
+	if len(data) < 8 {
+		return nil
+	}
+
+	t := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil
+	}
+	return t
+}
+
+// parseGPS reads the coordinates from the GPS IFD
+func parseGPS(t *tiffReader, ifd0 map[uint16]ifdEntry) *Location {
+	pointer, ok := ifd0[tagGPSIFD]
+	if !ok {
+		return nil
+	}
+	gps := t.entries(t.order.Uint32(pointer.valueOff))
+
+	latitude, ok := coordinate(t, gps[tagGPSLatitude], gps[tagGPSLatitudeRef], 'S')
+	if !ok || math.Abs(latitude) > 90 {
+		return nil
+	}
+	longitude, ok := coordinate(t, gps[tagGPSLongitude], gps[tagGPSLongitudeRef], 'W')
+	if !ok || math.Abs(longitude) > 180 {
+		return nil
+	}
+
+	// Cameras without a fix often write zeros
+	if latitude == 0 && longitude == 0 {
+		return nil
+	}
+	return &Location{Latitude: latitude, Longitude: longitude}
+}
+
+// coordinate converts degrees, minutes and seconds to signed decimal degrees
+func coordinate(t *tiffReader, value ifdEntry, ref ifdEntry, negative byte) (float64, bool) {
+	parts := t.rationals(value)
+	if len(parts) != 3 {
+		return 0, false
+	}
+
+	degrees := parts[0] + parts[1]/60 + parts[2]/3600
+	if ref.count > 0 && ref.valueOff[0] == negative {
+		degrees = -degrees
+	}
+	return degrees, true
+}
+
+// parseTakenAt reads DateTimeOriginal from the Exif IFD. Cameras record local time, so the
+// offset tag is applied when present and UTC is assumed otherwise.
+func parseTakenAt(t *tiffReader, ifd0 map[uint16]ifdEntry) *time.Time {
+	pointer, ok := ifd0[tagExifIFD]
+	if !ok {
+		return nil
+	}
+	exif := t.entries(t.order.Uint32(pointer.valueOff))
+
+	value := t.ascii(exif[tagDateTimeOriginal])
+	layout := "2006:01:02 15:04:05"
+	if offset := t.ascii(exif[tagOffsetTimeOriginal]); offset != "" {
+		value += offset
+		layout += "-07:00"
+	}
+
+	takenAt, err := time.Parse(layout, value)
+	if err != nil || takenAt.Year() < 1900 {
+		return nil
+	}
+	takenAt = takenAt.UTC()
+	return &takenAt
+}
+
+// ascii reads the value of an ASCII entry without its NUL terminator
+func (t *tiffReader) ascii(entry ifdEntry) string {
+	const typeASCII = 2
+	if entry.typ != typeASCII || entry.count == 0 || entry.count > 64 {
+		return ""
+	}
+
+	value := entry.valueOff[:min(entry.count, 4)]
+	if entry.count > 4 {
+		offset := int(t.order.Uint32(entry.valueOff))
+		if offset+int(entry.count) > len(t.data) {
+			return ""
+		}
+		value = t.data[offset : offset+int(entry.count)]
+	}
+	return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+}
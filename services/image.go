@@ -1,157 +1,146 @@
 package services
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/json"
+	"cmp"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
-
-	"github.com/spf13/viper"
+	"log/slog"
+	"mime"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/hooks"
+	"github.com/pablobfonseca/go-image-vector/storage"
 )
 
-func ExtractTextFromImage(imagePath string) (string, error) {
-	file, err := os.Open(imagePath)
+// readImage reads a stored image as the vision model sees it, after the pre-analysis hooks
+func (c Clients) readImage(ctx context.Context, imagePath string) ([]byte, error) {
+	data, err := storage.ReadFile(ctx, c.Files, imagePath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	defer file.Close()
-
-	imageBytes, _ := io.ReadAll(file)
-	imageBase64 := base64.StdEncoding.EncodeToString(imageBytes)
-
-	model := viper.GetString("MODEL")
-	if model == "" {
-		model = "gemma3"
+	image := &hooks.Image{FilePath: imagePath, MediaType: mime.TypeByExtension(path.Ext(imagePath)), Data: data}
+	if err := hooks.BeforeAnalysis(ctx, image); err != nil {
+		return nil, err
 	}
+	return image.Data, nil
+}
 
-	ollamaConnction := NewOllamaConnection(GenerateEndpoint, model, OllamaRequest{
-		Model:  model,
-		Prompt: "Tell me what's happening in this image and figure out the context in natural language, always respond using the markdown syntax",
-		Images: []string{imageBase64},
-		Stream: false,
-	})
-
-	resp, err := ollamaConnction.Request()
+func (c Clients) ExtractTextFromImage(ctx context.Context, imagePath string) (string, error) {
+	imageBytes, err := c.readImage(ctx, imagePath)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	var result map[string]any
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
-	}
-
-	// Check if the response field exists and convert it to string properly
-	if response, ok := result["response"]; ok {
-		switch v := response.(type) {
-		case string:
-			return v, nil
-		case bool, float64, int:
-			return fmt.Sprintf("%v", v), nil
-		default:
-			return "", fmt.Errorf("unexpected response type: %T", v)
-		}
+	return c.describeImage(ctx, imageBytes)
+}
+
+// ExtractTextFromImageData describes an image that is not stored, such as the example image
+// of a search, after the pre-analysis hooks
+func (c Clients) ExtractTextFromImageData(ctx context.Context, data []byte, mediaType string) (string, error) {
+	image := &hooks.Image{MediaType: mediaType, Data: data}
+	if err := hooks.BeforeAnalysis(ctx, image); err != nil {
+		return "", err
 	}
+	return c.describeImage(ctx, image.Data)
+}
 
-	return "", fmt.Errorf("no response field in API result")
+// describeImage asks the vision model what an image shows
+func (c Clients) describeImage(ctx context.Context, imageBytes []byte) (string, error) {
+	return c.Generator.Generate(ctx, GenerateOptions{
+		Prompt: "Tell me what's happening in this image and figure out the context in natural language, always respond using the markdown syntax",
+		Images: [][]byte{imageBytes},
+	})
 }
 
-// ExtractTextFromMultipleImages analyzes multiple images at once to understand context connections
-func ExtractTextFromMultipleImages(imagePaths []string) (string, error) {
+// ExtractTextFromMultipleImages analyzes multiple images at once to understand context connections,
+// with the prompt of the scenario
+func (c Clients) ExtractTextFromMultipleImages(ctx context.Context, imagePaths []string, scenario Scenario) (string, error) {
 	if len(imagePaths) == 0 {
 		return "", fmt.Errorf("no image paths provided")
 	}
 
-	// Convert all images to base64
-	imageBase64List := []string{}
+	images := make([][]byte, 0, len(imagePaths))
 	for _, path := range imagePaths {
-		file, err := os.Open(path)
+		imageBytes, err := c.readImage(ctx, path)
 		if err != nil {
-			return "", fmt.Errorf("failed to open image %s: %v", path, err)
+			return "", fmt.Errorf("failed to read image %s: %w", path, err)
 		}
-
-		imageBytes, err := io.ReadAll(file)
-		file.Close()
-		if err != nil {
-			return "", fmt.Errorf("failed to read image %s: %v", path, err)
-		}
-
-		imageBase64 := base64.StdEncoding.EncodeToString(imageBytes)
-		imageBase64List = append(imageBase64List, imageBase64)
+		images = append(images, imageBytes)
 	}
 
-	model := viper.GetString("MODEL")
-	if model == "" {
-		model = "gemma3"
-	}
-
-	ollamaHost := os.Getenv("OLLAMA_HOST")
-	if ollamaHost == "" {
-		ollamaHost = "localhost"
-	}
-
-	ollamaURL := fmt.Sprintf("http://%s:11434/api/generate", ollamaHost)
-
-	// Enhanced prompt for analyzing multiple images together
-	batchPrompt := "I'm showing you multiple sequential screenshots from a user journey on a website. " +
-		"Analyze these images as a sequence and describe the complete user journey. " +
-		"Focus on identifying patterns, user actions, and transitions between pages. " +
-		"What is the user trying to accomplish? What steps are they taking? " +
-		"What might be their goals or pain points? " +
-		"Provide a detailed narrative of the entire journey, not just individual images. " +
-		"Always respond using markdown syntax."
+	return c.Generator.Generate(ctx, GenerateOptions{Prompt: scenario.batchPrompt(), Images: images})
+}
 
-	requestBody, _ := json.Marshal(OllamaRequest{
-		Model:  model,
-		Prompt: batchPrompt,
-		Images: imageBase64List,
-		Stream: false,
-	})
+// ChunkTolerance lets a parallel batch analysis survive failing chunks
+type ChunkTolerance struct {
+	// Retries is how many more times a failed chunk is analyzed
+	Retries int
+	// MinSuccess is the share of chunks that must succeed for the analysis to proceed without
+	// the others, 1 (or 0) requires every chunk
+	MinSuccess float64
+}
 
-	resp, err := http.Post(ollamaURL, "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to call Ollama at %s: %v", ollamaURL, err)
-	}
-	defer resp.Body.Close()
+// SkippedChunk is a chunk left out of a batch analysis after all of its attempts failed
+type SkippedChunk struct {
+	Index      int      `json:"index"`
+	ImagePaths []string `json:"file_paths"`
+	Attempts   int      `json:"attempts"`
+	Error      string   `json:"error"`
+}
 
-	var result map[string]any
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
-	}
-
-	// Check if the response field exists and convert it to string properly
-	if response, ok := result["response"]; ok {
-		switch v := response.(type) {
-		case string:
-			return v, nil
-		case bool, float64, int:
-			return fmt.Sprintf("%v", v), nil
-		default:
-			return "", fmt.Errorf("unexpected response type: %T", v)
-		}
-	}
+// chunkRetryDelay is the wait before the first retry of a chunk, growing with each attempt
+const chunkRetryDelay = time.Second
 
-	return "", fmt.Errorf("no response field in API result")
+// ChunkCheckpoints keeps the analyses of finished chunks, so a batch that is run again
+// resumes from them instead of analyzing every chunk anew
+type ChunkCheckpoints interface {
+	// Load returns the analysis of a chunk saved by an earlier run, if any
+	Load(index int, imagePaths []string) (string, bool)
+	// Save records the analysis of a finished chunk
+	Save(index int, imagePaths []string, text string)
 }
 
 // ParallelExtractTextFromImages processes images in parallel and then combines the results
 // maxChunkSize: maximum number of images to process in a single API call
 // maxParallel: maximum number of parallel processing operations
-func ParallelExtractTextFromImages(imagePaths []string, maxChunkSize int, maxParallel int) (string, error) {
+// scenario: the preset whose prompts describe what the images show
+// tolerance: retries of failed chunks, and how many must succeed to go on without the rest
+// checkpoints: where finished chunks are kept to resume from, nil to not keep them
+// It returns the chunks that were skipped.
+func (c Clients) ParallelExtractTextFromImages(ctx context.Context, imagePaths []string, maxChunkSize int, maxParallel int,
+	scenario Scenario, tolerance ChunkTolerance, checkpoints ChunkCheckpoints) (string, []SkippedChunk, error) {
 	if len(imagePaths) == 0 {
-		return "", fmt.Errorf("no image paths provided")
+		return "", nil, fmt.Errorf("no image paths provided")
+	}
+
+	// analyzeChunk retries a chunk up to the tolerance, reporting the attempts it took
+	analyzeChunk := func(paths []string) (string, int, error) {
+		var err error
+		for attempt := 1; ; attempt++ {
+			var text string
+			if text, err = c.ExtractTextFromMultipleImages(ctx, paths, scenario); err == nil {
+				return text, attempt, nil
+			}
+			if attempt > tolerance.Retries || ctx.Err() != nil {
+				return "", attempt, err
+			}
+
+			slog.WarnContext(ctx, "Retrying batch chunk", "attempt", attempt, "images", len(paths), "error", err)
+			select {
+			case <-ctx.Done():
+				return "", attempt, ctx.Err()
+			case <-time.After(time.Duration(attempt) * chunkRetryDelay):
+			}
+		}
 	}
 
 	// For small batches, use the original method
 	if len(imagePaths) <= maxChunkSize {
-		return ExtractTextFromMultipleImages(imagePaths)
+		text, _, err := analyzeChunk(imagePaths)
+		return text, nil, err
 	}
 
 	// Split into chunks
@@ -163,9 +152,10 @@ func ParallelExtractTextFromImages(imagePaths []string, maxChunkSize int, maxPar
 
 	// Process chunks in parallel
 	type chunkResult struct {
-		index int
-		text  string
-		err   error
+		index    int
+		text     string
+		attempts int
+		err      error
 	}
 
 	resultChan := make(chan chunkResult, len(chunks))
@@ -173,82 +163,74 @@ func ParallelExtractTextFromImages(imagePaths []string, maxChunkSize int, maxPar
 
 	for i, chunk := range chunks {
 		go func(idx int, imgPaths []string) {
+			// Chunks finished by an earlier run are not analyzed again
+			if checkpoints != nil {
+				if text, ok := checkpoints.Load(idx, imgPaths); ok {
+					slog.InfoContext(ctx, "Resuming batch chunk from checkpoint", "index", idx)
+					resultChan <- chunkResult{idx, text, 0, nil}
+					return
+				}
+			}
+
 			sem <- struct{}{}        // Acquire semaphore
 			defer func() { <-sem }() // Release semaphore
 
 			// Process this chunk
-			text, err := ExtractTextFromMultipleImages(imgPaths)
-			resultChan <- chunkResult{idx, text, err}
+			text, attempts, err := analyzeChunk(imgPaths)
+			if err == nil && checkpoints != nil {
+				checkpoints.Save(idx, imgPaths, text)
+			}
+			resultChan <- chunkResult{idx, text, attempts, err}
 		}(i, chunk)
 	}
 
 	// Collect results in order
 	chunkTexts := make([]string, len(chunks))
+	var skipped []SkippedChunk
+	var firstErr error
 	for range chunks {
 		result := <-resultChan
 		if result.err != nil {
-			return "", result.err
+			skipped = append(skipped, SkippedChunk{
+				Index:      result.index,
+				ImagePaths: chunks[result.index],
+				Attempts:   result.attempts,
+				Error:      result.err.Error(),
+			})
+			firstErr = cmp.Or(firstErr, result.err)
+			continue
 		}
 		chunkTexts[result.index] = result.text
 	}
 
-	// If we only had one chunk after all, return that result
-	if len(chunkTexts) == 1 {
-		return chunkTexts[0], nil
+	// Without tolerance any failure fails the batch, with it enough chunks must succeed
+	minSuccess := tolerance.MinSuccess
+	if minSuccess <= 0 || minSuccess > 1 {
+		minSuccess = 1
 	}
-
-	// Now synthesize a combined analysis from the chunk results
-	model := viper.GetString("MODEL")
-	if model == "" {
-		model = "gemma3"
+	succeeded := len(chunks) - len(skipped)
+	if succeeded == 0 || float64(succeeded) < minSuccess*float64(len(chunks)) {
+		if len(skipped) == 1 {
+			return "", skipped, firstErr
+		}
+		return "", skipped, fmt.Errorf("%d of %d chunks failed: %w", len(skipped), len(chunks), firstErr)
 	}
 
-	ollamaHost := os.Getenv("OLLAMA_HOST")
-	if ollamaHost == "" {
-		ollamaHost = "localhost"
+	slices.SortFunc(skipped, func(a, b SkippedChunk) int { return a.Index - b.Index })
+	for _, chunk := range skipped {
+		slog.WarnContext(ctx, "Skipping failed batch chunk", "index", chunk.Index, "attempts", chunk.Attempts, "error", chunk.Error)
 	}
+	chunkTexts = slices.DeleteFunc(chunkTexts, func(text string) bool { return text == "" })
 
-	ollamaURL := fmt.Sprintf("http://%s:11434/api/generate", ollamaHost)
-
-	// Final synthesis prompt
-	synthesisPrompt := "I've analyzed parts of a user journey through a website and need to combine them into a cohesive narrative.\n\n" +
-		"Here are the separate analyses: \n\n" +
-		"```\n" +
-		fmt.Sprintf("%s", chunkTexts) +
-		"\n```\n\n" +
-		"Please synthesize these analyses into a single coherent narrative that describes the complete user journey. " +
-		"Avoid repetition, ensure continuity, and focus on the overall flow and user goals. " +
-		"Always respond using markdown syntax."
-
-	requestBody, _ := json.Marshal(map[string]any{
-		"model":  model,
-		"prompt": synthesisPrompt,
-		"stream": false,
-	})
-
-	resp, err := http.Post(ollamaURL, "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to call Ollama for synthesis: %v", err)
+	// If we only had one chunk after all, return that result
+	if len(chunkTexts) == 1 {
+		return chunkTexts[0], skipped, nil
 	}
-	defer resp.Body.Close()
 
-	var result map[string]any
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	// Now synthesize a combined analysis from the chunk results
+	synthesis, err := c.Generator.Generate(ctx, GenerateOptions{Prompt: scenario.synthesisPrompt(chunkTexts, len(skipped) > 0)})
 	if err != nil {
-		return "", fmt.Errorf("failed to parse synthesis response: %v", err)
-	}
-
-	// Check if the response field exists
-	if response, ok := result["response"]; ok {
-		switch v := response.(type) {
-		case string:
-			return v, nil
-		case bool, float64, int:
-			return fmt.Sprintf("%v", v), nil
-		default:
-			return "", fmt.Errorf("unexpected synthesis response type: %T", v)
-		}
+		return "", skipped, fmt.Errorf("synthesis failed: %w", err)
 	}
-
-	return "", fmt.Errorf("no response field in synthesis API result")
+	return synthesis, skipped, nil
 }
@@ -0,0 +1,78 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/services/servicestest"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/storage/storagetest"
+)
+
+func TestExtractTextFromImage(t *testing.T) {
+	store := storagetest.NewMemory()
+	ollama := servicestest.Start(t)
+	clients := ollama.Clients(store)
+
+	ctx := context.Background()
+	if err := store.Save(ctx, "ab/cd.png", strings.NewReader("image bytes")); err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := clients.ExtractTextFromImage(ctx, storage.Path("ab/cd.png"))
+	if err != nil {
+		t.Fatalf("ExtractTextFromImage: %v", err)
+	}
+	if !strings.Contains(text, "Fake description") {
+		t.Errorf("text = %q, want the fake description", text)
+	}
+	if requests := ollama.Requests(); len(requests) != 1 || requests[0].Images != 1 {
+		t.Errorf("requests = %+v, want one generate call with the image", requests)
+	}
+}
+
+func TestExtractTextFromImageMissing(t *testing.T) {
+	ollama := servicestest.Start(t)
+	clients := ollama.Clients(storagetest.NewMemory())
+
+	_, err := clients.ExtractTextFromImage(context.Background(), storage.Path("missing.png"))
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("err = %v, want storage.ErrNotFound", err)
+	}
+	if requests := ollama.Requests(); len(requests) != 0 {
+		t.Errorf("Ollama was called %d times for a missing image", len(requests))
+	}
+}
+
+func TestParallelExtractTextFromImages(t *testing.T) {
+	store := storagetest.NewMemory()
+	ollama := servicestest.Start(t)
+	clients := ollama.Clients(store)
+	ollama.Respond(func(prompt string, images int) string {
+		if images == 0 {
+			return "Synthesized journey"
+		}
+		return "Chunk analysis"
+	})
+
+	ctx := context.Background()
+	var paths []string
+	for _, key := range []string{"a.png", "b.png", "c.png"} {
+		if err := store.Save(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, storage.Path(key))
+	}
+
+	scenario, _ := services.LookupScenario("web")
+	text, skipped, err := clients.ParallelExtractTextFromImages(ctx, paths, 2, 2, scenario, services.ChunkTolerance{}, nil)
+	if err != nil {
+		t.Fatalf("ParallelExtractTextFromImages: %v", err)
+	}
+	if text != "Synthesized journey" || len(skipped) != 0 {
+		t.Errorf("got %q with %d skipped chunks, want the synthesis", text, len(skipped))
+	}
+}
@@ -2,12 +2,22 @@ package services
 
 import (
 	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/reporting"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/tracing"
+	"github.com/spf13/viper"
 )
 
+// OllamaEndpoint is a path of the Ollama API
 type OllamaEndpoint string
 
 const (
@@ -15,44 +25,317 @@ const (
 	EmbeddingEndpoint OllamaEndpoint = "embeddings"
 )
 
-type OllamaRequest struct {
+// Client calls the Ollama API. Its zero value calls the Ollama at OLLAMA_HOST with
+// http.DefaultClient, and requests without a model use MODEL or EMBEDDING_MODEL, both read
+// on every call so configuration reloads apply.
+type Client struct {
+	// BaseURL replaces the API URL built from OLLAMA_HOST, such as http://ollama:11434/api
+	BaseURL string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Ollama is the client of the configured Ollama
+var Ollama = &Client{}
+
+// Generator answers prompts with a text model, or a vision model when given images
+type Generator interface {
+	Generate(ctx context.Context, opts GenerateOptions) (string, error)
+}
+
+// Embedder embeds texts
+type Embedder interface {
+	Embed(ctx context.Context, opts EmbeddingOptions) ([]float32, error)
+}
+
+// Clients are what the services call: the models answering prompts and embedding texts, and
+// the storage the images they describe are read from. Tests pass fakes of each.
+type Clients struct {
+	Generator Generator
+	Embedder  Embedder
+	Files     storage.Storage
+}
+
+// NewClients returns the clients of the configured Ollama, reading images from files
+func NewClients(files storage.Storage) Clients {
+	return Clients{Generator: Ollama, Embedder: Ollama, Files: files}
+}
+
+// GeneratorFunc adapts a function to a Generator
+type GeneratorFunc func(ctx context.Context, opts GenerateOptions) (string, error)
+
+func (f GeneratorFunc) Generate(ctx context.Context, opts GenerateOptions) (string, error) {
+	return f(ctx, opts)
+}
+
+// EmbedderFunc adapts a function to an Embedder
+type EmbedderFunc func(ctx context.Context, opts EmbeddingOptions) ([]float32, error)
+
+func (f EmbedderFunc) Embed(ctx context.Context, opts EmbeddingOptions) ([]float32, error) {
+	return f(ctx, opts)
+}
+
+// GenerateOptions is a text generation request, with images for vision models
+type GenerateOptions struct {
+	// Model defaults to MODEL
+	Model  string
+	Prompt string
+	// Images are the raw bytes of the images the prompt is about
+	Images [][]byte
+}
+
+// EmbeddingOptions is a request to embed a text
+type EmbeddingOptions struct {
+	// Model defaults to EMBEDDING_MODEL
+	Model string
+	Text  string
+}
+
+// generateRequest is the body of a generate call, answered in one response
+type generateRequest struct {
 	Model  string   `json:"model"`
 	Prompt string   `json:"prompt"`
 	Stream bool     `json:"stream"`
-	Images []string `json:"images"`
+	Images []string `json:"images,omitempty"`
 }
 
-type OllamaConnection struct {
-	Path          OllamaEndpoint
-	Model         string
-	OllamaRequest OllamaRequest
+// embeddingRequest is the body of an embeddings call
+type embeddingRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
 }
 
-type OllamaResponse struct {
+// embeddingResponse is the answer of an embeddings call
+type embeddingResponse struct {
 	Embedding []float32 `json:"embedding"`
+	Error     string    `json:"error"`
+}
+
+// OllamaError is a failed Ollama call. Unreachable is set when Ollama could not be called at
+// all, otherwise the model failed to answer, with the HTTP status when Ollama returned an error.
+type OllamaError struct {
+	Unreachable bool
+	StatusCode  int
+	Err         error
+}
+
+func (e *OllamaError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *OllamaError) Unwrap() error {
+	return e.Err
+}
+
+// modelError is an answer of a model that could not be used
+func modelError(format string, args ...any) error {
+	return &OllamaError{Err: fmt.Errorf(format, args...)}
+}
+
+// Generate answers a prompt with a text model, or a vision model when there are images
+func (c *Client) Generate(ctx context.Context, opts GenerateOptions) (string, error) {
+	model := cmp.Or(opts.Model, viper.GetString("MODEL"), "gemma3")
+
+	images := make([]string, len(opts.Images))
+	for i, image := range opts.Images {
+		images[i] = base64.StdEncoding.EncodeToString(image)
+	}
+
+	resp, err := c.post(ctx, GenerateEndpoint, model, len(images), generateRequest{
+		Model:  model,
+		Prompt: opts.Prompt,
+		Stream: false,
+		Images: images,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return generateResponse(resp)
+}
+
+// Embed returns the embedding of a text
+func (c *Client) Embed(ctx context.Context, opts EmbeddingOptions) ([]float32, error) {
+	model := cmp.Or(opts.Model, viper.GetString("EMBEDDING_MODEL"), "nomic-embed-text")
+
+	resp, err := c.post(ctx, EmbeddingEndpoint, model, 0, embeddingRequest{Model: model, Prompt: opts.Text})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, modelError("failed to parse response: %v", err)
+	}
+	if result.Error != "" {
+		return nil, modelError("Ollama returned an error: %s", result.Error)
+	}
+	if len(result.Embedding) == 0 {
+		return nil, modelError("model %s returned no embedding; set EMBEDDING_MODEL to an embedding model such as nomic-embed-text", model)
+	}
+	return result.Embedding, nil
 }
 
-func NewOllamaConnection(path OllamaEndpoint, model string, request OllamaRequest) *OllamaConnection {
-	return &OllamaConnection{
-		Path:          path,
-		Model:         model,
-		OllamaRequest: request,
+// ListModels returns the names of the models available in Ollama
+func (c *Client) ListModels(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpointURL("tags"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Ollama at %s: %v%s", req.URL, err, unreachableHint(ctx))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, errorMessage(resp))
+	}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
 	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		names = append(names, model.Name)
+	}
+	return names, nil
 }
 
-func (c *OllamaConnection) Request() (*http.Response, error) {
-	ollamaHost := os.Getenv("OLLAMA_HOST")
+// endpointURL returns the URL of an Ollama API endpoint
+func (c *Client) endpointURL(path string) string {
+	if c.BaseURL != "" {
+		return strings.TrimSuffix(c.BaseURL, "/") + "/" + path
+	}
+
+	ollamaHost := viper.GetString("OLLAMA_HOST")
 	if ollamaHost == "" {
 		ollamaHost = "localhost"
 	}
 
-	ollamaURL := fmt.Sprintf("http://%s:11434/api/%s", ollamaHost, c.Path)
+	return fmt.Sprintf("http://%s:11434/api/%s", ollamaHost, path)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// post sends a request to an endpoint, traced and reported to Sentry when it fails, and
+// returns the response of a successful call
+func (c *Client) post(ctx context.Context, endpoint OllamaEndpoint, model string, images int, body any) (*http.Response, error) {
+	ollamaURL := c.endpointURL(string(endpoint))
+
+	requestBody, _ := json.Marshal(body)
 
-	requestBody, _ := json.Marshal(c.OllamaRequest)
+	ctx, span := tracing.Start(ctx, "ollama."+string(endpoint), tracing.KindClient,
+		"ollama.model", model,
+		"ollama.images", images,
+	)
+	defer span.End()
 
-	resp, err := http.Post(ollamaURL, "application/json", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaURL, bytes.NewBuffer(requestBody))
 	if err != nil {
-		return nil, fmt.Errorf("failed to call Ollama at %s: %v", ollamaURL, err)
+		span.RecordError(err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		span.RecordError(err)
+		err = &OllamaError{Unreachable: true, Err: fmt.Errorf("failed to call Ollama at %s: %v%s", ollamaURL, err, unreachableHint(ctx))}
+		reporting.Capture(ctx, err, "ollama.endpoint", endpoint, "ollama.model", model)
+		return nil, err
+	}
+	span.SetAttributes("http.status_code", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		message := errorMessage(resp)
+		if hint := failureHint(endpoint, model, resp.StatusCode, message); hint != "" {
+			message += "; " + hint
+		}
+		err := &OllamaError{StatusCode: resp.StatusCode, Err: fmt.Errorf("Ollama %s returned status %d: %s",
+			endpoint, resp.StatusCode, message)}
+		span.RecordError(err)
+		reporting.Capture(ctx, err, "ollama.endpoint", endpoint, "ollama.model", model)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// errorMessage is the explanation Ollama gives in the error field of a failed response, such
+// as a missing model, or the status text when there is none
+func errorMessage(resp *http.Response) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	return cmp.Or(body.Error, http.StatusText(resp.StatusCode), "unknown error")
+}
+
+// failureHint says how to fix the common causes of a failed call: a model that is not
+// pulled, a model of the wrong kind for the endpoint, too little memory, or an overloaded server
+func failureHint(endpoint OllamaEndpoint, model string, status int, message string) string {
+	setting := "MODEL"
+	if endpoint == EmbeddingEndpoint {
+		setting = "EMBEDDING_MODEL"
+	}
+
+	message = strings.ToLower(message)
+	switch {
+	case status == http.StatusNotFound && strings.Contains(message, "not found"):
+		return fmt.Sprintf("pull the model with \"ollama pull %s\" or set %s to an installed model", model, setting)
+	case strings.Contains(message, "does not support"):
+		return fmt.Sprintf("set %s to a model that supports %s requests", setting, endpoint)
+	case strings.Contains(message, "memory"):
+		return fmt.Sprintf("free memory on the Ollama host or set %s to a smaller model", setting)
+	case status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests:
+		return "Ollama is busy, retry later or raise OLLAMA_NUM_PARALLEL and OLLAMA_MAX_QUEUE on the Ollama server"
+	}
+	return ""
+}
+
+// unreachableHint says how to fix a call that could not reach Ollama, unless the call was
+// only canceled or timed out
+func unreachableHint(ctx context.Context) string {
+	if ctx.Err() != nil {
+		return ""
+	}
+	return "; check that Ollama is running and OLLAMA_HOST points to it"
+}
+
+// generateResponse decodes the answer of a generate call. Ollama can report a failure in the
+// error field of a successful response, which is returned instead of a missing response.
+func generateResponse(resp *http.Response) (string, error) {
+	var result map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", modelError("failed to parse response: %v", err)
+	}
+	if message, _ := result["error"].(string); message != "" {
+		return "", modelError("Ollama returned an error: %s", message)
+	}
+
+	switch v := result["response"].(type) {
+	case string:
+		return v, nil
+	case bool, float64:
+		return fmt.Sprintf("%v", v), nil
+	case nil:
+		return "", modelError("no response field in API result")
+	default:
+		return "", modelError("unexpected response type: %T", v)
 	}
-	return resp, err
 }
@@ -0,0 +1,161 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/services/servicestest"
+	"github.com/spf13/viper"
+)
+
+func TestClientGenerate(t *testing.T) {
+	ollama := servicestest.NewOllama()
+	defer ollama.Close()
+	viper.Set("MODEL", "")
+
+	answer, err := ollama.Client().Generate(context.Background(), services.GenerateOptions{
+		Prompt: "describe",
+		Images: [][]byte{[]byte("png"), []byte("jpeg")},
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if !strings.Contains(answer, "2 image(s)") {
+		t.Errorf("answer = %q, want the fake description of 2 images", answer)
+	}
+
+	requests := ollama.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	if got := requests[0]; got.Endpoint != "generate" || got.Model != "gemma3" || got.Prompt != "describe" || got.Images != 2 {
+		t.Errorf("request = %+v, want a generate call to gemma3 with the prompt and 2 images", got)
+	}
+}
+
+func TestClientEmbed(t *testing.T) {
+	ollama := servicestest.NewOllama()
+	defer ollama.Close()
+	viper.Set("EMBEDDING_MODEL", "custom-embed")
+
+	embedding, err := ollama.Client().Embed(context.Background(), services.EmbeddingOptions{Text: "login page"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(embedding) != servicestest.Dimensions {
+		t.Errorf("got %d dimensions, want %d", len(embedding), servicestest.Dimensions)
+	}
+	if model := ollama.Requests()[0].Model; model != "custom-embed" {
+		t.Errorf("model = %q, want EMBEDDING_MODEL", model)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		message  string
+		embed    bool
+		contains []string
+	}{
+		{
+			name:     "missing model",
+			status:   http.StatusNotFound,
+			message:  `model "llava" not found, try pulling it first`,
+			contains: []string{"status 404", `model "llava" not found`, `ollama pull gemma3`, "MODEL"},
+		},
+		{
+			name:     "wrong kind of model",
+			status:   http.StatusBadRequest,
+			message:  `"gemma3" does not support embeddings`,
+			embed:    true,
+			contains: []string{"status 400", "EMBEDDING_MODEL to a model that supports embeddings"},
+		},
+		{
+			name:     "out of memory",
+			status:   http.StatusInternalServerError,
+			message:  "model requires more system memory (9 GiB) than is available (4 GiB)",
+			contains: []string{"status 500", "free memory"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ollama := servicestest.NewOllama()
+			defer ollama.Close()
+			ollama.Fail(tt.status, tt.message)
+			viper.Set("MODEL", "gemma3")
+
+			var err error
+			if tt.embed {
+				_, err = ollama.Client().Embed(context.Background(), services.EmbeddingOptions{Text: "text"})
+			} else {
+				_, err = ollama.Client().Generate(context.Background(), services.GenerateOptions{Prompt: "prompt"})
+			}
+
+			var ollamaErr *services.OllamaError
+			if !errors.As(err, &ollamaErr) {
+				t.Fatalf("err = %v, want an OllamaError", err)
+			}
+			if ollamaErr.StatusCode != tt.status || ollamaErr.Unreachable {
+				t.Errorf("status = %d, unreachable = %v, want %d", ollamaErr.StatusCode, ollamaErr.Unreachable, tt.status)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientErrorField(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":"unexpected EOF"}`))
+	}))
+	defer server.Close()
+	client := &services.Client{BaseURL: server.URL}
+
+	if _, err := client.Generate(context.Background(), services.GenerateOptions{Prompt: "prompt"}); err == nil ||
+		!strings.Contains(err.Error(), "unexpected EOF") {
+		t.Errorf("Generate err = %v, want the error field", err)
+	}
+	if _, err := client.Embed(context.Background(), services.EmbeddingOptions{Text: "text"}); err == nil ||
+		!strings.Contains(err.Error(), "unexpected EOF") {
+		t.Errorf("Embed err = %v, want the error field", err)
+	}
+}
+
+func TestClientUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	client := &services.Client{BaseURL: server.URL}
+
+	_, err := client.Generate(context.Background(), services.GenerateOptions{Prompt: "prompt"})
+	var ollamaErr *services.OllamaError
+	if !errors.As(err, &ollamaErr) || !ollamaErr.Unreachable {
+		t.Fatalf("err = %v, want an unreachable OllamaError", err)
+	}
+	if !strings.Contains(err.Error(), "OLLAMA_HOST") {
+		t.Errorf("error %q does not say to check OLLAMA_HOST", err)
+	}
+}
+
+func TestClientListModels(t *testing.T) {
+	ollama := servicestest.NewOllama()
+	defer ollama.Close()
+	ollama.SetModels("gemma3:latest")
+
+	models, err := ollama.Client().ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	if len(models) != 1 || models[0] != "gemma3:latest" {
+		t.Errorf("models = %v, want [gemma3:latest]", models)
+	}
+}
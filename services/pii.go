@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"strings"
+)
+
+// DetectNames asks the text model for the names of people that appear in a description, such
+// as a signed-in user or the sender of a message, for them to be redacted
+func (c Clients) DetectNames(ctx context.Context, description string) ([]string, error) {
+	prompt := "List the names of real people that appear in the screenshot or image description below, " +
+		"such as account holders, contacts or message senders, one per line exactly as written. " +
+		"Leave out product, company and place names. Answer with none when there are no names.\n\nDescription:\n" +
+		description
+
+	answer, err := c.Generator.Generate(ctx, GenerateOptions{Prompt: prompt})
+	if err != nil {
+		return nil, err
+	}
+	return parseNames(answer), nil
+}
+
+// parseNames reads the names listed in a model answer, without list markers, quotes or none
+func parseNames(answer string) []string {
+	var names []string
+	for _, line := range strings.Split(answer, "\n") {
+		name := strings.TrimLeft(strings.TrimSpace(line), "0123456789")
+		name = strings.Trim(name, "-*•\"'`.) ")
+		if name == "" || strings.EqualFold(name, "none") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,16 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNames(t *testing.T) {
+	names := parseNames("- Jane Doe\n* \"Carlos Souza\"\n\n3. Ana Lima\nNone.")
+	if want := []string{"Jane Doe", "Carlos Souza", "Ana Lima"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("parseNames = %q, want %q", names, want)
+	}
+	if names := parseNames("none"); names != nil {
+		t.Errorf("parseNames of none = %q", names)
+	}
+}
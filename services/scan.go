@@ -0,0 +1,144 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ScanResult is the outcome of scanning an uploaded file
+type ScanResult struct {
+	Flagged bool
+	Reason  string
+}
+
+// Scanner inspects uploaded files before they are stored and queued
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+}
+
+// NewScanner returns the scanner selected by SCANNER ("clamav" or "command"), or nil when scanning is disabled
+func NewScanner() (Scanner, error) {
+	switch viper.GetString("SCANNER") {
+	case "":
+		return nil, nil
+	case "clamav":
+		addr := viper.GetString("CLAMAV_ADDR")
+		if addr == "" {
+			addr = "localhost:3310"
+		}
+		return &ClamAVScanner{Addr: addr}, nil
+	case "command":
+		command := viper.GetString("SCAN_COMMAND")
+		if command == "" {
+			return nil, fmt.Errorf("SCAN_COMMAND is required when SCANNER=command")
+		}
+		return &CommandScanner{Command: command}, nil
+	default:
+		return nil, fmt.Errorf("unknown scanner %q", viper.GetString("SCANNER"))
+	}
+}
+
+// ClamAVScanner streams files to a clamd daemon using the INSTREAM command
+type ClamAVScanner struct {
+	Addr string
+}
+
+func (s *ClamAVScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("failed to connect to clamd at %s: %v", s.Addr, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, err
+	}
+
+	// Each chunk is prefixed by its length, a zero-length chunk ends the stream
+	buf := make([]byte, 32*1024)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return ScanResult{}, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return ScanResult{}, err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ScanResult{}, err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return ScanResult{}, err
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	// Replies look like "stream: OK" or "stream: Eicar-Signature FOUND"
+	response := strings.TrimSpace(strings.TrimRight(string(reply), "\x00"))
+	response = strings.TrimPrefix(response, "stream: ")
+	switch {
+	case response == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(response, " FOUND"):
+		return ScanResult{Flagged: true, Reason: strings.TrimSuffix(response, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("unexpected clamd response: %s", response)
+	}
+}
+
+// CommandScanner pipes files to an external command on stdin; exit code 1 flags the
+// file, with stdout as the reason, and any other non-zero code is a scan error
+type CommandScanner struct {
+	Command string
+}
+
+func (s *CommandScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	fields := strings.Fields(s.Command)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return ScanResult{}, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		reason := strings.TrimSpace(stdout.String())
+		if reason == "" {
+			reason = "flagged by scan command"
+		}
+		return ScanResult{Flagged: true, Reason: reason}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("scan command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+}
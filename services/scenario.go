@@ -0,0 +1,163 @@
+package services
+
+import (
+	"bytes"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// Scenario tailors the batch prompts to what the images of a batch show
+type Scenario struct {
+	// Batch asks for a narrative of a set of images
+	Batch string
+	// Combine introduces the analyses of the chunks of a large batch
+	Combine string
+	// Synthesize asks for one narrative from the chunk analyses
+	Synthesize string
+}
+
+// DefaultScenario is used when a batch does not name one
+const DefaultScenario = "web"
+
+// markdownInstruction ends every prompt, as results are rendered as markdown
+const markdownInstruction = "Always respond using markdown syntax."
+
+// scenarios are the batch presets callers can choose from
+var scenarios = map[string]Scenario{
+	"web": {
+		Batch: "I'm showing you multiple sequential screenshots from a user journey on a website. " +
+			"The images are in journey order, from the first step to the last. " +
+			"Analyze these images as a sequence and describe the complete user journey. " +
+			"Focus on identifying patterns, user actions, and transitions between pages. " +
+			"What is the user trying to accomplish? What steps are they taking? " +
+			"What might be their goals or pain points? " +
+			"Provide a detailed narrative of the entire journey, not just individual images. ",
+		Combine: "I've analyzed parts of a user journey through a website and need to combine them into a cohesive narrative.",
+		Synthesize: "Please synthesize these analyses into a single coherent narrative that describes the complete user journey. " +
+			"Avoid repetition, ensure continuity, and focus on the overall flow and user goals. ",
+	},
+	"mobile_app": {
+		Batch: "I'm showing you multiple sequential screenshots of a flow through a mobile app. " +
+			"The images are in flow order, from the first screen to the last. " +
+			"Describe the complete flow: the screens, what the user taps or enters on each, " +
+			"navigation patterns such as tabs, sheets and back navigation, and system prompts like permissions or notifications. " +
+			"What is the user trying to accomplish, and where might they get stuck? " +
+			"Provide a narrative of the entire flow, not just individual screens. ",
+		Combine: "I've analyzed parts of a flow through a mobile app and need to combine them into a cohesive narrative.",
+		Synthesize: "Please synthesize these analyses into a single coherent narrative of the complete app flow. " +
+			"Avoid repetition, keep the screens in order, and focus on the user's goal and any friction along the way. ",
+	},
+	"photo_album": {
+		Batch: "I'm showing you multiple photos from the same album, in chronological order. " +
+			"Describe the story they tell together: the places, people, activities and occasions, " +
+			"how the scenes change from one photo to the next, and the overall mood. " +
+			"Mention recurring subjects and notable details that would help find these photos later. " +
+			"Provide a narrative of the whole album, not just individual photos. ",
+		Combine: "I've described parts of a photo album and need to combine them into one story.",
+		Synthesize: "Please synthesize these descriptions into a single coherent story of the whole album. " +
+			"Avoid repetition, keep the chronology, and highlight the main places, people and events. ",
+	},
+	"surveillance": {
+		Batch: "I'm showing you a sequence of frames from a fixed camera, in chronological order. " +
+			"Describe the scene and what changes between frames: people, vehicles and objects that appear, " +
+			"move or leave, their direction and actions, and anything unusual. " +
+			"Be factual and specific, do not guess identities, and note when frames are unclear. " +
+			"Provide a timeline of the events across all frames. ",
+		Combine: "I've analyzed consecutive segments of footage from a fixed camera and need to combine them into one timeline.",
+		Synthesize: "Please synthesize these analyses into a single chronological timeline of events. " +
+			"Avoid repetition, keep events in order, and keep to what is visible. ",
+	},
+	"document_scan": {
+		Batch: "I'm showing you the scanned pages of a document set, in page order. " +
+			"Identify what kind of documents these are, transcribe the key text such as titles, names, dates, " +
+			"amounts and reference numbers, and summarize the content of each page. " +
+			"Note where a document continues across pages and where a new one starts. " +
+			"Provide an overview of the whole set, followed by the details. ",
+		Combine: "I've analyzed parts of a set of scanned documents and need to combine them into one overview.",
+		Synthesize: "Please synthesize these analyses into a single overview of the document set. " +
+			"Avoid repetition, keep the page order, and preserve key names, dates, amounts and reference numbers exactly. ",
+	},
+}
+
+// LookupScenario returns the preset called name, the default when name is empty
+func LookupScenario(name string) (Scenario, bool) {
+	if name == "" {
+		name = DefaultScenario
+	}
+	scenario, ok := scenarios[name]
+	return scenario, ok
+}
+
+// ScenarioNames lists the available presets in alphabetical order
+func ScenarioNames() []string {
+	names := make([]string, 0, len(scenarios))
+	for name := range scenarios {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// batchPrompt is the prompt for analyzing a set of images together
+func (s Scenario) batchPrompt() string {
+	return strings.TrimSpace(s.Batch + markdownInstruction)
+}
+
+// gapInstruction is added to the synthesis prompt when chunks were skipped
+const gapInstruction = "Some parts of the sequence could not be analyzed, so point out where steps are missing " +
+	"instead of inventing what happened in between. "
+
+// DefaultChunkTemplate renders one chunk analysis in the synthesis prompt
+const DefaultChunkTemplate = "### Part {{.Number}} of {{.Total}}\n\n{{.Text}}"
+
+// DefaultChunkDelimiter separates the chunk analyses in the synthesis prompt
+const DefaultChunkDelimiter = "\n\n---\n\n"
+
+// chunkSection is the data of SYNTHESIS_CHUNK_TEMPLATE
+type chunkSection struct {
+	Number int
+	Total  int
+	Text   string
+}
+
+// ParseChunkTemplate parses a SYNTHESIS_CHUNK_TEMPLATE value
+func ParseChunkTemplate(text string) (*template.Template, error) {
+	return template.New("chunk").Parse(text)
+}
+
+// synthesisPrompt is the prompt combining the analyses of chunks into one narrative. Each
+// analysis is rendered with SYNTHESIS_CHUNK_TEMPLATE and separated by SYNTHESIS_CHUNK_DELIMITER.
+// With gaps, some chunks were skipped and the model is told not to bridge them.
+func (s Scenario) synthesisPrompt(analyses []string, gaps bool) string {
+	synthesize := s.Synthesize
+	if gaps {
+		synthesize += gapInstruction
+	}
+
+	chunkTemplate, err := ParseChunkTemplate(viper.GetString("SYNTHESIS_CHUNK_TEMPLATE"))
+	if err != nil {
+		slog.Warn("Invalid SYNTHESIS_CHUNK_TEMPLATE, using the default", "error", err)
+		chunkTemplate = template.Must(ParseChunkTemplate(DefaultChunkTemplate))
+	}
+
+	sections := make([]string, len(analyses))
+	for i, analysis := range analyses {
+		var section bytes.Buffer
+		data := chunkSection{Number: i + 1, Total: len(analyses), Text: strings.TrimSpace(analysis)}
+		if err := chunkTemplate.Execute(&section, data); err != nil {
+			slog.Warn("Failed to render SYNTHESIS_CHUNK_TEMPLATE, using the default", "error", err)
+			section.Reset()
+			template.Must(ParseChunkTemplate(DefaultChunkTemplate)).Execute(&section, data)
+		}
+		sections[i] = section.String()
+	}
+
+	return s.Combine + "\n\n" +
+		"Here are the separate analyses, in order:\n\n" +
+		strings.Join(sections, viper.GetString("SYNTHESIS_CHUNK_DELIMITER")) +
+		"\n\n" + synthesize + markdownInstruction
+}
@@ -0,0 +1,180 @@
+// Package servicestest provides a fake Ollama server for tests of the services and the code
+// calling them.
+package servicestest
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode"
+
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+)
+
+// Dimensions is the size of the fake embeddings, the size of the embedding columns
+const Dimensions = 768
+
+// Request is a call the fake Ollama received
+type Request struct {
+	Endpoint string
+	Model    string
+	Prompt   string
+	Images   int
+}
+
+// Ollama is a fake Ollama API answering generate, embeddings and tags calls. Generate calls
+// are answered by its response function, and embeddings with Embedding of the text.
+type Ollama struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	respond  func(prompt string, images int) string
+	status   int
+	message  string
+	models   []string
+	requests []Request
+}
+
+// NewOllama starts a fake Ollama, answering generate calls with a markdown description
+// that names the number of images
+func NewOllama() *Ollama {
+	o := &Ollama{
+		respond: func(prompt string, images int) string {
+			if images == 0 {
+				return "Fake answer"
+			}
+			return "# Fake description\n\nA screenshot of a fake page, in " + strconv.Itoa(images) + " image(s)."
+		},
+		models: []string{"gemma3:latest", "nomic-embed-text:latest"},
+	}
+	o.Server = httptest.NewServer(http.HandlerFunc(o.serve))
+	return o
+}
+
+// Start starts a fake Ollama that is closed when the test ends
+func Start(t testing.TB) *Ollama {
+	t.Helper()
+
+	o := NewOllama()
+	t.Cleanup(o.Close)
+	return o
+}
+
+// Client returns a client calling the fake
+func (o *Ollama) Client() *services.Client {
+	return &services.Client{BaseURL: o.URL + "/api", HTTPClient: o.Server.Client()}
+}
+
+// Clients returns the clients of the services with the fake as both models, reading images
+// from files
+func (o *Ollama) Clients(files storage.Storage) services.Clients {
+	client := o.Client()
+	return services.Clients{Generator: client, Embedder: client, Files: files}
+}
+
+// Respond sets the function answering generate calls
+func (o *Ollama) Respond(respond func(prompt string, images int) string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.respond = respond
+}
+
+// Fail makes every later generate and embeddings call fail with an HTTP status and the
+// error message Ollama would give, such as 404 and `model "llava" not found`. A zero
+// status makes calls succeed again.
+func (o *Ollama) Fail(status int, message string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.status, o.message = status, message
+}
+
+// SetModels sets the models the tags endpoint lists
+func (o *Ollama) SetModels(names ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.models = names
+}
+
+// Requests returns the generate and embeddings calls received so far
+func (o *Ollama) Requests() []Request {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Request(nil), o.requests...)
+}
+
+func (o *Ollama) serve(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	endpoint := strings.TrimPrefix(r.URL.Path, "/api/")
+	if endpoint == "tags" {
+		models := make([]map[string]string, len(o.models))
+		for i, name := range o.models {
+			models[i] = map[string]string{"name": name}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"models": models})
+		return
+	}
+
+	var body struct {
+		Model  string   `json:"model"`
+		Prompt string   `json:"prompt"`
+		Images []string `json:"images"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	o.requests = append(o.requests, Request{Endpoint: endpoint, Model: body.Model, Prompt: body.Prompt, Images: len(body.Images)})
+
+	if o.status != 0 {
+		w.WriteHeader(o.status)
+		json.NewEncoder(w).Encode(map[string]string{"error": o.message})
+		return
+	}
+
+	switch endpoint {
+	case "generate":
+		json.NewEncoder(w).Encode(map[string]any{"model": body.Model, "response": o.respond(body.Prompt, len(body.Images)), "done": true})
+	case "embeddings":
+		json.NewEncoder(w).Encode(map[string]any{"embedding": Embedding(body.Prompt)})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "unknown endpoint " + endpoint})
+	}
+}
+
+// Embedding is the embedding the fake returns for a text: a unit vector counting its words
+// in hashed dimensions, so texts sharing words are nearer than unrelated ones
+func Embedding(text string) []float32 {
+	vector := make([]float32, Dimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vector[h.Sum32()%Dimensions]++
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		vector[0] = 1
+		return vector
+	}
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / math.Sqrt(norm))
+	}
+	return vector
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+	"context"
+	"strings"
+)
+
+// Summarize asks the text model for a one-paragraph summary of a description. Summaries
+// leave out the detail of the full description, so their embeddings often match short
+// search queries better.
+func (c Clients) Summarize(ctx context.Context, description string) (string, error) {
+	prompt := "Summarize the screenshot or image described below in one short paragraph of plain text, " +
+		"keeping what it shows and what the user is doing but leaving out minor details. " +
+		"Answer with the summary only, without markdown.\n\nDescription:\n" + description
+
+	answer, err := c.Generator.Generate(ctx, GenerateOptions{Prompt: prompt})
+	if err != nil {
+		return "", err
+	}
+	summary := strings.Join(strings.Fields(answer), " ")
+	if summary == "" {
+		return "", modelError("model returned an empty summary")
+	}
+	return summary, nil
+}
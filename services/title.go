@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"strings"
+)
+
+// titleMaxWords is the longest title of a record
+const titleMaxWords = 10
+
+// GenerateTitle asks the text model for a short title of a description, for lists and
+// search results that cannot show the whole markdown description
+func (c Clients) GenerateTitle(ctx context.Context, description string) (string, error) {
+	prompt := "Write a short title, at most 10 words, for the screenshot or image described below. " +
+		"Answer with the title only, without quotes or markdown.\n\nDescription:\n" + description
+
+	answer, err := c.Generator.Generate(ctx, GenerateOptions{Prompt: prompt})
+	if err != nil {
+		return "", err
+	}
+	title := cleanTitle(answer)
+	if title == "" {
+		return "", modelError("model returned an empty title")
+	}
+	return title, nil
+}
+
+// FallbackTitle derives a title from the first line of a description, for records whose
+// title could not be generated
+func FallbackTitle(description string) string {
+	return cleanTitle(description)
+}
+
+// cleanTitle keeps the first non-empty line of text without markdown markers or quotes,
+// shortened to titleMaxWords words
+func cleanTitle(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimLeft(strings.TrimSpace(line), "#*->` ")
+		line = strings.NewReplacer("**", "", "__", "", "`", "").Replace(line)
+		line = strings.Trim(strings.TrimSpace(line), `"'“”`)
+		if line == "" {
+			continue
+		}
+
+		words := strings.Fields(line)
+		if len(words) > titleMaxWords {
+			words = words[:titleMaxWords]
+		}
+		return strings.TrimRight(strings.Join(words, " "), ".:;,")
+	}
+	return ""
+}
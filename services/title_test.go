@@ -0,0 +1,21 @@
+package services
+
+import "testing"
+
+func TestCleanTitle(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Login page with error", "Login page with error"},
+		{"\n\n# **Checkout** page\n\nDetails", "Checkout page"},
+		{`"Settings screen."`, "Settings screen"},
+		{"one two three four five six seven eight nine ten eleven twelve", "one two three four five six seven eight nine ten"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := cleanTitle(tt.text); got != tt.want {
+			t.Errorf("cleanTitle(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"context"
+	"strings"
+)
+
+// Translate asks the text model to translate a description into a language, given as a
+// language tag such as de or pt-br, keeping its markdown
+func (c Clients) Translate(ctx context.Context, description string, language string) (string, error) {
+	prompt := "Translate the screenshot or image description below into the language with the tag " + language +
+		". Keep its markdown structure, and keep text shown on the screen, such as button labels, as it appears. " +
+		"Answer with the translation only.\n\nDescription:\n" + description
+
+	answer, err := c.Generator.Generate(ctx, GenerateOptions{Prompt: prompt})
+	if err != nil {
+		return "", err
+	}
+	translation := strings.TrimSpace(answer)
+	if translation == "" {
+		return "", modelError("model returned an empty translation")
+	}
+	return translation, nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Frame is a still of a video at Offset from its start, as JPEG
+type Frame struct {
+	Offset time.Duration
+	Data   []byte
+}
+
+// IsVideo reports whether a media type is a video, described frame by frame with VIDEO_FRAMES
+func IsVideo(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "video/")
+}
+
+// ExtractFrames samples a frame of a video every interval, up to maxFrames, using the
+// external command configured in FFMPEG
+func ExtractFrames(ctx context.Context, r io.Reader, ext string, interval time.Duration, maxFrames int) ([]Frame, error) {
+	// The fps filter keeps the frame nearest to every multiple of interval, starting at 0
+	data, _, err := runFFmpeg(ctx, r, ext, "error", frameOutput,
+		"-vf", "fps=1/"+strconv.FormatFloat(interval.Seconds(), 'f', -1, 64),
+		"-frames:v", strconv.Itoa(maxFrames), "-q:v", "3")
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]Frame, len(data))
+	for i := range data {
+		frames[i] = Frame{Offset: time.Duration(i) * interval, Data: data[i]}
+	}
+	return frames, nil
+}
+
+// ExtractScenes cuts a video into scenes where the picture changes by more than threshold,
+// between 0 and 1, and returns the first frame of each scene, up to maxScenes, using the
+// external command configured in FFMPEG. The first scene starts at the start of the video.
+func ExtractScenes(ctx context.Context, r io.Reader, ext string, threshold float64, maxScenes int) ([]Frame, error) {
+	// The select filter keeps the first frame and every frame whose scene score is above the
+	// threshold, and showinfo logs the time of each kept frame
+	data, log, err := runFFmpeg(ctx, r, ext, "info", frameOutput,
+		"-vf", "select='eq(n,0)+gt(scene,"+strconv.FormatFloat(threshold, 'f', -1, 64)+")',showinfo",
+		"-fps_mode", "vfr", "-frames:v", strconv.Itoa(maxScenes), "-q:v", "3")
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := parseShowinfoTimes(log)
+	if len(offsets) < len(data) {
+		return nil, fmt.Errorf("found the times of %d of %d scenes", len(offsets), len(data))
+	}
+	scenes := make([]Frame, len(data))
+	for i := range data {
+		scenes[i] = Frame{Offset: offsets[i], Data: data[i]}
+	}
+	return scenes, nil
+}
+
+// GeneratePreview makes a short animated GIF of the first duration of a video, width pixels
+// wide at fps frames per second, using the external command configured in FFMPEG. Search UIs
+// show it as a motion preview of the video.
+func GeneratePreview(ctx context.Context, r io.Reader, ext string, duration time.Duration, width int, fps int) ([]byte, error) {
+	// A palette generated from the clip itself keeps the colors of the GIF close to the video
+	data, _, err := runFFmpeg(ctx, r, ext, "error", "preview.gif",
+		"-t", strconv.FormatFloat(duration.Seconds(), 'f', -1, 64), "-an",
+		"-vf", fmt.Sprintf("fps=%d,scale=%d:-2:flags=lanczos,split[a][b];[a]palettegen[p];[b][p]paletteuse", fps, width),
+		"-loop", "0")
+	if err != nil {
+		return nil, err
+	}
+	return data[0], nil
+}
+
+// frameOutput is the output of FFMPEG runs writing a JPEG per frame
+const frameOutput = "frame-%06d.jpg"
+
+// showinfoTime matches the presentation time of a frame logged by the showinfo filter
+var showinfoTime = regexp.MustCompile(`pts_time:\s*([0-9.]+)`)
+
+// parseShowinfoTimes reads the times of the frames logged by the showinfo filter, in order
+func parseShowinfoTimes(log string) []time.Duration {
+	var offsets []time.Duration
+	for _, line := range strings.Split(log, "\n") {
+		if !strings.Contains(line, "showinfo") {
+			continue
+		}
+		match := showinfoTime.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+		offsets = append(offsets, time.Duration(seconds*float64(time.Second)).Round(time.Millisecond))
+	}
+	return offsets
+}
+
+// runFFmpeg writes a video to a temporary file, runs the external command configured in
+// FFMPEG on it with the output args, logging at logLevel, and returns the files it wrote to
+// output, a pattern such as frame-%06d.jpg for one file per frame, in order, and its log
+func runFFmpeg(ctx context.Context, r io.Reader, ext, logLevel string, output string, outputArgs ...string) ([][]byte, string, error) {
+	command := strings.Fields(viper.GetString("FFMPEG"))
+	if len(command) == 0 {
+		command = []string{"ffmpeg"}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "frames-*")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	inPath := filepath.Join(tmpDir, "input"+ext)
+	in, err := os.Create(inPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := io.Copy(in, r); err != nil {
+		in.Close()
+		return nil, "", err
+	}
+	in.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	args := append(command[1:], "-hide_banner", "-nostats", "-loglevel", logLevel, "-i", inPath)
+	args = append(args, outputArgs...)
+	args = append(args, filepath.Join(tmpDir, output))
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, "", fmt.Errorf("failed to extract frames with %s: %v: %s", command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	paths, err := filepath.Glob(filepath.Join(tmpDir, strings.ReplaceAll(output, "%06d", "*")))
+	if err != nil {
+		return nil, "", err
+	}
+	slices.Sort(paths)
+
+	files := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		files = append(files, data)
+	}
+	if len(files) == 0 {
+		return nil, "", fmt.Errorf("no frames extracted with %s", command[0])
+	}
+	return files, stderr.String(), nil
+}
+
+// FormatTimestamp writes an offset into a video as minutes and seconds, such as 01:23, with
+// hours for offsets past the first hour
+func FormatTimestamp(offset time.Duration) string {
+	seconds := int(offset / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+}
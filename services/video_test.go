@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatTimestamp(t *testing.T) {
+	for offset, want := range map[time.Duration]string{
+		0:                                     "00:00",
+		83*time.Second + 400*time.Millisecond: "01:23",
+		59*time.Minute + 59*time.Second:       "59:59",
+		2*time.Hour + 5*time.Minute + time.Second: "2:05:01",
+	} {
+		if got := FormatTimestamp(offset); got != want {
+			t.Errorf("FormatTimestamp(%s) = %q, want %q", offset, got, want)
+		}
+	}
+}
+
+func TestParseShowinfoTimes(t *testing.T) {
+	log := `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'input.mp4':
+[Parsed_showinfo_1 @ 0x55d5] config in time_base: 1/15360, frame_rate: 30/1
+[Parsed_showinfo_1 @ 0x55d5] n:   0 pts:      0 pts_time:0       duration:    512 fmt:yuv420p
+[Parsed_showinfo_1 @ 0x55d5] n:   1 pts: 645120 pts_time:42      duration:    512 fmt:yuv420p
+[Parsed_showinfo_1 @ 0x55d5] n:   2 pts:1936896 pts_time:126.1   duration:    512 fmt:yuv420p
+`
+	want := []time.Duration{0, 42 * time.Second, 126*time.Second + 100*time.Millisecond}
+	got := parseShowinfoTimes(log)
+	if len(got) != len(want) {
+		t.Fatalf("parseShowinfoTimes() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseShowinfoTimes()[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/auth"
+	"github.com/pablobfonseca/go-image-vector/httpcache"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// shareMaxBodyBytes bounds the body of a share request
+const shareMaxBodyBytes = 4 << 10
+
+// shareRequest shares a single record by ID, or a batch by its batch ID
+type shareRequest struct {
+	RecordID uint   `json:"record_id,omitempty"`
+	BatchID  string `json:"batch_id,omitempty"`
+	// ExpiresIn is a duration such as 72h, SHARE_DEFAULT_TTL when empty
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// requireAPIKey answers requests without one of API_KEYS with 401 and reports whether r may go on
+func requireAPIKey(w http.ResponseWriter, r *http.Request, action string) bool {
+	if auth.Authenticated(r) {
+		return true
+	}
+	apierror.Write(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, action+" needs an API key in the Authorization header"))
+	return false
+}
+
+// createShare creates an expiring token giving read-only access to a record or a batch, its
+// images and journey report, to people without an API key
+func (s *server) createShare(w http.ResponseWriter, r *http.Request) {
+	if !requireAPIKey(w, r, "Sharing") {
+		return
+	}
+
+	var req shareRequest
+	if err := decodeJSON(w, r, shareMaxBodyBytes, &req); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	if (req.RecordID == 0) == (req.BatchID == "") {
+		apierror.Write(w, r, apierror.BadRequest("Share either a record_id or a batch_id"))
+		return
+	}
+
+	ttl := viper.GetDuration("SHARE_DEFAULT_TTL")
+	if req.ExpiresIn != "" {
+		parsed, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || parsed <= 0 {
+			apierror.Write(w, r, apierror.InvalidParameter("expires_in", "expires_in must be a positive duration such as 72h"))
+			return
+		}
+		ttl = parsed
+	}
+	if maxTTL := viper.GetDuration("SHARE_MAX_TTL"); ttl > maxTTL {
+		apierror.Write(w, r, apierror.InvalidParameter("expires_in", fmt.Sprintf("expires_in must be at most %s", maxTTL)))
+		return
+	}
+
+	share := &queue.Share{RecordID: req.RecordID, BatchID: req.BatchID}
+	if _, err := s.sharedRecord(r.Context(), share); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierror.Write(w, r, apierror.NotFound("Record to share not found"))
+			return
+		}
+		apierror.Write(w, r, apierror.Internal("Failed to load record to share", err))
+		return
+	}
+
+	if err := s.queue.CreateShare(share, ttl); err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to create share", err))
+		return
+	}
+	slog.InfoContext(r.Context(), "Created share", "record_id", share.RecordID, "batch_id", share.BatchID, "expires_at", share.ExpiresAt)
+
+	response := map[string]any{
+		"token":      share.Token,
+		"url":        shareURL(r, share.Token),
+		"expires_at": share.ExpiresAt,
+	}
+	if share.BatchID != "" {
+		response["batch_id"] = share.BatchID
+		response["report_url"] = shareURL(r, share.Token) + "/report"
+	} else {
+		response["record_id"] = share.RecordID
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// getShare returns the shared record or batch, linking its images through the share
+func (s *server) getShare(w http.ResponseWriter, r *http.Request) {
+	share, record, ok := s.loadShare(w, r)
+	if !ok {
+		return
+	}
+
+	link := func(filePath string) string {
+		return sharedFileURL(r, share.Token, filePath)
+	}
+	response := map[string]any{
+		"token":      share.Token,
+		"expires_at": share.ExpiresAt,
+	}
+
+	if share.BatchID == "" {
+		response["record"] = map[string]any{
+			"id":            record.ID,
+			"original_name": record.OriginalName,
+			"media_type":    record.MediaType,
+			"text":          record.Text,
+			"caption":       record.Caption,
+			"created_at":    record.CreatedAt,
+			"url":           link(record.FilePath),
+		}
+	} else {
+		paths := batchMemberPaths(record)
+		images := make([]map[string]any, len(paths))
+		for i, filePath := range paths {
+			images[i] = map[string]any{"position": i + 1, "url": link(filePath)}
+			if i == 0 && record.OriginalName != "" {
+				images[i]["original_name"] = record.OriginalName
+			}
+		}
+		response["batch"] = map[string]any{
+			"id":            record.ID,
+			"batch_id":      record.BatchID,
+			"original_name": record.OriginalName,
+			"text":          record.Text,
+			"created_at":    record.CreatedAt,
+			"file_count":    len(paths),
+			"images":        images,
+		}
+		response["report_url"] = shareURL(r, share.Token) + "/report"
+	}
+
+	// Shares can be revoked, so they are kept by the client only
+	httpcache.WriteJSON(w, r, false, response)
+}
+
+// getShareReport renders the journey report of a shared batch, linking its screens through the share
+func (s *server) getShareReport(w http.ResponseWriter, r *http.Request) {
+	format, err := reportFormat(r)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	share, record, ok := s.loadShare(w, r)
+	if !ok {
+		return
+	}
+	if share.BatchID == "" {
+		apierror.Write(w, r, apierror.NotFound("Shared record is not a batch, it has no journey report"))
+		return
+	}
+
+	s.writeBatchReport(w, r, record, format, func(filePath string) string {
+		return sharedFileURL(r, share.Token, filePath)
+	})
+}
+
+// deleteShare revokes a share before it expires
+func (s *server) deleteShare(w http.ResponseWriter, r *http.Request) {
+	if !requireAPIKey(w, r, "Revoking shares") {
+		return
+	}
+
+	token := mux.Vars(r)["token"]
+	if err := s.queue.DeleteShare(token); err != nil {
+		if errors.Is(err, queue.ErrShareNotFound) {
+			apierror.Write(w, r, apierror.NotFound("Share not found"))
+			return
+		}
+		apierror.Write(w, r, apierror.Internal("Failed to revoke share", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"token": token, "revoked": true})
+}
+
+// loadShare loads the share of the token in the URL with its record, answering tokens that
+// expired, were revoked or whose record was deleted with 404
+func (s *server) loadShare(w http.ResponseWriter, r *http.Request) (*queue.Share, models.ImageEmbedding, bool) {
+	share, err := s.queue.GetShare(mux.Vars(r)["token"])
+	if errors.Is(err, queue.ErrShareNotFound) {
+		apierror.Write(w, r, apierror.NotFound("Share not found, it may have expired or been revoked"))
+		return nil, models.ImageEmbedding{}, false
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load share", err))
+		return nil, models.ImageEmbedding{}, false
+	}
+
+	record, err := s.sharedRecord(r.Context(), share)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Write(w, r, apierror.NotFound("Shared record no longer exists"))
+		return nil, models.ImageEmbedding{}, false
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load shared record", err))
+		return nil, models.ImageEmbedding{}, false
+	}
+	return share, record, true
+}
+
+// sharedRecord loads the record of a share, whatever its visibility
+func (s *server) sharedRecord(ctx context.Context, share *queue.Share) (models.ImageEmbedding, error) {
+	if share.BatchID != "" {
+		return s.findBatch(s.db.WithContext(ctx), share.BatchID)
+	}
+
+	var record models.ImageEmbedding
+	err := s.db.WithContext(ctx).Omit("embedding").First(&record, share.RecordID).Error
+	return record, err
+}
+
+// isSharedFile reports whether the share of token covers the file at filePath: the file or
+// original or a frame of a shared record, or an image of a shared batch
+func (s *server) isSharedFile(ctx context.Context, token string, filePath string) (bool, error) {
+	share, err := s.queue.GetShare(token)
+	if errors.Is(err, queue.ErrShareNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	record, err := s.sharedRecord(ctx, share)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if share.BatchID != "" {
+		return slices.Contains(batchMemberPaths(record), filePath), nil
+	}
+	if filePath == record.FilePath || record.OriginalPath != "" && filePath == record.OriginalPath ||
+		record.PreviewPath != "" && filePath == record.PreviewPath {
+		return true, nil
+	}
+
+	// The frames of a shared video are its thumbnails
+	var frames int64
+	err = s.db.WithContext(ctx).Model(&models.VideoFrame{}).Where("record_id = ? AND file_path = ?", record.ID, filePath).
+		Limit(1).Count(&frames).Error
+	return frames > 0, err
+}
+
+// shareURL is the absolute URL of a share
+func shareURL(r *http.Request, token string) string {
+	return baseURL(r) + "/api/v1/shares/" + url.PathEscape(token)
+}
+
+// sharedFileURL is the absolute URL of a stored file, readable through the share of token
+func sharedFileURL(r *http.Request, token string, filePath string) string {
+	return fileURL(r, filePath) + "?share=" + url.QueryEscape(token)
+}
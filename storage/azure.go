@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const azureAPIVersion = "2021-08-06"
+
+// AzureConfig holds the settings for an Azure Blob Storage backend
+type AzureConfig struct {
+	Account   string
+	Key       string
+	Container string
+	Prefix    string
+	// Endpoint overrides the default https://<account>.blob.core.windows.net, e.g. for Azurite
+	Endpoint string
+}
+
+// AzureStorage keeps files as block blobs in an Azure Storage container,
+// authenticating with the account's shared key
+type AzureStorage struct {
+	config AzureConfig
+	key    []byte
+	client *http.Client
+}
+
+// NewAzureStorage creates an Azure Blob storage from the given configuration
+func NewAzureStorage(config AzureConfig) (*AzureStorage, error) {
+	if config.Account == "" || config.Key == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY are required")
+	}
+	if config.Container == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_CONTAINER is required")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("AZURE_STORAGE_KEY is not valid base64: %v", err)
+	}
+
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", config.Account)
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	return &AzureStorage{
+		config: config,
+		key:    key,
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *AzureStorage) blobURL(key string) string {
+	name := strings.TrimPrefix(key, "/")
+	if s.config.Prefix != "" {
+		name = s.config.Prefix + "/" + name
+	}
+	return s.config.Endpoint + "/" + s.config.Container + "/" + uriEncode(name)
+}
+
+func (s *AzureStorage) do(ctx context.Context, method, key string, body io.Reader, size int64, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.blobURL(key), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	s.sign(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Azure Blob Storage at %s: %v", s.config.Endpoint, err)
+	}
+	return resp, nil
+}
+
+func (s *AzureStorage) Save(ctx context.Context, key string, r io.Reader) error {
+	// Stream seekable readers such as spooled uploads, buffer anything else
+	size, ok := seekableSize(r)
+	if !ok {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(body), int64(len(body))
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, map[string]string{
+		"x-ms-blob-type": "BlockBlob",
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return azureError(resp)
+	}
+	return nil
+}
+
+func (s *AzureStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, azureError(resp)
+	}
+}
+
+func (s *AzureStorage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		return azureError(resp)
+	}
+	return nil
+}
+
+func (s *AzureStorage) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, azureError(resp)
+	}
+}
+
+// sign adds a Shared Key Authorization header to the request
+func (s *AzureStorage) sign(req *http.Request) {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var msHeaders []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower)
+		}
+	}
+	sort.Strings(msHeaders)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range msHeaders {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, superseded by x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalHeaders.String() + "/" + s.config.Account + req.URL.EscapedPath(),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", s.config.Account, signature))
+}
+
+func azureError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("azure blob request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsAPIURL           = "https://storage.googleapis.com"
+	gcsMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// GCSConfig holds the settings for a Google Cloud Storage backend
+type GCSConfig struct {
+	Bucket string
+	Prefix string
+}
+
+// GCSStorage keeps files in a Google Cloud Storage bucket, authenticating
+// with the workload identity exposed by the GCE/GKE metadata server
+type GCSStorage struct {
+	config GCSConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewGCSStorage creates a GCS storage from the given configuration
+func NewGCSStorage(config GCSConfig) (*GCSStorage, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("GCS_BUCKET is required")
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	return &GCSStorage{
+		config: config,
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *GCSStorage) objectName(key string) string {
+	name := strings.TrimPrefix(key, "/")
+	if s.config.Prefix != "" {
+		name = s.config.Prefix + "/" + name
+	}
+	return name
+}
+
+// accessToken returns a cached OAuth token, refreshing it from the metadata server when close to expiry
+func (s *GCSStorage) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Until(s.tokenExpiry) > time.Minute {
+		return s.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch token from metadata server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", gcsError(resp)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse metadata token: %v", err)
+	}
+
+	s.token = token.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+func (s *GCSStorage) do(ctx context.Context, method, rawURL string, body io.Reader) (*http.Response, error) {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call GCS: %v", err)
+	}
+	return resp, nil
+}
+
+func (s *GCSStorage) objectURL(key string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", gcsAPIURL,
+		url.PathEscape(s.config.Bucket), url.PathEscape(s.objectName(key)))
+}
+
+func (s *GCSStorage) Save(ctx context.Context, key string, r io.Reader) error {
+	uploadURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", gcsAPIURL,
+		url.PathEscape(s.config.Bucket), url.QueryEscape(s.objectName(key)))
+
+	resp, err := s.do(ctx, http.MethodPost, uploadURL, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return gcsError(resp)
+	}
+	return nil
+}
+
+func (s *GCSStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, gcsError(resp)
+	}
+}
+
+func (s *GCSStorage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusNotFound {
+		return gcsError(resp)
+	}
+	return nil
+}
+
+func (s *GCSStorage) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, gcsError(resp)
+	}
+}
+
+func gcsError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("GCS request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sniffLen is the number of leading bytes inspected to detect a file's type
+const sniffLen = 512
+
+// mediaTypeExtensions maps detected MIME types to the extension used for storage keys
+var mediaTypeExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"image/heic":      ".heic",
+	"image/avif":      ".avif",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"video/quicktime": ".mov",
+}
+
+// DetectMediaType sniffs the MIME type of r from its leading bytes, ignoring any
+// client-supplied name or header. The reader is rewound afterwards.
+func DetectMediaType(r io.ReadSeeker) (string, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return detectMediaType(header[:n]), nil
+}
+
+func detectMediaType(header []byte) string {
+	// ISO base media files (MP4, QuickTime, HEIF, AVIF) carry their brand in an ftyp box
+	if len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")) {
+		switch string(header[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+			return "image/heic"
+		case "avif", "avis":
+			return "image/avif"
+		case "qt  ":
+			return "video/quicktime"
+		default:
+			return "video/mp4"
+		}
+	}
+
+	contentType := http.DetectContentType(header)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return contentType
+}
+
+// IsAllowedMediaType reports whether mediaType is in the allow-list, whose
+// entries may themselves be comma-separated lists as read from env files
+func IsAllowedMediaType(mediaType string, allowed []string) bool {
+	for _, entry := range allowed {
+		for _, candidate := range strings.Split(entry, ",") {
+			if strings.EqualFold(strings.TrimSpace(candidate), mediaType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MediaTypeExtension returns the storage key extension for a detected MIME type
+func MediaTypeExtension(mediaType string) string {
+	return mediaTypeExtensions[mediaType]
+}
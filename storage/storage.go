@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/httpcache"
+	"github.com/pablobfonseca/go-image-vector/logging"
+	"github.com/spf13/viper"
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+	BackendGCS   = "gcs"
+	BackendAzure = "azure"
+
+	// DefaultRoute is the URL path stored files are served under
+	DefaultRoute = "/uploads/"
+
+	// QuarantinePrefix is prepended to the keys of files flagged by the upload scanner
+	QuarantinePrefix = "quarantine/"
+)
+
+// route is the configured URL path stored files are served under, always with surrounding slashes
+var route = DefaultRoute
+
+// ErrNotFound is returned when a key does not exist in the backend
+var ErrNotFound = errors.New("file not found in storage")
+
+// ErrInvalidKey is returned when a key could escape the storage root or prefix
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage is a backend able to persist and serve uploaded files
+type Storage interface {
+	Save(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// Initialize sets up the storage backend selected by STORAGE_BACKEND and returns it
+func Initialize() Storage {
+	backend := viper.GetString("STORAGE_BACKEND")
+	if backend == "" {
+		backend = BackendLocal
+	}
+
+	route = "/" + strings.Trim(viper.GetString("UPLOADS_ROUTE"), "/") + "/"
+	if route == "//" {
+		route = DefaultRoute
+	}
+
+	var store Storage
+	var err error
+	switch backend {
+	case BackendLocal:
+		dir := viper.GetString("UPLOADS_DIR")
+		if dir == "" {
+			dir = "./uploads"
+		}
+		store, err = NewLocalStorage(dir)
+	case BackendS3:
+		store, err = NewS3Storage(S3Config{
+			Endpoint:  viper.GetString("S3_ENDPOINT"),
+			Region:    viper.GetString("S3_REGION"),
+			Bucket:    viper.GetString("S3_BUCKET"),
+			Prefix:    viper.GetString("S3_PREFIX"),
+			AccessKey: viper.GetString("S3_ACCESS_KEY"),
+			SecretKey: viper.GetString("S3_SECRET_KEY"),
+			UseSSL:    viper.GetBool("S3_USE_SSL"),
+		})
+	case BackendGCS:
+		store, err = NewGCSStorage(GCSConfig{
+			Bucket: viper.GetString("GCS_BUCKET"),
+			Prefix: viper.GetString("GCS_PREFIX"),
+		})
+	case BackendAzure:
+		store, err = NewAzureStorage(AzureConfig{
+			Account:   viper.GetString("AZURE_STORAGE_ACCOUNT"),
+			Key:       viper.GetString("AZURE_STORAGE_KEY"),
+			Container: viper.GetString("AZURE_STORAGE_CONTAINER"),
+			Prefix:    viper.GetString("AZURE_STORAGE_PREFIX"),
+			Endpoint:  viper.GetString("AZURE_STORAGE_ENDPOINT"),
+		})
+	default:
+		logging.Fatal("Unknown storage backend", "backend", backend)
+	}
+
+	if err != nil {
+		logging.Fatal("Failed to initialize storage", "backend", backend, "error", err)
+	}
+
+	slog.Info("Storage initialized", "backend", backend)
+	return store
+}
+
+// Route returns the URL path stored files are served under, e.g. "/uploads/"
+func Route() string {
+	return route
+}
+
+// Path returns the file path recorded for a key, relative to the API base URL
+func Path(key string) string {
+	return strings.TrimPrefix(route, "/") + key
+}
+
+// Key extracts the storage key from a recorded file path
+func Key(filePath string) string {
+	filePath = strings.TrimPrefix(filePath, "./")
+	filePath = strings.TrimPrefix(filePath, "/")
+	if prefix := strings.TrimPrefix(route, "/"); strings.HasPrefix(filePath, prefix) {
+		return strings.TrimPrefix(filePath, prefix)
+	}
+	// Records created before the route was configurable
+	return strings.TrimPrefix(filePath, strings.TrimPrefix(DefaultRoute, "/"))
+}
+
+// ValidKey reports whether key is a relative, slash-separated path without empty, "." or
+// ".." segments, backslashes or control characters, so it stays inside every backend's
+// root or prefix whatever the key was built from
+func ValidKey(key string) bool {
+	if key == "" || strings.Contains(key, `\`) {
+		return false
+	}
+	for _, r := range key {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// HashKey builds a content-addressed key from a hex sha256 digest, using the
+// extension of the detected media type
+func HashKey(sum string, mediaType string) string {
+	return sum + MediaTypeExtension(mediaType)
+}
+
+// SaveIfMissing stores r under key in store unless a file with that key already exists,
+// reporting whether an existing blob was reused
+func SaveIfMissing(ctx context.Context, store Storage, key string, r io.Reader) (bool, error) {
+	if !ValidKey(key) {
+		return false, ErrInvalidKey
+	}
+
+	exists, err := store.Exists(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return true, nil
+	}
+
+	return false, store.Save(ctx, key, r)
+}
+
+// seekableSize returns the remaining length of r when it can be determined without
+// reading it, so backends can stream uploads instead of buffering them
+func seekableSize(r io.Reader) (int64, bool) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return 0, false
+	}
+
+	return end - current, true
+}
+
+// ReadFile reads the whole file behind a recorded file path from store
+func ReadFile(ctx context.Context, store Storage, filePath string) ([]byte, error) {
+	if store == nil {
+		return nil, errors.New("storage not initialized")
+	}
+
+	rc, err := store.Open(ctx, Key(filePath))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// Access is how a request may read a stored file
+type Access int
+
+const (
+	// AccessDenied files are not found
+	AccessDenied Access = iota
+	// AccessPrivate files are served for the request alone and kept by the client only
+	AccessPrivate
+	// AccessPublic files are served to anyone and may be kept by shared caches such as CDNs
+	AccessPublic
+)
+
+// Handler serves the files of store, expecting the key as the remainder of the URL path. allow
+// decides how the request may read the file. Keys are written once, so a file is tagged
+// by its key and cached for FILES_CACHE_MAX_AGE, which bounds how long a file made private
+// stays in caches.
+func Handler(store Storage, allow func(r *http.Request, key string) (Access, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if !ValidKey(key) || strings.HasPrefix(key, QuarantinePrefix) {
+			apierror.Write(w, r, apierror.NotFound("File not found"))
+			return
+		}
+
+		access, err := allow(r, key)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal("Failed to check file access", err))
+			return
+		}
+		if access == AccessDenied {
+			apierror.Write(w, r, apierror.NotFound("File not found"))
+			return
+		}
+
+		etag := httpcache.ETag([]byte(key))
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", httpcache.CacheControl(access == AccessPublic, viper.GetDuration("FILES_CACHE_MAX_AGE")))
+		if httpcache.NotModified(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		rc, err := store.Open(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				apierror.Write(w, r, apierror.NotFound("File not found"))
+				return
+			}
+			apierror.Write(w, r, apierror.Internal("Failed to read file", err))
+			return
+		}
+		defer rc.Close()
+
+		if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		io.Copy(w, rc)
+	})
+}
@@ -0,0 +1,69 @@
+// Package storagetest provides an in-memory storage backend for tests.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pablobfonseca/go-image-vector/storage"
+)
+
+// Memory is a storage backend keeping files in memory
+type Memory struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemory returns an empty in-memory storage backend
+func NewMemory() *Memory {
+	return &Memory{files: map[string][]byte{}}
+}
+
+func (m *Memory) Save(ctx context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[key] = data
+	return nil
+}
+
+func (m *Memory) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, key)
+	return nil
+}
+
+func (m *Memory) Exists(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.files[key]
+	return ok, nil
+}
+
+// Keys returns the keys of the stored files
+func (m *Memory) Keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.files))
+	for key := range m.files {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/httpcache"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/spf13/viper"
+)
+
+// Timeline dates group records by when the photo was taken, falling back to the upload
+// time, or by the upload time alone
+const (
+	timelineDateCaptured = "captured"
+	timelineDateUploaded = "uploaded"
+)
+
+// timelineIntervals are the bucket sizes, as PostgreSQL date_trunc fields
+var timelineIntervals = map[string]bool{"day": true, "week": true, "month": true, "year": true}
+
+// timelineMaxThumbnails caps the thumbnails a request can ask for per bucket
+const timelineMaxThumbnails = 50
+
+// timelineRow is one representative record of a bucket, with the totals of the bucket
+type timelineRow struct {
+	Bucket       time.Time
+	Total        int
+	ID           uint
+	FilePath     string
+	OriginalName string
+	Title        string
+	MediaType    string
+	IsBatch      bool
+	DatedAt      time.Time
+}
+
+// getTimeline groups records into time buckets, newest first, with the number of records
+// in each bucket and its most recent records as thumbnails
+func (s *server) getTimeline(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := allowQueryParams(query, "interval", "date", "kind", "limit", "thumbnails", "since", "until"); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = "month"
+	}
+	if !timelineIntervals[interval] {
+		apierror.Write(w, r, apierror.InvalidParameter("interval", "interval must be one of day, week, month or year"))
+		return
+	}
+
+	// Both are whitelisted, so they can be written into the statement
+	date := "COALESCE(taken_at, created_at)"
+	switch query.Get("date") {
+	case "", timelineDateCaptured:
+	case timelineDateUploaded:
+		date = "created_at"
+	default:
+		apierror.Write(w, r, apierror.InvalidParameter("date", "date must be captured or uploaded"))
+		return
+	}
+
+	kind := query.Get("kind")
+	if !validSearchKind(kind) {
+		apierror.Write(w, r, apierror.InvalidParameter("kind", "kind must be one of all, batch or image"))
+		return
+	}
+
+	buckets := viper.GetInt("TIMELINE_MAX_BUCKETS")
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			apierror.Write(w, r, apierror.InvalidParameter("limit", "limit must be a positive integer"))
+			return
+		}
+		buckets = min(parsed, buckets)
+	}
+
+	thumbnails := viper.GetInt("TIMELINE_THUMBNAILS")
+	if value := query.Get("thumbnails"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > timelineMaxThumbnails {
+			apierror.Write(w, r, apierror.InvalidParameter("thumbnails", fmt.Sprintf("thumbnails must be between 0 and %d", timelineMaxThumbnails)))
+			return
+		}
+		thumbnails = parsed
+	}
+
+	conditions := "TRUE"
+	var args []any
+	switch kind {
+	case searchKindBatch:
+		conditions += " AND is_batch"
+	case searchKindImage:
+		conditions += " AND NOT is_batch"
+	}
+	if publicOnly(r) {
+		conditions += " AND visibility = ?"
+		args = append(args, models.VisibilityPublic)
+	}
+	for _, bound := range []struct{ param, operator string }{
+		{"since", ">="},
+		{"until", "<"},
+	} {
+		if value := query.Get(bound.param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				apierror.Write(w, r, apierror.InvalidParameter(bound.param, bound.param+" must be an RFC 3339 time such as 2024-01-31T00:00:00Z"))
+				return
+			}
+			conditions += fmt.Sprintf(" AND %s %s ?", date, bound.operator)
+			args = append(args, t)
+		}
+	}
+
+	// Buckets are truncated in UTC so they do not depend on the session time zone. Every
+	// bucket keeps at least one row to carry its total, even when no thumbnails are wanted.
+	statement := fmt.Sprintf(`
+		SELECT bucket, total, id, file_path, original_name, title, media_type, is_batch, dated_at
+		FROM (
+			SELECT *,
+				count(*) OVER (PARTITION BY bucket) AS total,
+				row_number() OVER (PARTITION BY bucket ORDER BY dated_at DESC, id DESC) AS position,
+				dense_rank() OVER (ORDER BY bucket DESC) AS bucket_rank
+			FROM (
+				SELECT id, file_path, original_name, title, media_type, is_batch, %[1]s AS dated_at,
+					date_trunc('%[2]s', %[1]s AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket
+				FROM image_embeddings
+				WHERE %[3]s
+			) dated
+		) ranked
+		WHERE bucket_rank <= ? AND position <= ?
+		ORDER BY bucket DESC, position`, date, interval, conditions)
+	args = append(args, buckets, max(thumbnails, 1))
+
+	var rows []timelineRow
+	if err := s.db.WithContext(r.Context()).Raw(statement, args...).Scan(&rows).Error; err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load timeline", err))
+		return
+	}
+
+	timeline := []map[string]any{}
+	for i, row := range rows {
+		if i == 0 || !row.Bucket.Equal(rows[i-1].Bucket) {
+			timeline = append(timeline, map[string]any{
+				"start":      row.Bucket.UTC(),
+				"count":      row.Total,
+				"thumbnails": []map[string]any{},
+			})
+		}
+		if thumbnails == 0 {
+			continue
+		}
+
+		bucket := timeline[len(timeline)-1]
+		bucket["thumbnails"] = append(bucket["thumbnails"].([]map[string]any), map[string]any{
+			"id":            row.ID,
+			"file_path":     row.FilePath,
+			"original_name": row.OriginalName,
+			"title":         row.Title,
+			"media_type":    row.MediaType,
+			"is_batch":      row.IsBatch,
+			"date":          row.DatedAt,
+		})
+	}
+
+	httpcache.WriteJSON(w, r, publicOnly(r), map[string]any{
+		"interval":  interval,
+		"truncated": len(timeline) == buckets,
+		"buckets":   timeline,
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configureTLS prepares srv for HTTPS. With autocert, certificates are obtained from
+// Let's Encrypt for the configured domains and cached on disk. It returns the server
+// redirecting plain HTTP to HTTPS, or nil when TLS_REDIRECT_ADDR is not set.
+func configureTLS(cfg *config.Config, srv *http.Server) *http.Server {
+	var redirect http.Handler = http.HandlerFunc(redirectToHTTPS(cfg.Port))
+
+	if len(cfg.TLSAutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(cfg.TLSAutocertCacheDir),
+			Email:      cfg.TLSAutocertEmail,
+		}
+		srv.TLSConfig = manager.TLSConfig()
+
+		// Answer HTTP-01 challenges on the redirect port and redirect everything else
+		redirect = manager.HTTPHandler(redirect)
+	}
+
+	if cfg.TLSRedirectAddr == "" {
+		return nil
+	}
+
+	return &http.Server{
+		Addr:              cfg.TLSRedirectAddr,
+		Handler:           redirect,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// redirectToHTTPS redirects requests to the same URL on the HTTPS port
+func redirectToHTTPS(port string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	}
+}
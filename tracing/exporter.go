@@ -0,0 +1,184 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// exporter is the active OTLP exporter, nil when tracing is disabled
+var exporter *otlpExporter
+
+// otlpExporter batches finished spans and sends them to an OTLP/HTTP collector as JSON
+type otlpExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+
+	mu      sync.Mutex
+	pending []*Span
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Initialize enables tracing when OTEL_EXPORTER_OTLP_ENDPOINT is set, exporting spans
+// under OTEL_SERVICE_NAME (or defaultServiceName). The returned function flushes and stops the exporter.
+func Initialize(defaultServiceName string) func() {
+	endpoint := viper.GetString("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return func() {}
+	}
+
+	serviceName := viper.GetString("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	exporter = &otlpExporter{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go exporter.run()
+
+	slog.Info("Tracing enabled", "endpoint", exporter.endpoint, "service", serviceName)
+
+	return func() {
+		close(exporter.stop)
+		<-exporter.done
+	}
+}
+
+func (e *otlpExporter) add(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending = append(e.pending, span)
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			e.flush()
+			return
+		case <-ticker.C:
+			e.flush()
+		}
+	}
+}
+
+func (e *otlpExporter) flush() {
+	e.mu.Lock()
+	spans := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(e.payload(spans))
+	if err != nil {
+		slog.Error("Failed to encode spans", "error", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Failed to export spans", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		slog.Error("Failed to export spans", "error", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		slog.Error("Failed to export spans", "status", resp.StatusCode)
+	}
+}
+
+// payload builds an OTLP ExportTraceServiceRequest in its JSON encoding
+func (e *otlpExporter) payload(spans []*Span) map[string]any {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		entry := map[string]any{
+			"traceId":           hex.EncodeToString(span.TraceID[:]),
+			"spanId":            hex.EncodeToString(span.SpanID[:]),
+			"name":              span.Name,
+			"kind":              int(span.Kind),
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.end.UnixNano(), 10),
+			"attributes":        encodeAttributes(span.attributes),
+		}
+		if span.ParentID != [8]byte{} {
+			entry["parentSpanId"] = hex.EncodeToString(span.ParentID[:])
+		}
+		if span.err != nil {
+			entry["status"] = map[string]any{"code": 2, "message": span.err.Error()}
+		}
+		span.mu.Unlock()
+
+		encoded = append(encoded, entry)
+	}
+
+	return map[string]any{
+		"resourceSpans": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": encodeAttributes(map[string]any{"service.name": e.serviceName}),
+			},
+			"scopeSpans": []map[string]any{{
+				"scope": map[string]any{"name": "github.com/pablobfonseca/go-image-vector"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+func encodeAttributes(attributes map[string]any) []map[string]any {
+	encoded := make([]map[string]any, 0, len(attributes))
+	for key, value := range attributes {
+		var otlpValue map[string]any
+		switch v := value.(type) {
+		case string:
+			otlpValue = map[string]any{"stringValue": v}
+		case bool:
+			otlpValue = map[string]any{"boolValue": v}
+		case int:
+			otlpValue = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			otlpValue = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case uint:
+			otlpValue = map[string]any{"intValue": strconv.FormatUint(uint64(v), 10)}
+		case float64:
+			otlpValue = map[string]any{"doubleValue": v}
+		default:
+			otlpValue = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]any{"key": key, "value": otlpValue})
+	}
+	return encoded
+}
@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, such as to flush
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Middleware starts a server span per request, joining the caller's trace when a
+// traceparent header is present, and names it after the matched route
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx := Extract(r.Context(), r.Header.Get("traceparent"))
+		ctx, span := Start(ctx, fmt.Sprintf("%s %s", r.Method, route), KindServer,
+			"http.method", r.Method,
+			"http.route", route,
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes("http.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("HTTP %d", recorder.status))
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanKind mirrors the OpenTelemetry span kinds
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+	KindProducer SpanKind = 4
+	KindConsumer SpanKind = 5
+)
+
+// Span is a timed operation within a trace
+type Span struct {
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte
+	Name     string
+	Kind     SpanKind
+	Start    time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes map[string]any
+	err        error
+	recording  bool
+}
+
+type spanKey struct{}
+
+// Start begins a span as a child of the span in ctx, or a new trace when there is none.
+// Spans are only recorded when an exporter is configured.
+func Start(ctx context.Context, name string, kind SpanKind, attributes ...any) (context.Context, *Span) {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		attributes: map[string]any{},
+		recording:  exporter != nil,
+	}
+
+	if parent := FromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		rand.Read(span.TraceID[:])
+	}
+	rand.Read(span.SpanID[:])
+
+	span.SetAttributes(attributes...)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the current span, or nil
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttributes adds key/value pairs to the span
+func (s *Span) SetAttributes(attributes ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i+1 < len(attributes); i += 2 {
+		if key, ok := attributes[i].(string); ok {
+			s.attributes[key] = attributes[i+1]
+		}
+	}
+}
+
+// RecordError marks the span as failed
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End finishes the span and hands it to the exporter
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt finishes the span at the given time, for spans reconstructed after the fact such as queue waits
+func (s *Span) EndAt(end time.Time) {
+	s.mu.Lock()
+	s.end = end
+	s.mu.Unlock()
+
+	if s.recording && exporter != nil {
+		exporter.add(s)
+	}
+}
+
+// TraceParent formats the span as a W3C traceparent header value
+func (s *Span) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.TraceID[:]), hex.EncodeToString(s.SpanID[:]))
+}
+
+// Inject returns the traceparent of the span in ctx, or "" when there is none
+func Inject(ctx context.Context) string {
+	if span := FromContext(ctx); span != nil {
+		return span.TraceParent()
+	}
+	return ""
+}
+
+// Extract returns a context whose parent span is described by a W3C traceparent value,
+// so spans started from it join the remote trace
+func Extract(ctx context.Context, traceParent string) context.Context {
+	parts := strings.Split(traceParent, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+
+	remote := &Span{}
+	if _, err := hex.Decode(remote.TraceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(remote.SpanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanKey{}, remote)
+}
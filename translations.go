@@ -0,0 +1,96 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/config"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/worker"
+)
+
+// translationMaxBodyBytes caps the JSON body of a translation request
+const translationMaxBodyBytes = 1 << 20
+
+// translationRequest is the JSON body of POST /api/v1/translations
+type translationRequest struct {
+	// Languages defaults to TRANSLATION_LANGUAGES
+	Languages []string `json:"languages"`
+	// RecordIDs limits the translation to these records, every record when empty
+	RecordIDs []uint `json:"record_ids"`
+	Queue     string `json:"queue"`
+	// Priority defaults to low, so translating the corpus does not hold up uploads
+	Priority string `json:"priority"`
+}
+
+// translationLanguages checks the languages of a translation, TRANSLATION_LANGUAGES when none
+// are given, leaving out repeated ones
+func translationLanguages(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		requested = config.List("TRANSLATION_LANGUAGES")
+	}
+	if len(requested) == 0 {
+		return nil, apierror.InvalidParameter("languages", "languages is required when TRANSLATION_LANGUAGES is not set")
+	}
+
+	var languages []string
+	for _, value := range requested {
+		language, ok := parseLanguage(value)
+		if !ok {
+			return nil, apierror.InvalidParameter("languages", "languages must be language tags such as de or pt-br")
+		}
+		if language == descriptionLanguage() {
+			return nil, apierror.InvalidParameter("languages", "records are described in "+language+" when analyzed")
+		}
+		if !slices.Contains(languages, language) {
+			languages = append(languages, language)
+		}
+	}
+	return languages, nil
+}
+
+// createTranslation queues a task translating the descriptions of records into languages
+// they have no description in, so records analyzed before are found by language searches
+func (s *server) createTranslation(w http.ResponseWriter, r *http.Request) {
+	if !requireAPIKey(w, r, "Translating descriptions") {
+		return
+	}
+	var req translationRequest
+	if err := decodeJSON(w, r, translationMaxBodyBytes, &req); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	languages, err := translationLanguages(req.Languages)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	target, err := parseTaskTarget(req.Queue, cmp.Or(req.Priority, queue.PriorityLow))
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	taskID, err := s.enqueue(r.Context(), target, worker.TaskTypeTranslateDescriptions, map[string]any{
+		"languages":  languages,
+		"record_ids": req.RecordIDs,
+	})
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to queue translation", err))
+		return
+	}
+	slog.InfoContext(r.Context(), "Queued translation", "task_id", taskID, "languages", languages,
+		"records", len(req.RecordIDs))
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{
+		"task_id":   taskID,
+		"languages": languages,
+		"queue":     target.queue,
+		"priority":  target.priority,
+	})
+}
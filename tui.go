@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+)
+
+// tuiRefreshInterval is how often the queue status and recent ingests are reloaded
+const tuiRefreshInterval = 2 * time.Second
+
+// tuiRecentCount is the number of recent ingests shown
+const tuiRecentCount = 8
+
+// tuiPreviewLines caps the description shown for the selected result
+const tuiPreviewLines = 12
+
+// tuiModel is the state of the terminal UI
+type tuiModel struct {
+	ctx       context.Context
+	server    *server
+	queueName string
+	topK      int
+
+	width int
+
+	stats     *queue.QueueStats
+	recent    []models.ImageEmbedding
+	statusErr error
+
+	query     []rune
+	searching bool
+	searched  string
+	results   []models.ImageEmbedding
+	searchErr error
+	selected  int
+}
+
+// tuiStatusMsg carries a refresh of the queue status and recent ingests
+type tuiStatusMsg struct {
+	stats  *queue.QueueStats
+	recent []models.ImageEmbedding
+	err    error
+}
+
+// tuiResultsMsg carries the results of a search
+type tuiResultsMsg struct {
+	query   string
+	results []models.ImageEmbedding
+	err     error
+}
+
+type tuiTickMsg time.Time
+
+func newTUIModel(ctx context.Context, s *server, queueName string, topK int) tuiModel {
+	return tuiModel{ctx: ctx, server: s, queueName: queueName, topK: topK}
+}
+
+func (m tuiModel) Init() tea.Cmd {
+	return m.refresh()
+}
+
+// refresh loads the queue status and the most recent records
+func (m tuiModel) refresh() tea.Cmd {
+	return func() tea.Msg {
+		stats, err := m.server.queue.Stats(m.queueName)
+		if err != nil {
+			return tuiStatusMsg{err: err}
+		}
+
+		var recent []models.ImageEmbedding
+		if err := m.server.db.WithContext(m.ctx).Order("created_at DESC").Limit(tuiRecentCount).
+			Omit("embedding").Find(&recent).Error; err != nil {
+			return tuiStatusMsg{err: err}
+		}
+		return tuiStatusMsg{stats: stats, recent: recent}
+	}
+}
+
+// search embeds the query and finds the closest records
+func (m tuiModel) search(query string) tea.Cmd {
+	return func() tea.Msg {
+		embedding, err := m.server.services.GenerateEmbedding(m.ctx, query)
+		if err != nil {
+			return tuiResultsMsg{query: query, err: fmt.Errorf("failed to generate embedding: %v", err)}
+		}
+		results, err := m.server.findSimilar(m.ctx, embedding, searchParams{TopK: m.topK})
+		return tuiResultsMsg{query: query, results: results, err: err}
+	}
+}
+
+func tuiTick() tea.Cmd {
+	return tea.Tick(tuiRefreshInterval, func(t time.Time) tea.Msg {
+		return tuiTickMsg(t)
+	})
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+
+	case tuiTickMsg:
+		return m, m.refresh()
+
+	case tuiStatusMsg:
+		m.statusErr = msg.err
+		if msg.err == nil {
+			m.stats, m.recent = msg.stats, msg.recent
+		}
+		return m, tuiTick()
+
+	case tuiResultsMsg:
+		m.searching = false
+		m.searched = msg.query
+		m.results, m.searchErr = msg.results, msg.err
+		m.selected = 0
+
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC, tea.KeyEsc:
+			return m, tea.Quit
+		case tea.KeyEnter:
+			query := strings.TrimSpace(string(m.query))
+			if query == "" || m.searching {
+				return m, nil
+			}
+			m.searching = true
+			return m, m.search(query)
+		case tea.KeyUp, tea.KeyCtrlP:
+			if m.selected > 0 {
+				m.selected--
+			}
+		case tea.KeyDown, tea.KeyCtrlN:
+			if m.selected < len(m.results)-1 {
+				m.selected++
+			}
+		case tea.KeyBackspace:
+			if len(m.query) > 0 {
+				m.query = m.query[:len(m.query)-1]
+			}
+		case tea.KeyCtrlU:
+			m.query = nil
+		case tea.KeySpace:
+			m.query = append(m.query, ' ')
+		case tea.KeyRunes:
+			m.query = append(m.query, msg.Runes...)
+		}
+	}
+
+	return m, nil
+}
+
+func (m tuiModel) View() string {
+	width := m.width
+	if width <= 0 {
+		width = 80
+	}
+
+	var view strings.Builder
+
+	view.WriteString(tuiHeading("Queue " + m.queueName))
+	switch {
+	case m.statusErr != nil:
+		fmt.Fprintf(&view, "  error: %v\n", m.statusErr)
+	case m.stats == nil:
+		view.WriteString("  loading...\n")
+	default:
+		fmt.Fprintf(&view, "  %d pending   %d dead letters", m.stats.Pending, m.stats.DeadLetters)
+		if m.stats.Pending > 0 {
+			fmt.Fprintf(&view, "   oldest %s", m.stats.OldestAge.Round(time.Second))
+		}
+		view.WriteString("\n")
+	}
+
+	view.WriteString("\n" + tuiHeading("Recent ingests"))
+	if len(m.recent) == 0 && m.statusErr == nil && m.stats != nil {
+		view.WriteString("  none yet\n")
+	}
+	for _, record := range m.recent {
+		line := fmt.Sprintf("%s  %s  %s", record.CreatedAt.Local().Format("01-02 15:04"), tuiFile(record), record.Text)
+		view.WriteString("  " + truncate(line, width-2) + "\n")
+	}
+
+	view.WriteString("\n" + tuiHeading("Search"))
+	fmt.Fprintf(&view, "  > %s█\n", string(m.query))
+
+	switch {
+	case m.searching:
+		view.WriteString("  searching...\n")
+	case m.searchErr != nil:
+		fmt.Fprintf(&view, "  error: %v\n", m.searchErr)
+	case m.searched != "" && len(m.results) == 0:
+		fmt.Fprintf(&view, "  no results for %q\n", m.searched)
+	}
+
+	for i, result := range m.results {
+		cursor := "  "
+		if i == m.selected {
+			cursor = "▸ "
+		}
+		line := fmt.Sprintf("%.4f  %s  %s", result.Distance, tuiFile(result), result.Text)
+		view.WriteString(cursor + truncate(line, width-2) + "\n")
+	}
+
+	if m.selected < len(m.results) {
+		view.WriteString("\n" + tuiPreview(m.results[m.selected], width))
+	}
+
+	view.WriteString("\nenter search · ↑/↓ select · ctrl+u clear · esc quit\n")
+	return view.String()
+}
+
+// tuiPreview shows the full details of a search result, wrapped to width
+func tuiPreview(result models.ImageEmbedding, width int) string {
+	var preview strings.Builder
+	preview.WriteString(tuiHeading("Preview"))
+	fmt.Fprintf(&preview, "  ID %d   distance %.4f   %s\n", result.ID, result.Distance, result.CreatedAt.Local().Format(time.DateTime))
+	fmt.Fprintf(&preview, "  %s\n", result.FilePath)
+	if result.OriginalName != "" {
+		fmt.Fprintf(&preview, "  original name: %s\n", result.OriginalName)
+	}
+	for _, path := range result.BatchPaths {
+		if path != result.FilePath {
+			fmt.Fprintf(&preview, "  + %s\n", path)
+		}
+	}
+	preview.WriteString("\n")
+	lines := wrap(result.Text, width-4)
+	if len(lines) > tuiPreviewLines {
+		lines = append(lines[:tuiPreviewLines-1], "...")
+	}
+	for _, line := range lines {
+		preview.WriteString("  " + line + "\n")
+	}
+	return preview.String()
+}
+
+func tuiHeading(title string) string {
+	return "\033[1m" + title + "\033[0m\n"
+}
+
+// tuiFile is the file path of a record, with the number of other images in a batch
+func tuiFile(record models.ImageEmbedding) string {
+	if record.IsBatch {
+		return fmt.Sprintf("%s (+%d)", record.FilePath, max(len(record.BatchPaths)-1, 0))
+	}
+	return record.FilePath
+}
+
+// wrap breaks text into lines of at most width characters at word boundaries
+func wrap(text string, width int) []string {
+	if width <= 0 {
+		width = 80
+	}
+
+	var lines []string
+	var line []rune
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+		if len(line) > 0 && len(line)+1+len(runes) > width {
+			lines = append(lines, string(line))
+			line = nil
+		}
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, runes...)
+	}
+	if len(line) > 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
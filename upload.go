@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/config"
+	"github.com/pablobfonseca/go-image-vector/logging"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/worker"
+	"github.com/spf13/viper"
+)
+
+// uploadedFile is an uploaded file spooled to a temporary file while it was hashed
+type uploadedFile struct {
+	Filename string
+	Size     int64
+	Hash     string
+	// Archive is the name of the zip archive the file was expanded from, if any
+	Archive string
+	*os.File
+}
+
+// Close closes and removes the temporary file
+func (f *uploadedFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.File.Name())
+	return err
+}
+
+// uploadLimitError reports which upload limit a request exceeded
+type uploadLimitError struct {
+	message string
+	limit   string
+	value   int64
+}
+
+func (e *uploadLimitError) Error() string {
+	return e.message
+}
+
+// readUpload streams a multipart upload part by part, spooling each file in the
+// "images" field to disk while hashing it, so memory use stays bounded whatever
+// the upload size. A zip archive in the "archive" field is expanded into its images,
+// which follow the other files. Other fields are returned as form values.
+func readUpload(r *http.Request, maxUploadBytes int64, maxFileBytes int64, maxFiles int) ([]*uploadedFile, url.Values, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := []*uploadedFile{}
+	values := url.Values{}
+	var archive *uploadedFile
+
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+		if archive != nil {
+			archive.Close()
+		}
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			closeAll()
+			return nil, nil, asLimitError(err, maxUploadBytes)
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, 1<<20))
+			part.Close()
+			if err != nil {
+				closeAll()
+				return nil, nil, asLimitError(err, maxUploadBytes)
+			}
+			values.Add(part.FormName(), string(value))
+			continue
+		}
+
+		if part.FormName() == "archive" {
+			if archive != nil {
+				part.Close()
+				closeAll()
+				return nil, nil, apierror.InvalidParameter("archive", "Only one archive is allowed per upload")
+			}
+			archive, err = spoolPart(part, maxUploadBytes)
+			part.Close()
+			if err != nil {
+				closeAll()
+				return nil, nil, asLimitError(err, maxUploadBytes)
+			}
+			continue
+		}
+
+		if part.FormName() != "images" {
+			part.Close()
+			continue
+		}
+
+		if len(files) >= maxFiles {
+			part.Close()
+			closeAll()
+			return nil, nil, &uploadLimitError{
+				message: fmt.Sprintf("Maximum %d images allowed", maxFiles),
+				limit:   "max_upload_files",
+				value:   int64(maxFiles),
+			}
+		}
+
+		file, err := spoolPart(part, maxFileBytes)
+		part.Close()
+		if err != nil {
+			closeAll()
+			var limitErr *uploadLimitError
+			if errors.As(err, &limitErr) {
+				return nil, nil, err
+			}
+			return nil, nil, asLimitError(err, maxUploadBytes)
+		}
+		files = append(files, file)
+	}
+
+	if archive != nil {
+		expanded, err := expandArchive(archive, maxFileBytes)
+		archive.Close()
+		archive = nil
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		files = append(files, expanded...)
+	}
+
+	return files, values, nil
+}
+
+// maxDisplayNameBytes caps the length of a stored original file name
+const maxDisplayNameBytes = 255
+
+// displayName turns a client-supplied file name into one that is safe to store and show: the
+// last path element only, without control or invisible formatting characters, whitespace
+// collapsed and at most maxDisplayNameBytes long. It is only ever display metadata, files are
+// stored under their content hash.
+func displayName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.ToValidUTF8(name, "")
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+
+	for len(name) > maxDisplayNameBytes {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == ".." {
+		return "upload"
+	}
+	return name
+}
+
+// spoolPart copies a file part to a temporary file, hashing it on the way
+func spoolPart(part *multipart.Part, maxFileBytes int64) (*uploadedFile, error) {
+	return spoolFile(part, displayName(part.FileName()), maxFileBytes)
+}
+
+// spoolFile copies the content of a file to a temporary file, hashing it on the way
+func spoolFile(r io.Reader, filename string, maxFileBytes int64) (*uploadedFile, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	file := &uploadedFile{Filename: filename, File: tmp}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(r, maxFileBytes+1))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if size > maxFileBytes {
+		file.Close()
+		return nil, &uploadLimitError{
+			message: fmt.Sprintf("File %s exceeds the maximum file size of %d bytes", filename, maxFileBytes),
+			limit:   "max_file_bytes",
+			value:   maxFileBytes,
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	file.Size = size
+	file.Hash = hex.EncodeToString(hasher.Sum(nil))
+	return file, nil
+}
+
+// asLimitError turns a request body overflow into an upload limit error
+func asLimitError(err error, maxUploadBytes int64) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &uploadLimitError{
+			message: fmt.Sprintf("Upload exceeds the maximum request size of %d bytes", maxUploadBytes),
+			limit:   "max_upload_bytes",
+			value:   maxUploadBytes,
+		}
+	}
+	return err
+}
+
+// storedUpload is an upload saved to storage and ready for analysis
+type storedUpload struct {
+	FilePath     string
+	OriginalPath string
+	MediaType    string
+
+	// Metadata holds the EXIF location and capture time, when present
+	Metadata services.Metadata
+
+	// QuarantineTaskID is set instead when the scanner flagged the file
+	QuarantineTaskID string
+
+	// RecordID is the ID reserved for the record of the file, which its task saves it under,
+	// 0 when none was reserved
+	RecordID uint
+}
+
+// checkStorageQuota rejects an upload of files that would take storage past STORAGE_QUOTA_BYTES,
+// or past STORAGE_QUOTA_BYTES_PER_KEY for the files of the API key keyID. It answers early,
+// before any file is stored; each file is then reserved within the quotas as it is stored.
+func (s *server) checkStorageQuota(files []*uploadedFile, keyID string) error {
+	var uploadSize int64
+	for _, file := range files {
+		uploadSize += file.Size
+	}
+
+	quota := storageQuota()
+	if err := s.checkQuota("", quota.Total, uploadSize); err != nil {
+		return err
+	}
+	if keyID == "" {
+		return nil
+	}
+	return s.checkQuota(keyID, quota.PerKey, uploadSize)
+}
+
+// checkQuota rejects an upload of uploadSize bytes that would take the storage usage of keyID,
+// or the total when it is empty, past quota. A quota of 0 is no limit.
+func (s *server) checkQuota(keyID string, quota int64, uploadSize int64) error {
+	if quota <= 0 {
+		return nil
+	}
+	usage, err := s.queue.GetStorageUsage(keyID)
+	if err != nil {
+		return apierror.Internal("Failed to check storage usage", err)
+	}
+	if usage+uploadSize > quota {
+		return quotaExceeded(&queue.QuotaExceededError{KeyID: keyID, Usage: usage, Quota: quota, Size: uploadSize})
+	}
+	return nil
+}
+
+// storageQuota is the storage quota of uploads, STORAGE_QUOTA_BYTES in total and
+// STORAGE_QUOTA_BYTES_PER_KEY for each API key
+func storageQuota() queue.StorageQuota {
+	return queue.StorageQuota{
+		Total:  viper.GetInt64("STORAGE_QUOTA_BYTES"),
+		PerKey: viper.GetInt64("STORAGE_QUOTA_BYTES_PER_KEY"),
+	}
+}
+
+// quotaExceeded is the API error of an upload that would take storage past a quota
+func quotaExceeded(exceeded *queue.QuotaExceededError) *apierror.Error {
+	name := "Storage quota"
+	if exceeded.KeyID != "" {
+		name = "Storage quota of the API key"
+	}
+	return apierror.Newf(http.StatusInsufficientStorage, apierror.CodeStorageQuotaExceeded,
+		"%s exceeded: %d of %d bytes used, upload needs %d more", name, exceeded.Usage, exceeded.Quota, exceeded.Size).
+		With("usage_bytes", exceeded.Usage).With("quota_bytes", exceeded.Quota).With("upload_bytes", exceeded.Size)
+}
+
+// saveTracked saves a file under key unless it is stored already, reserving its size in the
+// storage usage, and in that of the API key keyID, within quota first. The reservation is
+// released when the save fails. It reports whether the file was stored already.
+func (s *server) saveTracked(ctx context.Context, key string, r io.Reader, size int64, keyID string, quota queue.StorageQuota) (bool, error) {
+	reserved, err := s.queue.ReserveStoredFile(key, size, keyID, quota)
+	if err != nil {
+		return false, err
+	}
+
+	reused, err := storage.SaveIfMissing(ctx, s.files, key, r)
+	if err != nil && reserved {
+		if err := s.queue.UntrackStoredFile(key); err != nil {
+			slog.ErrorContext(ctx, "Error releasing storage usage", "key", key, "error", err)
+		}
+	}
+	return reused, err
+}
+
+// saveError is the API error of a file that could not be saved, message unless it was over quota
+func saveError(err error, message string) *apierror.Error {
+	var exceeded *queue.QuotaExceededError
+	if errors.As(err, &exceeded) {
+		return quotaExceeded(exceeded)
+	}
+	return apierror.Internal(message, err)
+}
+
+// storeUpload validates, scans and stores one file under its content hash, converting
+// HEIC/AVIF images to JPEG for analysis, and counts new files to the storage usage of the API
+// key keyID. It is shared by uploads and the ingest command.
+func (s *server) storeUpload(ctx context.Context, file io.ReadSeeker, filename string, hash string, size int64, keyID string) (*storedUpload, error) {
+	// Validate the actual content rather than the client-supplied name or type
+	mediaType, err := storage.DetectMediaType(file)
+	if err != nil {
+		return nil, apierror.BadRequest("Failed to read uploaded file: " + err.Error())
+	}
+	if !storage.IsAllowedMediaType(mediaType, viper.GetStringSlice("ALLOWED_MEDIA_TYPES")) {
+		return nil, apierror.Newf(http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType,
+			"File %s has unsupported type %s", filename, mediaType).With("filename", filename).With("media_type", mediaType)
+	}
+
+	// Name the file by its content hash so identical uploads share one blob
+	key := storage.HashKey(hash, mediaType)
+
+	// Scan the file before it is stored or queued, flagged files go to quarantine
+	if scanner != nil {
+		result, err := scanner.Scan(ctx, file)
+		if err != nil {
+			return nil, apierror.Internal("Failed to scan uploaded file", err)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, apierror.Internal("Failed to read uploaded file", err)
+		}
+
+		if result.Flagged {
+			taskID, err := s.quarantineUpload(ctx, file, key, size, keyID, filename, result.Reason)
+			if err != nil {
+				return nil, saveError(err, "Failed to quarantine file")
+			}
+			return &storedUpload{QuarantineTaskID: taskID}, nil
+		}
+	}
+
+	reused, err := s.saveTracked(ctx, key, file, size, keyID, storageQuota())
+	if err != nil {
+		return nil, saveError(err, "Failed to save file")
+	}
+	if reused {
+		slog.InfoContext(ctx, "Reusing stored file", "key", key, "filename", filename)
+	}
+
+	stored := &storedUpload{
+		FilePath:  storage.Path(key),
+		MediaType: mediaType,
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, apierror.Internal("Failed to read uploaded file", err)
+	}
+	stored.Metadata = services.ExtractMetadata(file)
+
+	// Store a JPEG rendition next to HEIC/AVIF originals and analyze that instead
+	if services.NeedsConversion(mediaType) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, apierror.Internal("Failed to read uploaded file", err)
+		}
+
+		converted, err := services.ConvertToJPEG(ctx, file, storage.MediaTypeExtension(mediaType))
+		if err != nil {
+			slog.WarnContext(ctx, "Keeping file unconverted", "filename", filename, "error", err)
+		} else {
+			// The rendition counts to the usage of the original, without a quota of its own, as
+			// the original passed it
+			convertedKey := key + ".jpg"
+			if _, err := s.saveTracked(ctx, convertedKey, bytes.NewReader(converted), int64(len(converted)), keyID, queue.StorageQuota{}); err != nil {
+				return nil, apierror.Internal("Failed to save converted file", err)
+			}
+
+			stored.OriginalPath = stored.FilePath
+			stored.FilePath = storage.Path(convertedKey)
+			stored.MediaType = "image/jpeg"
+
+			// The EXIF tags of HEIC/AVIF originals survive in the rendition when the converter keeps metadata
+			if stored.Metadata == (services.Metadata{}) {
+				stored.Metadata = services.ExtractMetadata(bytes.NewReader(converted))
+			}
+		}
+	}
+
+	return stored, nil
+}
+
+// taskTarget is the queue and priority an analysis task is queued with
+type taskTarget struct {
+	queue    string
+	priority string
+}
+
+// parseTaskTarget checks a requested queue and priority. The queue must be one of QUEUES,
+// the first when empty, and the priority defaults to normal.
+func parseTaskTarget(queueName string, priority string) (taskTarget, error) {
+	queues := config.List("QUEUES")
+	if queueName == "" && len(queues) > 0 {
+		queueName = queues[0]
+	}
+	if !slices.Contains(queues, queueName) {
+		return taskTarget{}, apierror.InvalidParameter("queue", "queue must be one of "+strings.Join(queues, ", "))
+	}
+
+	if priority == "" {
+		priority = queue.PriorityNormal
+	}
+	if !queue.ValidPriority(priority) {
+		return taskTarget{}, apierror.InvalidParameter("priority", "priority must be one of "+strings.Join(queue.Priorities, ", "))
+	}
+
+	return taskTarget{queue: queueName, priority: priority}, nil
+}
+
+// enqueue queues a task on the target, marking it pending
+func (s *server) enqueue(ctx context.Context, target taskTarget, taskType string, taskData map[string]any) (string, error) {
+	taskID, err := queue.Enqueue(ctx, s.tasks, target.queue, target.priority, taskType, taskData)
+	if err != nil {
+		return "", err
+	}
+
+	// Set initial task status
+	s.tasks.SetTaskStatus(taskID, "pending")
+	return taskID, nil
+}
+
+// enqueueAnalysis queues a single image analysis task for a stored file
+func (s *server) enqueueAnalysis(ctx context.Context, stored *storedUpload, filename string, caption imageCaption, visibility string, audit string, target taskTarget) (string, error) {
+	return s.enqueue(ctx, target, worker.TaskTypeAnalyzeImage, analysisTaskData(stored, filename, caption, visibility, audit))
+}
+
+// analyzeSync analyzes a stored file inline, recording the outcome as a task that can be looked
+// up like a queued one. An analysis not done within SYNC_TIMEOUT, or whose client went away, is
+// queued under the same task ID instead and the result is nil.
+func (s *server) analyzeSync(ctx context.Context, stored *storedUpload, filename string, caption imageCaption, visibility string, audit string, target taskTarget) (string, *worker.AnalyzeImageResult, error) {
+	task := &queue.TaskPayload{
+		TaskID:    queue.NewTaskID(),
+		TaskType:  worker.TaskTypeAnalyzeImage,
+		Queue:     target.queue,
+		Priority:  target.priority,
+		Data:      analysisTaskData(stored, filename, caption, visibility, audit),
+		Created:   time.Now(),
+		RequestID: logging.RequestIDFromContext(ctx),
+	}
+	s.tasks.SetTaskStatus(task.TaskID, "processing")
+
+	analyzeCtx, cancel := context.WithTimeout(ctx, viper.GetDuration("SYNC_TIMEOUT"))
+	defer cancel()
+
+	value, err := s.workerDeps().Process(analyzeCtx, task)
+	if err != nil && analyzeCtx.Err() != nil {
+		slog.WarnContext(ctx, "Inline analysis did not finish, queueing it", "task_id", task.TaskID,
+			"filename", filename, "error", err)
+		if err := s.tasks.Requeue(task); err != nil {
+			return "", nil, err
+		}
+		s.tasks.SetTaskStatus(task.TaskID, "pending")
+		return task.TaskID, nil, nil
+	}
+	if err != nil {
+		// Failed like a queued task, so it can be retried without uploading the file again
+		s.workerDeps().Fail(task, err, slog.With("task_id", task.TaskID))
+		return task.TaskID, nil, err
+	}
+	result, ok := value.(*worker.AnalyzeImageResult)
+	if !ok {
+		return task.TaskID, nil, fmt.Errorf("unexpected result %T", value)
+	}
+
+	s.tasks.SetTaskStatus(task.TaskID, "completed")
+	s.tasks.StoreTaskResult(task.TaskID, result)
+	return task.TaskID, result, nil
+}
+
+// analysisTaskData is the task data analyzing a stored file on its own
+func analysisTaskData(stored *storedUpload, filename string, caption imageCaption, visibility string, audit string) map[string]any {
+	taskData := map[string]any{
+		"file_path":     stored.FilePath,
+		"original_name": filename,
+		"media_type":    stored.MediaType,
+		"original_path": stored.OriginalPath,
+		"visibility":    visibility,
+	}
+	if location := stored.Metadata.Location; location != nil {
+		taskData["latitude"] = location.Latitude
+		taskData["longitude"] = location.Longitude
+	}
+	if takenAt := stored.Metadata.TakenAt; takenAt != nil {
+		taskData["taken_at"] = takenAt.Format(time.RFC3339)
+	}
+	if caption.text != "" {
+		taskData["caption"] = caption.text
+		taskData["caption_mode"] = caption.mode
+	}
+	if audit != "" {
+		taskData["audit"] = audit
+	}
+	if stored.RecordID != 0 {
+		taskData["record_id"] = float64(stored.RecordID)
+	}
+	return taskData
+}
+
+// recordIDFor returns the ID the record of a stored single image will have, so the upload can
+// answer with it before the analysis. A file analyzed before with the same visibility resolves
+// to its record, as in the worker, any other reserves a new ID.
+func (s *server) recordIDFor(ctx context.Context, filePath string, visibility string) (uint, error) {
+	var ids []uint
+	if err := s.db.WithContext(ctx).Model(&models.ImageEmbedding{}).
+		Where("file_path = ? AND is_batch = ? AND visibility = ?", filePath, false, visibility).
+		Order("id").Limit(1).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) > 0 {
+		return ids[0], nil
+	}
+	return s.reserveRecordID(ctx)
+}
+
+// reserveRecordID takes the next ID of the records table for a record its task creates later.
+// IDs reserved for tasks that fail for good are never used.
+func (s *server) reserveRecordID(ctx context.Context) (uint, error) {
+	var id uint
+	err := s.db.WithContext(ctx).Raw("SELECT nextval(pg_get_serial_sequence('image_embeddings', 'id'))").Scan(&id).Error
+	return id, err
+}
+
+// uploadedEntry describes an uploaded file in the upload response, in upload order: where it is
+// stored and served, and the task and record of its analysis. task_id and record_id are null
+// until known.
+func uploadedEntry(r *http.Request, file *uploadedFile, filePath string, mediaType string, status string) map[string]any {
+	entry := map[string]any{
+		"filename":  file.Filename,
+		"sha256":    file.Hash,
+		"status":    status,
+		"task_id":   nil,
+		"record_id": nil,
+	}
+	if file.Archive != "" {
+		entry["archive"] = file.Archive
+	}
+	if filePath != "" {
+		entry["file_path"] = filePath
+		entry["url"] = fileURL(r, filePath)
+		entry["media_type"] = mediaType
+	}
+	return entry
+}
+
+// quarantineUpload moves a flagged upload out of the served storage area, where it still
+// counts to the storage usage of keyID, and records a failed task carrying the moderation reason
+func (s *server) quarantineUpload(ctx context.Context, file io.Reader, key string, size int64, keyID string, filename string, reason string) (string, error) {
+	if _, err := s.saveTracked(ctx, storage.QuarantinePrefix+key, file, size, keyID, storageQuota()); err != nil {
+		return "", err
+	}
+
+	slog.WarnContext(ctx, "Quarantined upload", "filename", filename, "key", key, "reason", reason)
+
+	taskID := queue.NewTaskID()
+	if err := s.tasks.SetTaskStatus(taskID, "failed"); err != nil {
+		return "", err
+	}
+	if err := s.tasks.StoreTaskResult(taskID, &worker.ErrorResult{
+		Type:             worker.ResultTypeError,
+		Error:            "file flagged by scanner",
+		Category:         worker.ErrorCategoryBadInput,
+		ModerationReason: reason,
+		OriginalName:     filename,
+	}); err != nil {
+		return "", err
+	}
+
+	return taskID, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"gorm.io/gorm"
+)
+
+// parseUploadHashes reads the sha256 fields of an upload, one hex digest per image in upload
+// order. With files, each digest must match the content of its file.
+func parseUploadHashes(values url.Values, files []*uploadedFile) ([]string, error) {
+	var hashes []string
+	for _, value := range values["sha256"] {
+		for _, field := range strings.Split(value, ",") {
+			sum := strings.ToLower(strings.TrimSpace(field))
+			if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != 32 {
+				return nil, apierror.InvalidParameter("sha256", fmt.Sprintf("sha256 must list hex SHA-256 digests, got %q", field))
+			}
+			hashes = append(hashes, sum)
+		}
+	}
+	if len(hashes) == 0 || len(files) == 0 {
+		return hashes, nil
+	}
+
+	if len(hashes) != len(files) {
+		return nil, apierror.InvalidParameter("sha256", fmt.Sprintf("sha256 has %d digests for %d images", len(hashes), len(files)))
+	}
+	for i, file := range files {
+		if hashes[i] != file.Hash {
+			return nil, apierror.InvalidParameter("sha256", fmt.Sprintf("sha256 of %s does not match its content", file.Filename)).
+				With("filename", file.Filename)
+		}
+	}
+	return hashes, nil
+}
+
+// findAnalyzedByHash returns the single-image record with the given visibility of the file with
+// content hash sum, or nil when that content was never analyzed on its own with it. Files are
+// stored under their hash, with the JPEG rendition of HEIC/AVIF uploads under the original key
+// plus ".jpg".
+func (s *server) findAnalyzedByHash(ctx context.Context, sum string, visibility string) (*models.ImageEmbedding, error) {
+	var record models.ImageEmbedding
+	err := s.db.WithContext(ctx).Omit("embedding").
+		Where("is_batch = ? AND file_path LIKE ?", false, storage.Path(sum)+".%").
+		Where("visibility = ?", visibility).
+		Order("id").First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// existingRecord describes a record returned instead of analyzing an upload again
+func existingRecord(sum string, record *models.ImageEmbedding) map[string]any {
+	return map[string]any{
+		"sha256":        sum,
+		"id":            record.ID,
+		"file_path":     record.FilePath,
+		"original_name": record.OriginalName,
+		"media_type":    record.MediaType,
+		"caption":       record.Caption,
+		"visibility":    record.Visibility,
+		"created_at":    record.CreatedAt,
+	}
+}
+
+// respondExisting answers an upload that sent only digests: with the records of the contents
+// when all of them were analyzed before, or with the digests the client still has to upload
+func (s *server) respondExisting(w http.ResponseWriter, r *http.Request, hashes []string, visibility string) {
+	records := []map[string]any{}
+	var missing []string
+	for _, sum := range hashes {
+		record, err := s.findAnalyzedByHash(r.Context(), sum, visibility)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal("Failed to look up existing records", err))
+			return
+		}
+		if record == nil {
+			missing = append(missing, sum)
+			continue
+		}
+		records = append(records, existingRecord(sum, record))
+	}
+
+	if len(missing) > 0 {
+		apierror.Write(w, r, apierror.NotFound(fmt.Sprintf("%d of %d images were never analyzed, upload them", len(missing), len(hashes))).
+			With("missing", missing))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":  "Images already analyzed",
+		"existing": true,
+		"records":  records,
+		"task_ids": []string{},
+	})
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/spf13/viper"
+)
+
+// searchMaxBodyBytes caps the JSON body of a search request
+const searchMaxBodyBytes = 1 << 20
+
+// searchRequest is the JSON body of POST /search
+type searchRequest struct {
+	QueryText     string      `json:"query"`
+	Queries       []queryPart `json:"queries"`
+	TopK          int         `json:"top_k"`
+	Kind          string      `json:"kind"`
+	Field         string      `json:"field"`
+	Rank          string      `json:"rank"`
+	HalfLife      string      `json:"half_life"`
+	RecencyWeight *float64    `json:"recency_weight"`
+	MinScore      float64     `json:"min_score"`
+	// HybridWeights override the weight of the fields hybrid ranking fuses, by field name
+	HybridWeights map[string]float64 `json:"hybrid_weights"`
+	Exact         bool               `json:"exact"`
+	Near          *geoFilter         `json:"near"`
+	Label         string             `json:"label"`
+	// AccessibilityIssue keeps records the accessibility audit found an issue of this type on
+	AccessibilityIssue string `json:"accessibility_issue"`
+	Language           string `json:"language"`
+	// MediaType, IsBatch, BatchID, Since and Until filter records by their metadata
+	MediaType string   `json:"media_type"`
+	IsBatch   *bool    `json:"is_batch"`
+	BatchID   string   `json:"batch_id"`
+	Since     string   `json:"since"`
+	Until     string   `json:"until"`
+	Fields    []string `json:"fields"`
+	Exclude   []string `json:"exclude"`
+
+	// selection is the checked field selection of Fields and Exclude
+	selection fieldSelection
+	// byImage is set for searches by an example image, which is their query
+	byImage bool
+}
+
+// decodeJSON decodes a request body into v, rejecting unknown fields so misspelled filters
+// are not silently ignored
+func decodeJSON(w http.ResponseWriter, r *http.Request, maxBytes int64, v any) error {
+	return decodeStrictJSON(http.MaxBytesReader(w, r.Body, maxBytes), v)
+}
+
+// decodeStrictJSON decodes JSON from body into v, rejecting unknown fields
+func decodeStrictJSON(body io.Reader, v any) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return err
+		}
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field, _ = strconv.Unquote(field)
+			return apierror.InvalidParameter(field, fmt.Sprintf("unknown field %q", field))
+		}
+		if errors.Is(err, io.EOF) {
+			return apierror.BadRequest("Request body is empty")
+		}
+		return apierror.BadRequest("Invalid request body: " + err.Error())
+	}
+	return nil
+}
+
+// validate checks a search request against the search limits before any embedding or
+// database work, defaulting top_k, field and the radius of near. Streamed searches may ask
+// for up to SEARCH_STREAM_MAX_TOP_K results.
+func (req *searchRequest) validate(streamed bool) error {
+	maxTopK := viper.GetInt("SEARCH_MAX_TOP_K")
+	if streamed {
+		maxTopK = viper.GetInt("SEARCH_STREAM_MAX_TOP_K")
+	}
+	if req.TopK == 0 {
+		req.TopK = min(5, maxTopK)
+	}
+	if req.TopK < 0 || req.TopK > maxTopK {
+		return apierror.InvalidParameter("top_k", fmt.Sprintf("top_k must be between 1 and %d", maxTopK))
+	}
+	selection, err := newFieldSelection(req.Fields, req.Exclude, jsonFieldNames(models.ImageEmbedding{}))
+	if err != nil {
+		return err
+	}
+	req.selection = selection
+
+	if !validSearchKind(req.Kind) {
+		return apierror.InvalidParameter("kind", "kind must be one of all, batch or image")
+	}
+	if req.IsBatch != nil {
+		kind := searchKindImage
+		if *req.IsBatch {
+			kind = searchKindBatch
+		}
+		if req.Kind != "" && req.Kind != searchKindAll && req.Kind != kind {
+			return apierror.InvalidParameter("is_batch", "is_batch contradicts kind "+req.Kind)
+		}
+		req.Kind = kind
+	}
+	if req.MediaType != "" && !validMediaTypeFilter(req.MediaType) {
+		return apierror.InvalidParameter("media_type", "media_type must be a media type such as image/png, or image/* for every image type")
+	}
+	for _, bound := range []struct {
+		param string
+		value string
+	}{
+		{"since", req.Since},
+		{"until", req.Until},
+	} {
+		if _, err := parseTimeBound(bound.value); err != nil {
+			return apierror.InvalidParameter(bound.param, bound.param+" must be an RFC 3339 time such as 2024-01-31T00:00:00Z")
+		}
+	}
+	if !validSearchField(req.Field) {
+		return apierror.InvalidParameter("field", "field must be one of description, summary, chunks or frames")
+	}
+	if req.Language != "" {
+		language, ok := parseLanguage(req.Language)
+		if !ok {
+			return apierror.InvalidParameter("language", "language must be all or a language tag such as de or pt-br")
+		}
+		if req.Field != "" && req.Field != searchFieldDescription {
+			return apierror.InvalidParameter("language", "language searches descriptions, so field must be description")
+		}
+		// The language records are analyzed in is their own description
+		req.Language, req.Field = language, searchFieldDescription
+		if language == descriptionLanguage() {
+			req.Language = ""
+		}
+	}
+	if req.Field == "" {
+		req.Field = viper.GetString("SEARCH_FIELD")
+	}
+	if err := validAccessibilityIssue(req.AccessibilityIssue); err != nil {
+		return err
+	}
+	if req.Label != "" && !services.ValidLabel(req.Label) {
+		return apierror.InvalidParameter("label", "label must be one of "+strings.Join(services.Labels, ", "))
+	}
+	if !validSearchRank(req.Rank) {
+		return apierror.InvalidParameter("rank", "rank must be one of similarity, recency or hybrid")
+	}
+	if req.Rank == rankHybrid && (!validHybridField(req.Field) || req.Language != "") {
+		return apierror.InvalidParameter("rank", "hybrid ranking matches whole descriptions, so field must be description or summary, without a language")
+	}
+	if req.Near != nil {
+		if err := req.Near.validate(); err != nil {
+			return apierror.InvalidParameter("near", err.Error())
+		}
+	}
+	if req.HalfLife != "" {
+		if halfLife, err := time.ParseDuration(req.HalfLife); err != nil || halfLife <= 0 {
+			return apierror.InvalidParameter("half_life", "half_life must be a positive duration such as 168h")
+		}
+	}
+	if req.RecencyWeight != nil && (*req.RecencyWeight < 0 || *req.RecencyWeight > 1) {
+		return apierror.InvalidParameter("recency_weight", "recency_weight must be between 0 and 1")
+	}
+	if req.MinScore < 0 || req.MinScore > 1 {
+		return apierror.InvalidParameter("min_score", "min_score must be between 0 and 1")
+	}
+	if len(req.HybridWeights) > 0 {
+		if req.Rank != rankHybrid {
+			return apierror.InvalidParameter("hybrid_weights", "hybrid_weights only applies to rank hybrid")
+		}
+		if err := validateHybridWeights(req.HybridWeights); err != nil {
+			return apierror.InvalidParameter("hybrid_weights", err.Error())
+		}
+	}
+	if req.Rank == rankHybrid && req.MinScore > 0 {
+		return apierror.InvalidParameter("min_score", "min_score scores the distance alone, which would drop the full-text matches of hybrid ranking, so it cannot be used with rank hybrid")
+	}
+
+	if req.byImage {
+		if req.QueryText != "" || len(req.Queries) > 0 {
+			return apierror.InvalidParameter("query", "image searches use the image as their query, query and queries must be empty")
+		}
+		return nil
+	}
+	if req.QueryText == "" && len(req.Queries) == 0 {
+		return apierror.InvalidParameter("query", "query or queries is required")
+	}
+	if req.Rank == rankHybrid && req.text() == "" {
+		return apierror.InvalidParameter("rank", "hybrid ranking matches the query text, so query or queries needs a text")
+	}
+	if maxQueries := viper.GetInt("SEARCH_MAX_QUERIES"); len(req.Queries) > maxQueries {
+		return apierror.InvalidParameter("queries", fmt.Sprintf("queries can combine at most %d parts", maxQueries))
+	}
+	maxLength := viper.GetInt("SEARCH_MAX_QUERY_LENGTH")
+	if utf8.RuneCountInString(req.QueryText) > maxLength {
+		return apierror.InvalidParameter("query", fmt.Sprintf("query must be at most %d characters", maxLength))
+	}
+	for _, part := range req.Queries {
+		if err := part.validate(); err != nil {
+			return apierror.InvalidParameter("queries", err.Error())
+		}
+		if utf8.RuneCountInString(part.Text) > maxLength {
+			return apierror.InvalidParameter("queries", fmt.Sprintf("query texts must be at most %d characters", maxLength))
+		}
+	}
+	return nil
+}
+
+// text is the text of the query and of its text parts, matched against descriptions by
+// hybrid ranking
+func (req *searchRequest) text() string {
+	texts := []string{}
+	if req.QueryText != "" {
+		texts = append(texts, req.QueryText)
+	}
+	for _, part := range req.Queries {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+// validMediaTypeFilter reports whether filter is a media type, or a type followed by /* to
+// match all of its subtypes
+func validMediaTypeFilter(filter string) bool {
+	family, subtype, ok := strings.Cut(filter, "/")
+	if !ok || family == "" || subtype == "" || strings.ContainsAny(family, "*/ ,;") {
+		return false
+	}
+	return subtype == "*" || !strings.ContainsAny(subtype, "*/ ,;")
+}
+
+// parseTimeBound reads an optional RFC 3339 time, the zero time when value is empty
+func parseTimeBound(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// allowQueryParams rejects query parameters other than the allowed ones, so a misspelled
+// filter fails instead of being ignored
+func allowQueryParams(query url.Values, allowed ...string) error {
+	for name := range query {
+		if !slices.Contains(allowed, name) {
+			return apierror.InvalidParameter(name, fmt.Sprintf("unknown parameter %q, expected one of %s",
+				name, strings.Join(allowed, ", ")))
+		}
+	}
+	return nil
+}
+
+// parsePage reads the limit (default 50, at most maxLimit) and offset query parameters of
+// a paged list
+func parsePage(query url.Values, maxLimit int) (limit int, offset int, err error) {
+	limit = 50
+	for _, param := range []struct {
+		name  string
+		value *int
+		min   int
+	}{
+		{"limit", &limit, 1},
+		{"offset", &offset, 0},
+	} {
+		if value := query.Get(param.name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < param.min {
+				return 0, 0, apierror.InvalidParameter(param.name, fmt.Sprintf("%s must be an integer of at least %d", param.name, param.min))
+			}
+			*param.value = parsed
+		}
+	}
+	return min(limit, maxLimit), offset, nil
+}
+
+// uploadFields are the form fields an upload accepts besides the images
+var uploadFields = []string{
+	"batch_analyze", "per_image", "order", "sequence", "scenario", "max_chunk_size", "max_parallel",
+	"chunk_retries", "min_chunk_success", "queue", "priority", "sha256",
+	"sync", "caption", "caption_mode", "visibility", "audit", "scenes",
+}
+
+// validateUploadFiles checks every file of an upload before any is stored, so a bad file
+// rejects the whole upload instead of leaving the files before it stored and queued
+func validateUploadFiles(files []*uploadedFile) error {
+	allowed := viper.GetStringSlice("ALLOWED_MEDIA_TYPES")
+	for _, file := range files {
+		if file.Size == 0 {
+			return apierror.BadRequest(fmt.Sprintf("File %s is empty", file.Filename)).With("filename", file.Filename)
+		}
+
+		mediaType, err := storage.DetectMediaType(file)
+		if err != nil {
+			return apierror.BadRequest("Failed to read uploaded file: " + err.Error())
+		}
+		if !storage.IsAllowedMediaType(mediaType, allowed) {
+			return apierror.Newf(http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType,
+				"File %s has unsupported type %s", file.Filename, mediaType).
+				With("filename", file.Filename).With("media_type", mediaType)
+		}
+	}
+	return nil
+}
+
+// validateSceneFiles checks that every file of an upload cut into scenes is a video
+func validateSceneFiles(files []*uploadedFile) error {
+	for _, file := range files {
+		mediaType, err := storage.DetectMediaType(file)
+		if err != nil {
+			return apierror.BadRequest("Failed to read uploaded file: " + err.Error())
+		}
+		if !services.IsVideo(mediaType) {
+			return apierror.InvalidParameter("scenes", fmt.Sprintf("scenes=true cuts videos into scenes, %s is %s", file.Filename, mediaType)).
+				With("filename", file.Filename)
+		}
+	}
+	return nil
+}
+
+// positiveFormInt reads an optional positive integer form field, def when it is absent
+func positiveFormInt(values url.Values, name string, def int) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		return 0, apierror.InvalidParameter(name, name+" must be a positive integer")
+	}
+	return parsed, nil
+}
@@ -0,0 +1,41 @@
+package version
+
+import "runtime/debug"
+
+// Build information, set at build time with
+// -ldflags "-X github.com/pablobfonseca/go-image-vector/version.Version=v1.2.0 ..."
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+func init() {
+	// Fall back to the VCS details Go embeds in binaries built from a checkout
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if Commit == "unknown" {
+				Commit = setting.Value
+			}
+		case "vcs.time":
+			if Date == "unknown" {
+				Date = setting.Value
+			}
+		}
+	}
+}
+
+// Info returns the build information as a JSON-friendly map
+func Info() map[string]any {
+	return map[string]any{
+		"version":    Version,
+		"commit":     Commit,
+		"build_date": Date,
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/auth"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/worker"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// publicOnly reports whether r may only see public records, because API_KEYS is set and r
+// does not carry one of them
+func publicOnly(r *http.Request) bool {
+	return !auth.Authenticated(r)
+}
+
+// visibleRecords limits a query of records to the ones r may see
+func visibleRecords(r *http.Request, query *gorm.DB) *gorm.DB {
+	if publicOnly(r) {
+		return query.Where("visibility = ?", models.VisibilityPublic)
+	}
+	return query
+}
+
+// resultVisible reports whether r may see the record of a task result. Results of other
+// tasks carry no record and are visible.
+func (s *server) resultVisible(r *http.Request, result any) (bool, error) {
+	if !publicOnly(r) {
+		return true, nil
+	}
+
+	var id uint
+	switch result := result.(type) {
+	case *worker.AnalyzeImageResult:
+		id = result.ID
+	case *worker.BatchResult:
+		id = result.ID
+	default:
+		return true, nil
+	}
+
+	var count int64
+	err := visibleRecords(r, s.db.WithContext(r.Context()).Model(&models.ImageEmbedding{})).
+		Where("id = ?", id).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// parseVisibility reads the visibility of the records of an upload, DEFAULT_VISIBILITY when
+// not given
+func parseVisibility(value string) (string, error) {
+	if value == "" {
+		value = viper.GetString("DEFAULT_VISIBILITY")
+	}
+	if value != models.VisibilityPublic && value != models.VisibilityPrivate {
+		return "", apierror.InvalidParameter("visibility", "visibility must be public or private")
+	}
+	return value, nil
+}
+
+// canServeFile decides how r may download a stored file: authenticated requests any file,
+// others only files of public records, as their file, original, batch member or video frame,
+// and the files of the share in their share parameter. Only files of public records are
+// public to caches; shares can be revoked.
+func (s *server) canServeFile(r *http.Request, key string) (storage.Access, error) {
+	if !publicOnly(r) {
+		return storage.AccessPrivate, nil
+	}
+	if token := r.URL.Query().Get("share"); token != "" {
+		if shared, err := s.isSharedFile(r.Context(), token, storage.Path(key)); err != nil {
+			return storage.AccessDenied, err
+		} else if shared {
+			return storage.AccessPrivate, nil
+		}
+	}
+	public, err := s.isPublicFile(r.Context(), storage.Path(key))
+	if err != nil || !public {
+		return storage.AccessDenied, err
+	}
+	return storage.AccessPublic, nil
+}
+
+// isPublicFile reports whether a public record, or a frame of one, references the file at
+// filePath
+func (s *server) isPublicFile(ctx context.Context, filePath string) (bool, error) {
+	member, err := json.Marshal([]string{filePath})
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	err = s.db.WithContext(ctx).Model(&models.ImageEmbedding{}).
+		Where("visibility = ?", models.VisibilityPublic).
+		Where("file_path = ? OR original_path = ? OR preview_path = ? OR batch_paths @> ?::jsonb OR id IN (?)", filePath, filePath, filePath, string(member),
+			s.db.Model(&models.VideoFrame{}).Select("record_id").Where("file_path = ?", filePath)).
+		Limit(1).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pablobfonseca/go-image-vector/apierror"
+	"github.com/pablobfonseca/go-image-vector/auth"
+	"github.com/pablobfonseca/go-image-vector/deliveries"
+	"gorm.io/gorm"
+)
+
+// deliveryListMax is the most failed webhook deliveries a page lists
+const deliveryListMax = 200
+
+// requireAdmin answers r with 403 and returns false unless it has an admin API key
+func requireAdmin(w http.ResponseWriter, r *http.Request, action string) bool {
+	if auth.Admin(r) {
+		return true
+	}
+	apierror.Write(w, r, apierror.New(http.StatusForbidden, apierror.CodeForbidden, action+" needs an admin API key in the Authorization header"))
+	return false
+}
+
+// listFailedDeliveries lists the webhook deliveries that failed and were not replayed since,
+// newest first, only to admins
+func (s *server) listFailedDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r, "Listing webhook deliveries") {
+		return
+	}
+	query := r.URL.Query()
+	if err := allowQueryParams(query, "limit", "offset"); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	limit, offset, err := parsePage(query, deliveryListMax)
+	if err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+
+	failed, total, err := deliveries.ListFailed(r.Context(), s.db, limit, offset)
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to load webhook deliveries", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"deliveries": failed,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+// replayDelivery posts a logged webhook delivery again and returns the new attempt, only
+// to admins
+func (s *server) replayDelivery(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r, "Replaying webhook deliveries") {
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id == 0 {
+		apierror.Write(w, r, apierror.InvalidParameter("id", "id must be a positive integer"))
+		return
+	}
+
+	delivery, err := deliveries.Replay(r.Context(), s.db, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Write(w, r, apierror.NotFound("Webhook delivery not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal("Failed to replay webhook delivery", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(delivery)
+}
@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"gorm.io/gorm"
+)
+
+// recordFindings runs the audit of entry on its image and returns its findings. The audit is
+// optional, so a record whose audit fails is saved without it, its Audit cleared.
+func (d Deps) recordFindings(ctx context.Context, entry *models.ImageEmbedding) []models.AccessibilityFinding {
+	if entry.Audit != services.AuditAccessibility {
+		return nil
+	}
+
+	audited, err := d.Services.AuditImageAccessibility(ctx, entry.FilePath)
+	if err != nil {
+		slog.WarnContext(ctx, "Error auditing accessibility, saving without findings", "file_path", entry.FilePath, "error", err)
+		entry.Audit = ""
+		return nil
+	}
+
+	findings := make([]models.AccessibilityFinding, len(audited))
+	for i, finding := range audited {
+		findings[i] = models.AccessibilityFinding{
+			Issue:       finding.Issue,
+			Severity:    finding.Severity,
+			Element:     finding.Element,
+			Description: finding.Description,
+		}
+	}
+	return findings
+}
+
+// createFindings stores the audit findings of a record
+func createFindings(tx *gorm.DB, recordID uint, findings []models.AccessibilityFinding) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	for i := range findings {
+		findings[i].RecordID = recordID
+	}
+	return tx.Create(&findings).Error
+}
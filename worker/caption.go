@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+// Caption modes, how a user supplied caption combines with the vision model output
+const (
+	// CaptionAugment describes the image with the vision model and embeds the caption with it
+	CaptionAugment = "augment"
+	// CaptionReplace skips the vision model, the caption is the text of the record
+	CaptionReplace = "replace"
+)
+
+// embeddingInput is the text embedded for a record, its caption first so both are searchable
+func embeddingInput(text string, caption string) string {
+	if caption == "" || caption == text {
+		return text
+	}
+	return "Caption: " + caption + "\n\n" + text
+}
+
+// applyCaption sets a new caption on an analyzed record and embeds it again, replacing the
+// text of the record with the caption in replace mode and summarizing it again
+func (d Deps) applyCaption(ctx context.Context, record *models.ImageEmbedding, caption string, mode string) error {
+	record.Caption = caption
+	columns := []any{"text", "embedding"}
+	if mode == CaptionReplace {
+		// The caption is the text now, there is no generated description left to redact
+		record.Text, record.RawText, record.Redactions = caption, "", nil
+		record.Summary, record.SummaryEmbedding = d.recordSummary(ctx, caption)
+		columns = append(columns, "raw_text", "redactions", "summary", "summary_embedding")
+	}
+
+	embedding, err := d.Services.GenerateEmbedding(ctx, embeddingInput(record.Text, record.Caption))
+	if err != nil {
+		return err
+	}
+	record.Embedding = pgvector.NewVector(embedding)
+
+	// A replaced text replaces the chunks of the old one
+	var chunks []models.DescriptionChunk
+	if mode == CaptionReplace {
+		chunks = d.recordChunks(ctx, record.Text, record.Embedding)
+	}
+
+	if err := d.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(record).Select("caption", columns...).Updates(record).Error; err != nil {
+			return err
+		}
+		if mode != CaptionReplace {
+			return nil
+		}
+		if err := tx.Where("record_id = ?", record.ID).Delete(&models.DescriptionChunk{}).Error; err != nil {
+			return err
+		}
+		// Translations of the old text are stale, descriptions provided through the API are kept
+		if err := tx.Where("record_id = ? AND source = ?", record.ID, models.DescriptionTranslated).Delete(&models.Description{}).Error; err != nil {
+			return err
+		}
+		return createChunks(tx, record.ID, chunks)
+	}); err != nil {
+		return dbError(err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/pablobfonseca/go-image-vector/hooks"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+)
+
+// Error categories of failed tasks
+const (
+	ErrorCategoryOllamaUnreachable = "ollama_unreachable"
+	ErrorCategoryModel             = "model_error"
+	ErrorCategoryDatabase          = "db_error"
+	ErrorCategoryBadInput          = "bad_input"
+	ErrorCategoryInternal          = "internal"
+)
+
+// categorizedError marks a task failure with its category
+type categorizedError struct {
+	category string
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+// dbError marks a failed database operation
+func dbError(err error) error {
+	return &categorizedError{category: ErrorCategoryDatabase, err: err}
+}
+
+// badInput marks a task whose data cannot be processed
+func badInput(err error) error {
+	return &categorizedError{category: ErrorCategoryBadInput, err: err}
+}
+
+// Classify returns the category of a task failure and whether running the task again may
+// succeed. Ollama being down and database errors are transient, as are model errors other
+// than Ollama rejecting the request, such as a model that is not pulled. Bad input fails
+// every time, as do images a pre-analysis hook rejected.
+func Classify(err error) (category string, retryable bool) {
+	var categorized *categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.category, categorized.category != ErrorCategoryBadInput
+	}
+
+	var ollamaErr *services.OllamaError
+	if errors.As(err, &ollamaErr) {
+		if ollamaErr.Unreachable {
+			return ErrorCategoryOllamaUnreachable, true
+		}
+		rejected := ollamaErr.StatusCode >= http.StatusBadRequest && ollamaErr.StatusCode < http.StatusInternalServerError &&
+			ollamaErr.StatusCode != http.StatusTooManyRequests
+		return ErrorCategoryModel, !rejected
+	}
+
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) || errors.Is(err, hooks.ErrRejected) {
+		return ErrorCategoryBadInput, false
+	}
+	return ErrorCategoryInternal, true
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/pablobfonseca/go-image-vector/hooks"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		category  string
+		retryable bool
+	}{
+		{"unreachable", &services.OllamaError{Unreachable: true, Err: errors.New("refused")}, ErrorCategoryOllamaUnreachable, true},
+		{"model not pulled", &services.OllamaError{StatusCode: http.StatusNotFound, Err: errors.New("not found")}, ErrorCategoryModel, false},
+		{"model overloaded", &services.OllamaError{StatusCode: http.StatusTooManyRequests, Err: errors.New("busy")}, ErrorCategoryModel, true},
+		{"model crashed", &services.OllamaError{StatusCode: http.StatusInternalServerError, Err: errors.New("crash")}, ErrorCategoryModel, true},
+		{"wrapped model error", fmt.Errorf("synthesis failed: %w", &services.OllamaError{Err: errors.New("bad answer")}), ErrorCategoryModel, true},
+		{"database", dbError(errors.New("connection reset")), ErrorCategoryDatabase, true},
+		{"bad input", badInput(errors.New("no file_path")), ErrorCategoryBadInput, false},
+		{"missing file", fmt.Errorf("read: %w", storage.ErrNotFound), ErrorCategoryBadInput, false},
+		{"rejected by a hook", fmt.Errorf("hook: %w", hooks.ErrRejected), ErrorCategoryBadInput, false},
+		{"anything else", errors.New("boom"), ErrorCategoryInternal, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category, retryable := Classify(tt.err)
+			if category != tt.category || retryable != tt.retryable {
+				t.Errorf("Classify() = %q, %v, want %q, %v", category, retryable, tt.category, tt.retryable)
+			}
+		})
+	}
+}
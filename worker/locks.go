@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/spf13/viper"
+)
+
+// lockRetryInterval is how often a worker waiting for a file lock tries to take it
+const lockRetryInterval = time.Second
+
+// lockFile takes the lock on a stored file for the time it is analyzed, so tasks of the same
+// content on other workers, such as from duplicate enqueues, wait for it and then find its
+// record. Stored files are named by content hash, so the path stands for the hash. The lock
+// is refreshed while held and expires after FILE_LOCK_TTL when its worker dies. Without
+// Redis, files are analyzed unlocked. The returned function releases the lock.
+func (d Deps) lockFile(ctx context.Context, filePath string) (func(), error) {
+	ttl := viper.GetDuration("FILE_LOCK_TTL")
+	name := "file:" + filePath
+
+	var lock *queue.Lock
+	for {
+		next, ok, err := d.Queue.TryLock(name, ttl)
+		if err != nil {
+			slog.WarnContext(ctx, "Error taking file lock, analyzing unlocked", "file_path", filePath, "error", err)
+			return func() {}, nil
+		}
+		if ok {
+			lock = next
+			break
+		}
+
+		slog.DebugContext(ctx, "File locked by another worker, waiting", "file_path", filePath)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if held, err := lock.Refresh(ttl); err != nil || !held {
+					slog.WarnContext(ctx, "File lock lost while analyzing", "file_path", filePath, "error", err)
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		if err := lock.Release(); err != nil {
+			slog.WarnContext(ctx, "Error releasing file lock", "file_path", filePath, "error", err)
+		}
+	}, nil
+}
@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/notify"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/spf13/viper"
+)
+
+// notifyBatchCompleted announces a finished batch analysis
+func notifyBatchCompleted(task *queue.TaskPayload, result *BatchResult) {
+	if !notify.Enabled(notify.EventBatchCompleted) {
+		return
+	}
+
+	fields := []notify.Field{
+		{Name: "Batch", Value: result.BatchID},
+		{Name: "Images", Value: strconv.Itoa(result.FileCount)},
+		{Name: "Scenario", Value: result.Scenario},
+		{Name: "Duration", Value: (time.Duration(result.ProcessingTimeMS) * time.Millisecond).String()},
+		{Name: "Record", Value: strconv.FormatUint(uint64(result.ID), 10)},
+	}
+	if len(result.SkippedChunks) > 0 {
+		fields = append(fields, notify.Field{Name: "Skipped chunks", Value: strconv.Itoa(len(result.SkippedChunks))})
+	}
+
+	notify.Send(notify.Event{
+		Kind:   notify.EventBatchCompleted,
+		Title:  "Batch analysis completed",
+		Text:   truncateText(result.Text, 300),
+		Fields: fields,
+	})
+}
+
+// notifyFailure counts a failed task, announcing repeated failures once
+// NOTIFY_FAILURE_THRESHOLD tasks failed within NOTIFY_FAILURE_WINDOW, and a dead letter list
+// that reached NOTIFY_DLQ_THRESHOLD, at most once per NOTIFY_COOLDOWN
+func (d Deps) notifyFailure(task *queue.TaskPayload, result *ErrorResult) {
+	if notify.Enabled(notify.EventTaskFailures) {
+		window := viper.GetDuration("NOTIFY_FAILURE_WINDOW")
+		count, err := d.Queue.CountInWindow("task_failures", window)
+		if err != nil {
+			slog.Warn("Error counting task failures", "error", err)
+		} else if count == viper.GetInt64("NOTIFY_FAILURE_THRESHOLD") {
+			notify.Send(notify.Event{
+				Kind:  notify.EventTaskFailures,
+				Title: fmt.Sprintf("%d tasks failed within %s", count, window),
+				Text:  "Latest failure: " + truncateText(result.Error, 300),
+				Fields: []notify.Field{
+					{Name: "Task", Value: task.TaskID},
+					{Name: "Type", Value: task.TaskType},
+					{Name: "Queue", Value: task.Queue},
+					{Name: "Category", Value: result.Category},
+					{Name: "Retryable", Value: strconv.FormatBool(result.Retryable)},
+				},
+			})
+		}
+	}
+
+	if notify.Enabled(notify.EventDeadLetters) {
+		deadLetters := queue.DeadLetterQueue(task.Queue)
+		length, err := d.Queue.Length(deadLetters)
+		if err != nil {
+			slog.Warn("Error counting dead letters", "queue", deadLetters, "error", err)
+			return
+		}
+		if length < viper.GetInt64("NOTIFY_DLQ_THRESHOLD") {
+			return
+		}
+		if claimed, err := d.Queue.ClaimOnce("notify:"+deadLetters, viper.GetDuration("NOTIFY_COOLDOWN")); err != nil || !claimed {
+			return
+		}
+		notify.Send(notify.Event{
+			Kind:  notify.EventDeadLetters,
+			Title: fmt.Sprintf("%d tasks in the dead letter list %s", length, deadLetters),
+			Text:  "Inspect them with `queue ls --dead` and retry with `queue requeue-dlq --retryable`.",
+			Fields: []notify.Field{
+				{Name: "Latest task", Value: task.TaskID},
+				{Name: "Latest error", Value: truncateText(result.Error, 300)},
+			},
+		})
+	}
+}
+
+// truncateText cuts text to at most n runes
+func truncateText(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
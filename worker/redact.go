@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pablobfonseca/go-image-vector/redact"
+	"github.com/spf13/viper"
+)
+
+// redactDescription redacts the email addresses, card numbers, tokens and, with
+// REDACT_PII_NAMES, names of people in a generated description with REDACT_PII. It returns
+// the text to store, and the original text with the kinds of data redacted from it when there
+// were any. Names are found by an extra MODEL call, whose failure only leaves names in.
+func (d Deps) redactDescription(ctx context.Context, text string) (string, string, []string) {
+	if !viper.GetBool("REDACT_PII") {
+		return text, "", nil
+	}
+
+	var names []string
+	if viper.GetBool("REDACT_PII_NAMES") {
+		var err error
+		if names, err = d.Services.DetectNames(ctx, text); err != nil {
+			slog.WarnContext(ctx, "Error detecting names, redacting without them", "error", err)
+		}
+	}
+
+	redacted, kinds := redact.Text(text, names)
+	if len(kinds) == 0 {
+		return text, "", nil
+	}
+	slog.InfoContext(ctx, "Redacted description", "kinds", kinds)
+	return redacted, text, kinds
+}
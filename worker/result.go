@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/services"
+)
+
+// Result types, recorded in every task result so it can be decoded into its struct
+const (
+	ResultTypeAnalyzeImage = TaskTypeAnalyzeImage
+	ResultTypeBatch        = TaskTypeAnalyzeMultipleImages
+	ResultTypeTranslation  = TaskTypeTranslateDescriptions
+	ResultTypeScenes       = TaskTypeAnalyzeScenes
+	ResultTypeError        = "error"
+)
+
+// AnalyzeImageResult is the result of an analyze_image task
+type AnalyzeImageResult struct {
+	Type         string `json:"type"`
+	ID           uint   `json:"id"`
+	FilePath     string `json:"file_path"`
+	OriginalName string `json:"original_name"`
+	MediaType    string `json:"media_type,omitempty"`
+	OriginalPath string `json:"original_path,omitempty"`
+	PreviewPath  string `json:"preview_path,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Summary      string `json:"summary,omitempty"`
+	Text         string `json:"text"`
+	Caption      string `json:"caption,omitempty"`
+	Visibility   string `json:"visibility,omitempty"`
+	// Audit is the audit the image passed, with its AccessibilityFindings for accessibility
+	Audit                 string                        `json:"audit,omitempty"`
+	AccessibilityFindings []models.AccessibilityFinding `json:"accessibility_findings,omitempty"`
+	// Redactions lists the kinds of data REDACT_PII redacted from Text
+	Redactions []string `json:"redactions,omitempty"`
+	// Existing is true when the file was analyzed before and its record was reused
+	Existing bool `json:"existing,omitempty"`
+}
+
+// BatchImageResult is an image of a batch analyzed on its own, at its step in the journey
+type BatchImageResult struct {
+	ID       uint   `json:"id"`
+	FilePath string `json:"file_path"`
+	Sequence int    `json:"sequence"`
+	Existing bool   `json:"existing"`
+}
+
+// SceneResult is a scene of a screen recording, a step of its journey, described from its
+// first frame at OffsetMS into the recording
+type SceneResult struct {
+	Step      int    `json:"step"`
+	OffsetMS  int64  `json:"offset_ms"`
+	Timestamp string `json:"timestamp"`
+	FilePath  string `json:"file_path"`
+	Text      string `json:"text"`
+}
+
+// BatchResult is the result of an analyze_multiple_images task, the journey record, and of an
+// analyze_scenes task, the journey record of a screen recording
+type BatchResult struct {
+	Type             string   `json:"type"`
+	ID               uint     `json:"id"`
+	FilePath         string   `json:"file_path"`
+	Title            string   `json:"title,omitempty"`
+	Summary          string   `json:"summary,omitempty"`
+	Text             string   `json:"text"`
+	FileCount        int      `json:"file_count"`
+	IsBatch          bool     `json:"is_batch"`
+	BatchID          string   `json:"batch_id"`
+	BatchPaths       []string `json:"batch_paths"`
+	PreviewPath      string   `json:"preview_path,omitempty"`
+	Scenario         string   `json:"scenario"`
+	ProcessingTimeMS int64    `json:"processing_time_ms"`
+	// Redactions lists the kinds of data REDACT_PII redacted from Text
+	Redactions []string `json:"redactions,omitempty"`
+	// Images lists the records of the images analyzed on their own, with per_image
+	Images []BatchImageResult `json:"images,omitempty"`
+	// SkippedChunks are the chunks left out after their retries failed, the narrative does
+	// not cover their images
+	SkippedChunks []services.SkippedChunk `json:"skipped_chunks,omitempty"`
+	// Scenes are the scenes of a screen recording, in order
+	Scenes []SceneResult `json:"scenes,omitempty"`
+}
+
+// TranslationResult is the result of a translate_descriptions task
+type TranslationResult struct {
+	Type      string   `json:"type"`
+	Languages []string `json:"languages"`
+	// Records is the number of records considered, Translated the descriptions stored,
+	// Skipped the ones records already had and Failed the ones the model failed to translate
+	Records    int `json:"records"`
+	Translated int `json:"translated"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+}
+
+// ErrorResult is the result of a failed task
+type ErrorResult struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+	// Category is one of the ErrorCategory values, and Retryable tells whether running the
+	// task again may succeed
+	Category  string `json:"category,omitempty"`
+	Retryable bool   `json:"retryable"`
+	// ModerationReason and OriginalName are set for uploads quarantined by the scanner
+	ModerationReason string `json:"moderation_reason,omitempty"`
+	OriginalName     string `json:"original_name,omitempty"`
+}
+
+// NewErrorResult returns the result of a task that failed with err, classified
+func NewErrorResult(err error) *ErrorResult {
+	category, retryable := Classify(err)
+	return &ErrorResult{Type: ResultTypeError, Error: err.Error(), Category: category, Retryable: retryable}
+}
+
+// DecodeResult decodes a stored task result into its result struct. Results stored before
+// they carried a type are recognized by their fields.
+func DecodeResult(data []byte) (any, error) {
+	var probe struct {
+		Type    string  `json:"type"`
+		Error   *string `json:"error"`
+		IsBatch bool    `json:"is_batch"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+
+	resultType := probe.Type
+	if resultType == "" {
+		switch {
+		case probe.Error != nil:
+			resultType = ResultTypeError
+		case probe.IsBatch:
+			resultType = ResultTypeBatch
+		default:
+			resultType = ResultTypeAnalyzeImage
+		}
+	}
+
+	switch resultType {
+	case ResultTypeAnalyzeImage:
+		result := &AnalyzeImageResult{}
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, err
+		}
+		result.Type = resultType
+		return result, nil
+	case ResultTypeBatch, ResultTypeScenes:
+		result := &BatchResult{}
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, err
+		}
+		result.Type = resultType
+		return result, nil
+	case ResultTypeTranslation:
+		result := &TranslationResult{}
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, err
+		}
+		result.Type = resultType
+		return result, nil
+	case ResultTypeError:
+		result := &ErrorResult{}
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, err
+		}
+		result.Type = resultType
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unknown task result type %q", resultType)
+	}
+}
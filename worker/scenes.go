@@ -0,0 +1,141 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/events"
+	"github.com/pablobfonseca/go-image-vector/hooks"
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pgvector/pgvector-go"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// processSceneAnalysisTask cuts a screen recording into scenes where the picture changes by
+// more than VIDEO_SCENE_THRESHOLD and describes each scene from its first frame. The recording
+// becomes a journey record like a batch, whose steps are the scenes: its batch paths are the
+// first frames and its frames are the scenes, at their offsets into the recording.
+func (d Deps) processSceneAnalysisTask(ctx context.Context, task *queue.TaskPayload) (*BatchResult, error) {
+	filePath, ok := task.Data["file_path"].(string)
+	if !ok {
+		return nil, badInput(errors.New("task has no file_path"))
+	}
+	originalName, _ := task.Data["original_name"].(string)
+	mediaType, _ := task.Data["media_type"].(string)
+	originalPath, _ := task.Data["original_path"].(string)
+
+	startTime := time.Now()
+	data, err := storage.ReadFile(ctx, d.Files, filePath)
+	if err != nil {
+		return nil, err
+	}
+	extracted, err := services.ExtractScenes(ctx, bytes.NewReader(data), path.Ext(filePath),
+		viper.GetFloat64("VIDEO_SCENE_THRESHOLD"), viper.GetInt("VIDEO_MAX_SCENES"))
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Describing scenes", "task_id", task.TaskID, "file_path", filePath, "scenes", len(extracted))
+
+	journeyEntry := models.ImageEmbedding{
+		ID:           reservedRecordID(task),
+		FilePath:     filePath,
+		OriginalName: originalName,
+		MediaType:    mediaType,
+		OriginalPath: originalPath,
+		IsBatch:      true,
+		BatchID:      task.TaskID,
+		Visibility:   taskVisibility(task),
+	}
+
+	// Each scene lasts until the next one starts, the last one until the end of the recording
+	key := storage.Key(filePath)
+	frames := make([]models.VideoFrame, 0, len(extracted))
+	scenes := make([]SceneResult, 0, len(extracted))
+	var steps, rawSteps []string
+	for i, scene := range extracted {
+		frame, raw, err := d.describeFrame(ctx, &journeyEntry, key, scene)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+		journeyEntry.BatchPaths = append(journeyEntry.BatchPaths, frame.FilePath)
+		scenes = append(scenes, SceneResult{
+			Step:      i + 1,
+			OffsetMS:  frame.OffsetMS,
+			Timestamp: services.FormatTimestamp(scene.Offset),
+			FilePath:  frame.FilePath,
+			Text:      frame.Text,
+		})
+
+		heading := fmt.Sprintf("Step %d (from %s)", i+1, services.FormatTimestamp(scene.Offset))
+		if i+1 < len(extracted) {
+			heading = fmt.Sprintf("Step %d (%s to %s)", i+1, services.FormatTimestamp(scene.Offset),
+				services.FormatTimestamp(extracted[i+1].Offset))
+		}
+		steps = append(steps, heading+"\n"+frame.Text)
+		rawSteps = append(rawSteps, heading+"\n"+raw)
+	}
+	journeyEntry.Text = strings.Join(steps, "\n\n")
+	if len(journeyEntry.Redactions) > 0 {
+		journeyEntry.RawText = strings.Join(rawSteps, "\n\n")
+	}
+
+	embedding, err := d.Services.GenerateEmbedding(ctx, journeyEntry.Text)
+	if err != nil {
+		return nil, err
+	}
+	journeyEntry.Embedding = pgvector.NewVector(embedding)
+	journeyEntry.Title = d.recordTitle(ctx, journeyEntry.Text)
+	journeyEntry.Summary, journeyEntry.SummaryEmbedding = d.recordSummary(ctx, journeyEntry.Text)
+	chunks := d.recordChunks(ctx, journeyEntry.Text, journeyEntry.Embedding)
+	journeyEntry.PreviewPath = d.videoPreview(ctx, filePath)
+
+	if err := d.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&journeyEntry).Error; err != nil {
+			return err
+		}
+		if err := createChunks(tx, journeyEntry.ID, chunks); err != nil {
+			return err
+		}
+		if err := createFrames(tx, journeyEntry.ID, frames); err != nil {
+			return err
+		}
+		payload := events.Media(&journeyEntry)
+		payload["file_count"] = len(frames)
+		payload["scenes"] = len(frames)
+		return events.Record(tx, events.BatchCompleted, payload)
+	}); err != nil {
+		return nil, dbError(err)
+	}
+	hooks.AfterPersist(ctx, &journeyEntry)
+
+	processingTime := time.Since(startTime)
+	slog.InfoContext(ctx, "Scene analysis completed", "task_id", task.TaskID, "scenes", len(frames), "duration", processingTime)
+
+	return &BatchResult{
+		Type:             ResultTypeScenes,
+		ID:               journeyEntry.ID,
+		FilePath:         journeyEntry.FilePath,
+		Title:            journeyEntry.Title,
+		Summary:          journeyEntry.Summary,
+		Text:             journeyEntry.Text,
+		FileCount:        len(frames),
+		IsBatch:          true,
+		BatchID:          journeyEntry.BatchID,
+		BatchPaths:       journeyEntry.BatchPaths,
+		PreviewPath:      journeyEntry.PreviewPath,
+		ProcessingTimeMS: processingTime.Milliseconds(),
+		Redactions:       journeyEntry.Redactions,
+		Scenes:           scenes,
+	}, nil
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm/clause"
+)
+
+// translationPageSize is the number of records a translation task loads at a time
+const translationPageSize = 100
+
+// translationTaskData is the data of a translate_descriptions task
+type translationTaskData struct {
+	// Languages are the language tags descriptions are translated into
+	Languages []string `json:"languages"`
+	// RecordIDs limits the task to these records, every record when empty
+	RecordIDs []uint `json:"record_ids"`
+}
+
+// processTranslationTask translates the descriptions of records into every language of the
+// task they have no description in, storing each translation embedded for language searches.
+// Descriptions already stored are kept, so a task run again after an interruption continues
+// where it stopped. A translation the model fails is skipped, unless Ollama is unreachable
+// or the task is interrupted, which fails the task.
+func (d Deps) processTranslationTask(ctx context.Context, task *queue.TaskPayload) (*TranslationResult, error) {
+	// Task data is decoded from JSON by the broker, so it is read back through JSON
+	encoded, err := json.Marshal(task.Data)
+	if err != nil {
+		return nil, badInput(err)
+	}
+	var data translationTaskData
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		return nil, badInput(err)
+	}
+	if len(data.Languages) == 0 {
+		return nil, badInput(errors.New("task has no languages"))
+	}
+
+	result := &TranslationResult{Type: ResultTypeTranslation, Languages: data.Languages}
+	var lastID uint
+	for {
+		query := d.DB.WithContext(ctx).Select("id", "text").Where("id > ? AND text <> ''", lastID)
+		if len(data.RecordIDs) > 0 {
+			query = query.Where("id IN ?", data.RecordIDs)
+		}
+		var records []models.ImageEmbedding
+		if err := query.Order("id").Limit(translationPageSize).Find(&records).Error; err != nil {
+			return nil, dbError(err)
+		}
+		if len(records) == 0 {
+			return result, nil
+		}
+
+		for _, record := range records {
+			if err := d.translateRecord(ctx, record, data.Languages, result); err != nil {
+				return nil, err
+			}
+		}
+		lastID = records[len(records)-1].ID
+	}
+}
+
+// translateRecord translates the description of a record into the languages it has no
+// description in, counting the outcome in result
+func (d Deps) translateRecord(ctx context.Context, record models.ImageEmbedding, languages []string, result *TranslationResult) error {
+	var existing []string
+	if err := d.DB.WithContext(ctx).Model(&models.Description{}).Where("record_id = ?", record.ID).
+		Pluck("language", &existing).Error; err != nil {
+		return dbError(err)
+	}
+	result.Records++
+
+	for _, language := range languages {
+		if slices.Contains(existing, language) {
+			result.Skipped++
+			continue
+		}
+
+		description, err := d.translateDescription(ctx, record.Text, language)
+		if err != nil {
+			if category, _ := Classify(err); ctx.Err() != nil || category == ErrorCategoryOllamaUnreachable {
+				return err
+			}
+			slog.WarnContext(ctx, "Error translating description, skipping it", "record_id", record.ID,
+				"language", language, "error", err)
+			result.Failed++
+			continue
+		}
+		description.RecordID = record.ID
+
+		// A description stored through the API meanwhile is kept
+		if err := d.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&description).Error; err != nil {
+			return dbError(err)
+		}
+		result.Translated++
+	}
+	return nil
+}
+
+// translateDescription translates a description into a language and embeds the translation
+func (d Deps) translateDescription(ctx context.Context, text string, language string) (models.Description, error) {
+	translation, err := d.Services.Translate(ctx, text, language)
+	if err != nil {
+		return models.Description{}, err
+	}
+	embedding, err := d.Services.GenerateEmbedding(ctx, translation)
+	if err != nil {
+		return models.Description{}, err
+	}
+	return models.Description{
+		Language:  language,
+		Text:      translation,
+		Source:    models.DescriptionTranslated,
+		Embedding: pgvector.NewVector(embedding),
+	}, nil
+}
@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pablobfonseca/go-image-vector/models"
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pgvector/pgvector-go"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// describeVideo samples a frame of a video record every VIDEO_FRAME_INTERVAL, stores each as
+// a JPEG next to the video, and describes and embeds it. The description of the record is the
+// timeline of the descriptions of its frames, each redacted with REDACT_PII like an image.
+func (d Deps) describeVideo(ctx context.Context, entry *models.ImageEmbedding) (string, []models.VideoFrame, error) {
+	data, err := storage.ReadFile(ctx, d.Files, entry.FilePath)
+	if err != nil {
+		return "", nil, err
+	}
+	extracted, err := services.ExtractFrames(ctx, bytes.NewReader(data), path.Ext(entry.FilePath),
+		viper.GetDuration("VIDEO_FRAME_INTERVAL"), viper.GetInt("VIDEO_MAX_FRAMES"))
+	if err != nil {
+		return "", nil, err
+	}
+	slog.InfoContext(ctx, "Describing video frames", "file_path", entry.FilePath, "frames", len(extracted))
+
+	key := storage.Key(entry.FilePath)
+	frames := make([]models.VideoFrame, 0, len(extracted))
+	var timeline, rawTimeline []string
+	for _, frame := range extracted {
+		described, raw, err := d.describeFrame(ctx, entry, key, frame)
+		if err != nil {
+			return "", nil, err
+		}
+		frames = append(frames, described)
+		timestamp := "[" + services.FormatTimestamp(frame.Offset) + "] "
+		timeline = append(timeline, timestamp+described.Text)
+		rawTimeline = append(rawTimeline, timestamp+raw)
+	}
+
+	if len(entry.Redactions) > 0 {
+		entry.RawText = strings.Join(rawTimeline, "\n\n")
+	}
+	return strings.Join(timeline, "\n\n"), frames, nil
+}
+
+// describeFrame stores a frame of the video of entry as a JPEG, describes and embeds it,
+// redacting the description with REDACT_PII, and adds the kinds redacted to entry. It returns
+// the frame and its description before redaction.
+func (d Deps) describeFrame(ctx context.Context, entry *models.ImageEmbedding, key string, frame services.Frame) (models.VideoFrame, string, error) {
+	// Frames are stored under the content-addressed key of their video, so uploads of the
+	// same video share them
+	frameKey := fmt.Sprintf("%s.%dms.jpg", key, frame.Offset.Milliseconds())
+	if err := d.saveDerivedFile(ctx, key, frameKey, frame.Data); err != nil {
+		return models.VideoFrame{}, "", fmt.Errorf("failed to save frame: %w", err)
+	}
+	framePath := storage.Path(frameKey)
+
+	text, err := d.Services.ExtractTextFromImage(ctx, framePath)
+	if err != nil {
+		return models.VideoFrame{}, "", fmt.Errorf("failed to describe frame at %s: %w", services.FormatTimestamp(frame.Offset), err)
+	}
+	text, raw, kinds := d.redactDescription(ctx, text)
+	embedding, err := d.Services.GenerateEmbedding(ctx, text)
+	if err != nil {
+		return models.VideoFrame{}, "", err
+	}
+	for _, kind := range kinds {
+		if !slices.Contains(entry.Redactions, kind) {
+			entry.Redactions = append(entry.Redactions, kind)
+		}
+	}
+
+	return models.VideoFrame{
+		OffsetMS:  frame.Offset.Milliseconds(),
+		FilePath:  framePath,
+		Text:      text,
+		Embedding: pgvector.NewVector(embedding),
+	}, cmp.Or(raw, text), nil
+}
+
+// videoPreview stores the animated preview of a video with VIDEO_PREVIEWS, next to the video,
+// and returns its path. The preview is optional, so a failure leaves the record without one.
+func (d Deps) videoPreview(ctx context.Context, filePath string) string {
+	if !viper.GetBool("VIDEO_PREVIEWS") {
+		return ""
+	}
+
+	data, err := storage.ReadFile(ctx, d.Files, filePath)
+	if err != nil {
+		slog.WarnContext(ctx, "Error reading video, saving without a preview", "file_path", filePath, "error", err)
+		return ""
+	}
+	preview, err := services.GeneratePreview(ctx, bytes.NewReader(data), path.Ext(filePath),
+		viper.GetDuration("VIDEO_PREVIEW_DURATION"), viper.GetInt("VIDEO_PREVIEW_WIDTH"), viper.GetInt("VIDEO_PREVIEW_FPS"))
+	if err != nil {
+		slog.WarnContext(ctx, "Error generating video preview, saving without one", "file_path", filePath, "error", err)
+		return ""
+	}
+
+	previewKey := storage.Key(filePath) + ".preview.gif"
+	if err := d.saveDerivedFile(ctx, storage.Key(filePath), previewKey, preview); err != nil {
+		slog.WarnContext(ctx, "Error saving video preview, saving without one", "file_path", filePath, "error", err)
+		return ""
+	}
+	return storage.Path(previewKey)
+}
+
+// createFrames stores the frames of a video record, in the transaction creating it
+func createFrames(tx *gorm.DB, recordID uint, frames []models.VideoFrame) error {
+	if len(frames) == 0 {
+		return nil
+	}
+	for i := range frames {
+		frames[i].RecordID = recordID
+	}
+	return tx.Create(&frames).Error
+}
+
+// saveDerivedFile saves a file derived from the stored file source, such as a frame of a
+// video, unless it is stored already. Its size is reserved in the storage usage of the API key
+// that stored source first, without a quota as source passed it, and released when the save
+// fails.
+func (d Deps) saveDerivedFile(ctx context.Context, source string, key string, data []byte) error {
+	keyID, err := d.Queue.StoredFileOwner(source)
+	if err != nil {
+		return err
+	}
+	reserved, err := d.Queue.ReserveStoredFile(key, int64(len(data)), keyID, queue.StorageQuota{})
+	if err != nil {
+		return err
+	}
+
+	if _, err := storage.SaveIfMissing(ctx, d.Files, key, bytes.NewReader(data)); err != nil {
+		if reserved {
+			if err := d.Queue.UntrackStoredFile(key); err != nil {
+				slog.ErrorContext(ctx, "Error releasing storage usage", "key", key, "error", err)
+			}
+		}
+		return err
+	}
+	return nil
+}
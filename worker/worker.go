@@ -1,83 +1,149 @@
 package worker
 
 import (
+	"cmp"
 	"context"
-	"log"
-	"os"
-	"os/signal"
-	"syscall"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
-	"github.com/pablobfonseca/go-image-vector/database"
+	"github.com/pablobfonseca/go-image-vector/cleanup"
+	"github.com/pablobfonseca/go-image-vector/events"
+	"github.com/pablobfonseca/go-image-vector/hooks"
+	"github.com/pablobfonseca/go-image-vector/logging"
 	"github.com/pablobfonseca/go-image-vector/models"
 	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/reporting"
 	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/storage"
+	"github.com/pablobfonseca/go-image-vector/tracing"
 	"github.com/pgvector/pgvector-go"
 	"github.com/spf13/viper"
+	"gorm.io/gorm"
 )
 
 // Task types
 const (
 	TaskTypeAnalyzeImage          = "analyze_image"
 	TaskTypeAnalyzeMultipleImages = "analyze_multiple_images"
+	TaskTypeTranslateDescriptions = "translate_descriptions"
+	TaskTypeAnalyzeScenes         = "analyze_scenes"
 )
 
+// Deps are the connections tasks are processed with
+type Deps struct {
+	DB *gorm.DB
+	// Queue holds the dead letters, chunk checkpoints and failure counters
+	Queue *queue.Client
+	// Tasks is the broker tasks are taken from and report their status to, usually Queue
+	Tasks queue.Broker
+	// Files is the storage of uploaded files and the frames and previews made from them
+	Files storage.Storage
+	// Services are the models describing and embedding images and texts, reading from Files
+	Services services.Clients
+}
+
 // Worker represents a background worker that processes tasks from a queue
 type Worker struct {
-	queueName  string
+	deps       Deps
+	queueNames []string
 	numWorkers int
 	stopChan   chan struct{}
-	doneChan   chan struct{}
+	stopOnce   sync.Once
+	running    sync.WaitGroup
+
+	// ctx is cancelled on stop, interrupting the tasks in progress so they are requeued
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-// NewWorker creates a new worker that processes tasks from the specified queue
-func NewWorker(queueName string, numWorkers int) *Worker {
+// NewWorker creates a new worker that processes tasks from the specified queues, by priority
+// and then in the order given
+func NewWorker(deps Deps, queueNames []string, numWorkers int) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Worker{
-		queueName:  queueName,
+		deps:       deps,
+		queueNames: queueNames,
 		numWorkers: numWorkers,
 		stopChan:   make(chan struct{}),
-		doneChan:   make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 }
 
-// Start begins processing tasks from the queue
+// Start begins processing tasks from the queue. The caller stops the workers, with Stop or
+// Drain, on shutdown.
 func (w *Worker) Start() {
-	log.Printf("Starting %d workers for queue %s", w.numWorkers, w.queueName)
+	slog.Info("Starting workers", "count", w.numWorkers, "queues", w.queueNames)
 
+	w.running.Add(w.numWorkers)
 	for i := range w.numWorkers {
 		go w.processItems(i)
 	}
+}
+
+// Stop stops the workers, interrupting the tasks in progress so they are requeued
+func (w *Worker) Stop() {
+	slog.Info("Stopping workers")
+	w.stopDequeuing()
+	w.cancel()
+	w.running.Wait()
+	slog.Info("All workers stopped")
+}
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+// Drain stops the workers from taking tasks and waits for the tasks in progress to finish.
+// Tasks still running when ctx is done are interrupted and requeued, and ctx's error is
+// returned once their workers stopped.
+func (w *Worker) Drain(ctx context.Context) error {
+	slog.Info("Draining workers")
+	w.stopDequeuing()
 
+	done := make(chan struct{})
 	go func() {
-		<-sigChan
-		log.Println("Received shutdown signal, stopping workers...")
-		close(w.stopChan)
+		w.running.Wait()
+		close(done)
 	}()
+
+	select {
+	case <-done:
+		slog.Info("All workers stopped")
+		return nil
+	case <-ctx.Done():
+		slog.Warn("Interrupting tasks still in progress", "error", ctx.Err())
+		w.cancel()
+		<-done
+		slog.Info("All workers stopped")
+		return ctx.Err()
+	}
 }
 
-// Stop signals the workers to stop processing tasks
-func (w *Worker) Stop() {
-	log.Println("Stopping workers...")
-	close(w.stopChan)
+// stopDequeuing tells the workers to stop taking tasks, once
+func (w *Worker) stopDequeuing() {
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+	})
+}
 
-	// Wait for all workers to finish
-	for range w.numWorkers {
-		<-w.doneChan
+// stopping reports whether the workers were told to stop taking tasks
+func (w *Worker) stopping() bool {
+	select {
+	case <-w.stopChan:
+		return true
+	default:
+		return false
 	}
-
-	log.Println("All workers stopped")
 }
 
 // processItems continuously processes tasks from the queue
 func (w *Worker) processItems(workerID int) {
-	log.Printf("Worker %d started", workerID)
+	logger := slog.With("worker_id", workerID)
+	logger.Info("Worker started")
 	defer func() {
-		log.Printf("Worker %d stopped", workerID)
-		w.doneChan <- struct{}{}
+		logger.Info("Worker stopped")
+		w.running.Done()
 	}()
 
 	for {
@@ -86,9 +152,9 @@ func (w *Worker) processItems(workerID int) {
 			return
 		default:
 			// Try to get a task from the queue with a timeout
-			task, err := queue.Dequeue(w.queueName, 5*time.Second)
+			task, err := w.deps.Tasks.Dequeue(w.queueNames, 5*time.Second)
 			if err != nil {
-				log.Printf("Error dequeueing task: %v", err)
+				logger.Error("Error dequeueing task", "error", err)
 				time.Sleep(1 * time.Second)
 				continue
 			}
@@ -99,97 +165,421 @@ func (w *Worker) processItems(workerID int) {
 				continue
 			}
 
-			log.Printf("Worker %d processing task %s of type %s", workerID, task.TaskID, task.TaskType)
+			// A task taken while the workers were told to stop is left to the next worker
+			if w.stopping() {
+				logger.Info("Requeueing task taken during shutdown", "task_id", task.TaskID)
+				if err := w.deps.Tasks.Requeue(task); err != nil {
+					logger.Error("Error requeueing task", "task_id", task.TaskID, "error", err)
+				}
+				return
+			}
+
+			taskLogger := logger.With("task_id", task.TaskID, "task_type", task.TaskType,
+				"queue", task.Queue, "priority", cmp.Or(task.Priority, queue.PriorityNormal))
+			if task.RequestID != "" {
+				taskLogger = taskLogger.With("request_id", task.RequestID)
+			}
+			if task.Attempt > 0 {
+				taskLogger = taskLogger.With("attempt", task.Attempt)
+			}
+			taskLogger.Info("Processing task")
+
+			// Continue the trace started by the enqueuing request, recording the time spent queued
+			taskCtx := tracing.Extract(w.ctx, task.TraceParent)
+			taskCtx = logging.ContextWithRequestID(taskCtx, task.RequestID)
+			_, waitSpan := tracing.Start(taskCtx, "queue.wait", tracing.KindConsumer, "task_id", task.TaskID)
+			waitSpan.Start = task.Created
+			waitSpan.End()
+
+			taskCtx, span := tracing.Start(taskCtx, "task."+task.TaskType, tracing.KindConsumer,
+				"task_id", task.TaskID,
+				"worker_id", workerID,
+			)
 
 			// Update task status to "processing"
-			if err := queue.SetTaskStatus(task.TaskID, "processing"); err != nil {
-				log.Printf("Error updating task status: %v", err)
+			if err := w.deps.Tasks.SetTaskStatus(task.TaskID, "processing"); err != nil {
+				taskLogger.Error("Error updating task status", "error", err)
 			}
 
 			// Process the task based on its type
-			var processErr error
-			var result map[string]any
-
-			switch task.TaskType {
-			case TaskTypeAnalyzeImage:
-				result, processErr = processImageAnalysisTask(task)
-			case TaskTypeAnalyzeMultipleImages:
-				result, processErr = processMultipleImagesAnalysisTask(task)
-			default:
-				processErr = nil
-				result = map[string]any{
-					"error": "unknown task type",
-				}
-			}
+			result, processErr := w.deps.processTask(taskCtx, task, workerID)
+
+			span.RecordError(processErr)
+			span.End()
 
 			// Update task status based on result
-			if processErr != nil {
-				log.Printf("Error processing task %s: %v", task.TaskID, processErr)
-				if err := queue.SetTaskStatus(task.TaskID, "failed"); err != nil {
-					log.Printf("Error updating task status: %v", err)
+			if processErr != nil && w.ctx.Err() != nil {
+				// Interrupted by shutdown: the next worker picks the task up again, and batches
+				// resume from their chunk checkpoints
+				taskLogger.Info("Requeueing task interrupted by shutdown")
+				if err := w.deps.Tasks.Requeue(task); err != nil {
+					taskLogger.Error("Error requeueing task", "error", err)
 				}
-				if err := queue.StoreTaskResult(task.TaskID, map[string]any{
-					"error": processErr.Error(),
-				}); err != nil {
-					log.Printf("Error storing task result: %v", err)
+				if err := w.deps.Tasks.SetTaskStatus(task.TaskID, "pending"); err != nil {
+					taskLogger.Error("Error updating task status", "error", err)
 				}
+			} else if processErr != nil {
+				taskLogger.Error("Error processing task", "error", processErr)
+				reporting.Capture(taskCtx, processErr,
+					"task_id", task.TaskID,
+					"task_type", task.TaskType,
+					"worker_id", workerID,
+				)
+				w.deps.Fail(task, processErr, taskLogger)
 			} else {
-				if err := queue.SetTaskStatus(task.TaskID, "completed"); err != nil {
-					log.Printf("Error updating task status: %v", err)
+				if err := w.deps.Tasks.SetTaskStatus(task.TaskID, "completed"); err != nil {
+					taskLogger.Error("Error updating task status", "error", err)
 				}
-				if err := queue.StoreTaskResult(task.TaskID, result); err != nil {
-					log.Printf("Error storing task result: %v", err)
+				if err := w.deps.Tasks.StoreTaskResult(task.TaskID, result); err != nil {
+					taskLogger.Error("Error storing task result", "error", err)
+				}
+				if batch, ok := result.(*BatchResult); ok {
+					notifyBatchCompleted(task, batch)
 				}
 			}
 		}
 	}
 }
 
+// Fail records a task that failed with err: its status, its classified result and its dead
+// letter, from which it can be retried. Repeated failures and a growing dead letter list are
+// notified.
+func (d Deps) Fail(task *queue.TaskPayload, err error, logger *slog.Logger) *ErrorResult {
+	if err := d.Tasks.SetTaskStatus(task.TaskID, "failed"); err != nil {
+		logger.Error("Error updating task status", "error", err)
+	}
+	result := NewErrorResult(err)
+	if err := d.Tasks.StoreTaskResult(task.TaskID, result); err != nil {
+		logger.Error("Error storing task result", "error", err)
+	}
+	task.ErrorCategory, task.Retryable = result.Category, result.Retryable
+	if err := d.Queue.DeadLetter(task.Queue, task, err.Error()); err != nil {
+		logger.Error("Error dead-lettering task", "error", err)
+	}
+
+	d.notifyFailure(task, result)
+	return result
+}
+
+// processTask runs the handler for the task type, turning a panic into a task failure
+func (d Deps) processTask(ctx context.Context, task *queue.TaskPayload, workerID int) (result any, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			reporting.CapturePanic(ctx, recovered,
+				"task_id", task.TaskID,
+				"task_type", task.TaskType,
+				"worker_id", workerID,
+			)
+			result, err = nil, fmt.Errorf("task panicked: %v", recovered)
+		}
+	}()
+
+	switch task.TaskType {
+	case TaskTypeAnalyzeImage:
+		return d.processImageAnalysisTask(ctx, task)
+	case TaskTypeAnalyzeMultipleImages:
+		return d.processMultipleImagesAnalysisTask(ctx, task)
+	case TaskTypeTranslateDescriptions:
+		return d.processTranslationTask(ctx, task)
+	case TaskTypeAnalyzeScenes:
+		return d.processSceneAnalysisTask(ctx, task)
+	default:
+		return NewErrorResult(badInput(fmt.Errorf("unknown task type %q", task.TaskType))), nil
+	}
+}
+
+// Process runs a task inline instead of on a worker, for uploads waiting on their analysis
+func (d Deps) Process(ctx context.Context, task *queue.TaskPayload) (any, error) {
+	return d.processTask(ctx, task, 0)
+}
+
 // processImageAnalysisTask processes an image analysis task
-func processImageAnalysisTask(task *queue.TaskPayload) (map[string]any, error) {
+func (d Deps) processImageAnalysisTask(ctx context.Context, task *queue.TaskPayload) (*AnalyzeImageResult, error) {
 	// Extract file path from task data
 	filePath, ok := task.Data["file_path"].(string)
 	if !ok {
-		return nil, nil
+		return nil, badInput(errors.New("task has no file_path"))
+	}
+
+	originalName, _ := task.Data["original_name"].(string)
+	mediaType, _ := task.Data["media_type"].(string)
+	originalPath, _ := task.Data["original_path"].(string)
+	caption, _ := task.Data["caption"].(string)
+	captionMode, _ := task.Data["caption_mode"].(string)
+	visibility := taskVisibility(task)
+
+	// Coordinates are only present for photos carrying EXIF GPS tags
+	var latitude, longitude *float64
+	if lat, ok := task.Data["latitude"].(float64); ok {
+		if lon, ok := task.Data["longitude"].(float64); ok {
+			latitude, longitude = &lat, &lon
+		}
+	}
+	var takenAt *time.Time
+	if value, ok := task.Data["taken_at"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+			takenAt = &parsed
+		}
 	}
 
-	// Extract text from image using AI
-	text, err := services.ExtractTextFromImage(filePath)
+	imageEntry, existing, err := d.analyzeImage(ctx, models.ImageEmbedding{
+		ID:           reservedRecordID(task),
+		FilePath:     filePath,
+		OriginalName: originalName,
+		MediaType:    mediaType,
+		OriginalPath: originalPath,
+		Latitude:     latitude,
+		Longitude:    longitude,
+		TakenAt:      takenAt,
+		Caption:      caption,
+		Visibility:   visibility,
+		Audit:        taskAudit(task),
+	}, captionMode)
 	if err != nil {
 		return nil, err
 	}
+	if err := d.Tasks.LinkRecordTask(imageEntry.ID, task.TaskID); err != nil {
+		slog.WarnContext(ctx, "Error linking task to its record", "task_id", task.TaskID, "record_id", imageEntry.ID, "error", err)
+	}
+	if existing {
+		slog.InfoContext(ctx, "File already analyzed, skipping", "task_id", task.TaskID, "file_path", filePath, "record_id", imageEntry.ID)
+		return &AnalyzeImageResult{
+			Type:         ResultTypeAnalyzeImage,
+			ID:           imageEntry.ID,
+			FilePath:     imageEntry.FilePath,
+			OriginalName: originalName,
+			Title:        imageEntry.Title,
+			Summary:      imageEntry.Summary,
+			Text:         imageEntry.Text,
+			Caption:      imageEntry.Caption,
+			Visibility:   imageEntry.Visibility,
+			Audit:        imageEntry.Audit,
+			Existing:     true,
+		}, nil
+	}
+
+	// Return result
+	return &AnalyzeImageResult{
+		Type:                  ResultTypeAnalyzeImage,
+		ID:                    imageEntry.ID,
+		FilePath:              imageEntry.FilePath,
+		OriginalName:          imageEntry.OriginalName,
+		MediaType:             imageEntry.MediaType,
+		OriginalPath:          imageEntry.OriginalPath,
+		PreviewPath:           imageEntry.PreviewPath,
+		Title:                 imageEntry.Title,
+		Summary:               imageEntry.Summary,
+		Text:                  imageEntry.Text,
+		Caption:               imageEntry.Caption,
+		Visibility:            imageEntry.Visibility,
+		Audit:                 imageEntry.Audit,
+		Redactions:            imageEntry.Redactions,
+		AccessibilityFindings: imageEntry.AccessibilityFindings,
+	}, nil
+}
+
+// analyzeImage describes and embeds one image and stores entry with the result. The same
+// bytes map to the same file, so a previous analysis of the file is returned instead when
+// there is one, reporting that it already existed. A caption on entry is embedded with the
+// description, or replaces it in CaptionReplace mode, and is applied to a previous analysis.
+func (d Deps) analyzeImage(ctx context.Context, entry models.ImageEmbedding, captionMode string) (models.ImageEmbedding, bool, error) {
+	unlock, err := d.lockFile(ctx, entry.FilePath)
+	if err != nil {
+		return entry, false, err
+	}
+	defer unlock()
+
+	var existing models.ImageEmbedding
+	if err := d.DB.WithContext(ctx).Where("file_path = ? AND is_batch = ?", entry.FilePath, false).
+		Where("visibility = ?", entry.Visibility).First(&existing).Error; err == nil {
+		if entry.Caption != "" && entry.Caption != existing.Caption {
+			if err := d.applyCaption(ctx, &existing, entry.Caption, captionMode); err != nil {
+				return existing, true, err
+			}
+		}
+		return existing, true, nil
+	}
+
+	// Extract text from image using AI, unless the caption already describes it. Videos are
+	// described frame by frame.
+	text := entry.Caption
+	var frames []models.VideoFrame
+	if entry.Caption == "" || captionMode != CaptionReplace {
+		var err error
+		if services.IsVideo(entry.MediaType) && viper.GetBool("VIDEO_FRAMES") {
+			if text, frames, err = d.describeVideo(ctx, &entry); err != nil {
+				return entry, false, err
+			}
+		} else {
+			if text, err = d.Services.ExtractTextFromImage(ctx, entry.FilePath); err != nil {
+				return entry, false, err
+			}
+			text, entry.RawText, entry.Redactions = d.redactDescription(ctx, text)
+		}
+	}
 
 	// Generate embedding from text
-	embedding, err := services.GenerateEmbedding(text)
+	embedding, err := d.Services.GenerateEmbedding(ctx, embeddingInput(text, entry.Caption))
 	if err != nil {
-		return nil, err
+		return entry, false, err
 	}
 
-	// Save to database
-	imageEntry := models.ImageEmbedding{
-		FilePath:  filePath,
-		Text:      text,
-		Embedding: pgvector.NewVector(embedding),
+	entry.Text = text
+	entry.Title = d.recordTitle(ctx, text)
+	entry.Embedding = pgvector.NewVector(embedding)
+	entry.Summary, entry.SummaryEmbedding = d.recordSummary(ctx, text)
+	chunks := d.recordChunks(ctx, text, entry.Embedding)
+
+	// The UI state label is optional, a record is still worth keeping without it
+	if viper.GetBool("CLASSIFY_UI_STATE") {
+		label, err := d.Services.ClassifyUIState(ctx, text)
+		if err != nil {
+			slog.WarnContext(ctx, "Error classifying UI state, saving without a label", "file_path", entry.FilePath, "error", err)
+		}
+		entry.Label = label
+	}
+	entry.AccessibilityFindings = d.recordFindings(ctx, &entry)
+	if services.IsVideo(entry.MediaType) {
+		entry.PreviewPath = d.videoPreview(ctx, entry.FilePath)
+	}
+	if err := d.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&entry).Error; err != nil {
+			return err
+		}
+		if err := createChunks(tx, entry.ID, chunks); err != nil {
+			return err
+		}
+		if err := createFrames(tx, entry.ID, frames); err != nil {
+			return err
+		}
+		if err := createFindings(tx, entry.ID, entry.AccessibilityFindings); err != nil {
+			return err
+		}
+		return events.Record(tx, events.MediaIngested, events.Media(&entry))
+	}); err != nil {
+		return entry, false, dbError(err)
 	}
+	hooks.AfterPersist(ctx, &entry)
+	return entry, false, nil
+}
 
-	if err := database.DB.Create(&imageEntry).Error; err != nil {
-		return nil, err
+// recordTitle is the short title of a description, generated with GENERATE_TITLES or taken
+// from its first line when generating fails or is disabled
+func (d Deps) recordTitle(ctx context.Context, text string) string {
+	if viper.GetBool("GENERATE_TITLES") {
+		title, err := d.Services.GenerateTitle(ctx, text)
+		if err == nil {
+			return title
+		}
+		slog.WarnContext(ctx, "Error generating title, using the first line of the description", "error", err)
 	}
+	return services.FallbackTitle(text)
+}
 
-	// Return result
-	return map[string]any{
-		"id":        imageEntry.ID,
-		"file_path": imageEntry.FilePath,
-		"text":      imageEntry.Text,
-	}, nil
+// recordSummary is the one-paragraph summary of a description and its embedding, generated
+// with GENERATE_SUMMARIES. The summary is optional, so a failure leaves the record without
+// one and it is only found by searches on its description.
+func (d Deps) recordSummary(ctx context.Context, text string) (string, *pgvector.Vector) {
+	if !viper.GetBool("GENERATE_SUMMARIES") {
+		return "", nil
+	}
+
+	summary, err := d.Services.Summarize(ctx, text)
+	if err != nil {
+		slog.WarnContext(ctx, "Error summarizing description, saving without a summary", "error", err)
+		return "", nil
+	}
+	embedding, err := d.Services.GenerateEmbedding(ctx, summary)
+	if err != nil {
+		slog.WarnContext(ctx, "Error embedding summary, saving without a summary", "error", err)
+		return "", nil
+	}
+	vector := pgvector.NewVector(embedding)
+	return summary, &vector
+}
+
+// recordChunks splits a description into the chunks searched with CHUNK_DESCRIPTIONS and
+// embeds them. A description that fits in one chunk reuses the embedding of its record.
+// With CHUNK_CONTEXT, each chunk of a longer one is embedded after a sentence situating it in
+// the description. Chunks are optional, so a failure leaves the record only found by
+// whole-text searches, and a chunk whose context fails is embedded alone.
+func (d Deps) recordChunks(ctx context.Context, text string, embedding pgvector.Vector) []models.DescriptionChunk {
+	if !viper.GetBool("CHUNK_DESCRIPTIONS") {
+		return nil
+	}
+
+	texts := services.SplitText(text, viper.GetInt("CHUNK_SIZE"), viper.GetInt("CHUNK_OVERLAP"))
+	if len(texts) == 1 {
+		return []models.DescriptionChunk{{Text: texts[0], Embedding: embedding}}
+	}
+
+	withContext := viper.GetBool("CHUNK_CONTEXT")
+	chunks := make([]models.DescriptionChunk, len(texts))
+	for i, chunkText := range texts {
+		chunks[i] = models.DescriptionChunk{Position: i, Text: chunkText}
+
+		input := chunkText
+		if withContext {
+			chunkContext, err := d.Services.ChunkContext(ctx, text, chunkText)
+			if err != nil {
+				slog.WarnContext(ctx, "Error generating chunk context, embedding the chunk alone", "chunk", i, "error", err)
+			} else {
+				chunks[i].Context = chunkContext
+				input = chunkContext + "\n\n" + chunkText
+			}
+		}
+
+		chunkEmbedding, err := d.Services.GenerateEmbedding(ctx, input)
+		if err != nil {
+			slog.WarnContext(ctx, "Error embedding description chunk, saving without chunks", "chunk", i, "error", err)
+			return nil
+		}
+		chunks[i].Embedding = pgvector.NewVector(chunkEmbedding)
+	}
+	return chunks
+}
+
+// createChunks stores the chunks of a record, in the transaction creating it
+func createChunks(tx *gorm.DB, recordID uint, chunks []models.DescriptionChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	for i := range chunks {
+		chunks[i].RecordID = recordID
+	}
+	return tx.Create(&chunks).Error
+}
+
+// taskAudit is the audit the images of a task are analyzed with, none for tasks without one
+func taskAudit(task *queue.TaskPayload) string {
+	if audit, _ := task.Data["audit"].(string); slices.Contains(services.Audits, audit) {
+		return audit
+	}
+	return ""
+}
+
+// taskVisibility is the visibility of the records of a task, public for tasks queued before
+// records had one
+func taskVisibility(task *queue.TaskPayload) string {
+	if visibility, _ := task.Data["visibility"].(string); visibility == models.VisibilityPrivate {
+		return visibility
+	}
+	return models.VisibilityPublic
+}
+
+// reservedRecordID is the ID the upload of a task reserved for its record, 0 for tasks queued
+// without one, whose record takes the next ID when saved
+func reservedRecordID(task *queue.TaskPayload) uint {
+	id, _ := task.Data["record_id"].(float64)
+	return uint(id)
 }
 
 // processMultipleImagesAnalysisTask processes a batch of images together for journey analysis
-func processMultipleImagesAnalysisTask(task *queue.TaskPayload) (map[string]any, error) {
+func (d Deps) processMultipleImagesAnalysisTask(ctx context.Context, task *queue.TaskPayload) (*BatchResult, error) {
 	// Extract file paths from task data
 	filePaths, ok := task.Data["file_paths"].([]any)
 	if !ok {
-		return nil, nil
+		return nil, badInput(errors.New("task has no file_paths"))
 	}
 
 	// Convert to string slice
@@ -201,7 +591,7 @@ func processMultipleImagesAnalysisTask(task *queue.TaskPayload) (map[string]any,
 	}
 
 	if len(stringPaths) == 0 {
-		return nil, nil
+		return nil, badInput(errors.New("task has no file_paths"))
 	}
 
 	// Get optional configuration from task data or use defaults
@@ -215,33 +605,69 @@ func processMultipleImagesAnalysisTask(task *queue.TaskPayload) (map[string]any,
 		maxParallel = int(val)
 	}
 
+	perImage, _ := task.Data["per_image"].(bool)
+
+	tolerance := services.ChunkTolerance{
+		Retries:    viper.GetInt("BATCH_CHUNK_RETRIES"),
+		MinSuccess: viper.GetFloat64("BATCH_MIN_CHUNK_SUCCESS"),
+	}
+	if val, ok := task.Data["chunk_retries"].(float64); ok {
+		tolerance.Retries = int(val)
+	}
+	if val, ok := task.Data["min_chunk_success"].(float64); ok {
+		tolerance.MinSuccess = val
+	}
+
+	// The scenario selects prompts for what the images show, such as an app flow or a photo album
+	scenarioName, _ := task.Data["scenario"].(string)
+	if scenarioName == "" {
+		scenarioName = viper.GetString("BATCH_SCENARIO")
+	}
+	scenario, ok := services.LookupScenario(scenarioName)
+	if !ok {
+		return nil, badInput(fmt.Errorf("unknown batch scenario %q", scenarioName))
+	}
+
 	// Log processing configuration
-	log.Printf("Processing batch with %d images: chunk_size=%d, parallel=%d",
-		len(stringPaths), maxChunkSize, maxParallel)
+	slog.InfoContext(ctx, "Processing batch", "task_id", task.TaskID, "file_count", len(stringPaths),
+		"chunk_size", maxChunkSize, "parallel", maxParallel, "per_image", perImage, "scenario", scenarioName)
+
+	// Analyze each image on its own first, so a retry after the journey fails reuses them
+	var images []BatchImageResult
+	if perImage {
+		var err error
+		if images, err = d.analyzeBatchImages(ctx, task, stringPaths, maxParallel); err != nil {
+			return nil, err
+		}
+	}
 
 	// Extract text from multiple images using parallel processing
 	var journeyText string
+	var skipped []services.SkippedChunk
 	var err error
 
 	// Time the operation
 	startTime := time.Now()
 
-	// If batch is small, use standard method, otherwise use parallel method
-	if len(stringPaths) <= maxChunkSize {
-		journeyText, err = services.ExtractTextFromMultipleImages(stringPaths)
-	} else {
-		journeyText, err = services.ParallelExtractTextFromImages(stringPaths, maxChunkSize, maxParallel)
+	// Small batches are analyzed in one call, larger ones in parallel chunks that are synthesized
+	// Finished chunks are checkpointed, so a batch that is interrupted or requeued resumes from them
+	var checkpoints services.ChunkCheckpoints
+	if viper.GetBool("BATCH_CHECKPOINTS") {
+		checkpoints = taskCheckpoints{queue: d.Queue, taskID: task.TaskID}
 	}
+	journeyText, skipped, err = d.Services.ParallelExtractTextFromImages(ctx, stringPaths, maxChunkSize, maxParallel,
+		scenario, tolerance, checkpoints)
 
 	processingTime := time.Since(startTime)
-	log.Printf("Batch processing completed in %v", processingTime)
+	slog.InfoContext(ctx, "Batch processing completed", "task_id", task.TaskID, "duration", processingTime)
 
 	if err != nil {
 		return nil, err
 	}
+	journeyText, rawText, redactions := d.redactDescription(ctx, journeyText)
 
 	// Generate embedding from the combined journey text
-	embedding, err := services.GenerateEmbedding(journeyText)
+	embedding, err := d.Services.GenerateEmbedding(ctx, journeyText)
 	if err != nil {
 		return nil, err
 	}
@@ -249,36 +675,164 @@ func processMultipleImagesAnalysisTask(task *queue.TaskPayload) (map[string]any,
 	// Generate a batch ID to link all images in this batch
 	batchID := task.TaskID
 
+	originalName := batchDataAt(task, "original_names", 0)
+	mediaType := batchDataAt(task, "media_types", 0)
+	originalPath := batchDataAt(task, "original_paths", 0)
+
 	// Create a combined record for the journey
+	summary, summaryEmbedding := d.recordSummary(ctx, journeyText)
+	chunks := d.recordChunks(ctx, journeyText, pgvector.NewVector(embedding))
 	journeyEntry := models.ImageEmbedding{
-		FilePath:   stringPaths[0],
-		Text:       journeyText,
-		Embedding:  pgvector.NewVector(embedding),
-		IsBatch:    true,
-		BatchID:    batchID,
-		BatchPaths: stringPaths,
+		ID:               reservedRecordID(task),
+		FilePath:         stringPaths[0],
+		OriginalName:     originalName,
+		MediaType:        mediaType,
+		OriginalPath:     originalPath,
+		Title:            d.recordTitle(ctx, journeyText),
+		Text:             journeyText,
+		RawText:          rawText,
+		Redactions:       redactions,
+		Embedding:        pgvector.NewVector(embedding),
+		Summary:          summary,
+		SummaryEmbedding: summaryEmbedding,
+		IsBatch:          true,
+		BatchID:          batchID,
+		BatchPaths:       stringPaths,
+		Visibility:       taskVisibility(task),
 	}
 
-	if err := database.DB.Create(&journeyEntry).Error; err != nil {
-		return nil, err
+	if err := d.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&journeyEntry).Error; err != nil {
+			return err
+		}
+		if err := createChunks(tx, journeyEntry.ID, chunks); err != nil {
+			return err
+		}
+		payload := events.Media(&journeyEntry)
+		payload["file_count"] = len(stringPaths)
+		payload["scenario"] = scenarioName
+		payload["skipped_chunks"] = len(skipped)
+		return events.Record(tx, events.BatchCompleted, payload)
+	}); err != nil {
+		return nil, dbError(err)
+	}
+	hooks.AfterPersist(ctx, &journeyEntry)
+	if err := d.Queue.DeleteChunkCheckpoints(task.TaskID); err != nil {
+		slog.WarnContext(ctx, "Error deleting chunk checkpoints", "task_id", task.TaskID, "error", err)
 	}
 
 	// Return result with all file paths in the batch
-	return map[string]any{
-		"id":                 journeyEntry.ID,
-		"file_path":          journeyEntry.FilePath,
-		"text":               journeyEntry.Text,
-		"file_count":         len(stringPaths),
-		"is_batch":           true,
-		"batch_id":           batchID,
-		"batch_paths":        stringPaths,
-		"processing_time_ms": processingTime.Milliseconds(),
+	return &BatchResult{
+		Type:             ResultTypeBatch,
+		ID:               journeyEntry.ID,
+		FilePath:         journeyEntry.FilePath,
+		Title:            journeyEntry.Title,
+		Summary:          journeyEntry.Summary,
+		Text:             journeyEntry.Text,
+		FileCount:        len(stringPaths),
+		IsBatch:          true,
+		BatchID:          batchID,
+		BatchPaths:       stringPaths,
+		Scenario:         scenarioName,
+		ProcessingTimeMS: processingTime.Milliseconds(),
+		Redactions:       journeyEntry.Redactions,
+		Images:           images,
+		SkippedChunks:    skipped,
 	}, nil
 }
 
-// RunWorkers starts a pool of workers for image processing
-func RunWorkers(ctx context.Context, numWorkers int) *Worker {
-	worker := NewWorker(queue.ImageProcessingQueue, numWorkers)
+// analyzeBatchImages analyzes every image of a batch on its own, up to maxParallel at once,
+// linking the records to the batch through their batch ID and step in the journey. Images analyzed before are reused
+// and linked when they do not belong to another batch yet.
+func (d Deps) analyzeBatchImages(ctx context.Context, task *queue.TaskPayload, filePaths []string, maxParallel int) ([]BatchImageResult, error) {
+	images := make([]BatchImageResult, len(filePaths))
+	errs := make([]error, len(filePaths))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(maxParallel, 1))
+	for i, filePath := range filePaths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			record, existing, err := d.analyzeImage(ctx, models.ImageEmbedding{
+				FilePath:      filePath,
+				OriginalName:  batchDataAt(task, "original_names", i),
+				MediaType:     batchDataAt(task, "media_types", i),
+				OriginalPath:  batchDataAt(task, "original_paths", i),
+				BatchID:       task.TaskID,
+				BatchSequence: i + 1,
+				Visibility:    taskVisibility(task),
+				Audit:         taskAudit(task),
+			}, CaptionAugment)
+			if err != nil {
+				errs[i] = fmt.Errorf("analyzing %s: %w", filePath, err)
+				return
+			}
+			if existing && record.BatchID == "" {
+				if err := d.DB.WithContext(ctx).Model(&record).
+					Updates(map[string]any{"batch_id": task.TaskID, "batch_sequence": i + 1}).Error; err != nil {
+					errs[i] = dbError(err)
+					return
+				}
+			}
+
+			images[i] = BatchImageResult{
+				ID:       record.ID,
+				FilePath: record.FilePath,
+				Sequence: i + 1,
+				Existing: existing,
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// taskCheckpoints keeps the chunk analyses of a batch task in Redis
+type taskCheckpoints struct {
+	queue  *queue.Client
+	taskID string
+}
+
+func (c taskCheckpoints) Load(index int, imagePaths []string) (string, bool) {
+	text, ok, err := c.queue.LoadChunkCheckpoint(c.taskID, index, imagePaths)
+	if err != nil {
+		slog.Warn("Error loading chunk checkpoint", "task_id", c.taskID, "index", index, "error", err)
+	}
+	return text, ok
+}
+
+func (c taskCheckpoints) Save(index int, imagePaths []string, text string) {
+	if err := c.queue.SaveChunkCheckpoint(c.taskID, index, imagePaths, text); err != nil {
+		slog.Warn("Error saving chunk checkpoint", "task_id", c.taskID, "index", index, "error", err)
+	}
+}
+
+// batchDataAt returns the i-th string of a list in the task data of a batch
+func batchDataAt(task *queue.TaskPayload, key string, i int) string {
+	values, _ := task.Data[key].([]any)
+	if i >= len(values) {
+		return ""
+	}
+	value, _ := values[i].(string)
+	return value
+}
+
+// RunWorkers starts a pool of workers for image processing consuming queueNames, with the
+// retention and event relay loops
+func RunWorkers(ctx context.Context, deps Deps, queueNames []string, numWorkers int) *Worker {
+	worker := NewWorker(deps, queueNames, numWorkers)
 	worker.Start()
+
+	go cleanup.RunRetention(ctx, deps.DB, deps.Queue, deps.Files)
+	go events.RunRelay(ctx, deps.DB, deps.Queue)
+
 	return worker
 }
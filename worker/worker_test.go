@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pablobfonseca/go-image-vector/queue"
+	"github.com/pablobfonseca/go-image-vector/queue/queuetest"
+	"github.com/pablobfonseca/go-image-vector/services"
+	"github.com/pablobfonseca/go-image-vector/services/servicestest"
+	"github.com/pgvector/pgvector-go"
+	"github.com/spf13/viper"
+)
+
+func TestRecordTitle(t *testing.T) {
+	ollama := servicestest.Start(t)
+	d := Deps{Services: ollama.Clients(nil)}
+	ollama.Respond(func(prompt string, images int) string { return "**Checkout error page**" })
+	ctx := context.Background()
+
+	viper.Set("GENERATE_TITLES", true)
+	if title := d.recordTitle(ctx, "# Description\n\nDetails"); title != "Checkout error page" {
+		t.Errorf("generated title = %q", title)
+	}
+
+	viper.Set("GENERATE_TITLES", false)
+	if title := d.recordTitle(ctx, "# Description\n\nDetails"); title != "Description" {
+		t.Errorf("title without generation = %q, want the first line", title)
+	}
+
+	viper.Set("GENERATE_TITLES", true)
+	ollama.Fail(500, "crash")
+	if title := d.recordTitle(ctx, "# Description\n\nDetails"); title != "Description" {
+		t.Errorf("title when generation fails = %q, want the first line", title)
+	}
+}
+
+func TestRecordSummary(t *testing.T) {
+	ollama := servicestest.Start(t)
+	d := Deps{Services: ollama.Clients(nil)}
+	ollama.Respond(func(prompt string, images int) string { return "A login form\nwith an error." })
+	ctx := context.Background()
+
+	viper.Set("GENERATE_SUMMARIES", false)
+	if summary, embedding := d.recordSummary(ctx, "text"); summary != "" || embedding != nil {
+		t.Errorf("disabled summaries gave %q", summary)
+	}
+
+	viper.Set("GENERATE_SUMMARIES", true)
+	summary, embedding := d.recordSummary(ctx, "text")
+	if summary != "A login form with an error." {
+		t.Errorf("summary = %q", summary)
+	}
+	if embedding == nil || len(embedding.Slice()) != servicestest.Dimensions {
+		t.Errorf("summary embedding = %v, want the embedding of the summary", embedding)
+	}
+
+	ollama.Fail(500, "crash")
+	if summary, embedding := d.recordSummary(ctx, "text"); summary != "" || embedding != nil {
+		t.Errorf("failed summary gave %q, want none", summary)
+	}
+}
+
+func TestRecordChunks(t *testing.T) {
+	var embedded []string
+	d := Deps{Services: services.Clients{Embedder: services.EmbedderFunc(func(ctx context.Context, opts services.EmbeddingOptions) ([]float32, error) {
+		embedded = append(embedded, opts.Text)
+		return servicestest.Embedding(opts.Text), nil
+	})}}
+
+	ctx := context.Background()
+	viper.Set("CHUNK_DESCRIPTIONS", true)
+	viper.Set("CHUNK_CONTEXT", false)
+	viper.Set("CHUNK_SIZE", 100)
+	viper.Set("CHUNK_OVERLAP", 10)
+
+	// A short description is one chunk with the embedding of its record
+	record := pgvector.NewVector([]float32{1, 2, 3})
+	chunks := d.recordChunks(ctx, "short", record)
+	if len(chunks) != 1 || chunks[0].Text != "short" || len(chunks[0].Embedding.Slice()) != 3 || len(embedded) != 0 {
+		t.Errorf("short description: got %+v and %d embeddings", chunks, len(embedded))
+	}
+
+	long := strings.Repeat("first part of the journey. ", 5) + "\n\n" + strings.Repeat("second part of the journey. ", 5)
+	chunks = d.recordChunks(ctx, long, record)
+	if len(chunks) < 2 || len(embedded) != len(chunks) {
+		t.Fatalf("long description: got %d chunks and %d embeddings", len(chunks), len(embedded))
+	}
+	for i, chunk := range chunks {
+		if chunk.Position != i || chunk.Text != embedded[i] {
+			t.Errorf("chunk %d = %+v, want position %d embedded alone", i, chunk, i)
+		}
+	}
+
+	d.Services.Embedder = services.EmbedderFunc(func(ctx context.Context, opts services.EmbeddingOptions) ([]float32, error) {
+		return nil, errors.New("embedding failed")
+	})
+	if chunks := d.recordChunks(ctx, long, record); chunks != nil {
+		t.Errorf("failed embeddings gave %d chunks, want none", len(chunks))
+	}
+}
+
+func TestRecordChunksContext(t *testing.T) {
+	ollama := servicestest.Start(t)
+	d := Deps{Services: ollama.Clients(nil)}
+	ollama.Respond(func(prompt string, images int) string { return "This part covers the checkout." })
+	ctx := context.Background()
+	viper.Set("CHUNK_DESCRIPTIONS", true)
+	viper.Set("CHUNK_CONTEXT", true)
+	viper.Set("CHUNK_SIZE", 100)
+	viper.Set("CHUNK_OVERLAP", 10)
+	t.Cleanup(func() { viper.Set("CHUNK_CONTEXT", false) })
+
+	long := strings.Repeat("first part of the journey. ", 5) + "\n\n" + strings.Repeat("second part of the journey. ", 5)
+	chunks := d.recordChunks(ctx, long, pgvector.NewVector([]float32{1}))
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the description split", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if chunk.Context != "This part covers the checkout." {
+			t.Errorf("chunk context = %q", chunk.Context)
+		}
+	}
+
+	for _, request := range ollama.Requests() {
+		if request.Endpoint == "embeddings" && !strings.HasPrefix(request.Prompt, "This part covers the checkout.\n\n") {
+			t.Errorf("chunk embedded without its context: %q", request.Prompt)
+		}
+	}
+}
+
+func TestEmbeddingInput(t *testing.T) {
+	if got := embeddingInput("text", ""); got != "text" {
+		t.Errorf("without caption = %q", got)
+	}
+	if got := embeddingInput("text", "caption"); got != "Caption: caption\n\ntext" {
+		t.Errorf("with caption = %q", got)
+	}
+}
+
+func TestDrainStopsDequeuing(t *testing.T) {
+	tasks := queuetest.NewMemory()
+	w := NewWorker(Deps{Tasks: tasks}, []string{queue.ImageProcessingQueue}, 2)
+	w.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Drain(ctx); err != nil {
+		t.Fatalf("Drain() = %v, want idle workers to stop", err)
+	}
+
+	task := &queue.TaskPayload{TaskID: "1", TaskType: TaskTypeAnalyzeImage, Queue: queue.ImageProcessingQueue}
+	tasks.Push(ctx, task)
+	time.Sleep(100 * time.Millisecond)
+	if queued := tasks.Queued(queue.ImageProcessingQueue, queue.PriorityNormal); len(queued) != 1 {
+		t.Errorf("%d tasks queued after draining, want the pushed task left queued", len(queued))
+	}
+}